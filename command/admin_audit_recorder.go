@@ -0,0 +1,62 @@
+package command
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/xerrors"
+)
+
+// Actions recorded by AdminAuditRecorder.
+const (
+	AdminActionExport       = "export"
+	AdminActionForceConfirm = "force_confirm"
+	AdminActionRotateToken  = "rotate_token"
+	AdminActionSendWelcome  = "send_welcome"
+	AdminActionTestSend     = "test_send"
+)
+
+// AdminAuditRecorder records an action taken through an admin endpoint for
+// accountability. It should be run in the same transaction as the action so
+// that the two are committed or rolled back together.
+type AdminAuditRecorder struct {
+	Action string `validate:"required"`
+
+	// AdminTokenID identifies the admin token used for the action. It should
+	// be a hash of the token rather than the token itself.
+	AdminTokenID string `validate:"required"`
+
+	// Target is what the action was taken on, like a subscriber's email.
+	Target string `validate:"required"`
+}
+
+// Run executes the mediator.
+func (c *AdminAuditRecorder) Run(ctx context.Context, tx pgx.Tx) (*AdminAuditRecorderResult, error) {
+	logrus.Debugf("AdminAuditRecorder running")
+
+	if err := validate.Struct(c); err != nil {
+		return nil, xerrors.Errorf("error validating command: %w", err)
+	}
+
+	var res AdminAuditRecorderResult
+	err := tx.QueryRow(ctx, `
+		INSERT INTO admin_audit
+			(action, admin_token_id, target)
+		VALUES
+			($1, $2, $3)
+		RETURNING id
+	`, c.Action, c.AdminTokenID, c.Target).Scan(&res.AuditID)
+	if err != nil {
+		return nil, xerrors.Errorf("error recording admin action: %w", err)
+	}
+
+	logrus.Infof("Recorded admin action %q on %q by token %s", c.Action, c.Target, c.AdminTokenID)
+	return &res, nil
+}
+
+// AdminAuditRecorderResult holds the results of a successful run of
+// AdminAuditRecorder.
+type AdminAuditRecorderResult struct {
+	AuditID int64
+}
@@ -0,0 +1,49 @@
+package command
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brandur/passages-signup/testhelpers"
+)
+
+func TestAdminAuditRecorder(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("RecordsAction", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			mediator := &AdminAuditRecorder{
+				Action:       AdminActionForceConfirm,
+				AdminTokenID: "0123456789abcdef",
+				Target:       testhelpers.TestEmail,
+			}
+			res, err := mediator.Run(ctx, tx)
+			require.NoError(t, err)
+
+			var action, adminTokenID, target string
+			err = tx.QueryRow(ctx, `
+				SELECT action, admin_token_id, target
+				FROM admin_audit
+				WHERE id = $1
+			`, res.AuditID).Scan(&action, &adminTokenID, &target)
+			require.NoError(t, err)
+			require.Equal(t, AdminActionForceConfirm, action)
+			require.Equal(t, "0123456789abcdef", adminTokenID)
+			require.Equal(t, testhelpers.TestEmail, target)
+		})
+	})
+
+	t.Run("RequiresTokenID", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			mediator := &AdminAuditRecorder{
+				Action: AdminActionForceConfirm,
+				Target: testhelpers.TestEmail,
+			}
+			_, err := mediator.Run(ctx, tx)
+			require.EqualError(t, err, "error validating command: AdminTokenID is a required field")
+		})
+	})
+}
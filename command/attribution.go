@@ -0,0 +1,62 @@
+package command
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxAttributionValueLength is the maximum length of a single UTM value.
+// Anything longer is truncated. Real campaign names are short, so anything near
+// this is probably garbage anyway.
+const maxAttributionValueLength = 100
+
+// Attribution holds the UTM parameters that a signup arrived with so that
+// signups can be attributed to marketing campaigns.
+type Attribution struct {
+	Campaign string
+	Medium   string
+	Source   string
+}
+
+// NewAttribution builds an Attribution from raw UTM parameters, like those
+// from a query string or form. Values are sanitized so that they're safe to
+// store and show back.
+func NewAttribution(campaign, medium, source string) *Attribution {
+	return &Attribution{
+		Campaign: sanitizeAttributionValue(campaign),
+		Medium:   sanitizeAttributionValue(medium),
+		Source:   sanitizeAttributionValue(source),
+	}
+}
+
+//
+// Private functions
+//
+
+// nullIfEmpty returns nil for an empty string so that it's stored as NULL.
+func nullIfEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// sanitizeAttributionValue strips a UTM value down to letters, digits, and a
+// few common separators, then truncates it to maxAttributionValueLength.
+func sanitizeAttributionValue(value string) string {
+	var sb strings.Builder
+	for _, r := range strings.TrimSpace(value) {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune(" +-._", r) {
+			continue
+		}
+
+		if sb.Len()+utf8.RuneLen(r) > maxAttributionValueLength {
+			break
+		}
+
+		sb.WriteRune(r)
+	}
+
+	return strings.TrimSpace(sb.String())
+}
@@ -0,0 +1,39 @@
+package command
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewAttribution(t *testing.T) {
+	require.Equal(t,
+		&Attribution{Campaign: "spring_2024", Medium: "email", Source: "news.ycombinator.com"},
+		NewAttribution("spring_2024", " email ", "news.ycombinator.com"),
+	)
+}
+
+func TestSanitizeAttributionValue(t *testing.T) {
+	testCases := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"Empty", "", ""},
+		{"Plain", "newsletter", "newsletter"},
+		{"Separators", "a-b_c.d+e f", "a-b_c.d+e f"},
+		{"Trimmed", "  newsletter\n", "newsletter"},
+		{"Unicode", "café", "café"},
+		{"StripsMarkup", `<script>alert("x")</script>`, "scriptalertxscript"},
+		{"StripsControl", "news\x00letter\r\n", "newsletter"},
+		{"Truncated", strings.Repeat("a", 150), strings.Repeat("a", maxAttributionValueLength)},
+		{"TruncatedMultibyte", "a" + strings.Repeat("é", 100), "a" + strings.Repeat("é", 49)},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.want, sanitizeAttributionValue(tc.value))
+		})
+	}
+}
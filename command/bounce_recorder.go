@@ -0,0 +1,49 @@
+package command
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/xerrors"
+)
+
+// BounceRecorder marks a signup's email as having hard bounced so that no
+// further confirmation emails are sent to it.
+type BounceRecorder struct {
+	Email string `validate:"required"`
+}
+
+// Run executes the mediator.
+func (c *BounceRecorder) Run(ctx context.Context, tx pgx.Tx) (*BounceRecorderResult, error) {
+	logrus.Debugf("BounceRecorder running")
+
+	if err := validate.Struct(c); err != nil {
+		return nil, xerrors.Errorf("error validating command: %w", err)
+	}
+
+	// Keep the original bounce time if one was already recorded.
+	tag, err := tx.Exec(ctx, `
+		UPDATE signup
+		SET bounced_at = COALESCE(bounced_at, NOW())
+		WHERE email = $1
+	`, c.Email)
+	if err != nil {
+		return nil, xerrors.Errorf("error recording bounce: %w", err)
+	}
+
+	if tag.RowsAffected() < 1 {
+		logrus.Infof("No signup found for bounced email: %s", c.Email)
+		return &BounceRecorderResult{EmailNotFound: true}, nil
+	}
+
+	logrus.Infof("Recorded bounce for email: %s", c.Email)
+	return &BounceRecorderResult{BounceRecorded: true}, nil
+}
+
+// BounceRecorderResult holds the results of a successful run of
+// BounceRecorder.
+type BounceRecorderResult struct {
+	BounceRecorded bool
+	EmailNotFound  bool
+}
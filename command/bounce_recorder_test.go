@@ -0,0 +1,53 @@
+package command
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brandur/passages-signup/testhelpers"
+)
+
+func TestBounceRecorder(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("RecordsBounce", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			_, err := tx.Exec(ctx, `
+				INSERT INTO signup
+					(email, token)
+				VALUES
+					($1, 'not-a-real-token')
+			`, testhelpers.TestEmail)
+			require.NoError(t, err)
+
+			mediator := &BounceRecorder{Email: testhelpers.TestEmail}
+			res, err := mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.True(t, res.BounceRecorded)
+			require.False(t, res.EmailNotFound)
+
+			var bouncedAt *time.Time
+			err = tx.QueryRow(ctx, `
+				SELECT bounced_at
+				FROM signup
+				WHERE email = $1
+			`, testhelpers.TestEmail).Scan(&bouncedAt)
+			require.NoError(t, err)
+			require.NotNil(t, bouncedAt)
+		})
+	})
+
+	t.Run("EmailNotFound", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			mediator := &BounceRecorder{Email: testhelpers.TestEmail}
+			res, err := mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.False(t, res.BounceRecorded)
+			require.True(t, res.EmailNotFound)
+		})
+	})
+}
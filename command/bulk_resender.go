@@ -0,0 +1,147 @@
+package command
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/xerrors"
+
+	"github.com/brandur/passages-signup/mailclient"
+	"github.com/brandur/passages-signup/ptemplate"
+	"github.com/brandur/passages-signup/signedtoken"
+)
+
+// BulkResender prepares a fresh confirmation email for the next pending signup
+// after AfterID as part of re-sending confirmations to every pending signup,
+// like after fixing a bug in the confirmation template.
+//
+// It's meant to be run repeatedly, each time in a new transaction, with
+// AfterID set to the previous result's SignupID until Done is set. The message
+// is returned rather than sent so that the caller can send it once the
+// transaction has committed. That way, an interrupted bulk resend never rolls
+// back the new tokens of messages that were already sent.
+//
+// Signups that have bounced are skipped. Signups whose emails are on the
+// suppression list are reported with Suppressed set and left unchanged.
+type BulkResender struct {
+	// AfterID is the ID of the last signup that was resent to. Signups are
+	// resent to in order of ID, so zero starts from the beginning.
+	AfterID int64 `validate:"min=0"`
+
+	// EmailCasePolicy normalizes emails before they're checked against the
+	// suppression list, so that signups stored before the policy was changed
+	// still match suppressions recorded after.
+	EmailCasePolicy EmailCasePolicy `validate:"omitempty,oneof=preserve lower-domain lower-all"`
+
+	FromAddress string              `validate:"omitempty,email"`
+	ListAddress string              `validate:"required"`
+	Renderer    *ptemplate.Renderer `validate:"required"`
+
+	// ReplyToAddress is an optional address for replies. If empty, replies
+	// go to the list address.
+	ReplyToAddress string `validate:"-"`
+
+	// TokenSigner and TokenTTL are used to sign fresh confirmation tokens the
+	// same way as SignupStarter.
+	TokenSigner *signedtoken.Signer `validate:"-"`
+	TokenTTL    time.Duration       `validate:"required_with=TokenSigner"`
+}
+
+// Run executes the mediator.
+func (c *BulkResender) Run(ctx context.Context, tx pgx.Tx) (*BulkResenderResult, error) {
+	logrus.Debugf("BulkResender running")
+
+	if err := validate.Struct(c); err != nil {
+		return nil, xerrors.Errorf("error validating command: %w", err)
+	}
+
+	var (
+		email string
+		id    int64
+		token string
+	)
+	err := tx.QueryRow(ctx, `
+		SELECT id, email, token
+		FROM signup
+		WHERE id > $1
+		  AND completed_at IS NULL
+		  AND bounced_at IS NULL
+		ORDER BY id
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	`, c.AfterID).Scan(&id, &email, &token)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return &BulkResenderResult{Done: true}, nil
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("error querying pending signup: %w", err)
+	}
+
+	store := NewPgxSignupStore(tx)
+
+	suppressed, err := store.IsSuppressed(ctx, c.EmailCasePolicy.Apply(email))
+	if err != nil {
+		return nil, err
+	}
+	if suppressed {
+		signupLogger(id).Infof("Email is on the suppression list so not resending confirmation: %s", email)
+		return &BulkResenderResult{Email: email, SignupID: id, Suppressed: true}, nil
+	}
+
+	// Reuse SignupStarter's logic for signing tokens and rendering messages so
+	// that resent messages are identical to the originals.
+	starter := &SignupStarter{
+		Email:          email,
+		FromAddress:    c.FromAddress,
+		ListAddress:    c.ListAddress,
+		Renderer:       c.Renderer,
+		ReplyToAddress: c.ReplyToAddress,
+		TokenSigner:    c.TokenSigner,
+		TokenTTL:       c.TokenTTL,
+	}
+
+	token, err = starter.refreshSignedToken(ctx, store, id, token)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE signup
+		SET last_sent_at = NOW()
+		WHERE id = $1
+	`, id)
+	if err != nil {
+		return nil, xerrors.Errorf("error updating last sent: %w", err)
+	}
+
+	message, err := starter.confirmationMessage(token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BulkResenderResult{Email: email, Message: message, SignupID: id}, nil
+}
+
+// BulkResenderResult holds the results of a successful run of BulkResender.
+type BulkResenderResult struct {
+	// Done is set if there are no pending signups left after AfterID, in
+	// which case no other fields are populated.
+	Done bool
+
+	Email string
+
+	// Message is the confirmation message to send to Email once the
+	// transaction has committed. It's nil if Suppressed is set.
+	Message *mailclient.SendMessageParams
+
+	// SignupID is the ID of the signup, to be used as AfterID on the next
+	// run.
+	SignupID int64
+
+	// Suppressed is set if the email is on the suppression list, in which
+	// case nothing was changed and there's no message to send.
+	Suppressed bool
+}
@@ -0,0 +1,110 @@
+package command
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brandur/passages-signup/testhelpers"
+)
+
+func TestBulkResender(t *testing.T) {
+	ctx := context.Background()
+
+	bulkResender := func(afterID int64) *BulkResender {
+		return &BulkResender{
+			AfterID:        afterID,
+			ListAddress:    testListAddress,
+			Renderer:       renderer,
+			ReplyToAddress: testReplyToAddress,
+		}
+	}
+
+	lastSentAt := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	insertSignups := func(t *testing.T, tx pgx.Tx) {
+		t.Helper()
+
+		// Start from a clean slate in case the test database has data
+		_, err := tx.Exec(ctx, `DELETE FROM signup`)
+		require.NoError(t, err)
+
+		_, err = tx.Exec(ctx, `
+			INSERT INTO signup
+				(email, token, completed_at, bounced_at, last_sent_at)
+			VALUES
+				('pending1@example.com', 'token-1', NULL, NULL, $1),
+				('completed@example.com', 'token-2', NOW(), NULL, $1),
+				('bounced@example.com', 'token-3', NULL, NOW(), $1),
+				('pending2@example.com', 'token-4', NULL, NULL, $1)
+		`, lastSentAt)
+		require.NoError(t, err)
+	}
+
+	t.Run("ResendsToPending", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			insertSignups(t, tx)
+
+			res, err := bulkResender(0).Run(ctx, tx)
+			require.NoError(t, err)
+			require.Equal(t, "pending1@example.com", res.Email)
+			require.Equal(t, "pending1@example.com", res.Message.Recipient)
+			require.Contains(t, res.Message.ContentsPlain, "/confirm/token-1")
+
+			var sentAt time.Time
+			err = tx.QueryRow(ctx, `
+				SELECT last_sent_at
+				FROM signup
+				WHERE id = $1
+			`, res.SignupID).Scan(&sentAt)
+			require.NoError(t, err)
+			require.True(t, sentAt.After(lastSentAt))
+
+			// Completed and bounced signups are skipped.
+			res, err = bulkResender(res.SignupID).Run(ctx, tx)
+			require.NoError(t, err)
+			require.Equal(t, "pending2@example.com", res.Email)
+			require.Contains(t, res.Message.ContentsPlain, "/confirm/token-4")
+
+			res, err = bulkResender(res.SignupID).Run(ctx, tx)
+			require.NoError(t, err)
+			require.Equal(t, &BulkResenderResult{Done: true}, res)
+		})
+	})
+
+	t.Run("Suppressed", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			insertSignups(t, tx)
+
+			_, err := tx.Exec(ctx, `
+				INSERT INTO suppression
+					(email, reason)
+				VALUES
+					('pending1@example.com', 'complained')
+			`)
+			require.NoError(t, err)
+
+			res, err := bulkResender(0).Run(ctx, tx)
+			require.NoError(t, err)
+			require.True(t, res.Suppressed)
+			require.Equal(t, "pending1@example.com", res.Email)
+			require.Nil(t, res.Message)
+
+			var (
+				sentAt time.Time
+				token  string
+			)
+			err = tx.QueryRow(ctx, `
+				SELECT last_sent_at, token
+				FROM signup
+				WHERE id = $1
+			`, res.SignupID).Scan(&sentAt, &token)
+			require.NoError(t, err)
+			require.True(t, sentAt.Equal(lastSentAt))
+			require.Equal(t, "token-1", token)
+		})
+	})
+}
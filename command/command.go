@@ -1,7 +1,27 @@
 package command
 
 import (
-	"github.com/go-playground/validator/v10"
+	"context"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/brandur/passages-signup/validation"
 )
 
-var validate = validator.New()
+// Mediators are run with parameters assembled by the program, so their
+// validation failures are internal errors. Input from users should be checked
+// before it's passed to a mediator.
+var validate = validation.New()
+
+// contextCancelled checks whether ctx was cancelled, like because the client
+// disconnected mid-request. Mediators check it before taking an action that
+// can't be rolled back along with their transaction, like sending mail, so
+// that it isn't taken for a request that was abandoned.
+func contextCancelled(ctx context.Context, email string) bool {
+	if ctx.Err() == nil {
+		return false
+	}
+
+	logrus.Infof("Context cancelled; abandoning request for %v: %v", email, ctx.Err())
+	return true
+}
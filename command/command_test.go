@@ -1,8 +1,12 @@
 package command
 
 import (
+	"context"
 	"os"
 
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+
 	"github.com/brandur/passages-signup/newslettermeta"
 	"github.com/brandur/passages-signup/ptemplate"
 )
@@ -10,6 +14,7 @@ import (
 const (
 	testReplyToAddress = "passages@example.com"
 	testListAddress    = "passages@example.com"
+	testTokenSecret    = "a-secret-that-is-at-least-32-chars"
 )
 
 var renderer *ptemplate.Renderer
@@ -26,3 +31,25 @@ func init() {
 		panic(err)
 	}
 }
+
+// queryCountingTx wraps a transaction and counts the number of queries issued
+// through it so that tests can verify when the database was or wasn't used.
+type queryCountingTx struct {
+	pgx.Tx
+	numQueries int
+}
+
+func (tx *queryCountingTx) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	tx.numQueries++
+	return tx.Tx.Exec(ctx, sql, args...)
+}
+
+func (tx *queryCountingTx) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	tx.numQueries++
+	return tx.Tx.Query(ctx, sql, args...)
+}
+
+func (tx *queryCountingTx) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	tx.numQueries++
+	return tx.Tx.QueryRow(ctx, sql, args...)
+}
@@ -0,0 +1,116 @@
+package command
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ConfirmedEmailCache is a small, bounded, in-memory LRU of email addresses
+// that have recently finished the signup process. It lets SignupStarter skip a
+// database round trip when an already confirmed user resubmits the form, which
+// tends to happen in bursts.
+//
+// Entries expire after a TTL so that a user who's since unsubscribed through
+// Mailgun will eventually be able to go through the full flow again. The cache
+// is per process, so an unsubscribe recorded by another process, like a
+// reconciliation run, only takes effect here once the entry expires. It's safe
+// for concurrent use.
+type ConfirmedEmailCache struct {
+	entries map[string]*list.Element
+	maxSize int
+	mut     sync.Mutex
+	order   *list.List
+	timeNow func() time.Time
+	ttl     time.Duration
+}
+
+type confirmedEmailCacheEntry struct {
+	email     string
+	expiresAt time.Time
+}
+
+// NewConfirmedEmailCache initializes a new cache holding up to maxSize emails,
+// each of which expires after ttl.
+func NewConfirmedEmailCache(maxSize int, ttl time.Duration) *ConfirmedEmailCache {
+	return &ConfirmedEmailCache{
+		entries: make(map[string]*list.Element),
+		maxSize: maxSize,
+		order:   list.New(),
+		timeNow: time.Now,
+		ttl:     ttl,
+	}
+}
+
+// Add marks an email as confirmed, evicting the least recently used entry if
+// the cache is full.
+func (c *ConfirmedEmailCache) Add(email string) {
+	email = normalizeEmail(email)
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	expiresAt := c.timeNow().Add(c.ttl)
+
+	if elem, ok := c.entries[email]; ok {
+		elem.Value.(*confirmedEmailCacheEntry).expiresAt = expiresAt
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[email] = c.order.PushFront(&confirmedEmailCacheEntry{
+		email:     email,
+		expiresAt: expiresAt,
+	})
+
+	for c.order.Len() > c.maxSize {
+		c.removeElement(c.order.Back())
+	}
+}
+
+// Contains returns true if the email was recently confirmed and its entry
+// hasn't yet expired.
+func (c *ConfirmedEmailCache) Contains(email string) bool {
+	email = normalizeEmail(email)
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	elem, ok := c.entries[email]
+	if !ok {
+		return false
+	}
+
+	if c.timeNow().After(elem.Value.(*confirmedEmailCacheEntry).expiresAt) {
+		c.removeElement(elem)
+		return false
+	}
+
+	c.order.MoveToFront(elem)
+	return true
+}
+
+// Remove removes an email from the cache, like after it's unsubscribed, so
+// that its next signup goes through the full flow.
+func (c *ConfirmedEmailCache) Remove(email string) {
+	email = normalizeEmail(email)
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if elem, ok := c.entries[email]; ok {
+		c.removeElement(elem)
+	}
+}
+
+func (c *ConfirmedEmailCache) removeElement(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*confirmedEmailCacheEntry).email)
+}
+
+// normalizeEmail produces a canonical form of an email address for use as a
+// cache key.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -0,0 +1,62 @@
+package command
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfirmedEmailCache(t *testing.T) {
+	t.Run("AddAndContains", func(t *testing.T) {
+		cache := NewConfirmedEmailCache(10, time.Hour)
+		require.False(t, cache.Contains("foo@example.com"))
+
+		cache.Add("foo@example.com")
+		require.True(t, cache.Contains("foo@example.com"))
+	})
+
+	t.Run("Normalized", func(t *testing.T) {
+		cache := NewConfirmedEmailCache(10, time.Hour)
+		cache.Add(" Foo@Example.com ")
+		require.True(t, cache.Contains("foo@example.com"))
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		cache := NewConfirmedEmailCache(10, time.Hour)
+		cache.Add("foo@example.com")
+		cache.Add("bar@example.com")
+
+		cache.Remove(" Foo@Example.com ")
+		require.False(t, cache.Contains("foo@example.com"))
+		require.True(t, cache.Contains("bar@example.com"))
+
+		// Removing an email that isn't there is a no-op.
+		cache.Remove("foo@example.com")
+	})
+
+	t.Run("Expires", func(t *testing.T) {
+		now := time.Now()
+
+		cache := NewConfirmedEmailCache(10, time.Hour)
+		cache.timeNow = func() time.Time { return now }
+		cache.Add("foo@example.com")
+
+		cache.timeNow = func() time.Time { return now.Add(2 * time.Hour) }
+		require.False(t, cache.Contains("foo@example.com"))
+	})
+
+	t.Run("EvictsLeastRecentlyUsed", func(t *testing.T) {
+		cache := NewConfirmedEmailCache(2, time.Hour)
+		cache.Add("a@example.com")
+		cache.Add("b@example.com")
+
+		// Touch `a` so that `b` becomes the least recently used.
+		require.True(t, cache.Contains("a@example.com"))
+
+		cache.Add("c@example.com")
+		require.True(t, cache.Contains("a@example.com"))
+		require.False(t, cache.Contains("b@example.com"))
+		require.True(t, cache.Contains("c@example.com"))
+	})
+}
@@ -0,0 +1,92 @@
+package command
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/xerrors"
+
+	"github.com/brandur/passages-signup/mailclient"
+)
+
+// DeferredMemberAdder retries adding one confirmed signup to the list whose
+// add was deferred by SignupFinisher, like because the mail service's quota
+// was exceeded. It's meant to be run repeatedly by a worker, each time in a
+// new transaction, until it reports that no deferred adds are left or that
+// the quota is still exceeded.
+//
+// The signup is locked while it's added so that multiple workers don't add
+// the same one.
+type DeferredMemberAdder struct {
+	ListAddress string         `validate:"required"`
+	MailAPI     mailclient.API `validate:"required"`
+}
+
+// Run executes the mediator.
+func (c *DeferredMemberAdder) Run(ctx context.Context, tx pgx.Tx) (*DeferredMemberAdderResult, error) {
+	logrus.Debugf("DeferredMemberAdder running")
+
+	if err := validate.Struct(c); err != nil {
+		return nil, xerrors.Errorf("error validating command: %w", err)
+	}
+
+	// Signups that unsubscribed since their add was deferred are left alone.
+	var (
+		email string
+		id    int64
+	)
+	err := tx.QueryRow(ctx, `
+		SELECT id, email
+		FROM signup
+		WHERE member_add_deferred_at IS NOT NULL
+		  AND member_added_at IS NULL
+		  AND completed_at IS NOT NULL
+		  AND unsubscribed_at IS NULL
+		ORDER BY member_add_deferred_at, id
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	`).Scan(&id, &email)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return &DeferredMemberAdderResult{NoneDeferred: true}, nil
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("error querying deferred member adds: %w", err)
+	}
+
+	logger := signupLogger(id)
+
+	logger.Infof("Retrying deferred add of %v to the list", email)
+	err = c.MailAPI.AddMember(ctx, c.ListAddress, email)
+
+	// Still over quota, so there's no point in trying the rest until later.
+	if errors.Is(err, mailclient.ErrQuotaExceeded) {
+		logger.Infof("Mail quota still exceeded; leaving add of %v deferred", email)
+		return &DeferredMemberAdderResult{QuotaExceeded: true}, nil
+	}
+
+	if err != nil {
+		return nil, xerrors.Errorf("error adding email to list: %w", err)
+	}
+
+	if err := NewPgxSignupStore(tx).MarkMemberAdded(ctx, id); err != nil {
+		return nil, err
+	}
+
+	return &DeferredMemberAdderResult{Email: email, MemberAdded: true}, nil
+}
+
+// DeferredMemberAdderResult holds the results of a successful run of
+// DeferredMemberAdder.
+type DeferredMemberAdderResult struct {
+	Email       string
+	MemberAdded bool
+
+	// NoneDeferred is set if there were no deferred adds left to retry.
+	NoneDeferred bool
+
+	// QuotaExceeded is set if the mail service's quota is still exceeded, in
+	// which case the add stays deferred.
+	QuotaExceeded bool
+}
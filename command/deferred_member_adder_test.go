@@ -0,0 +1,145 @@
+package command
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/brandur/passages-signup/mailclient"
+	"github.com/brandur/passages-signup/testhelpers"
+)
+
+func TestDeferredMemberAdder(t *testing.T) {
+	ctx := context.Background()
+
+	insertDeferred := func(t *testing.T, tx pgx.Tx, email string) {
+		t.Helper()
+
+		_, err := tx.Exec(ctx, `
+			INSERT INTO signup
+				(email, token, completed_at, member_add_deferred_at)
+			VALUES
+				($1, $2, NOW(), NOW())
+		`, email, "token-"+email)
+		require.NoError(t, err)
+	}
+
+	t.Run("AddsMember", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			insertDeferred(t, tx, testhelpers.TestEmail)
+
+			mailAPI := mailclient.NewFakeClient()
+			mediator := &DeferredMemberAdder{ListAddress: testListAddress, MailAPI: mailAPI}
+
+			res, err := mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.Equal(t, &DeferredMemberAdderResult{Email: testhelpers.TestEmail, MemberAdded: true}, res)
+
+			require.Len(t, mailAPI.MembersAdded, 1)
+			require.Equal(t, testListAddress, mailAPI.MembersAdded[0].List)
+			require.Equal(t, testhelpers.TestEmail, mailAPI.MembersAdded[0].Email)
+
+			var memberAddDeferredAt, memberAddedAt *time.Time
+			err = tx.QueryRow(ctx, `
+				SELECT member_add_deferred_at, member_added_at
+				FROM signup
+				WHERE email = $1
+			`, testhelpers.TestEmail).Scan(&memberAddDeferredAt, &memberAddedAt)
+			require.NoError(t, err)
+			require.Nil(t, memberAddDeferredAt)
+			require.NotNil(t, memberAddedAt)
+
+			// Nothing is left to retry.
+			res, err = mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.Equal(t, &DeferredMemberAdderResult{NoneDeferred: true}, res)
+			require.Len(t, mailAPI.MembersAdded, 1)
+		})
+	})
+
+	t.Run("NoneDeferred", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			_, err := tx.Exec(ctx, `
+				INSERT INTO signup
+					(email, token, completed_at)
+				VALUES
+					($1, 'test-token', NOW())
+			`, testhelpers.TestEmail)
+			require.NoError(t, err)
+
+			mailAPI := mailclient.NewFakeClient()
+			mediator := &DeferredMemberAdder{ListAddress: testListAddress, MailAPI: mailAPI}
+
+			res, err := mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.Equal(t, &DeferredMemberAdderResult{NoneDeferred: true}, res)
+			require.Empty(t, mailAPI.MembersAdded)
+		})
+	})
+
+	// Unsubscribed since the add was deferred, so not added
+	t.Run("SkipsUnsubscribed", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			insertDeferred(t, tx, testhelpers.TestEmail)
+
+			_, err := tx.Exec(ctx, `
+				UPDATE signup
+				SET unsubscribed_at = NOW()
+			`)
+			require.NoError(t, err)
+
+			mailAPI := mailclient.NewFakeClient()
+			mediator := &DeferredMemberAdder{ListAddress: testListAddress, MailAPI: mailAPI}
+
+			res, err := mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.True(t, res.NoneDeferred)
+			require.Empty(t, mailAPI.MembersAdded)
+		})
+	})
+
+	t.Run("QuotaStillExceeded", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			insertDeferred(t, tx, testhelpers.TestEmail)
+
+			mailAPI := &listFailingClient{
+				FakeClient:  mailclient.NewFakeClient(),
+				err:         xerrors.Errorf("list full: %w", mailclient.ErrQuotaExceeded),
+				failingList: testListAddress,
+			}
+			mediator := &DeferredMemberAdder{ListAddress: testListAddress, MailAPI: mailAPI}
+
+			res, err := mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.Equal(t, &DeferredMemberAdderResult{QuotaExceeded: true}, res)
+
+			var memberAddDeferredAt *time.Time
+			err = tx.QueryRow(ctx, `
+				SELECT member_add_deferred_at
+				FROM signup
+				WHERE email = $1
+			`, testhelpers.TestEmail).Scan(&memberAddDeferredAt)
+			require.NoError(t, err)
+			require.NotNil(t, memberAddDeferredAt)
+		})
+	})
+
+	t.Run("OtherError", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			insertDeferred(t, tx, testhelpers.TestEmail)
+
+			mailAPI := &listFailingClient{
+				FakeClient:  mailclient.NewFakeClient(),
+				failingList: testListAddress,
+			}
+			mediator := &DeferredMemberAdder{ListAddress: testListAddress, MailAPI: mailAPI}
+
+			_, err := mediator.Run(ctx, tx)
+			require.Error(t, err)
+		})
+	})
+}
@@ -0,0 +1,59 @@
+package command
+
+import (
+	"strings"
+)
+
+// EmailBlocklist is a set of email addresses that aren't allowed to sign up,
+// for blocking specific abusive addresses entirely. Addresses are matched in
+// normalized form, so differences in case or surrounding whitespace don't
+// allow a blocked address through.
+//
+// An entry starting with `@` (e.g. `@spam.example`) blocks every address at
+// that domain. Only the exact domain is blocked, and not its subdomains.
+type EmailBlocklist struct {
+	domains map[string]struct{}
+	emails  map[string]struct{}
+}
+
+// NewEmailBlocklist initializes a new EmailBlocklist containing the given
+// email addresses and domain patterns. Empty values are ignored.
+func NewEmailBlocklist(entries []string) *EmailBlocklist {
+	b := &EmailBlocklist{
+		domains: make(map[string]struct{}),
+		emails:  make(map[string]struct{}),
+	}
+
+	for _, entry := range entries {
+		entry = normalizeEmail(entry)
+
+		switch {
+		case entry == "" || entry == "@":
+			continue
+		case strings.HasPrefix(entry, "@"):
+			b.domains[strings.TrimPrefix(entry, "@")] = struct{}{}
+		default:
+			b.emails[entry] = struct{}{}
+		}
+	}
+
+	return b
+}
+
+// Contains checks whether the given email is blocked, either by exact address
+// or by its domain.
+func (b *EmailBlocklist) Contains(email string) bool {
+	email = normalizeEmail(email)
+
+	if _, ok := b.emails[email]; ok {
+		return true
+	}
+
+	i := strings.LastIndex(email, "@")
+	if i == -1 {
+		return false
+	}
+
+	_, ok := b.domains[email[i+1:]]
+	return ok
+}
@@ -0,0 +1,39 @@
+package command
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmailBlocklist(t *testing.T) {
+	blocklist := NewEmailBlocklist([]string{
+		"Blocked@Example.com",
+		" ",
+		"@",
+		"other@example.com ",
+		"@Spam.example",
+	})
+
+	t.Run("ExactMatch", func(t *testing.T) {
+		require.True(t, blocklist.Contains("blocked@example.com"))
+		require.True(t, blocklist.Contains(" BLOCKED@example.com"))
+		require.True(t, blocklist.Contains("other@example.com"))
+	})
+
+	t.Run("DomainMatch", func(t *testing.T) {
+		require.True(t, blocklist.Contains("anyone@spam.example"))
+		require.True(t, blocklist.Contains("Someone.Else@SPAM.example"))
+	})
+
+	t.Run("NearMiss", func(t *testing.T) {
+		require.False(t, blocklist.Contains("allowed@example.com"))
+		require.False(t, blocklist.Contains("blocked@example.co"))
+		require.False(t, blocklist.Contains("anyone@notspam.example"))
+		require.False(t, blocklist.Contains("anyone@sub.spam.example"))
+		require.False(t, blocklist.Contains("spam.example@example.com"))
+		require.False(t, blocklist.Contains("spam.example"))
+		require.False(t, blocklist.Contains(""))
+		require.False(t, blocklist.Contains(" "))
+	})
+}
@@ -0,0 +1,52 @@
+package command
+
+import (
+	"strings"
+)
+
+// EmailCasePolicy controls how the case of an email address is normalized
+// before it's stored or compared with existing signups.
+//
+// The local part of an address (before the `@`) is technically case-sensitive
+// per RFC 5321, but almost every provider treats it case-insensitively. The
+// domain is always case-insensitive.
+type EmailCasePolicy string
+
+const (
+	// EmailCasePolicyLowerAll lowercases the whole address. Treats
+	// differently cased addresses as the same signup, which matches the
+	// behavior of nearly all providers.
+	EmailCasePolicyLowerAll EmailCasePolicy = "lower-all"
+
+	// EmailCasePolicyLowerDomain lowercases only the domain, which is the
+	// only normalization that's always correct. This is the default.
+	EmailCasePolicyLowerDomain EmailCasePolicy = "lower-domain"
+
+	// EmailCasePolicyPreserve leaves addresses exactly as they were entered.
+	// Useful to keep matching signups that were stored before policies
+	// existed, which weren't normalized.
+	EmailCasePolicyPreserve EmailCasePolicy = "preserve"
+)
+
+// Apply normalizes the case of email according to the policy. An empty policy
+// is treated as EmailCasePolicyLowerDomain.
+//
+// It's the one normalizer for emails coming into the app, whether from the
+// signup form, an admin endpoint, or Mailgun, so that they all match the same
+// stored signups.
+func (p EmailCasePolicy) Apply(email string) string {
+	switch p {
+	case EmailCasePolicyLowerAll:
+		return strings.ToLower(email)
+
+	case EmailCasePolicyPreserve:
+		return email
+
+	default:
+		at := strings.LastIndex(email, "@")
+		if at < 0 {
+			return email
+		}
+		return email[:at+1] + strings.ToLower(email[at+1:])
+	}
+}
@@ -0,0 +1,32 @@
+package command
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmailCasePolicyApply(t *testing.T) {
+	testCases := []struct {
+		name   string
+		policy EmailCasePolicy
+		email  string
+		want   string
+	}{
+		{"Preserve", EmailCasePolicyPreserve, "Brandur@Example.COM", "Brandur@Example.COM"},
+		{"LowerDomain", EmailCasePolicyLowerDomain, "Brandur@Example.COM", "Brandur@example.com"},
+		{"LowerAll", EmailCasePolicyLowerAll, "Brandur@Example.COM", "brandur@example.com"},
+		{"Default", "", "Brandur@Example.COM", "Brandur@example.com"},
+
+		// Quoted local parts may contain their own `@`, so only the part
+		// after the last one is the domain.
+		{"LowerDomainQuotedAt", EmailCasePolicyLowerDomain, `"Foo@Bar"@Example.com`, `"Foo@Bar"@example.com`},
+		{"LowerDomainNoAt", EmailCasePolicyLowerDomain, "Not-An-Email", "Not-An-Email"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.want, tc.policy.Apply(tc.email))
+		})
+	}
+}
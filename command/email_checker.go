@@ -0,0 +1,58 @@
+package command
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/brandur/passages-signup/emailvalidator"
+)
+
+// EmailChecker checks that an email address looks deliverable before it's
+// signed up. It's used by SignupStarter, but can also be used to check an
+// email on its own without storing or sending anything.
+type EmailChecker struct {
+	// MaxEmailLength is the maximum length of an email address that will be
+	// accepted. Zero means that only the limits of RFC 5321 apply.
+	MaxEmailLength int
+
+	// MXResolver, if set, is used to check that the email's domain has mail
+	// servers. Failed lookups other than the domain definitively having none
+	// are logged and let through.
+	MXResolver emailvalidator.MXResolver
+}
+
+// Check checks an email address, returning ErrEmailTooLong, ErrInvalidEmail,
+// or ErrNoMailServers if it's not acceptable.
+func (c *EmailChecker) Check(ctx context.Context, email string) error {
+	// Check length first so that absurd values are rejected cheaply and never
+	// stored.
+	if c.MaxEmailLength > 0 && len(email) > c.MaxEmailLength {
+		logrus.Infof("Email longer than %d characters: %.100q", c.MaxEmailLength, email)
+		return ErrEmailTooLong
+	}
+
+	// We know that validation won't detect all invalid email addresses, so to
+	// some extent we'll be relying on Mailgun to do some of that work for us.
+	if err := emailvalidator.Validate(email); err != nil {
+		logrus.Infof("Invalid email %.100q: %v", email, err)
+		if errors.Is(err, emailvalidator.ErrTooLong) {
+			return ErrEmailTooLong
+		}
+		return ErrInvalidEmail
+	}
+
+	if c.MXResolver != nil {
+		err := emailvalidator.ValidateMX(ctx, c.MXResolver, email)
+		switch {
+		case errors.Is(err, emailvalidator.ErrNoMX):
+			logrus.Infof("Email domain has no mail servers: %s", email)
+			return ErrNoMailServers
+		case err != nil:
+			logrus.Errorf("Error checking mail servers; allowing email: %v", err)
+		}
+	}
+
+	return nil
+}
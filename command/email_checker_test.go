@@ -0,0 +1,66 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/brandur/passages-signup/testhelpers"
+)
+
+func TestEmailChecker(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Valid", func(t *testing.T) {
+		require.NoError(t, (&EmailChecker{}).Check(ctx, testhelpers.TestEmail))
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		err := (&EmailChecker{}).Check(ctx, "not-an-email")
+		require.ErrorIs(t, err, ErrInvalidEmail)
+	})
+
+	t.Run("LongerThanMax", func(t *testing.T) {
+		err := (&EmailChecker{MaxEmailLength: 10}).Check(ctx, testhelpers.TestEmail)
+		require.ErrorIs(t, err, ErrEmailTooLong)
+	})
+
+	t.Run("LongerThanRFC", func(t *testing.T) {
+		err := (&EmailChecker{}).Check(ctx, strings.Repeat("a", 65)+"@example.com")
+		require.ErrorIs(t, err, ErrEmailTooLong)
+	})
+
+	t.Run("HasMailServers", func(t *testing.T) {
+		checker := &EmailChecker{MXResolver: &stubMXResolver{
+			records: []*net.MX{{Host: "mx.example.com.", Pref: 10}},
+		}}
+		require.NoError(t, checker.Check(ctx, testhelpers.TestEmail))
+	})
+
+	t.Run("NoMailServers", func(t *testing.T) {
+		checker := &EmailChecker{MXResolver: &stubMXResolver{}}
+		err := checker.Check(ctx, testhelpers.TestEmail)
+		require.ErrorIs(t, err, ErrNoMailServers)
+	})
+
+	// A failed lookup lets the email through rather than rejecting a real
+	// user because of a DNS problem.
+	t.Run("LookupError", func(t *testing.T) {
+		checker := &EmailChecker{MXResolver: &stubMXResolver{err: errors.New("timeout")}}
+		require.NoError(t, checker.Check(ctx, testhelpers.TestEmail))
+	})
+}
+
+// stubMXResolver is an MXResolver that returns canned results.
+type stubMXResolver struct {
+	err     error
+	records []*net.MX
+}
+
+func (r *stubMXResolver) LookupMX(_ context.Context, _ string) ([]*net.MX, error) {
+	return r.records, r.err
+}
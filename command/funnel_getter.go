@@ -0,0 +1,74 @@
+package command
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/xerrors"
+)
+
+// FunnelGetter computes how signups have moved through the signup funnel
+// within a recent window of time: how many were started, how many were
+// confirmed, and what fraction of those started went on to be confirmed.
+type FunnelGetter struct {
+	// Window is how far back from now to look.
+	Window time.Duration `validate:"required,gt=0"`
+}
+
+// Run executes the mediator.
+func (c *FunnelGetter) Run(ctx context.Context, tx pgx.Tx) (*FunnelGetterResult, error) {
+	logrus.Debugf("FunnelGetter running")
+
+	if err := validate.Struct(c); err != nil {
+		return nil, xerrors.Errorf("error validating command: %w", err)
+	}
+
+	// The window is passed as a number of seconds and multiplied into an
+	// interval so that it's never interpolated into the query.
+	windowSeconds := int64(c.Window / time.Second)
+
+	var res FunnelGetterResult
+	var numStartedConfirmed int64
+	err := tx.QueryRow(ctx, `
+		SELECT
+			COUNT(*),
+			COUNT(completed_at)
+		FROM signup
+		WHERE created_at >= NOW() - $1 * INTERVAL '1 second'
+	`, windowSeconds).Scan(&res.NumStarted, &numStartedConfirmed)
+	if err != nil {
+		return nil, xerrors.Errorf("error querying started signups: %w", err)
+	}
+
+	// Signups confirmed in the window may have been started before it, so
+	// they're counted separately.
+	err = tx.QueryRow(ctx, `
+		SELECT COUNT(*)
+		FROM signup
+		WHERE completed_at >= NOW() - $1 * INTERVAL '1 second'
+	`, windowSeconds).Scan(&res.NumConfirmed)
+	if err != nil {
+		return nil, xerrors.Errorf("error querying confirmed signups: %w", err)
+	}
+
+	if res.NumStarted > 0 {
+		res.ConversionRate = float64(numStartedConfirmed) / float64(res.NumStarted)
+	}
+
+	return &res, nil
+}
+
+// FunnelGetterResult holds the results of a successful run of FunnelGetter.
+type FunnelGetterResult struct {
+	// ConversionRate is the ratio of signups started in the window that have
+	// since been confirmed. Zero if no signups were started.
+	ConversionRate float64 `json:"conversion_rate"`
+
+	// NumConfirmed is the number of signups confirmed in the window,
+	// regardless of when they were started.
+	NumConfirmed int64 `json:"num_confirmed"`
+
+	NumStarted int64 `json:"num_started"`
+}
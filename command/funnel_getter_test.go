@@ -0,0 +1,84 @@
+package command
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brandur/passages-signup/testhelpers"
+)
+
+func TestFunnelGetter(t *testing.T) {
+	ctx := context.Background()
+
+	// No signups at all, which must not divide by zero
+	t.Run("Empty", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			// Start from a clean slate in case the test database has data
+			_, err := tx.Exec(ctx, `DELETE FROM signup`)
+			require.NoError(t, err)
+
+			res, err := (&FunnelGetter{Window: 24 * time.Hour}).Run(ctx, tx)
+			require.NoError(t, err)
+
+			require.Equal(t, &FunnelGetterResult{}, res)
+		})
+	})
+
+	// Signups seeded on either side of the window's boundary
+	t.Run("SeededAcrossWindow", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			_, err := tx.Exec(ctx, `DELETE FROM signup`)
+			require.NoError(t, err)
+
+			_, err = tx.Exec(ctx, `
+				INSERT INTO signup
+					(email, token, created_at, completed_at)
+				VALUES
+					-- started and confirmed in the window
+					('a@example.com', 'token-a', NOW() - '1 hour'::interval, NOW() - '30 minutes'::interval),
+
+					-- started in the window, but not confirmed
+					('b@example.com', 'token-b', NOW() - '2 hours'::interval, NULL),
+					('c@example.com', 'token-c', NOW() - '23 hours'::interval, NULL),
+
+					-- started before the window, but confirmed in it
+					('d@example.com', 'token-d', NOW() - '25 hours'::interval, NOW() - '1 hour'::interval),
+
+					-- started and confirmed before the window
+					('e@example.com', 'token-e', NOW() - '3 days'::interval, NOW() - '2 days'::interval),
+					('f@example.com', 'token-f', NOW() - '3 days'::interval, NULL)
+			`)
+			require.NoError(t, err)
+
+			res, err := (&FunnelGetter{Window: 24 * time.Hour}).Run(ctx, tx)
+			require.NoError(t, err)
+
+			require.Equal(t, &FunnelGetterResult{
+				ConversionRate: 1.0 / 3.0,
+				NumConfirmed:   2,
+				NumStarted:     3,
+			}, res)
+
+			// A wider window takes in everything
+			res, err = (&FunnelGetter{Window: 7 * 24 * time.Hour}).Run(ctx, tx)
+			require.NoError(t, err)
+
+			require.Equal(t, &FunnelGetterResult{
+				ConversionRate: 3.0 / 6.0,
+				NumConfirmed:   3,
+				NumStarted:     6,
+			}, res)
+		})
+	})
+
+	t.Run("ValidationError", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			_, err := (&FunnelGetter{Window: -1 * time.Hour}).Run(ctx, tx)
+			require.EqualError(t, err, "error validating command: Window must be greater than 0")
+		})
+	})
+}
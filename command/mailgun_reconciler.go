@@ -0,0 +1,141 @@
+package command
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/xerrors"
+
+	"github.com/brandur/passages-signup/mailclient"
+)
+
+// MailgunReconciler reconciles a page of the members of the mailing list in
+// Mailgun, bringing local signups back in line with them. Mailgun is treated as
+// the source of truth because members can unsubscribe (or be resubscribed by
+// an operator) there without this app ever hearing about it, like when a
+// webhook is missed.
+//
+// It's meant to be run repeatedly, each time in a new transaction, with Cursor
+// set to the previous result's NextCursor until Done is set. That way, a large
+// list doesn't hold one transaction open while every page is fetched.
+//
+// Members unsubscribed in Mailgun have unsubscribed_at set locally, and
+// members subscribed in Mailgun but unsubscribed locally are marked as added
+// again. Members with no completed local signup are only counted.
+type MailgunReconciler struct {
+	// ConfirmedEmailCache is an optional cache of recently confirmed emails.
+	// Members found to be unsubscribed are removed from it. Reconciliation
+	// usually runs in its own process though, so the caches of web processes
+	// aren't updated, and may keep treating a member as subscribed until their
+	// entry expires.
+	ConfirmedEmailCache *ConfirmedEmailCache `validate:"-"`
+
+	// Cursor is the cursor of the page of members to reconcile. Empty starts
+	// from the beginning of the list.
+	Cursor string `validate:"-"`
+
+	// EmailCasePolicy normalizes the emails of members before they're matched
+	// with signups, the same way that they were when signing up.
+	EmailCasePolicy EmailCasePolicy `validate:"omitempty,oneof=preserve lower-domain lower-all"`
+
+	ListAddress string         `validate:"required"`
+	MailAPI     mailclient.API `validate:"required"`
+}
+
+// Run executes the mediator.
+func (c *MailgunReconciler) Run(ctx context.Context, tx pgx.Tx) (*MailgunReconcilerResult, error) {
+	logrus.Debugf("MailgunReconciler running")
+
+	if err := validate.Struct(c); err != nil {
+		return nil, xerrors.Errorf("error validating command: %w", err)
+	}
+
+	members, nextCursor, err := c.MailAPI.ListMembers(ctx, c.ListAddress, c.Cursor)
+	if err != nil {
+		return nil, xerrors.Errorf("error listing members: %w", err)
+	}
+
+	res := &MailgunReconcilerResult{
+		Done:       nextCursor == "",
+		NextCursor: nextCursor,
+	}
+
+	for _, member := range members {
+		if err := c.reconcile(ctx, tx, member, res); err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+func (c *MailgunReconciler) reconcile(ctx context.Context, tx pgx.Tx, member *mailclient.Member,
+	res *MailgunReconcilerResult,
+) error {
+	res.NumMembers++
+
+	email := c.EmailCasePolicy.Apply(member.Email)
+
+	var id int64
+	var unsubscribed bool
+	err := tx.QueryRow(ctx, `
+		SELECT id, unsubscribed_at IS NOT NULL
+		FROM signup
+		WHERE email = $1
+		  AND completed_at IS NOT NULL
+	`, email).Scan(&id, &unsubscribed)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		logrus.Debugf("%v is a member in Mailgun but has no completed signup", email)
+		res.NumNotFound++
+		return nil
+	}
+
+	if err != nil {
+		return xerrors.Errorf("error querying signup: %w", err)
+	}
+
+	switch {
+	case !member.Subscribed && !unsubscribed:
+		logrus.Infof("%v unsubscribed in Mailgun; recording unsubscribe", email)
+		_, err := tx.Exec(ctx, `
+			UPDATE signup
+			SET unsubscribed_at = NOW()
+			WHERE id = $1
+		`, id)
+		if err != nil {
+			return xerrors.Errorf("error recording unsubscribe: %w", err)
+		}
+		if c.ConfirmedEmailCache != nil {
+			c.ConfirmedEmailCache.Remove(email)
+		}
+		res.NumUnsubscribed++
+
+	case member.Subscribed && unsubscribed:
+		logrus.Infof("%v subscribed in Mailgun; recording resubscribe", email)
+		if err := NewPgxSignupStore(tx).MarkMemberAdded(ctx, id); err != nil {
+			return err
+		}
+		res.NumResubscribed++
+	}
+
+	return nil
+}
+
+// MailgunReconcilerResult holds the results of a successful run of
+// MailgunReconciler.
+type MailgunReconcilerResult struct {
+	// Done is set if this was the last page of members.
+	Done bool
+
+	// NextCursor is the cursor of the next page of members, to be used as
+	// Cursor on the next run.
+	NextCursor string
+
+	NumMembers      int
+	NumNotFound     int
+	NumResubscribed int
+	NumUnsubscribed int
+}
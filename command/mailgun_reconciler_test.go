@@ -0,0 +1,174 @@
+package command
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brandur/passages-signup/mailclient"
+	"github.com/brandur/passages-signup/testhelpers"
+)
+
+func TestMailgunReconciler(t *testing.T) {
+	ctx := context.Background()
+
+	// Produces a fake client whose list contains a subscribed member, an
+	// unsubscribed member, and a member with no local signup. The page size is
+	// small so that reconciling has to page through the list.
+	mailAPI := func(t *testing.T) *mailclient.FakeClient {
+		t.Helper()
+
+		mailAPI := mailclient.NewFakeClient()
+		mailAPI.ListMembersPageSize = 2
+		for _, email := range []string{"subscribed@example.com", "unsubscribed@example.com", "unknown@example.com"} {
+			require.NoError(t, mailAPI.AddMember(ctx, testListAddress, email))
+		}
+		require.NoError(t, mailAPI.UnsubscribeMember(ctx, testListAddress, "unsubscribed@example.com"))
+		return mailAPI
+	}
+
+	// Runs the mediator over every page of the list the way the server does,
+	// returning the totals across pages.
+	reconcile := func(t *testing.T, tx pgx.Tx, mediator *MailgunReconciler) *MailgunReconcilerResult {
+		t.Helper()
+
+		total := &MailgunReconcilerResult{}
+		for numPages := 1; ; numPages++ {
+			require.LessOrEqual(t, numPages, 2, "reconcile should have finished")
+
+			res, err := mediator.Run(ctx, tx)
+			require.NoError(t, err)
+
+			total.NumMembers += res.NumMembers
+			total.NumNotFound += res.NumNotFound
+			total.NumResubscribed += res.NumResubscribed
+			total.NumUnsubscribed += res.NumUnsubscribed
+
+			if res.Done {
+				require.Empty(t, res.NextCursor)
+				total.Done = true
+				return total
+			}
+			mediator.Cursor = res.NextCursor
+		}
+	}
+
+	unsubscribedAt := func(t *testing.T, tx pgx.Tx, email string) *time.Time {
+		t.Helper()
+
+		var unsubscribedAt *time.Time
+		err := tx.QueryRow(ctx, `
+			SELECT unsubscribed_at
+			FROM signup
+			WHERE email = $1
+		`, email).Scan(&unsubscribedAt)
+		require.NoError(t, err)
+		return unsubscribedAt
+	}
+
+	t.Run("Reconciles", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			_, err := tx.Exec(ctx, `
+				INSERT INTO signup
+					(email, token, completed_at, member_added_at, unsubscribed_at)
+				VALUES
+					('subscribed@example.com', 'token-1', NOW(), NULL, NOW()),
+					('unsubscribed@example.com', 'token-2', NOW(), NOW(), NULL)
+			`)
+			require.NoError(t, err)
+
+			res := reconcile(t, tx, &MailgunReconciler{ListAddress: testListAddress, MailAPI: mailAPI(t)})
+			require.Equal(t, &MailgunReconcilerResult{
+				Done:            true,
+				NumMembers:      3,
+				NumNotFound:     1,
+				NumResubscribed: 1,
+				NumUnsubscribed: 1,
+			}, res)
+
+			require.Nil(t, unsubscribedAt(t, tx, "subscribed@example.com"))
+			require.NotNil(t, unsubscribedAt(t, tx, "unsubscribed@example.com"))
+		})
+	})
+
+	t.Run("AlreadyInSync", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			_, err := tx.Exec(ctx, `
+				INSERT INTO signup
+					(email, token, completed_at, member_added_at, unsubscribed_at)
+				VALUES
+					('subscribed@example.com', 'token-1', NOW(), NOW(), NULL),
+					('unsubscribed@example.com', 'token-2', NOW(), NOW(), NOW())
+			`)
+			require.NoError(t, err)
+
+			res := reconcile(t, tx, &MailgunReconciler{ListAddress: testListAddress, MailAPI: mailAPI(t)})
+			require.Equal(t, &MailgunReconcilerResult{Done: true, NumMembers: 3, NumNotFound: 1}, res)
+		})
+	})
+
+	t.Run("RemovesUnsubscribedFromCache", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			_, err := tx.Exec(ctx, `
+				INSERT INTO signup
+					(email, token, completed_at, member_added_at)
+				VALUES
+					('unsubscribed@example.com', 'token-1', NOW(), NOW())
+			`)
+			require.NoError(t, err)
+
+			cache := NewConfirmedEmailCache(10, time.Hour)
+			cache.Add("unsubscribed@example.com")
+
+			res := reconcile(t, tx, &MailgunReconciler{
+				ConfirmedEmailCache: cache,
+				ListAddress:         testListAddress,
+				MailAPI:             mailAPI(t),
+			})
+			require.Equal(t, 1, res.NumUnsubscribed)
+			require.False(t, cache.Contains("unsubscribed@example.com"))
+		})
+	})
+
+	t.Run("EmailCasePolicy", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			_, err := tx.Exec(ctx, `
+				INSERT INTO signup
+					(email, token, completed_at, member_added_at, unsubscribed_at)
+				VALUES
+					('mixed@example.com', 'token-1', NOW(), NOW(), NOW())
+			`)
+			require.NoError(t, err)
+
+			mailAPI := mailclient.NewFakeClient()
+			require.NoError(t, mailAPI.AddMember(ctx, testListAddress, "Mixed@Example.COM"))
+
+			res := reconcile(t, tx, &MailgunReconciler{
+				EmailCasePolicy: EmailCasePolicyLowerAll,
+				ListAddress:     testListAddress,
+				MailAPI:         mailAPI,
+			})
+			require.Equal(t, 1, res.NumResubscribed)
+			require.Nil(t, unsubscribedAt(t, tx, "mixed@example.com"))
+		})
+	})
+
+	t.Run("IgnoresPendingSignups", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			_, err := tx.Exec(ctx, `
+				INSERT INTO signup
+					(email, token)
+				VALUES
+					('unsubscribed@example.com', 'token-1')
+			`)
+			require.NoError(t, err)
+
+			res := reconcile(t, tx, &MailgunReconciler{ListAddress: testListAddress, MailAPI: mailAPI(t)})
+			require.Equal(t, 3, res.NumNotFound)
+			require.Nil(t, unsubscribedAt(t, tx, "unsubscribed@example.com"))
+		})
+	})
+}
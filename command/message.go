@@ -0,0 +1,104 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"strings"
+
+	"github.com/aymerick/douceur/inliner"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/xerrors"
+
+	"github.com/brandur/passages-signup/mailclient"
+	"github.com/brandur/passages-signup/ptemplate"
+)
+
+// ConfirmationLocals returns the locals that the confirmation message is
+// rendered with for the given token, including a link to view it in a browser
+// if previewLink is set. It's shared with the admin preview endpoint so that
+// the preview matches what was sent.
+func ConfirmationLocals(renderer *ptemplate.Renderer, token string, previewLink bool) map[string]interface{} {
+	locals := map[string]interface{}{
+		"token": token,
+	}
+	if previewLink {
+		locals["previewURL"] = renderer.PublicURL + "/admin/preview/" + token
+	}
+	return locals
+}
+
+//
+// Private functions
+//
+
+// confirmationSubject returns the subject of the message asking a new signup
+// to confirm their email.
+func confirmationSubject(newsletterName string) string {
+	return newsletterName + " signup confirmation"
+}
+
+// renderMessage renders an email from a pair of templates: an HTML version at
+// templateFile and a plain text version at templateFile suffixed with
+// `_plain`. CSS in the HTML version is inlined because that's the only way
+// mail clients will support it.
+//
+// If the plain text version fails to render, one is derived from the HTML
+// version instead so that the message can still be sent.
+func renderMessage(renderer *ptemplate.Renderer, templateFile string, locals map[string]interface{}) (string, string, error) {
+	buf := new(bytes.Buffer)
+	plainErr := renderer.RenderTemplate(buf, templateFile+"_plain", locals)
+	contentsPlain := strings.TrimSpace(buf.String())
+
+	buf = new(bytes.Buffer)
+	err := renderer.RenderTemplate(buf, templateFile, locals)
+	if err != nil {
+		return "", "", xerrors.Errorf("error rendering message (HTML): %w", err)
+	}
+
+	contentsHTML, err := inliner.Inline(buf.String())
+	if err != nil {
+		return "", "", xerrors.Errorf("error inlining CSS styling: %w", err)
+	}
+
+	if plainErr != nil {
+		logrus.Errorf("Error rendering message (plain), deriving it from HTML instead: %v", plainErr)
+
+		contentsPlain, err = ptemplate.HTMLToPlain(contentsHTML)
+		if err != nil {
+			return "", "", xerrors.Errorf("error rendering message (plain): %w", plainErr)
+		}
+	}
+
+	return contentsHTML, contentsPlain, nil
+}
+
+// sendWelcomeMessage sends a message welcoming a newly confirmed subscriber
+// to the list. It's shared between finishing a signup and an administrator
+// re-sending the message. token is the signup's token, which is used to build
+// the one-click unsubscribe link advertised in the message's headers.
+func sendWelcomeMessage(ctx context.Context, mailAPI mailclient.API, renderer *ptemplate.Renderer,
+	listAddress, fromAddress, replyToAddress, email, token string,
+) error {
+	contentsHTML, contentsPlain, err := renderMessage(renderer, "views/messages/welcome", map[string]interface{}{})
+	if err != nil {
+		return xerrors.Errorf("error rendering welcome email: %w", err)
+	}
+
+	return mailAPI.SendMessage(ctx, &mailclient.SendMessageParams{
+		ContentsHTML:   contentsHTML,
+		ContentsPlain:  contentsPlain,
+		FromAddress:    fromAddress,
+		ListAddress:    listAddress,
+		NewsletterName: renderer.NewsletterMeta.Name,
+		Recipient:      email,
+		ReplyTo:        replyToAddress,
+		Subject:        welcomeSubject(renderer.NewsletterMeta.Name),
+		UnsubscribeURL: renderer.PublicURL + "/unsubscribe/" + token,
+	})
+}
+
+// welcomeSubject returns the subject of the message welcoming a newly
+// confirmed subscriber.
+func welcomeSubject(newsletterName string) string {
+	return "Welcome to " + newsletterName
+}
@@ -0,0 +1,68 @@
+package command
+
+import (
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/brandur/passages-signup/newslettermeta"
+	"github.com/brandur/passages-signup/ptemplate"
+)
+
+func TestConfirmationLocals(t *testing.T) {
+	require.Equal(t, map[string]interface{}{"token": "test-token"},
+		ConfirmationLocals(renderer, "test-token", false))
+	require.Equal(t, map[string]interface{}{
+		"previewURL": "https://passages.example.com/admin/preview/test-token",
+		"token":      "test-token",
+	}, ConfirmationLocals(renderer, "test-token", true))
+}
+
+func TestRenderMessage(t *testing.T) {
+	locals := map[string]interface{}{"token": "test-token"}
+
+	t.Run("Plain", func(t *testing.T) {
+		_, contentsPlain, err := renderMessage(renderer, "views/messages/confirm", locals)
+		require.NoError(t, err)
+
+		// The hand-written plain version puts the link on its own line.
+		require.Contains(t, contentsPlain, "\n    https://passages.example.com/confirm/test-token\n")
+	})
+
+	t.Run("PlainFallback", func(t *testing.T) {
+		renderer, err := ptemplate.NewRenderer(&ptemplate.RendererConfig{
+			DynamicReload:  true,
+			NewsletterMeta: newslettermeta.MustMetaFor("list.brandur.org", newslettermeta.PassagesID),
+			PublicURL:      "https://passages.example.com",
+			Templates:      &noPlainTemplatesFS{os.DirFS("..")},
+		})
+		require.NoError(t, err)
+
+		contentsHTML, contentsPlain, err := renderMessage(renderer, "views/messages/confirm", locals)
+		require.NoError(t, err)
+		require.Contains(t, contentsHTML, "<html")
+		require.NotContains(t, contentsPlain, "<")
+		require.Contains(t, contentsPlain,
+			"confirm by clicking here (https://passages.example.com/confirm/test-token)")
+	})
+}
+
+//
+// Private types
+//
+
+// noPlainTemplatesFS hides plain text message templates to simulate one that
+// fails to render.
+type noPlainTemplatesFS struct {
+	fs.FS
+}
+
+func (f *noPlainTemplatesFS) Open(name string) (fs.File, error) {
+	if strings.HasSuffix(name, "_plain.ace") {
+		return nil, fs.ErrNotExist
+	}
+	return f.FS.Open(name)
+}
@@ -0,0 +1,45 @@
+package command
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/xerrors"
+)
+
+// OutboxCounter counts unsent messages in the outbox to gauge how far behind
+// sending is.
+type OutboxCounter struct{}
+
+// Run executes the mediator.
+func (c *OutboxCounter) Run(ctx context.Context, tx pgx.Tx) (*OutboxCounterResult, error) {
+	logrus.Debugf("OutboxCounter running")
+
+	// Abandoned messages will never be sent, so they're counted separately
+	// from pending ones to be noticed and looked into.
+	var res OutboxCounterResult
+	err := tx.QueryRow(ctx, `
+		SELECT
+			COUNT(*) FILTER (WHERE num_attempts >= $1),
+			COUNT(*) FILTER (WHERE num_attempts < $1)
+		FROM outbox_message
+		WHERE sent_at IS NULL
+	`, maxOutboxAttempts).Scan(&res.NumAbandoned, &res.NumPending)
+	if err != nil {
+		return nil, xerrors.Errorf("error counting outbox messages: %w", err)
+	}
+
+	return &res, nil
+}
+
+// OutboxCounterResult holds the results of a successful run of OutboxCounter.
+type OutboxCounterResult struct {
+	// NumAbandoned is the number of messages that failed to send too many
+	// times and won't be tried again.
+	NumAbandoned int64
+
+	// NumPending is the number of messages waiting to be sent, including
+	// ones being retried.
+	NumPending int64
+}
@@ -0,0 +1,37 @@
+package command
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brandur/passages-signup/testhelpers"
+)
+
+func TestOutboxCounter(t *testing.T) {
+	ctx := context.Background()
+
+	testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+		// Start from a clean slate in case the test database has data
+		_, err := tx.Exec(ctx, `DELETE FROM outbox_message`)
+		require.NoError(t, err)
+
+		// One sent, one pending, one being retried, and one abandoned.
+		_, err = tx.Exec(ctx, `
+			INSERT INTO outbox_message
+				(params, send_after, num_attempts, sent_at)
+			VALUES
+				('{}', NOW(), 1, NOW()),
+				('{}', NOW(), 0, NULL),
+				('{}', NOW(), 2, NULL),
+				('{}', NOW(), $1, NULL)
+		`, maxOutboxAttempts)
+		require.NoError(t, err)
+
+		res, err := (&OutboxCounter{}).Run(ctx, tx)
+		require.NoError(t, err)
+		require.Equal(t, &OutboxCounterResult{NumAbandoned: 1, NumPending: 2}, res)
+	})
+}
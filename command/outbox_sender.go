@@ -0,0 +1,125 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/xerrors"
+
+	"github.com/brandur/passages-signup/mailclient"
+)
+
+const (
+	// maxOutboxAttempts is the number of times that sending an outbox message
+	// is tried before it's abandoned.
+	maxOutboxAttempts = 5
+
+	// outboxRetryBackoff is how long after a first failed attempt an outbox
+	// message is retried. It doubles with each attempt after that.
+	outboxRetryBackoff = 1 * time.Minute
+)
+
+// OutboxSender sends the next message from the outbox whose send_after time
+// has passed. It's meant to be run repeatedly by a worker, each time in a new
+// transaction, until it reports that no messages are due.
+//
+// The message is locked while it's sent so that multiple workers don't send
+// the same one. A message that fails to send is retried with backoff on later
+// runs up to maxOutboxAttempts times, after which it's abandoned.
+type OutboxSender struct {
+	MailAPI mailclient.API `validate:"required"`
+}
+
+// Run executes the mediator.
+func (c *OutboxSender) Run(ctx context.Context, tx pgx.Tx) (*OutboxSenderResult, error) {
+	logrus.Debugf("OutboxSender running")
+
+	if err := validate.Struct(c); err != nil {
+		return nil, xerrors.Errorf("error validating command: %w", err)
+	}
+
+	var (
+		data        []byte
+		id          int64
+		numAttempts int
+		params      *mailclient.SendMessageParams
+	)
+	err := tx.QueryRow(ctx, `
+		SELECT id, num_attempts, params
+		FROM outbox_message
+		WHERE sent_at IS NULL
+		  AND send_after <= NOW()
+		  AND num_attempts < $1
+		ORDER BY send_after, id
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	`, maxOutboxAttempts).Scan(&id, &numAttempts, &data)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return &OutboxSenderResult{NoneDue: true}, nil
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("error querying outbox messages: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &params); err != nil {
+		return nil, xerrors.Errorf("error decoding outbox message %d: %w", id, err)
+	}
+
+	numAttempts++
+
+	sendErr := c.MailAPI.SendMessage(ctx, params)
+	if sendErr == nil {
+		_, err := tx.Exec(ctx, `
+			UPDATE outbox_message
+			SET
+			  num_attempts = $1,
+			  sent_at = NOW()
+			WHERE id = $2
+		`, numAttempts, id)
+		if err != nil {
+			return nil, xerrors.Errorf("error updating outbox message: %w", err)
+		}
+
+		return &OutboxSenderResult{Sent: true}, nil
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE outbox_message
+		SET
+		  num_attempts = $1,
+		  send_after = $2
+		WHERE id = $3
+	`, numAttempts, time.Now().Add(outboxRetryBackoff<<(numAttempts-1)), id)
+	if err != nil {
+		return nil, xerrors.Errorf("error updating outbox message: %w", err)
+	}
+
+	if numAttempts >= maxOutboxAttempts {
+		logrus.Errorf("Abandoning outbox message %d to %v after %d attempt(s): %v",
+			id, params.Recipient, numAttempts, sendErr)
+		return &OutboxSenderResult{Abandoned: true}, nil
+	}
+
+	logrus.Errorf("Error sending outbox message %d to %v (attempt %d); will retry: %v",
+		id, params.Recipient, numAttempts, sendErr)
+	return &OutboxSenderResult{Failed: true}, nil
+}
+
+// OutboxSenderResult holds the results of a successful run of OutboxSender.
+type OutboxSenderResult struct {
+	// Abandoned is set if the message failed to send for the last time, so
+	// it won't be tried again.
+	Abandoned bool
+
+	// Failed is set if the message failed to send, but will be retried.
+	Failed bool
+
+	// NoneDue is set if there were no messages due to be sent.
+	NoneDue bool
+
+	Sent bool
+}
@@ -0,0 +1,115 @@
+package command
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/brandur/passages-signup/mailclient"
+	"github.com/brandur/passages-signup/testhelpers"
+)
+
+func TestOutboxSender(t *testing.T) {
+	ctx := context.Background()
+
+	params := &mailclient.SendMessageParams{
+		ContentsHTML:   "<p>Hello</p>",
+		ContentsPlain:  "Hello",
+		ListAddress:    testListAddress,
+		NewsletterName: "Passages & Glass",
+		Recipient:      testhelpers.TestEmail,
+		ReplyTo:        testReplyToAddress,
+		Subject:        "Hello",
+	}
+
+	enqueue := func(t *testing.T, tx pgx.Tx, sendAfter time.Time) {
+		t.Helper()
+		require.NoError(t, NewPgxSignupStore(tx).EnqueueMessage(ctx, params, sendAfter))
+	}
+
+	// A message isn't sent until its send_after has passed
+	t.Run("NotSentBeforeSendAfter", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			enqueue(t, tx, time.Now().Add(time.Hour))
+
+			mailAPI := mailclient.NewFakeClient()
+			mediator := &OutboxSender{MailAPI: mailAPI}
+
+			res, err := mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.Equal(t, &OutboxSenderResult{NoneDue: true}, res)
+			require.Empty(t, mailAPI.MessagesSent)
+		})
+	})
+
+	t.Run("SentAfterSendAfter", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			enqueue(t, tx, time.Now().Add(-time.Second))
+
+			mailAPI := mailclient.NewFakeClient()
+			mediator := &OutboxSender{MailAPI: mailAPI}
+
+			res, err := mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.Equal(t, &OutboxSenderResult{Sent: true}, res)
+
+			require.Len(t, mailAPI.MessagesSent, 1)
+			require.Equal(t, params.Recipient, mailAPI.MessagesSent[0].Recipient)
+			require.Equal(t, params.Subject, mailAPI.MessagesSent[0].Subject)
+
+			// Sent messages aren't sent again.
+			res, err = mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.Equal(t, &OutboxSenderResult{NoneDue: true}, res)
+			require.Len(t, mailAPI.MessagesSent, 1)
+		})
+	})
+
+	// A failed message is retried with backoff, but only so many times
+	t.Run("Failure", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			enqueue(t, tx, time.Now().Add(-time.Second))
+
+			mediator := &OutboxSender{MailAPI: &sendFailingClient{mailclient.NewFakeClient()}}
+
+			for i := 1; i <= maxOutboxAttempts; i++ {
+				res, err := mediator.Run(ctx, tx)
+				require.NoError(t, err)
+				if i < maxOutboxAttempts {
+					require.Equal(t, &OutboxSenderResult{Failed: true}, res)
+				} else {
+					require.Equal(t, &OutboxSenderResult{Abandoned: true}, res)
+				}
+
+				// Not retried until its backoff has passed.
+				res, err = mediator.Run(ctx, tx)
+				require.NoError(t, err)
+				require.Equal(t, &OutboxSenderResult{NoneDue: true}, res)
+
+				_, err = tx.Exec(ctx, `
+					UPDATE outbox_message
+					SET send_after = NOW() - '1 second'::interval
+				`)
+				require.NoError(t, err)
+			}
+
+			// Abandoned, so never tried again.
+			res, err := mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.Equal(t, &OutboxSenderResult{NoneDue: true}, res)
+		})
+	})
+}
+
+// sendFailingClient is a fake mail client that fails to send messages.
+type sendFailingClient struct {
+	*mailclient.FakeClient
+}
+
+func (c *sendFailingClient) SendMessage(_ context.Context, params *mailclient.SendMessageParams) error {
+	return xerrors.Errorf("error sending to: %s", params.Recipient)
+}
@@ -0,0 +1,109 @@
+package command
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/xerrors"
+
+	"github.com/brandur/passages-signup/mailclient"
+	"github.com/brandur/passages-signup/ptemplate"
+)
+
+// Templates that can be sent by SampleMessageSender.
+const (
+	SampleMessageConfirm = "confirm"
+	SampleMessageWelcome = "welcome"
+)
+
+// sampleMessages maps the names of messages that can be sent by
+// SampleMessageSender to their template, sample data, and a function that
+// produces their subject given the newsletter's name. Subject functions are
+// shared with the senders of the real messages so that the two never drift.
+var sampleMessages = map[string]struct {
+	templateFile string
+	locals       map[string]interface{}
+	subject      func(name string) string
+}{
+	SampleMessageConfirm: {
+		"views/messages/confirm",
+		map[string]interface{}{"token": "sample-token"},
+		confirmationSubject,
+	},
+	SampleMessageWelcome: {
+		"views/messages/welcome",
+		map[string]interface{}{},
+		welcomeSubject,
+	},
+}
+
+// SampleMessageSender renders one of the messages normally sent to
+// subscribers with sample data and sends it to an arbitrary address. It's
+// used by administrators to check what a message looks like in a real mail
+// client before a campaign or after changing a template.
+//
+// It doesn't touch the database, so tx may be nil.
+type SampleMessageSender struct {
+	FromAddress string              `validate:"omitempty,email"`
+	ListAddress string              `validate:"required"`
+	MailAPI     mailclient.API      `validate:"required"`
+	Recipient   string              `validate:"required,email"`
+	Renderer    *ptemplate.Renderer `validate:"required"`
+
+	// ReplyToAddress is an optional address for replies. If empty, replies
+	// go to the list address.
+	ReplyToAddress string `validate:"-"`
+
+	// Template is the name of the message to send, like
+	// SampleMessageConfirm.
+	Template string `validate:"required"`
+}
+
+// Run executes the mediator.
+func (c *SampleMessageSender) Run(ctx context.Context, _ pgx.Tx) (*SampleMessageSenderResult, error) {
+	logrus.Debugf("SampleMessageSender running")
+
+	if err := validate.Struct(c); err != nil {
+		return nil, xerrors.Errorf("error validating command: %w", err)
+	}
+
+	message, ok := sampleMessages[c.Template]
+	if !ok {
+		return &SampleMessageSenderResult{UnknownTemplate: true}, nil
+	}
+
+	contentsHTML, contentsPlain, err := renderMessage(c.Renderer, message.templateFile, message.locals)
+	if err != nil {
+		return nil, xerrors.Errorf("error rendering sample message: %w", err)
+	}
+
+	logrus.Infof("Sending sample %v mail to %v\n", c.Template, c.Recipient)
+	err = c.MailAPI.SendMessage(ctx, &mailclient.SendMessageParams{
+		ContentsHTML:   contentsHTML,
+		ContentsPlain:  contentsPlain,
+		FromAddress:    c.FromAddress,
+		ListAddress:    c.ListAddress,
+		NewsletterName: c.Renderer.NewsletterMeta.Name,
+		Recipient:      c.Recipient,
+		ReplyTo:        c.ReplyToAddress,
+
+		// Marked so that a sample isn't mistaken for the real thing.
+		Subject: "[Test] " + message.subject(c.Renderer.NewsletterMeta.Name),
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("error sending sample message: %w", err)
+	}
+
+	return &SampleMessageSenderResult{MessageSent: true}, nil
+}
+
+// SampleMessageSenderResult holds the results of a successful run of
+// SampleMessageSender.
+type SampleMessageSenderResult struct {
+	MessageSent bool `json:"message_sent"`
+
+	// UnknownTemplate is set if Template isn't the name of a message that can
+	// be sent.
+	UnknownTemplate bool `json:"-"`
+}
@@ -0,0 +1,69 @@
+package command
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/brandur/passages-signup/mailclient"
+	"github.com/brandur/passages-signup/testhelpers"
+)
+
+func TestSampleMessageSender(t *testing.T) {
+	ctx := context.Background()
+
+	sampleMessageSender := func(mailAPI mailclient.API, template string) *SampleMessageSender {
+		return &SampleMessageSender{
+			ListAddress:    testListAddress,
+			MailAPI:        mailAPI,
+			Recipient:      testhelpers.TestEmail,
+			Renderer:       renderer,
+			ReplyToAddress: testReplyToAddress,
+			Template:       template,
+		}
+	}
+
+	t.Run("Confirm", func(t *testing.T) {
+		mailAPI := mailclient.NewFakeClient()
+		res, err := sampleMessageSender(mailAPI, SampleMessageConfirm).Run(ctx, nil)
+		require.NoError(t, err)
+		require.True(t, res.MessageSent)
+
+		require.Len(t, mailAPI.MessagesSent, 1)
+		require.Equal(t, testhelpers.TestEmail, mailAPI.MessagesSent[0].Recipient)
+		require.Equal(t, "[Test] "+renderer.NewsletterMeta.Name+" signup confirmation",
+			mailAPI.MessagesSent[0].Subject)
+		require.Contains(t, mailAPI.MessagesSent[0].ContentsPlain, "/confirm/sample-token")
+	})
+
+	t.Run("Welcome", func(t *testing.T) {
+		mailAPI := mailclient.NewFakeClient()
+		res, err := sampleMessageSender(mailAPI, SampleMessageWelcome).Run(ctx, nil)
+		require.NoError(t, err)
+		require.True(t, res.MessageSent)
+
+		require.Len(t, mailAPI.MessagesSent, 1)
+		require.Equal(t, "[Test] Welcome to "+renderer.NewsletterMeta.Name, mailAPI.MessagesSent[0].Subject)
+	})
+
+	t.Run("InvalidRecipient", func(t *testing.T) {
+		mailAPI := mailclient.NewFakeClient()
+		mediator := sampleMessageSender(mailAPI, SampleMessageConfirm)
+		mediator.Recipient = "not-an-email"
+		_, err := mediator.Run(ctx, nil)
+		require.Error(t, err)
+
+		require.Empty(t, mailAPI.MessagesSent)
+	})
+
+	t.Run("UnknownTemplate", func(t *testing.T) {
+		mailAPI := mailclient.NewFakeClient()
+		res, err := sampleMessageSender(mailAPI, "views/messages/confirm").Run(ctx, nil)
+		require.NoError(t, err)
+		require.True(t, res.UnknownTemplate)
+		require.False(t, res.MessageSent)
+
+		require.Empty(t, mailAPI.MessagesSent)
+	})
+}
@@ -9,35 +9,101 @@ import (
 	"golang.org/x/xerrors"
 
 	"github.com/brandur/passages-signup/mailclient"
+	"github.com/brandur/passages-signup/ptemplate"
+	"github.com/brandur/passages-signup/signedtoken"
 )
 
+// maxMemberAddAttempts is the maximum number of times that an email will be
+// added to the list when VerifyMemberAdd is on and the mail service keeps
+// reporting that it's not a member after a successful add.
+const maxMemberAddAttempts = 3
+
 // SignupFinisher takes an email that's already started the signup process and
 // fully adds it to the mailing list. It does this based on Token, which is
 // received through a secret URL.
+//
+// The email may optionally be added to a set of related lists as well. Failure
+// to add to the main list is an error, but failures on extra lists are only
+// reported in the result so that the main signup still goes through.
 type SignupFinisher struct {
+	// ConfirmedEmailCache is an optional cache of recently confirmed emails.
+	// If set, the email is added to it after a successful finish.
+	ConfirmedEmailCache *ConfirmedEmailCache `validate:"-"`
+
+	// ExtraListAddresses are addresses of additional lists that the email
+	// should be added to along with the one at ListAddress.
+	ExtraListAddresses []string `validate:"-"`
+
+	FromAddress string         `validate:"omitempty,email"`
 	ListAddress string         `validate:"required"`
 	MailAPI     mailclient.API `validate:"required"`
-	Token       string         `validate:"required"`
+
+	// NotifyWebhook enqueues a subscriber.confirmed webhook in the outbox when
+	// a signup is newly confirmed. It's only sent once the transaction
+	// commits, so a confirmation that's rolled back never notifies.
+	NotifyWebhook bool `validate:"-"`
+
+	// Renderer and ReplyToAddress are used to send a welcome message.
+	// Renderer is required if SendWelcome is set, but ReplyToAddress is
+	// optional, and if empty, replies go to the list address.
+	Renderer       *ptemplate.Renderer `validate:"required_if=SendWelcome true"`
+	ReplyToAddress string              `validate:"-"`
+
+	// SendWelcome sends a welcome message after the email is added to the
+	// list. Failing to send it doesn't fail the signup.
+	SendWelcome bool `validate:"-"`
+
+	// Store is where signups are read and written. Defaults to Postgres
+	// through the transaction that the command is run with.
+	Store SignupStore `validate:"-"`
+
+	Token string `validate:"required"`
+
+	// TokenSigner, if set, is used to verify signed tokens, which are checked
+	// for expiry before touching the database. Unsigned tokens from before
+	// signing was enabled continue to be looked up directly.
+	TokenSigner *signedtoken.Signer `validate:"-"`
+
+	// VerifyMemberAdd checks with the mail service that the email is actually
+	// a member of the list after adding it, and retries the add if it's not.
+	// Guards against the mail service reporting success for an add that
+	// didn't take.
+	VerifyMemberAdd bool `validate:"-"`
 }
 
 // Run executes the mediator.
 func (c *SignupFinisher) Run(ctx context.Context, tx pgx.Tx) (*SignupFinisherResult, error) {
-	logrus.Infof("SignupFinisher running")
+	logrus.Debugf("SignupFinisher running")
 
 	if err := validate.Struct(c); err != nil {
 		return nil, xerrors.Errorf("error validating command: %w", err)
 	}
 
-	var id *int64
-	var email *string
-	err := tx.QueryRow(ctx, `
-		SELECT id, email
-		FROM signup
-		WHERE token = $1
-	`, c.Token).Scan(&id, &email)
+	store := signupStoreOrDefault(c.Store, tx)
+
+	// By default, look up the signup by token. A signed token instead carries
+	// the signup's ID.
+	find := func() (*Signup, error) { return store.FindByToken(ctx, c.Token) }
+	if c.TokenSigner != nil {
+		signupID, err := c.TokenSigner.Decode(c.Token)
+		switch {
+		case err == nil:
+			find = func() (*Signup, error) { return store.FindByID(ctx, signupID) }
+		case errors.Is(err, signedtoken.ErrExpired):
+			return &SignupFinisherResult{TokenExpired: true}, nil
+		case errors.Is(err, signedtoken.ErrInvalidSignature):
+			logrus.Infof("Invalid token signature: %v", c.Token)
+			return &SignupFinisherResult{TokenNotFound: true}, nil
+		}
+
+		// Otherwise the token is malformed, so probably an unsigned one from
+		// before signing was enabled, and it's looked up by value.
+	}
+
+	signup, err := find()
 
 	// No such token.
-	if errors.Is(err, pgx.ErrNoRows) {
+	if errors.Is(err, ErrSignupNotFound) {
 		return &SignupFinisherResult{TokenNotFound: true}, nil
 	}
 
@@ -46,32 +112,181 @@ func (c *SignupFinisher) Run(ctx context.Context, tx pgx.Tx) (*SignupFinisherRes
 		return nil, xerrors.Errorf("error querying for token: %w", err)
 	}
 
+	// A signed token finds its signup by ID, so also make sure that it's the
+	// signup's current token. Otherwise one that was replaced by a rotation
+	// or resend would keep working until it expired.
+	if signup.Token != c.Token {
+		logrus.Infof("Superseded token: %v", c.Token)
+		return &SignupFinisherResult{TokenNotFound: true}, nil
+	}
+
+	email := signup.Email
+	logger := signupLogger(signup.ID)
+
+	// Checked before marking the signup completed so that a webhook is only
+	// sent the first time it's confirmed, and not when a link is reused.
+	webhookEnqueued := c.NotifyWebhook && signup.CompletedAt == nil
+
 	// Make sure to update the row to indicate that we've successfully
 	// completed the signup. Note that this run is fully idempotent. If the
 	// next API call fails, the user can safely retry this as many as many
 	// times as necessary.
-	_, err = tx.Exec(ctx, `
-		UPDATE signup
-		SET completed_at = NOW()
-		WHERE id = $1
-	`, *id)
+	err = store.MarkCompleted(ctx, signup.ID)
 	if err != nil {
 		return nil, xerrors.Errorf("error updating record: %w", err)
 	}
 
-	logrus.Infof("Adding %v to the list\n", *email)
-	err = c.MailAPI.AddMember(ctx, c.ListAddress, *email)
+	if webhookEnqueued {
+		if err := store.EnqueueWebhook(ctx, WebhookEventSubscriberConfirmed, email); err != nil {
+			return nil, err
+		}
+	}
+
+	// If the member was already added on a previous run, don't add them again.
+	// Re-adding is harmless, but costly with some providers and noisy in logs.
+	if signup.MemberAddedAt != nil {
+		logger.Infof("%v already added to the list; skipping\n", email)
+
+		if c.ConfirmedEmailCache != nil {
+			c.ConfirmedEmailCache.Add(email)
+		}
+
+		return &SignupFinisherResult{
+			Email:           email,
+			SignupFinished:  true,
+			WebhookEnqueued: webhookEnqueued,
+		}, nil
+	}
+
+	if contextCancelled(ctx, email) {
+		return &SignupFinisherResult{Cancelled: true}, nil
+	}
+
+	logger.Infof("Adding %v to the list\n", email)
+	err = c.MailAPI.AddMember(ctx, c.ListAddress, email)
+
+	// If the list is full or we're over quota, the signup is still confirmed,
+	// but mark the row so that adding the member can be retried later once
+	// the quota's been raised.
+	if errors.Is(err, mailclient.ErrQuotaExceeded) {
+		logger.Errorf("Mail quota exceeded; deferring add of %v to the list: %v", email, err)
+
+		if err := store.MarkMemberAddDeferred(ctx, signup.ID); err != nil {
+			return nil, err
+		}
+
+		return &SignupFinisherResult{
+			Email:             email,
+			MemberAddDeferred: true,
+			SignupFinished:    true,
+			WebhookEnqueued:   webhookEnqueued,
+		}, nil
+	}
+
 	if err != nil {
 		return nil, xerrors.Errorf("error adding email to list: %w", err)
 	}
 
-	return &SignupFinisherResult{Email: *email, SignupFinished: true}, nil
+	if c.VerifyMemberAdd {
+		if err := c.verifyMemberAdd(ctx, email); err != nil {
+			return nil, err
+		}
+	}
+
+	// Record that the member was added, clearing any previous deferral or
+	// unsubscribe.
+	if err := store.MarkMemberAdded(ctx, signup.ID); err != nil {
+		return nil, err
+	}
+
+	var extraListsFailed []string
+	for _, listAddress := range c.ExtraListAddresses {
+		logger.Infof("Adding %v to extra list %v\n", email, listAddress)
+		if err := c.MailAPI.AddMember(ctx, listAddress, email); err != nil {
+			logger.Errorf("Error adding %v to extra list %v: %v", email, listAddress, err)
+			extraListsFailed = append(extraListsFailed, listAddress)
+		}
+	}
+
+	var welcomeSent bool
+	if c.SendWelcome {
+		logger.Infof("Sending welcome mail to %v\n", email)
+		err := sendWelcomeMessage(ctx, c.MailAPI, c.Renderer, c.ListAddress, c.FromAddress, c.ReplyToAddress,
+			email, signup.Token)
+		if err != nil {
+			logger.Errorf("Error sending welcome mail to %v: %v", email, err)
+		} else {
+			welcomeSent = true
+		}
+	}
+
+	if c.ConfirmedEmailCache != nil {
+		c.ConfirmedEmailCache.Add(email)
+	}
+
+	return &SignupFinisherResult{
+		Email:            email,
+		ExtraListsFailed: extraListsFailed,
+		SignupFinished:   true,
+		WebhookEnqueued:  webhookEnqueued,
+		WelcomeSent:      welcomeSent,
+	}, nil
+}
+
+// verifyMemberAdd checks that an email that was just added is a member of the
+// list, re-adding it up to a total of maxMemberAddAttempts times if not.
+func (c *SignupFinisher) verifyMemberAdd(ctx context.Context, email string) error {
+	for attempt := 1; ; attempt++ {
+		exists, err := c.MailAPI.GetMember(ctx, c.ListAddress, email)
+		if err != nil {
+			return xerrors.Errorf("error verifying list membership: %w", err)
+		}
+
+		if exists {
+			return nil
+		}
+
+		if attempt >= maxMemberAddAttempts {
+			return xerrors.Errorf("email still not a list member after %d add attempt(s)", attempt)
+		}
+
+		logrus.Errorf("Email %v not a list member after add; retrying (attempt %d)", email, attempt+1)
+		if err := c.MailAPI.AddMember(ctx, c.ListAddress, email); err != nil {
+			return xerrors.Errorf("error re-adding email to list: %w", err)
+		}
+	}
 }
 
 // SignupFinisherResult holds the results of a successful run of
 // SignupFinisher.
 type SignupFinisherResult struct {
-	Email          string
+	// Cancelled indicates that the context was cancelled, like because the
+	// client disconnected, so the signup was abandoned before adding the
+	// email to the list. Changes made in the transaction should be rolled
+	// back.
+	Cancelled bool
+
+	Email string
+
+	// ExtraListsFailed contains the addresses of any extra lists that the
+	// email couldn't be added to.
+	ExtraListsFailed []string
+
+	// MemberAddDeferred indicates that the signup was confirmed, but adding
+	// the email to the list had to be deferred because the mail service's
+	// quota was exceeded.
+	MemberAddDeferred bool
+
 	SignupFinished bool
-	TokenNotFound  bool
+
+	// TokenExpired indicates that a signed token was valid, but has expired.
+	TokenExpired bool
+
+	TokenNotFound bool
+
+	// WebhookEnqueued indicates that a webhook for the newly confirmed
+	// subscriber was put in the outbox.
+	WebhookEnqueued bool
+
+	WelcomeSent bool
 }
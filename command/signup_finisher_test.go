@@ -3,12 +3,16 @@ package command
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/jackc/pgx/v4"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
 
 	"github.com/brandur/passages-signup/mailclient"
+	"github.com/brandur/passages-signup/signedtoken"
 	"github.com/brandur/passages-signup/testhelpers"
+	"github.com/brandur/passages-signup/validation"
 )
 
 func TestSignupFinisher(t *testing.T) {
@@ -43,7 +47,8 @@ func TestSignupFinisher(t *testing.T) {
 
 			//
 			// Make sure it's idempotent by running it immediately again with the
-			// same inputs
+			// same inputs. The member was already added, so they're not added
+			// a second time.
 			//
 
 			res, err = mediator.Run(ctx, tx)
@@ -53,8 +58,400 @@ func TestSignupFinisher(t *testing.T) {
 			require.True(t, res.SignupFinished)
 			require.False(t, res.TokenNotFound)
 
+			require.Len(t, mailAPI.MembersAdded, 1)
+
+			var memberAddedAt *time.Time
+			err = tx.QueryRow(ctx, `
+				SELECT member_added_at
+				FROM signup
+				WHERE token = $1
+			`, token).Scan(&memberAddedAt)
+			require.NoError(t, err)
+			require.NotNil(t, memberAddedAt)
+		})
+	})
+
+	// Signups can be added to extra lists in addition to the main one
+	t.Run("ExtraLists", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			token := "test-token"
+
+			_, err := tx.Exec(ctx, `
+				INSERT INTO signup
+					(email, token)
+				VALUES
+					($1, $2)
+			`, testhelpers.TestEmail, token)
+			require.NoError(t, err)
+
+			mailAPI := mailclient.NewFakeClient()
+			mediator := signupFinisher(mailAPI, token)
+			mediator.ExtraListAddresses = []string{"nanoglyph@example.com", "other@example.com"}
+
+			res, err := mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.True(t, res.SignupFinished)
+			require.Empty(t, res.ExtraListsFailed)
+
+			require.Len(t, mailAPI.MembersAdded, 3)
+			require.Equal(t, testListAddress, mailAPI.MembersAdded[0].List)
+			require.Equal(t, "nanoglyph@example.com", mailAPI.MembersAdded[1].List)
+			require.Equal(t, "other@example.com", mailAPI.MembersAdded[2].List)
+			for _, memberAdded := range mailAPI.MembersAdded {
+				require.Equal(t, testhelpers.TestEmail, memberAdded.Email)
+			}
+		})
+	})
+
+	// A failure on an extra list doesn't fail the signup
+	t.Run("ExtraListPartialFailure", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			token := "test-token"
+
+			_, err := tx.Exec(ctx, `
+				INSERT INTO signup
+					(email, token)
+				VALUES
+					($1, $2)
+			`, testhelpers.TestEmail, token)
+			require.NoError(t, err)
+
+			mailAPI := &listFailingClient{
+				FakeClient:  mailclient.NewFakeClient(),
+				failingList: "other@example.com",
+			}
+			mediator := signupFinisher(mailAPI, token)
+			mediator.ExtraListAddresses = []string{"nanoglyph@example.com", "other@example.com"}
+
+			res, err := mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.True(t, res.SignupFinished)
+			require.Equal(t, []string{"other@example.com"}, res.ExtraListsFailed)
+
 			require.Len(t, mailAPI.MembersAdded, 2)
-			require.Equal(t, testhelpers.TestEmail, mailAPI.MembersAdded[1].Email)
+		})
+	})
+
+	// Over quota on the mail service, the signup is still confirmed, but the
+	// member add is deferred
+	t.Run("MemberAddDeferredOnQuotaExceeded", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			token := "test-token"
+
+			_, err := tx.Exec(ctx, `
+				INSERT INTO signup
+					(email, token)
+				VALUES
+					($1, $2)
+			`, testhelpers.TestEmail, token)
+			require.NoError(t, err)
+
+			mailAPI := &listFailingClient{
+				FakeClient:  mailclient.NewFakeClient(),
+				err:         xerrors.Errorf("list full: %w", mailclient.ErrQuotaExceeded),
+				failingList: testListAddress,
+			}
+			mediator := signupFinisher(mailAPI, token)
+
+			res, err := mediator.Run(ctx, tx)
+			require.NoError(t, err)
+
+			require.Equal(t, testhelpers.TestEmail, res.Email)
+			require.True(t, res.MemberAddDeferred)
+			require.True(t, res.SignupFinished)
+			require.Empty(t, mailAPI.MembersAdded)
+
+			var completedAt, memberAddDeferredAt *time.Time
+			err = tx.QueryRow(ctx, `
+				SELECT completed_at, member_add_deferred_at
+				FROM signup
+				WHERE token = $1
+			`, token).Scan(&completedAt, &memberAddDeferredAt)
+			require.NoError(t, err)
+			require.NotNil(t, completedAt)
+			require.NotNil(t, memberAddDeferredAt)
+
+			//
+			// Once the quota is raised, running again adds the member and
+			// clears the deferral
+			//
+
+			mediator.MailAPI = mailAPI.FakeClient
+
+			res, err = mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.False(t, res.MemberAddDeferred)
+			require.Len(t, mailAPI.MembersAdded, 1)
+
+			var memberAddedAt *time.Time
+			err = tx.QueryRow(ctx, `
+				SELECT member_add_deferred_at, member_added_at
+				FROM signup
+				WHERE token = $1
+			`, token).Scan(&memberAddDeferredAt, &memberAddedAt)
+			require.NoError(t, err)
+			require.Nil(t, memberAddDeferredAt)
+			require.NotNil(t, memberAddedAt)
+		})
+	})
+
+	// Finished signups are added to the confirmed email cache
+	t.Run("AddsToConfirmedEmailCache", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			token := "test-token"
+
+			_, err := tx.Exec(ctx, `
+				INSERT INTO signup
+					(email, token)
+				VALUES
+					($1, $2)
+			`, testhelpers.TestEmail, token)
+			require.NoError(t, err)
+
+			cache := NewConfirmedEmailCache(10, time.Hour)
+
+			mailAPI := mailclient.NewFakeClient()
+			mediator := signupFinisher(mailAPI, token)
+			mediator.ConfirmedEmailCache = cache
+
+			_, err = mediator.Run(ctx, tx)
+			require.NoError(t, err)
+
+			require.True(t, cache.Contains(testhelpers.TestEmail))
+		})
+	})
+
+	t.Run("SendWelcome", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			token := "test-token"
+
+			_, err := tx.Exec(ctx, `
+				INSERT INTO signup
+					(email, token)
+				VALUES
+					($1, $2)
+			`, testhelpers.TestEmail, token)
+			require.NoError(t, err)
+
+			mailAPI := mailclient.NewFakeClient()
+			mediator := signupFinisher(mailAPI, token)
+			mediator.Renderer = renderer
+			mediator.ReplyToAddress = testReplyToAddress
+			mediator.SendWelcome = true
+
+			res, err := mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.True(t, res.SignupFinished)
+			require.True(t, res.WelcomeSent)
+
+			require.Len(t, mailAPI.MessagesSent, 1)
+			require.Equal(t, testhelpers.TestEmail, mailAPI.MessagesSent[0].Recipient)
+			require.Equal(t, renderer.PublicURL+"/unsubscribe/"+token, mailAPI.MessagesSent[0].UnsubscribeURL)
+
+			// No second welcome when the member was already added
+			res, err = mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.False(t, res.WelcomeSent)
+			require.Len(t, mailAPI.MessagesSent, 1)
+		})
+	})
+
+	t.Run("VerifyMemberAdd", func(t *testing.T) {
+		const token = "test-token"
+
+		insertSignup := func(t *testing.T, tx pgx.Tx) {
+			t.Helper()
+
+			_, err := tx.Exec(ctx, `
+				INSERT INTO signup
+					(email, token)
+				VALUES
+					($1, $2)
+			`, testhelpers.TestEmail, token)
+			require.NoError(t, err)
+		}
+
+		t.Run("Verified", func(t *testing.T) {
+			testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+				insertSignup(t, tx)
+
+				mailAPI := mailclient.NewFakeClient()
+				mediator := signupFinisher(mailAPI, token)
+				mediator.VerifyMemberAdd = true
+
+				res, err := mediator.Run(ctx, tx)
+				require.NoError(t, err)
+				require.True(t, res.SignupFinished)
+				require.Len(t, mailAPI.MembersAdded, 1)
+			})
+		})
+
+		// The first add is lost by the mail service, so it's retried.
+		t.Run("Retried", func(t *testing.T) {
+			testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+				insertSignup(t, tx)
+
+				mailAPI := mailclient.NewFakeClient()
+				mailAPI.NumAddsToDrop = 1
+				mediator := signupFinisher(mailAPI, token)
+				mediator.VerifyMemberAdd = true
+
+				res, err := mediator.Run(ctx, tx)
+				require.NoError(t, err)
+				require.True(t, res.SignupFinished)
+				require.Len(t, mailAPI.MembersAdded, 2)
+
+				exists, err := mailAPI.GetMember(ctx, testListAddress, testhelpers.TestEmail)
+				require.NoError(t, err)
+				require.True(t, exists)
+			})
+		})
+
+		// Every add is lost, so the signup fails after running out of
+		// attempts and can be retried later.
+		t.Run("GivesUp", func(t *testing.T) {
+			testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+				insertSignup(t, tx)
+
+				mailAPI := mailclient.NewFakeClient()
+				mailAPI.NumAddsToDrop = maxMemberAddAttempts
+				mediator := signupFinisher(mailAPI, token)
+				mediator.VerifyMemberAdd = true
+
+				_, err := mediator.Run(ctx, tx)
+				require.ErrorContains(t, err, "email still not a list member after 3 add attempt(s)")
+				require.Len(t, mailAPI.MembersAdded, maxMemberAddAttempts)
+			})
+		})
+
+		// Without verification, a lost add goes unnoticed.
+		t.Run("Disabled", func(t *testing.T) {
+			testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+				insertSignup(t, tx)
+
+				mailAPI := mailclient.NewFakeClient()
+				mailAPI.NumAddsToDrop = 1
+				mediator := signupFinisher(mailAPI, token)
+
+				res, err := mediator.Run(ctx, tx)
+				require.NoError(t, err)
+				require.True(t, res.SignupFinished)
+				require.Len(t, mailAPI.MembersAdded, 1)
+			})
+		})
+	})
+
+	t.Run("SignedToken", func(t *testing.T) {
+		signer := signedtoken.NewSigner(testTokenSecret)
+
+		// Inserts a signup and returns its ID.
+		insertSignup := func(t *testing.T, tx pgx.Tx) int64 {
+			t.Helper()
+
+			var id int64
+			err := tx.QueryRow(ctx, `
+				INSERT INTO signup
+					(email, token)
+				VALUES
+					($1, 'not-a-real-token')
+				RETURNING id
+			`, testhelpers.TestEmail).Scan(&id)
+			require.NoError(t, err)
+			return id
+		}
+
+		// Stores a newly signed token for a signup and returns it, like when
+		// a confirmation is sent.
+		storeSignedToken := func(t *testing.T, tx pgx.Tx, id int64) string {
+			t.Helper()
+
+			token := signer.Encode(id, time.Now().Add(1*time.Hour))
+			require.NoError(t, NewPgxSignupStore(tx).UpdateToken(ctx, id, token))
+			return token
+		}
+
+		t.Run("Valid", func(t *testing.T) {
+			testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+				id := insertSignup(t, tx)
+
+				mailAPI := mailclient.NewFakeClient()
+				mediator := signupFinisher(mailAPI, storeSignedToken(t, tx, id))
+				mediator.TokenSigner = signer
+
+				res, err := mediator.Run(ctx, tx)
+				require.NoError(t, err)
+				require.True(t, res.SignupFinished)
+				require.Equal(t, testhelpers.TestEmail, res.Email)
+				require.Len(t, mailAPI.MembersAdded, 1)
+			})
+		})
+
+		// A validly signed token that's since been replaced, like by a
+		// rotation, doesn't confirm anything
+		t.Run("Superseded", func(t *testing.T) {
+			testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+				id := insertSignup(t, tx)
+				storeSignedToken(t, tx, id)
+
+				mailAPI := mailclient.NewFakeClient()
+				mediator := signupFinisher(mailAPI, signer.Encode(id, time.Now().Add(2*time.Hour)))
+				mediator.TokenSigner = signer
+
+				res, err := mediator.Run(ctx, tx)
+				require.NoError(t, err)
+				require.True(t, res.TokenNotFound)
+				require.False(t, res.SignupFinished)
+				require.Empty(t, mailAPI.MembersAdded)
+			})
+		})
+
+		t.Run("Expired", func(t *testing.T) {
+			testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+				id := insertSignup(t, tx)
+
+				mailAPI := mailclient.NewFakeClient()
+				mediator := signupFinisher(mailAPI, signer.Encode(id, time.Now().Add(-1*time.Hour)))
+				mediator.TokenSigner = signer
+
+				res, err := mediator.Run(ctx, tx)
+				require.NoError(t, err)
+				require.True(t, res.TokenExpired)
+				require.False(t, res.SignupFinished)
+				require.Empty(t, mailAPI.MembersAdded)
+			})
+		})
+
+		t.Run("Tampered", func(t *testing.T) {
+			testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+				id := insertSignup(t, tx)
+
+				otherSigner := signedtoken.NewSigner("a-different-secret-also-32-chars-long")
+
+				mailAPI := mailclient.NewFakeClient()
+				mediator := signupFinisher(mailAPI, otherSigner.Encode(id, time.Now().Add(1*time.Hour)))
+				mediator.TokenSigner = signer
+
+				res, err := mediator.Run(ctx, tx)
+				require.NoError(t, err)
+				require.True(t, res.TokenNotFound)
+				require.False(t, res.SignupFinished)
+				require.Empty(t, mailAPI.MembersAdded)
+			})
+		})
+
+		// Tokens from before signing was enabled still work
+		t.Run("Unsigned", func(t *testing.T) {
+			testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+				insertSignup(t, tx)
+
+				mailAPI := mailclient.NewFakeClient()
+				mediator := signupFinisher(mailAPI, "not-a-real-token")
+				mediator.TokenSigner = signer
+
+				res, err := mediator.Run(ctx, tx)
+				require.NoError(t, err)
+				require.True(t, res.SignupFinished)
+			})
 		})
 	})
 
@@ -74,6 +471,42 @@ func TestSignupFinisher(t *testing.T) {
 			require.Empty(t, len(mailAPI.MembersAdded))
 		})
 	})
+
+	// Validation fails before the database is touched, with a readable
+	// message. Parameters are assembled by the program, so it's not
+	// user-facing.
+	t.Run("ValidationError", func(t *testing.T) {
+		mediator := signupFinisher(mailclient.NewFakeClient(), "")
+
+		_, err := mediator.Run(ctx, nil)
+		require.ErrorContains(t, err, "Token is a required field")
+
+		var validationErr *validation.Error
+		require.ErrorAs(t, err, &validationErr)
+		require.False(t, validationErr.UserFacing)
+	})
+}
+
+//
+// Private types
+//
+
+// listFailingClient is a fake mail client that fails to add members to one
+// particular list. Fails with err if set, or a generic error otherwise.
+type listFailingClient struct {
+	*mailclient.FakeClient
+	err         error
+	failingList string
+}
+
+func (a *listFailingClient) AddMember(ctx context.Context, list, email string) error {
+	if list == a.failingList {
+		if a.err != nil {
+			return a.err
+		}
+		return xerrors.Errorf("error adding to list: %s", list)
+	}
+	return a.FakeClient.AddMember(ctx, list, email)
 }
 
 //
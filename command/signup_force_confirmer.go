@@ -0,0 +1,109 @@
+package command
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/xerrors"
+
+	"github.com/brandur/passages-signup/mailclient"
+	"github.com/brandur/passages-signup/ptemplate"
+)
+
+// SignupForceConfirmer finishes a signup on behalf of a user who isn't able
+// to follow the link in their confirmation email (e.g. because their mail
+// server rewrites links). It's a variant of SignupFinisher that's keyed on
+// email instead of token, and is only available to administrators.
+type SignupForceConfirmer struct {
+	// ConfirmedEmailCache is an optional cache of recently confirmed emails.
+	// If set, the email is added to it after a successful finish.
+	ConfirmedEmailCache *ConfirmedEmailCache `validate:"-"`
+
+	Email string `validate:"required"`
+
+	// ExtraListAddresses are addresses of additional lists that the email
+	// should be added to along with the one at ListAddress.
+	ExtraListAddresses []string `validate:"-"`
+
+	ListAddress string         `validate:"required"`
+	MailAPI     mailclient.API `validate:"required"`
+
+	// NotifyWebhook is passed through to SignupFinisher so that a force
+	// confirmed subscriber notifies the same as one who followed their link.
+	NotifyWebhook bool `validate:"-"`
+
+	// FromAddress, Renderer, ReplyToAddress, and SendWelcome are passed
+	// through to SignupFinisher to send a welcome message.
+	FromAddress    string              `validate:"omitempty,email"`
+	Renderer       *ptemplate.Renderer `validate:"-"`
+	ReplyToAddress string              `validate:"-"`
+	SendWelcome    bool                `validate:"-"`
+}
+
+// Run executes the mediator.
+func (c *SignupForceConfirmer) Run(ctx context.Context, tx pgx.Tx) (*SignupForceConfirmerResult, error) {
+	logrus.Debugf("SignupForceConfirmer running")
+
+	if err := validate.Struct(c); err != nil {
+		return nil, xerrors.Errorf("error validating command: %w", err)
+	}
+
+	var token string
+	err := tx.QueryRow(ctx, `
+		SELECT token
+		FROM signup
+		WHERE email = $1
+	`, c.Email).Scan(&token)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return &SignupForceConfirmerResult{EmailNotFound: true}, nil
+	}
+
+	if err != nil {
+		return nil, xerrors.Errorf("error querying for email: %w", err)
+	}
+
+	// Finishing by token from here on guarantees identical behavior to a
+	// user following their link.
+	finisher := &SignupFinisher{
+		ConfirmedEmailCache: c.ConfirmedEmailCache,
+		ExtraListAddresses:  c.ExtraListAddresses,
+		FromAddress:         c.FromAddress,
+		ListAddress:         c.ListAddress,
+		MailAPI:             c.MailAPI,
+		NotifyWebhook:       c.NotifyWebhook,
+		Renderer:            c.Renderer,
+		ReplyToAddress:      c.ReplyToAddress,
+		SendWelcome:         c.SendWelcome,
+		Token:               token,
+	}
+	res, err := finisher.Run(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SignupForceConfirmerResult{
+		Email:             res.Email,
+		ExtraListsFailed:  res.ExtraListsFailed,
+		MemberAddDeferred: res.MemberAddDeferred,
+		SignupFinished:    res.SignupFinished,
+		WebhookEnqueued:   res.WebhookEnqueued,
+	}, nil
+}
+
+// SignupForceConfirmerResult holds the results of a successful run of
+// SignupForceConfirmer.
+type SignupForceConfirmerResult struct {
+	Email string `json:"email"`
+
+	// EmailNotFound is set if no signup exists for the email, in which case
+	// no other fields are populated.
+	EmailNotFound bool `json:"-"`
+
+	ExtraListsFailed  []string `json:"extra_lists_failed"`
+	MemberAddDeferred bool     `json:"member_add_deferred"`
+	SignupFinished    bool     `json:"signup_finished"`
+	WebhookEnqueued   bool     `json:"webhook_enqueued"`
+}
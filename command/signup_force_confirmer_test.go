@@ -0,0 +1,104 @@
+package command
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brandur/passages-signup/mailclient"
+	"github.com/brandur/passages-signup/testhelpers"
+)
+
+func TestSignupForceConfirmer(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("ConfirmsPendingSignup", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			_, err := tx.Exec(ctx, `
+				INSERT INTO signup
+					(email, token)
+				VALUES
+					($1, 'not-a-real-token')
+			`, testhelpers.TestEmail)
+			require.NoError(t, err)
+
+			mailAPI := mailclient.NewFakeClient()
+			mediator := &SignupForceConfirmer{
+				Email:       testhelpers.TestEmail,
+				ListAddress: testListAddress,
+				MailAPI:     mailAPI,
+			}
+
+			res, err := mediator.Run(ctx, tx)
+			require.NoError(t, err)
+
+			require.Equal(t, testhelpers.TestEmail, res.Email)
+			require.False(t, res.EmailNotFound)
+			require.True(t, res.SignupFinished)
+
+			require.Len(t, mailAPI.MembersAdded, 1)
+			require.Equal(t, testhelpers.TestEmail, mailAPI.MembersAdded[0].Email)
+
+			var completedAt *time.Time
+			err = tx.QueryRow(ctx, `
+				SELECT completed_at
+				FROM signup
+				WHERE email = $1
+			`, testhelpers.TestEmail).Scan(&completedAt)
+			require.NoError(t, err)
+			require.NotNil(t, completedAt)
+		})
+	})
+
+	t.Run("WebhookEnqueued", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			_, err := tx.Exec(ctx, `
+				INSERT INTO signup
+					(email, token)
+				VALUES
+					($1, 'not-a-real-token')
+			`, testhelpers.TestEmail)
+			require.NoError(t, err)
+
+			mediator := &SignupForceConfirmer{
+				Email:         testhelpers.TestEmail,
+				ListAddress:   testListAddress,
+				MailAPI:       mailclient.NewFakeClient(),
+				NotifyWebhook: true,
+			}
+
+			res, err := mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.True(t, res.WebhookEnqueued)
+
+			var email, eventType string
+			err = tx.QueryRow(ctx, `
+				SELECT email, event_type
+				FROM outbox_webhook
+			`).Scan(&email, &eventType)
+			require.NoError(t, err)
+			require.Equal(t, testhelpers.TestEmail, email)
+			require.Equal(t, WebhookEventSubscriberConfirmed, eventType)
+		})
+	})
+
+	t.Run("EmailNotFound", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			mailAPI := mailclient.NewFakeClient()
+			mediator := &SignupForceConfirmer{
+				Email:       testhelpers.TestEmail,
+				ListAddress: testListAddress,
+				MailAPI:     mailAPI,
+			}
+
+			res, err := mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.True(t, res.EmailNotFound)
+			require.False(t, res.SignupFinished)
+			require.Empty(t, mailAPI.MembersAdded)
+		})
+	})
+}
@@ -1,39 +1,57 @@
 package command
 
 import (
-	"bytes"
 	"context"
-	"regexp"
-	"strings"
+	"math/rand/v2"
 	"time"
 
-	"github.com/aymerick/douceur/inliner"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v4"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/xerrors"
 
+	"github.com/brandur/passages-signup/emailvalidator"
 	"github.com/brandur/passages-signup/mailclient"
 	"github.com/brandur/passages-signup/ptemplate"
+	"github.com/brandur/passages-signup/signedtoken"
 )
 
 const (
-	// Maximum of number of times we'll ever try to send a confirmation email
-	// to a particular email address.
+	// Maximum of number of times we'll try to send a confirmation email to a
+	// particular email address. Attempts stop counting towards it once
+	// they're outside of SignupStarter.AttemptsWindow.
 	maxNumSignupAttempts = 3
 
 	// If we've already tried to confirm a signup by sending a confirmation
-	// email, we won't try to send another confirmation email for at least this
-	// many hours, even if a user submits the forma again.
-	noResendHours = 24
+	// email, by default we won't try to send another confirmation email for at
+	// least this long, even if a user submits the form again. Overridden by
+	// SignupStarter.ResendWindow.
+	defaultResendWindow = 24 * time.Hour
+
+	// logFieldSignupID is the structured log field carrying a signup's ID.
+	// It's included in logs from both starting and finishing a signup so that
+	// the two can be grepped together.
+	logFieldSignupID = "signup_id"
 )
 
-// ErrInvalidEmail is the error that's returned if a given email address
-// didn't match a regex to check for email validity.
-var ErrInvalidEmail = errors.New("That doesn't look like a valid email address")
+var (
+	// ErrBlockedEmail is the error that's returned if a given email address
+	// is on the blocklist.
+	ErrBlockedEmail = errors.New("That email address isn't allowed to sign up")
+
+	// ErrEmailTooLong is the error that's returned if a given email address
+	// is longer than the configured maximum or the limits of RFC 5321.
+	ErrEmailTooLong = errors.New("That email address is too long")
 
-var emailRegexp = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+	// ErrInvalidEmail is the error that's returned if a given email address
+	// didn't match a regex to check for email validity.
+	ErrInvalidEmail = errors.New("That doesn't look like a valid email address")
+
+	// ErrNoMailServers is the error that's returned if a given email
+	// address's domain has no mail servers, so it can't receive mail.
+	ErrNoMailServers = errors.New("That email address's domain doesn't accept mail")
+)
 
 // SignupStarter takes an email and begins the signup process or it.
 //
@@ -43,56 +61,179 @@ var emailRegexp = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0
 // was dispatched but not yet confirmed, it may be resent, but only if outside
 // a rate limited window.
 type SignupStarter struct {
-	Email          string              `validate:"required"`
-	ListAddress    string              `validate:"required"`
-	MailAPI        mailclient.API      `validate:"required"`
-	Renderer       *ptemplate.Renderer `validate:"required"`
-	ReplyToAddress string              `validate:"required"`
+	// AttemptsWindow is how long confirmation attempts count towards the
+	// maximum number of attempts. If the last confirmation was sent longer
+	// ago than this, the signup is considered stale and its attempts start
+	// over. If zero, attempts never go stale.
+	AttemptsWindow time.Duration `validate:"min=0"`
+
+	// ConfirmationDelayMin and ConfirmationDelayMax, if set, delay sending the
+	// confirmation by a random duration in their range so that it doesn't
+	// look instantaneous to spam filters. Instead of being sent immediately,
+	// the message is put in the outbox to be sent by OutboxSender. No delay
+	// is used if ConfirmationDelayMax is zero.
+	ConfirmationDelayMin time.Duration `validate:"min=0"`
+	ConfirmationDelayMax time.Duration `validate:"gtefield=ConfirmationDelayMin"`
+
+	// ConfirmedEmailCache is an optional cache of recently confirmed emails.
+	// If set and the email is found within it, the command returns early
+	// without touching the database.
+	ConfirmedEmailCache *ConfirmedEmailCache `validate:"-"`
+
+	// Attribution holds optional UTM parameters that the signup arrived with.
+	// They're stored with a new signup, but never overwrite those of an
+	// existing one so that the first touch is what's attributed.
+	Attribution *Attribution `validate:"-"`
+
+	Email string `validate:"required"`
+
+	// EmailCasePolicy controls how the case of Email is normalized before
+	// it's stored or compared with existing signups. Defaults to
+	// EmailCasePolicyLowerDomain if empty.
+	EmailCasePolicy EmailCasePolicy `validate:"omitempty,oneof=preserve lower-domain lower-all"`
+
+	// EmailBlocklist is an optional set of emails that aren't allowed to sign
+	// up. A blocked email is rejected with ErrBlockedEmail before anything is
+	// stored or sent.
+	EmailBlocklist *EmailBlocklist `validate:"-"`
+
+	FromAddress string         `validate:"omitempty,email"`
+	ListAddress string         `validate:"required"`
+	MailAPI     mailclient.API `validate:"required"`
+
+	// MaxEmailLength is the maximum length of an email address that will be
+	// accepted. Zero means that only the limits of RFC 5321 apply.
+	MaxEmailLength int `validate:"-"`
+
+	// MaxSignupsPerDay is a ceiling on the number of new signups created
+	// across all emails each day (UTC), which caps costs during an attack that
+	// rotates through IPs and emails. Existing signups aren't affected. Zero
+	// means no limit.
+	MaxSignupsPerDay int `validate:"min=0"`
+
+	// MXResolver, if set, is used to reject emails whose domains have no mail
+	// servers.
+	MXResolver emailvalidator.MXResolver `validate:"-"`
+
+	// NotifyWebhook enqueues a subscriber.confirmed webhook in the outbox when
+	// SingleOptIn subscribes an email that wasn't already subscribed. It's
+	// only sent once the transaction commits.
+	NotifyWebhook bool `validate:"-"`
+
+	// PreviewLink includes a link in the confirmation email to a web version
+	// of the message. Useful for debugging rendering problems.
+	PreviewLink bool `validate:"-"`
+
+	Renderer *ptemplate.Renderer `validate:"required"`
+
+	// ReplyToAddress is an optional address for replies to the confirmation
+	// message. If empty, replies go to the list address.
+	ReplyToAddress string `validate:"-"`
+
+	// ResendWindow is how long after a confirmation is sent that a user
+	// submitting the form again won't cause it to be resent, which gives a
+	// malicious actor less opportunity to spam an innocent recipient.
+	// Defaults to 24 hours if zero.
+	ResendWindow time.Duration `validate:"min=0"`
+
+	// SingleOptIn skips the confirmation email and adds the email to the list
+	// immediately. Only suitable for low risk lists because anyone can sign up
+	// anyone else.
+	SingleOptIn bool `validate:"-"`
+
+	// Store is where signups are read and written. Defaults to Postgres
+	// through the transaction that the command is run with.
+	Store SignupStore `validate:"-"`
+
+	// TokenSigner, if set, replaces the random tokens in confirmation links
+	// with signed ones that expire after TokenTTL.
+	TokenSigner *signedtoken.Signer `validate:"-"`
+	TokenTTL    time.Duration       `validate:"required_with=TokenSigner"`
+
+	// TrustLocalSubscriptionState skips re-sending confirmations to signups
+	// that have completed and haven't been recorded as unsubscribed. Only
+	// safe if unsubscribes are being recorded, like through Unsubscriber or
+	// MailgunReconciler. Otherwise a user who unsubscribed could never come
+	// back.
+	TrustLocalSubscriptionState bool `validate:"-"`
 }
 
 // Run executes the mediator.
 func (c *SignupStarter) Run(ctx context.Context, tx pgx.Tx) (*SignupStarterResult, error) {
-	logrus.Infof("SignupStarter running")
+	logrus.Debugf("SignupStarter running")
 
 	if err := validate.Struct(c); err != nil {
 		return nil, xerrors.Errorf("error validating command: %w", err)
 	}
 
-	// We know that a simple regexp validation won't detect all invalid email
-	// addresses, so to some extent we'll be relying on Mailgun to do some of
-	// that work for us.
-	if !emailRegexp.MatchString(c.Email) {
-		return nil, ErrInvalidEmail
+	// Normalize once up front so that everything below stores and compares
+	// the same value.
+	c.Email = c.EmailCasePolicy.Apply(c.Email)
+
+	checker := &EmailChecker{MaxEmailLength: c.MaxEmailLength, MXResolver: c.MXResolver}
+	if err := checker.Check(ctx, c.Email); err != nil {
+		return nil, err
+	}
+
+	if c.EmailBlocklist != nil && c.EmailBlocklist.Contains(c.Email) {
+		logrus.Infof("Email on blocklist; rejecting signup: %s", c.Email)
+		return nil, ErrBlockedEmail
+	}
+
+	if c.ConfirmedEmailCache != nil && c.ConfirmedEmailCache.Contains(c.Email) {
+		logrus.Infof("Email recently confirmed; skipping signup: %s", c.Email)
+		return &SignupStarterResult{AlreadySubscribed: true}, nil
+	}
+
+	store := signupStoreOrDefault(c.Store, tx)
+
+	// Checked before anything is written so that a suppressed email neither
+	// gets a new signup nor has its existing one's attempts bumped.
+	suppressed, err := store.IsSuppressed(ctx, c.Email)
+	if err != nil {
+		return nil, err
+	}
+	if suppressed {
+		logrus.Infof("Email is on the suppression list so not signing up: %s", c.Email)
+		return &SignupStarterResult{Suppressed: true}, nil
+	}
+
+	if c.SingleOptIn {
+		return c.subscribeDirectly(ctx, store)
 	}
 
-	var id *int64
-	var completedAt *time.Time
-	var lastSentAt *time.Time
-	var numAttempts *int64
-	var token *string
-	err := tx.QueryRow(ctx, `
-		SELECT id, completed_at, last_sent_at, num_attempts, token
-		FROM signup
-		WHERE email = $1
-	`, c.Email).Scan(&id, &completedAt, &lastSentAt, &numAttempts, &token)
+	signup, err := store.FindByEmail(ctx, c.Email)
 
 	// The happy path: if we have nothing in the database, then just run the
 	// process from scratch.
-	if errors.Is(err, pgx.ErrNoRows) {
-		token := uuid.New().String()
-
-		_, err = tx.Exec(ctx, `
-			INSERT INTO signup
-				(email, token)
-			VALUES
-				($1, $2)
-		`, c.Email, token)
+	if errors.Is(err, ErrSignupNotFound) {
+		limitReached, err := c.globalLimitReached(ctx, store)
 		if err != nil {
-			return nil, xerrors.Errorf("error inserting singup row: %w", err)
+			return nil, err
+		}
+		if limitReached {
+			return &SignupStarterResult{GlobalLimitReached: true}, nil
+		}
+
+		if contextCancelled(ctx, c.Email) {
+			return &SignupStarterResult{Cancelled: true}, nil
+		}
+
+		signup := c.newSignup()
+		if err := store.Insert(ctx, signup); err != nil {
+			return nil, err
 		}
 
-		err = c.sendConfirmationMessage(ctx, token)
+		token, err := c.refreshSignedToken(ctx, store, signup.ID, signup.Token)
 		if err != nil {
+			return nil, err
+		}
+
+		if contextCancelled(ctx, c.Email) {
+			return &SignupStarterResult{Cancelled: true}, nil
+		}
+
+		if err := c.sendConfirmationMessage(ctx, store, signup.ID, token); err != nil {
 			return nil, xerrors.Errorf("error sending confirmation message: %w", err)
 		}
 
@@ -104,18 +245,39 @@ func (c *SignupStarter) Run(ctx context.Context, tx pgx.Tx) (*SignupStarterResul
 		return nil, xerrors.Errorf("error querying for existing record: %w", err)
 	}
 
-	if completedAt == nil && *numAttempts >= maxNumSignupAttempts {
-		logrus.Infof("Too many signup attempts for email: %s", c.Email)
+	logger := signupLogger(signup.ID)
+
+	// Mail to this address has hard bounced before, so sending another
+	// confirmation would be pointless.
+	if signup.BouncedAt != nil {
+		logger.Infof("Email previously bounced so not sending confirmation: %s", c.Email)
+		return &SignupStarterResult{Bounced: true}, nil
+	}
+
+	// Attempts from a confirmation sent outside of the attempts window are
+	// stale, so an unconfirmed signup gets a fresh set of them.
+	numAttempts := signup.NumAttempts
+	if signup.CompletedAt == nil && c.attemptsStale(signup) {
+		numAttempts = 0
+	}
+
+	if signup.CompletedAt == nil && numAttempts >= maxNumSignupAttempts {
+		logger.Infof("Too many signup attempts for email: %s", c.Email)
 		return &SignupStarterResult{MaxNumAttempts: true}, nil
 	}
 
-	// Note that we don't bail early even if the record appears to be completed
-	// because if the user was previously subscribed but then unsubscribed, we
-	// won't know about the unsubscription because it happens entirely through
-	// Mailgun.
+	// By default we don't bail early even if the record appears to be
+	// completed because if the user was previously subscribed but then
+	// unsubscribed, we may not know about the unsubscription because it
+	// happens entirely through Mailgun.
 	//
 	// The side effect is that we may send a signup confirmation to a user who
-	// is already subscribed, but that's not a big deal.
+	// is already subscribed, but that's not a big deal. If unsubscribes are
+	// being tracked locally, that can be avoided.
+	if c.TrustLocalSubscriptionState && signup.CompletedAt != nil && signup.UnsubscribedAt == nil {
+		logger.Infof("Email already subscribed so not re-sending confirmation: %s", c.Email)
+		return &SignupStarterResult{AlreadySubscribed: true}, nil
+	}
 
 	// If we sent the last confirmation email recently, then don't send it
 	// again. This gives a malicious actor less opportunity to spam an innocent
@@ -123,86 +285,300 @@ func (c *SignupStarter) Run(ctx context.Context, tx pgx.Tx) (*SignupStarterResul
 	//
 	// We do want to eventually sent another email in case the user signed up
 	// before but failed to complete the process, and now wants to try again.
-	// The duration parameter may need to be tweaked.
-	if lastSentAt.After(time.Now().Add(-noResendHours * time.Hour)) {
-		logrus.Infof("Last send was too soon so not re-sending confirmation, %s",
+	// That's governed by the resend window.
+	if signup.LastSentAt.After(time.Now().Add(-c.resendWindow())) {
+		logger.Infof("Last send was too soon so not re-sending confirmation, %s",
 			c.Email)
 		return &SignupStarterResult{ConfirmationRateLimited: true}, nil
 	}
 
+	// A signup that was completed but has since unsubscribed is coming back,
+	// so treat it as a fresh opt-in: its completion is cleared and its
+	// attempts reset so that it has to be confirmed again like a new signup.
+	// It stays unsubscribed until the confirmation link is followed, so
+	// nobody can resubscribe an address without its owner's say so.
+	resubscribing := signup.CompletedAt != nil && signup.UnsubscribedAt != nil
+
+	if contextCancelled(ctx, c.Email) {
+		return &SignupStarterResult{Cancelled: true}, nil
+	}
+
 	// Update the number of attempts, but only if this user hasn't already
 	// completed the signup flow.
-	if completedAt == nil {
-		*numAttempts++
+	switch {
+	case resubscribing:
+		logger.Infof("Unsubscribed email resubscribing; requiring confirmation: %s", c.Email)
+
+		err = store.ClearCompleted(ctx, signup.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		numAttempts = 1
+
+	case signup.CompletedAt == nil:
+		numAttempts++
 	}
 
 	// Otherwise, update the timestamp and number of attempts. Re-send the
 	// confirmation message.
-	_, err = tx.Exec(ctx, `
-		UPDATE signup
-		SET
-		  last_sent_at = NOW(),
-		  num_attempts = $1
-		WHERE id = $2
-	`, *numAttempts, *id)
+	//
+	// The member's list add is also reset so that following the link again
+	// re-adds them. This is what lets a user who unsubscribed through Mailgun
+	// come back.
+	err = store.UpdateSent(ctx, signup.ID, numAttempts)
 	if err != nil {
 		return nil, xerrors.Errorf("error updating existing record: %w", err)
 	}
 
-	// Re-send confirmation.
-	err = c.sendConfirmationMessage(ctx, *token)
+	// A signed token may have expired, so always send a fresh one.
+	token, err := c.refreshSignedToken(ctx, store, signup.ID, signup.Token)
 	if err != nil {
+		return nil, err
+	}
+
+	if contextCancelled(ctx, c.Email) {
+		return &SignupStarterResult{Cancelled: true}, nil
+	}
+
+	// Re-send confirmation.
+	if err := c.sendConfirmationMessage(ctx, store, signup.ID, token); err != nil {
 		return nil, xerrors.Errorf("error sending confirmation email: %w", err)
 	}
 
-	return &SignupStarterResult{ConfirmationResent: true}, nil
+	return &SignupStarterResult{ConfirmationResent: true, Resubscribing: resubscribing}, nil
 }
 
-func (c *SignupStarter) sendConfirmationMessage(ctx context.Context, token string) error {
-	logrus.Infof("Sending confirmation mail to %v with token %v\n", c.Email, token)
-
-	subject := c.Renderer.NewsletterMeta.Name + " signup confirmation"
+// attemptsStale returns whether a signup's last confirmation was sent outside
+// of the attempts window, so its attempts no longer count.
+func (c *SignupStarter) attemptsStale(signup *Signup) bool {
+	return c.AttemptsWindow > 0 && signup.LastSentAt.Before(time.Now().Add(-c.AttemptsWindow))
+}
 
-	buf := new(bytes.Buffer)
-	err := c.Renderer.RenderTemplate(buf, "views/messages/confirm_plain", map[string]interface{}{
-		"token": token,
-	})
-	if err != nil {
-		return xerrors.Errorf("error rendering confirmation email (plain): %w", err)
+// attributionValues returns the values for the UTM campaign, medium, and
+// source columns. Values that weren't provided are nil so that they're stored
+// as NULL.
+func (c *SignupStarter) attributionValues() (*string, *string, *string) {
+	if c.Attribution == nil {
+		return nil, nil, nil
 	}
-	confirmPlain := strings.TrimSpace(buf.String())
 
-	buf = new(bytes.Buffer)
-	err = c.Renderer.RenderTemplate(buf, "views/messages/confirm", map[string]interface{}{
-		"token": token,
-	})
-	if err != nil {
-		return xerrors.Errorf("error rendering confirmation email (HTML): %w", err)
-	}
-	confirmHTML := buf.String()
+	return nullIfEmpty(c.Attribution.Campaign),
+		nullIfEmpty(c.Attribution.Medium),
+		nullIfEmpty(c.Attribution.Source)
+}
 
-	// Inline CSS styling (because that's the only way mail clients will
-	// support it).
-	confirmHTML, err = inliner.Inline(confirmHTML)
+// confirmationMessage renders the confirmation message for the signup with
+// the given token.
+func (c *SignupStarter) confirmationMessage(token string) (*mailclient.SendMessageParams, error) {
+	confirmHTML, confirmPlain, err := renderMessage(c.Renderer, "views/messages/confirm",
+		ConfirmationLocals(c.Renderer, token, c.PreviewLink))
 	if err != nil {
-		return xerrors.Errorf("error inlining CSS styling: %w", err)
+		return nil, xerrors.Errorf("error rendering confirmation email: %w", err)
 	}
 
-	return c.MailAPI.SendMessage(ctx, &mailclient.SendMessageParams{
+	return &mailclient.SendMessageParams{
 		ContentsHTML:   confirmHTML,
 		ContentsPlain:  confirmPlain,
+		FromAddress:    c.FromAddress,
 		ListAddress:    c.ListAddress,
 		NewsletterName: c.Renderer.NewsletterMeta.Name,
 		Recipient:      c.Email,
 		ReplyTo:        c.ReplyToAddress,
-		Subject:        subject,
-	})
+		Subject:        confirmationSubject(c.Renderer.NewsletterMeta.Name),
+	}, nil
+}
+
+// confirmationSendAfter returns when a confirmation should be sent, which is
+// a random time within the confirmation delay's range from now.
+func (c *SignupStarter) confirmationSendAfter() time.Time {
+	delay := c.ConfirmationDelayMin
+	if spread := c.ConfirmationDelayMax - c.ConfirmationDelayMin; spread > 0 {
+		// Not security sensitive, so a weak random source is fine.
+		delay += rand.N(spread + 1) //nolint:gosec
+	}
+	return time.Now().Add(delay)
+}
+
+// globalLimitReached checks whether the number of signups created today has
+// reached MaxSignupsPerDay.
+func (c *SignupStarter) globalLimitReached(ctx context.Context, store SignupStore) (bool, error) {
+	if c.MaxSignupsPerDay == 0 {
+		return false, nil
+	}
+
+	startOfDay := time.Now().UTC().Truncate(24 * time.Hour)
+
+	numSignups, err := store.CountCreatedSince(ctx, startOfDay)
+	if err != nil {
+		return false, xerrors.Errorf("error counting today's signups: %w", err)
+	}
+
+	if numSignups >= c.MaxSignupsPerDay {
+		logrus.Warnf("Global limit of %d signup(s) per day reached; rejecting signup: %s",
+			c.MaxSignupsPerDay, c.Email)
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// newSignup initializes a signup for the email with a random token and any
+// attribution, ready to be inserted.
+func (c *SignupStarter) newSignup() *Signup {
+	utmCampaign, utmMedium, utmSource := c.attributionValues()
+
+	return &Signup{
+		Email:       c.Email,
+		Token:       uuid.New().String(),
+		UTMCampaign: utmCampaign,
+		UTMMedium:   utmMedium,
+		UTMSource:   utmSource,
+	}
+}
+
+// refreshSignedToken replaces a signup's token with a newly signed one that
+// expires after TokenTTL, returning it. If signed tokens aren't enabled, the
+// signup's existing token is returned unchanged.
+func (c *SignupStarter) refreshSignedToken(ctx context.Context, store SignupStore, id int64, token string) (string, error) {
+	if c.TokenSigner == nil {
+		return token, nil
+	}
+
+	token = c.TokenSigner.Encode(id, time.Now().Add(c.TokenTTL))
+
+	if err := store.UpdateToken(ctx, id, token); err != nil {
+		return "", xerrors.Errorf("error updating signed token: %w", err)
+	}
+
+	return token, nil
+}
+
+// resendWindow returns the window within which a confirmation won't be
+// resent, falling back to a default if one wasn't configured.
+func (c *SignupStarter) resendWindow() time.Duration {
+	if c.ResendWindow > 0 {
+		return c.ResendWindow
+	}
+	return defaultResendWindow
+}
+
+// sendConfirmationMessage sends a confirmation message with a link containing
+// token, or enqueues it if confirmations are delayed. The caller is expected
+// to have already checked that the email isn't on the suppression list.
+func (c *SignupStarter) sendConfirmationMessage(ctx context.Context, store SignupStore, signupID int64, token string) error {
+	logger := signupLogger(signupID)
+
+	logger.Infof("Sending confirmation mail to %v with token %v\n", c.Email, token)
+
+	params, err := c.confirmationMessage(token)
+	if err != nil {
+		return err
+	}
+
+	if c.ConfirmationDelayMax > 0 {
+		sendAfter := c.confirmationSendAfter()
+		logger.Infof("Scheduling confirmation mail to %v for %v", c.Email, sendAfter)
+		return store.EnqueueMessage(ctx, params, sendAfter)
+	}
+
+	return c.MailAPI.SendMessage(ctx, params)
+}
+
+// subscribeDirectly adds the email to the list without confirmation, marking
+// its signup as completed. Used for single opt-in. The caller is expected to
+// have already checked that the email isn't on the suppression list.
+func (c *SignupStarter) subscribeDirectly(ctx context.Context, store SignupStore) (*SignupStarterResult, error) {
+	// The global limit only applies to new signups.
+	existing, err := store.FindByEmail(ctx, c.Email)
+	switch {
+	case errors.Is(err, ErrSignupNotFound):
+		limitReached, err := c.globalLimitReached(ctx, store)
+		if err != nil {
+			return nil, err
+		}
+		if limitReached {
+			return &SignupStarterResult{GlobalLimitReached: true}, nil
+		}
+
+	case err != nil:
+		return nil, xerrors.Errorf("error querying for existing record: %w", err)
+	}
+
+	// Upsert rather than insert so that a concurrent signup for the same
+	// email completes the existing row instead of failing on its uniqueness.
+	signup := c.newSignup()
+	if err := store.UpsertCompleted(ctx, signup); err != nil {
+		return nil, err
+	}
+
+	// Only notify the first time that the email is subscribed, and not when
+	// the form is resubmitted.
+	webhookEnqueued := c.NotifyWebhook && (existing == nil || existing.CompletedAt == nil)
+	if webhookEnqueued {
+		if err := store.EnqueueWebhook(ctx, WebhookEventSubscriberConfirmed, c.Email); err != nil {
+			return nil, err
+		}
+	}
+
+	if contextCancelled(ctx, c.Email) {
+		return &SignupStarterResult{Cancelled: true}, nil
+	}
+
+	logger := signupLogger(signup.ID)
+	logger.Infof("Adding %v to the list directly (single opt-in)\n", c.Email)
+	err = c.MailAPI.AddMember(ctx, c.ListAddress, c.Email)
+	if err != nil {
+		return nil, xerrors.Errorf("error adding email to list: %w", err)
+	}
+
+	if err := store.MarkMemberAdded(ctx, signup.ID); err != nil {
+		return nil, err
+	}
+
+	if c.ConfirmedEmailCache != nil {
+		c.ConfirmedEmailCache.Add(c.Email)
+	}
+
+	return &SignupStarterResult{DirectlySubscribed: true, WebhookEnqueued: webhookEnqueued}, nil
 }
 
 // SignupStarterResult holds the results of a successful run of SignupStarter.
 type SignupStarterResult struct {
+	AlreadySubscribed bool
+	Bounced           bool
+
+	// Cancelled indicates that the context was cancelled, like because the
+	// client disconnected, so the signup was abandoned before sending
+	// anything. Changes made in the transaction should be rolled back.
+	Cancelled bool
+
 	ConfirmationRateLimited bool
 	ConfirmationResent      bool
+	DirectlySubscribed      bool
+	GlobalLimitReached      bool
 	MaxNumAttempts          bool
 	NewSignup               bool
+
+	// Resubscribing indicates that the email had unsubscribed, and a
+	// confirmation was sent so that it can subscribe again.
+	Resubscribing bool
+
+	// Suppressed indicates that the email is on the suppression list because
+	// mail to it bounced or was marked as spam, so no confirmation was sent.
+	Suppressed bool
+
+	// WebhookEnqueued indicates that a webhook for the newly subscribed email
+	// was put in the outbox.
+	WebhookEnqueued bool
+}
+
+//
+// Private functions
+//
+
+// signupLogger returns a logger whose entries are tagged with a signup's ID.
+// It's used by every mediator that logs about a particular signup.
+func signupLogger(signupID int64) *logrus.Entry {
+	return logrus.WithField(logFieldSignupID, signupID)
 }
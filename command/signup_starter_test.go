@@ -2,12 +2,15 @@ package command
 
 import (
 	"context"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/jackc/pgx/v4"
 	"github.com/stretchr/testify/require"
 
 	"github.com/brandur/passages-signup/mailclient"
+	"github.com/brandur/passages-signup/signedtoken"
 	"github.com/brandur/passages-signup/testhelpers"
 )
 
@@ -25,11 +28,80 @@ func TestSignupStarter(t *testing.T) {
 
 			require.False(t, res.ConfirmationRateLimited)
 			require.False(t, res.ConfirmationResent)
+			require.False(t, res.DirectlySubscribed)
 			require.False(t, res.MaxNumAttempts)
 			require.True(t, res.NewSignup)
 
 			require.Len(t, mailAPI.MessagesSent, 1)
 			require.Equal(t, testhelpers.TestEmail, mailAPI.MessagesSent[0].Recipient)
+			require.Empty(t, mailAPI.MembersAdded)
+			require.NotContains(t, mailAPI.MessagesSent[0].ContentsHTML, "/admin/preview/")
+
+			var createdAt time.Time
+			err = tx.QueryRow(ctx, `
+				SELECT created_at
+				FROM signup
+				WHERE email = $1
+			`, testhelpers.TestEmail).Scan(&createdAt)
+			require.NoError(t, err)
+			require.WithinDuration(t, time.Now(), createdAt, time.Minute)
+		})
+	})
+
+	// New signup with UTM parameters, which are stored with the row
+	t.Run("NewSignupAttribution", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			mailAPI := mailclient.NewFakeClient()
+			mediator := signupStarter(mailAPI, testhelpers.TestEmail)
+			mediator.Attribution = NewAttribution("spring", "", "twitter")
+
+			res, err := mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.True(t, res.NewSignup)
+
+			var utmCampaign, utmMedium, utmSource *string
+			err = tx.QueryRow(ctx, `
+				SELECT utm_campaign, utm_medium, utm_source
+				FROM signup
+				WHERE email = $1
+			`, testhelpers.TestEmail).Scan(&utmCampaign, &utmMedium, &utmSource)
+			require.NoError(t, err)
+			require.Equal(t, "spring", *utmCampaign)
+			require.Nil(t, utmMedium)
+			require.Equal(t, "twitter", *utmSource)
+		})
+	})
+
+	// New signup with signed tokens, which are sent in place of the random
+	// token generated for the row
+	t.Run("NewSignupSignedToken", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			signer := signedtoken.NewSigner(testTokenSecret)
+
+			mailAPI := mailclient.NewFakeClient()
+			mediator := signupStarter(mailAPI, testhelpers.TestEmail)
+			mediator.TokenSigner = signer
+			mediator.TokenTTL = 1 * time.Hour
+
+			res, err := mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.True(t, res.NewSignup)
+
+			var id int64
+			var token string
+			err = tx.QueryRow(ctx, `
+				SELECT id, token
+				FROM signup
+				WHERE email = $1
+			`, testhelpers.TestEmail).Scan(&id, &token)
+			require.NoError(t, err)
+
+			signupID, err := signer.Decode(token)
+			require.NoError(t, err)
+			require.Equal(t, id, signupID)
+
+			require.Len(t, mailAPI.MessagesSent, 1)
+			require.Contains(t, mailAPI.MessagesSent[0].ContentsPlain, "/confirm/"+token)
 		})
 	})
 
@@ -90,6 +162,134 @@ func TestSignupStarter(t *testing.T) {
 		})
 	})
 
+	// Resending to an already subscribed email resets its member add so that
+	// confirming again re-adds them (e.g. after unsubscribing)
+	t.Run("AlreadySubscribedResetsMemberAdded", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			_, err := tx.Exec(ctx, `
+				INSERT INTO signup
+					(email, token, last_sent_at, completed_at, member_added_at)
+				VALUES
+					($1, 'not-a-real-token', NOW() - '1 month'::interval, NOW(), NOW())
+			`, testhelpers.TestEmail)
+			require.NoError(t, err)
+
+			mailAPI := mailclient.NewFakeClient()
+			mediator := signupStarter(mailAPI, testhelpers.TestEmail)
+
+			res, err := mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.True(t, res.ConfirmationResent)
+
+			var memberAddedAt *time.Time
+			err = tx.QueryRow(ctx, `
+				SELECT member_added_at
+				FROM signup
+				WHERE email = $1
+			`, testhelpers.TestEmail).Scan(&memberAddedAt)
+			require.NoError(t, err)
+			require.Nil(t, memberAddedAt)
+		})
+	})
+
+	// Email that's already subscribed when local subscription state is
+	// trusted, which skips the resend entirely
+	t.Run("AlreadySubscribedTrustLocalState", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			_, err := tx.Exec(ctx, `
+				INSERT INTO signup
+					(email, token, last_sent_at, completed_at)
+				VALUES
+					($1, 'not-a-real-token', NOW() - '1 month'::interval, NOW())
+			`, testhelpers.TestEmail)
+			require.NoError(t, err)
+
+			mailAPI := mailclient.NewFakeClient()
+			mediator := signupStarter(mailAPI, testhelpers.TestEmail)
+			mediator.TrustLocalSubscriptionState = true
+
+			res, err := mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.Equal(t, &SignupStarterResult{AlreadySubscribed: true}, res)
+			require.Empty(t, mailAPI.MessagesSent)
+		})
+	})
+
+	// An unsubscribed email gets a confirmation even when local subscription
+	// state is trusted so that it can resubscribe
+	t.Run("UnsubscribedTrustLocalState", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			_, err := tx.Exec(ctx, `
+				INSERT INTO signup
+					(email, token, last_sent_at, completed_at, unsubscribed_at)
+				VALUES
+					($1, 'not-a-real-token', NOW() - '1 month'::interval, NOW(), NOW())
+			`, testhelpers.TestEmail)
+			require.NoError(t, err)
+
+			mailAPI := mailclient.NewFakeClient()
+			mediator := signupStarter(mailAPI, testhelpers.TestEmail)
+			mediator.TrustLocalSubscriptionState = true
+
+			res, err := mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.False(t, res.AlreadySubscribed)
+			require.True(t, res.ConfirmationResent)
+			require.Len(t, mailAPI.MessagesSent, 1)
+		})
+	})
+
+	// An email that completed signup and then unsubscribed starts over as a
+	// fresh opt-in, and stays unsubscribed until it's confirmed again
+	t.Run("Resubscribe", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			token := "test-token"
+
+			_, err := tx.Exec(ctx, `
+				INSERT INTO signup
+					(email, token, last_sent_at, completed_at, member_added_at, num_attempts, unsubscribed_at)
+				VALUES
+					($1, $2, NOW() - '1 month'::interval, NOW(), NOW(), $3, NOW())
+			`, testhelpers.TestEmail, token, maxNumSignupAttempts)
+			require.NoError(t, err)
+
+			mailAPI := mailclient.NewFakeClient()
+			mediator := signupStarter(mailAPI, testhelpers.TestEmail)
+
+			res, err := mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.Equal(t, &SignupStarterResult{ConfirmationResent: true, Resubscribing: true}, res)
+			require.Len(t, mailAPI.MessagesSent, 1)
+
+			var completedAt, unsubscribedAt *time.Time
+			var numAttempts int64
+			err = tx.QueryRow(ctx, `
+				SELECT completed_at, num_attempts, unsubscribed_at
+				FROM signup
+				WHERE email = $1
+			`, testhelpers.TestEmail).Scan(&completedAt, &numAttempts, &unsubscribedAt)
+			require.NoError(t, err)
+			require.Nil(t, completedAt)
+			require.Equal(t, int64(1), numAttempts)
+			require.NotNil(t, unsubscribedAt)
+
+			// Following the confirmation link clears the unsubscribe.
+			finishRes, err := signupFinisher(mailAPI, token).Run(ctx, tx)
+			require.NoError(t, err)
+			require.True(t, finishRes.SignupFinished)
+			require.Len(t, mailAPI.MembersAdded, 1)
+
+			err = tx.QueryRow(ctx, `
+				SELECT completed_at, unsubscribed_at
+				FROM signup
+				WHERE email = $1
+			`, testhelpers.TestEmail).Scan(&completedAt, &unsubscribedAt)
+			require.NoError(t, err)
+			require.NotNil(t, completedAt)
+			require.Nil(t, unsubscribedAt)
+		})
+	})
+
 	// Email already in progress, but too soon after last attempt
 	t.Run("ConfirmationRateLimited", func(t *testing.T) {
 		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
@@ -117,6 +317,91 @@ func TestSignupStarter(t *testing.T) {
 		})
 	})
 
+	// Email is on the suppression list, so nothing is written or sent
+	t.Run("Suppressed", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			lastSentAt := time.Now().Add(-1 * time.Hour * 24 * 30).Truncate(time.Second)
+
+			_, err := tx.Exec(ctx, `
+				INSERT INTO signup
+					(email, token, last_sent_at)
+				VALUES
+					($1, 'not-a-real-token', $2)
+			`, testhelpers.TestEmail, lastSentAt)
+			require.NoError(t, err)
+
+			_, err = tx.Exec(ctx, `
+				INSERT INTO suppression
+					(email, reason)
+				VALUES
+					($1, 'complained'),
+					('new@example.com', 'complained')
+			`, testhelpers.TestEmail)
+			require.NoError(t, err)
+
+			for _, email := range []string{testhelpers.TestEmail, "new@example.com"} {
+				mailAPI := mailclient.NewFakeClient()
+				mediator := signupStarter(mailAPI, email)
+
+				res, err := mediator.Run(ctx, tx)
+				require.NoError(t, err)
+				require.Equal(t, &SignupStarterResult{Suppressed: true}, res)
+				require.Empty(t, mailAPI.MessagesSent)
+			}
+
+			var (
+				newSignupExists bool
+				numAttempts     int64
+				sentAt          time.Time
+				token           string
+			)
+			err = tx.QueryRow(ctx, `
+				SELECT num_attempts, last_sent_at, token
+				FROM signup
+				WHERE email = $1
+			`, testhelpers.TestEmail).Scan(&numAttempts, &sentAt, &token)
+			require.NoError(t, err)
+			require.Equal(t, int64(1), numAttempts)
+			require.True(t, sentAt.Equal(lastSentAt))
+			require.Equal(t, "not-a-real-token", token)
+
+			err = tx.QueryRow(ctx, `
+				SELECT EXISTS (
+					SELECT 1
+					FROM signup
+					WHERE email = 'new@example.com'
+				)
+			`).Scan(&newSignupExists)
+			require.NoError(t, err)
+			require.False(t, newSignupExists)
+		})
+	})
+
+	// Email previously hard bounced, so no more confirmations are sent to it
+	t.Run("Bounced", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			_, err := tx.Exec(ctx, `
+				INSERT INTO signup
+					(email, token, last_sent_at, bounced_at)
+				VALUES
+					($1, 'not-a-real-token', NOW() - '1 month'::interval, NOW())
+			`, testhelpers.TestEmail)
+			require.NoError(t, err)
+
+			mailAPI := mailclient.NewFakeClient()
+			mediator := signupStarter(mailAPI, testhelpers.TestEmail)
+
+			res, err := mediator.Run(ctx, tx)
+			require.NoError(t, err)
+
+			require.True(t, res.Bounced)
+			require.False(t, res.ConfirmationResent)
+			require.False(t, res.NewSignup)
+
+			require.Empty(t, mailAPI.MessagesSent)
+		})
+	})
+
 	// We've tried to send a confirmation email many times before, but it's
 	// never worked out so we give up.
 	t.Run("MaxNumAttempts", func(t *testing.T) {
@@ -177,6 +462,293 @@ func TestSignupStarter(t *testing.T) {
 		})
 	})
 
+	// A link to view the message in a browser is included if enabled
+	t.Run("PreviewLink", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			mailAPI := mailclient.NewFakeClient()
+			mediator := signupStarter(mailAPI, testhelpers.TestEmail)
+			mediator.PreviewLink = true
+
+			_, err := mediator.Run(ctx, tx)
+			require.NoError(t, err)
+
+			require.Len(t, mailAPI.MessagesSent, 1)
+			require.Contains(t, mailAPI.MessagesSent[0].ContentsHTML, renderer.PublicURL+"/admin/preview/")
+			require.Contains(t, mailAPI.MessagesSent[0].ContentsPlain, renderer.PublicURL+"/admin/preview/")
+		})
+	})
+
+	// With a confirmation delay, the confirmation is put in the outbox to be
+	// sent later instead of being sent immediately
+	t.Run("ConfirmationDelay", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			mailAPI := mailclient.NewFakeClient()
+			mediator := signupStarter(mailAPI, testhelpers.TestEmail)
+			mediator.ConfirmationDelayMin = 10 * time.Second
+			mediator.ConfirmationDelayMax = 30 * time.Second
+
+			res, err := mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.True(t, res.NewSignup)
+			require.Empty(t, mailAPI.MessagesSent)
+
+			var sendAfter time.Time
+			err = tx.QueryRow(ctx, `
+				SELECT send_after
+				FROM outbox_message
+				WHERE sent_at IS NULL
+			`).Scan(&sendAfter)
+			require.NoError(t, err)
+			require.WithinRange(t, sendAfter,
+				time.Now().Add(10*time.Second-time.Minute), time.Now().Add(30*time.Second))
+
+			// Not sent before its send_after.
+			sender := &OutboxSender{MailAPI: mailAPI}
+			_, err = sender.Run(ctx, tx)
+			require.NoError(t, err)
+			require.Empty(t, mailAPI.MessagesSent)
+
+			_, err = tx.Exec(ctx, `
+				UPDATE outbox_message
+				SET send_after = NOW() - '1 second'::interval
+			`)
+			require.NoError(t, err)
+
+			_, err = sender.Run(ctx, tx)
+			require.NoError(t, err)
+			require.Len(t, mailAPI.MessagesSent, 1)
+			require.Equal(t, testhelpers.TestEmail, mailAPI.MessagesSent[0].Recipient)
+		})
+	})
+
+	t.Run("ConfirmationDelayInvalidRange", func(t *testing.T) {
+		mediator := signupStarter(mailclient.NewFakeClient(), testhelpers.TestEmail)
+		mediator.ConfirmationDelayMin = 30 * time.Second
+		mediator.ConfirmationDelayMax = 10 * time.Second
+
+		_, err := mediator.Run(ctx, nil)
+		require.ErrorContains(t, err, "ConfirmationDelayMax")
+	})
+
+	// Email that was recently confirmed and is in the cache skips the database
+	// entirely
+	t.Run("AlreadySubscribedCached", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			cache := NewConfirmedEmailCache(10, time.Hour)
+			cache.Add(testhelpers.TestEmail)
+
+			mailAPI := mailclient.NewFakeClient()
+			mediator := signupStarter(mailAPI, testhelpers.TestEmail)
+			mediator.ConfirmedEmailCache = cache
+
+			countingTx := &queryCountingTx{Tx: tx}
+			res, err := mediator.Run(ctx, countingTx)
+			require.NoError(t, err)
+
+			require.True(t, res.AlreadySubscribed)
+			require.False(t, res.NewSignup)
+
+			require.Zero(t, countingTx.numQueries)
+			require.Empty(t, mailAPI.MessagesSent)
+		})
+	})
+
+	// An email not in the cache goes through the database as usual
+	t.Run("NotCached", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			mailAPI := mailclient.NewFakeClient()
+			mediator := signupStarter(mailAPI, testhelpers.TestEmail)
+			mediator.ConfirmedEmailCache = NewConfirmedEmailCache(10, time.Hour)
+
+			countingTx := &queryCountingTx{Tx: tx}
+			res, err := mediator.Run(ctx, countingTx)
+			require.NoError(t, err)
+
+			require.False(t, res.AlreadySubscribed)
+			require.True(t, res.NewSignup)
+
+			require.NotZero(t, countingTx.numQueries)
+		})
+	})
+
+	// A blocked email is rejected without touching the database or sending
+	// any mail
+	t.Run("BlockedEmail", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			mailAPI := mailclient.NewFakeClient()
+			mediator := signupStarter(mailAPI, strings.ToUpper(testhelpers.TestEmail))
+			mediator.EmailBlocklist = NewEmailBlocklist([]string{testhelpers.TestEmail})
+
+			countingTx := &queryCountingTx{Tx: tx}
+			_, err := mediator.Run(ctx, countingTx)
+			require.ErrorIs(t, err, ErrBlockedEmail)
+
+			require.Zero(t, countingTx.numQueries)
+			require.Empty(t, mailAPI.MessagesSent)
+			require.Empty(t, mailAPI.MembersAdded)
+		})
+	})
+
+	// An email at a blocked domain is rejected
+	t.Run("BlockedDomain", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			mailAPI := mailclient.NewFakeClient()
+			mediator := signupStarter(mailAPI, "anyone@spam.example")
+			mediator.EmailBlocklist = NewEmailBlocklist([]string{"@spam.example"})
+
+			_, err := mediator.Run(ctx, tx)
+			require.ErrorIs(t, err, ErrBlockedEmail)
+			require.Empty(t, mailAPI.MessagesSent)
+		})
+	})
+
+	// An email that's not on the blocklist signs up as usual
+	t.Run("NotBlockedEmail", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			mailAPI := mailclient.NewFakeClient()
+			mediator := signupStarter(mailAPI, testhelpers.TestEmail)
+			mediator.EmailBlocklist = NewEmailBlocklist([]string{"blocked@example.com", "@spam.example"})
+
+			res, err := mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.True(t, res.NewSignup)
+			require.Len(t, mailAPI.MessagesSent, 1)
+		})
+	})
+
+	// New signups are accepted up to the global daily limit, after which
+	// they're rejected, but existing signups are still processed
+	t.Run("GlobalLimit", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			// Signups from before today don't count toward the limit.
+			_, err := tx.Exec(ctx, `
+				INSERT INTO signup
+					(email, token, created_at, last_sent_at)
+				VALUES
+					('yesterday@example.com', 'token-yesterday', NOW() - '2 days'::interval, NOW() - '2 days'::interval)
+			`)
+			require.NoError(t, err)
+
+			_, err = tx.Exec(ctx, `
+				INSERT INTO signup
+					(email, token)
+				VALUES
+					('today@example.com', 'token-today')
+			`)
+			require.NoError(t, err)
+
+			mailAPI := mailclient.NewFakeClient()
+			mediator := signupStarter(mailAPI, testhelpers.TestEmail)
+			mediator.MaxSignupsPerDay = 2
+
+			// One signup today, so one more is allowed.
+			res, err := mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.True(t, res.NewSignup)
+			require.False(t, res.GlobalLimitReached)
+
+			// Now at the limit.
+			mediator = signupStarter(mailAPI, "another@example.com")
+			mediator.MaxSignupsPerDay = 2
+
+			res, err = mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.True(t, res.GlobalLimitReached)
+			require.False(t, res.NewSignup)
+			require.Len(t, mailAPI.MessagesSent, 1)
+
+			var exists bool
+			err = tx.QueryRow(ctx, `
+				SELECT EXISTS (
+					SELECT 1
+					FROM signup
+					WHERE email = 'another@example.com'
+				)
+			`).Scan(&exists)
+			require.NoError(t, err)
+			require.False(t, exists)
+
+			// An existing signup isn't subject to the limit.
+			mediator = signupStarter(mailAPI, "yesterday@example.com")
+			mediator.MaxSignupsPerDay = 2
+
+			res, err = mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.True(t, res.ConfirmationResent)
+			require.Len(t, mailAPI.MessagesSent, 2)
+
+			// The limit also applies to single opt-in.
+			mediator = signupStarter(mailAPI, "another@example.com")
+			mediator.MaxSignupsPerDay = 2
+			mediator.SingleOptIn = true
+
+			res, err = mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.True(t, res.GlobalLimitReached)
+			require.Empty(t, mailAPI.MembersAdded)
+		})
+	})
+
+	// Single opt-in subscribes immediately without a confirmation email
+	t.Run("SingleOptIn", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			mailAPI := mailclient.NewFakeClient()
+			mediator := signupStarter(mailAPI, testhelpers.TestEmail)
+			mediator.SingleOptIn = true
+
+			res, err := mediator.Run(ctx, tx)
+			require.NoError(t, err)
+
+			require.True(t, res.DirectlySubscribed)
+			require.False(t, res.NewSignup)
+
+			require.Empty(t, mailAPI.MessagesSent)
+			require.Len(t, mailAPI.MembersAdded, 1)
+			require.Equal(t, testListAddress, mailAPI.MembersAdded[0].List)
+			require.Equal(t, testhelpers.TestEmail, mailAPI.MembersAdded[0].Email)
+
+			var completedAt *time.Time
+			err = tx.QueryRow(ctx, `
+				SELECT completed_at
+				FROM signup
+				WHERE email = $1
+			`, testhelpers.TestEmail).Scan(&completedAt)
+			require.NoError(t, err)
+			require.NotNil(t, completedAt)
+		})
+	})
+
+	// Single opt-in for an email with a pending signup completes it
+	t.Run("SingleOptInExistingPending", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			_, err := tx.Exec(ctx, `
+				INSERT INTO signup
+					(email, token)
+				VALUES
+					($1, 'not-a-real-token')
+			`, testhelpers.TestEmail)
+			require.NoError(t, err)
+
+			mailAPI := mailclient.NewFakeClient()
+			mediator := signupStarter(mailAPI, testhelpers.TestEmail)
+			mediator.SingleOptIn = true
+
+			res, err := mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.True(t, res.DirectlySubscribed)
+			require.Len(t, mailAPI.MembersAdded, 1)
+
+			var completedAt *time.Time
+			err = tx.QueryRow(ctx, `
+				SELECT completed_at
+				FROM signup
+				WHERE email = $1
+			`, testhelpers.TestEmail).Scan(&completedAt)
+			require.NoError(t, err)
+			require.NotNil(t, completedAt)
+		})
+	})
+
 	// Invalid email address
 	t.Run("InvalidEmail", func(t *testing.T) {
 		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
@@ -187,6 +759,58 @@ func TestSignupStarter(t *testing.T) {
 			require.ErrorIs(t, err, ErrInvalidEmail)
 		})
 	})
+
+	// Email addresses at the RFC 5321 limits are accepted
+	t.Run("EmailAtMaxLength", func(t *testing.T) {
+		for _, email := range []string{
+			strings.Repeat("a", 64) + "@example.com",
+			emailOfLength(64, 254),
+		} {
+			testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+				mailAPI := mailclient.NewFakeClient()
+				mediator := signupStarter(mailAPI, email)
+
+				res, err := mediator.Run(ctx, tx)
+				require.NoError(t, err)
+				require.True(t, res.NewSignup)
+			})
+		}
+	})
+
+	// Email addresses over the RFC 5321 limits are rejected
+	t.Run("EmailTooLong", func(t *testing.T) {
+		for _, email := range []string{
+			strings.Repeat("a", 65) + "@example.com",
+			emailOfLength(64, 255),
+		} {
+			testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+				mailAPI := mailclient.NewFakeClient()
+				mediator := signupStarter(mailAPI, email)
+
+				_, err := mediator.Run(ctx, tx)
+				require.ErrorIs(t, err, ErrEmailTooLong)
+				require.Empty(t, mailAPI.MessagesSent)
+			})
+		}
+	})
+
+	// A configured maximum is tighter than the RFC 5321 limits
+	t.Run("EmailOverConfiguredMaxLength", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			mailAPI := mailclient.NewFakeClient()
+			mediator := signupStarter(mailAPI, testhelpers.TestEmail)
+			mediator.MaxEmailLength = len(testhelpers.TestEmail) - 1
+
+			_, err := mediator.Run(ctx, tx)
+			require.ErrorIs(t, err, ErrEmailTooLong)
+
+			mediator.MaxEmailLength = len(testhelpers.TestEmail)
+
+			res, err := mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.True(t, res.NewSignup)
+		})
+	})
 }
 
 //
@@ -202,3 +826,21 @@ func signupStarter(mailAPI mailclient.API, email string) *SignupStarter {
 		ReplyToAddress: testReplyToAddress,
 	}
 }
+
+// emailOfLength builds a syntactically valid email address of exactly the
+// given total length, with a local part of the given length.
+func emailOfLength(localLength, length int) string {
+	local := strings.Repeat("a", localLength)
+
+	// Fill the domain with labels of the maximum length, then finish with a
+	// top-level domain.
+	domainLength := length - localLength - 1
+	var labels []string
+	for domainLength-len(".com") > 63 {
+		labels = append(labels, strings.Repeat("b", 63))
+		domainLength -= 64
+	}
+	labels = append(labels, strings.Repeat("c", domainLength-len(".com")), "com")
+
+	return local + "@" + strings.Join(labels, ".")
+}
@@ -0,0 +1,381 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+	"golang.org/x/xerrors"
+
+	"github.com/brandur/passages-signup/mailclient"
+)
+
+// ErrSignupNotFound is returned by a SignupStore when no signup matches a
+// lookup.
+var ErrSignupNotFound = errors.New("signup not found")
+
+// Signup is a signup as kept in a SignupStore.
+type Signup struct {
+	BouncedAt   *time.Time
+	CompletedAt *time.Time
+	CreatedAt   time.Time
+	Email       string
+	ID          int64
+	LastSentAt  time.Time
+
+	// MemberAddDeferredAt is set if adding the email to the list was
+	// deferred, like because the mail service's quota was exceeded.
+	MemberAddDeferredAt *time.Time
+
+	MemberAddedAt  *time.Time
+	NumAttempts    int64
+	Token          string
+	UnsubscribedAt *time.Time
+
+	// UTM parameters that the signup arrived with. Nil if not provided.
+	UTMCampaign *string
+	UTMMedium   *string
+	UTMSource   *string
+}
+
+// SignupStore is where the signup mediators read and write signups. It allows
+// the mediators to be exercised without Postgres, and alternative stores to
+// be swapped in.
+//
+// Mediators default to a PgxSignupStore on the transaction that they're run
+// with, so a store only needs to be set explicitly to use another one.
+type SignupStore interface {
+	// ClearCompleted clears a signup's completion so that it has to be
+	// confirmed again.
+	ClearCompleted(ctx context.Context, id int64) error
+
+	// CountCreatedSince counts signups created at or after since.
+	CountCreatedSince(ctx context.Context, since time.Time) (int, error)
+
+	// EnqueueMessage stores a message in the outbox to be sent by
+	// OutboxSender once sendAfter has passed.
+	EnqueueMessage(ctx context.Context, params *mailclient.SendMessageParams, sendAfter time.Time) error
+
+	// EnqueueWebhook stores a webhook event in the outbox to be sent by
+	// WebhookOutboxSender.
+	EnqueueWebhook(ctx context.Context, eventType, email string) error
+
+	// FindByEmail finds a signup by email, returning ErrSignupNotFound if
+	// there isn't one.
+	FindByEmail(ctx context.Context, email string) (*Signup, error)
+
+	// FindByID finds a signup by ID, returning ErrSignupNotFound if there
+	// isn't one.
+	FindByID(ctx context.Context, id int64) (*Signup, error)
+
+	// FindByToken finds a signup by token, returning ErrSignupNotFound if
+	// there isn't one.
+	FindByToken(ctx context.Context, token string) (*Signup, error)
+
+	// IsSuppressed checks whether an email is on the suppression list, in
+	// which case no mail should be sent to it.
+	IsSuppressed(ctx context.Context, email string) (bool, error)
+
+	// Insert stores a new signup from its email, token, and UTM parameters.
+	// The signup's ID and other defaults are filled in after insertion.
+	Insert(ctx context.Context, signup *Signup) error
+
+	// MarkCompleted records a signup as having been completed now.
+	MarkCompleted(ctx context.Context, id int64) error
+
+	// MarkMemberAddDeferred records that adding a signup's email to the list
+	// has been deferred.
+	MarkMemberAddDeferred(ctx context.Context, id int64) error
+
+	// MarkMemberAdded records that a signup's email was added to the list,
+	// clearing any previous deferral or unsubscribe.
+	MarkMemberAdded(ctx context.Context, id int64) error
+
+	// UpdateSent records that a confirmation was sent now along with the
+	// new number of attempts. The member's list add is reset so that
+	// following the confirmation link again re-adds them.
+	UpdateSent(ctx context.Context, id int64, numAttempts int64) error
+
+	// UpdateToken replaces a signup's token.
+	UpdateToken(ctx context.Context, id int64, token string) error
+
+	// UpsertCompleted stores a new signup as already completed. If a signup
+	// with the same email exists, like because one was inserted concurrently,
+	// it's marked completed instead of failing. Either way, the stored
+	// signup's ID and other values are filled in.
+	UpsertCompleted(ctx context.Context, signup *Signup) error
+}
+
+// signupStoreOrDefault returns store if it's set, or otherwise a Postgres
+// store that uses tx.
+func signupStoreOrDefault(store SignupStore, tx pgx.Tx) SignupStore {
+	if store != nil {
+		return store
+	}
+	return NewPgxSignupStore(tx)
+}
+
+//
+// PgxSignupStore
+//
+
+// PgxSignupStore is an implementation of SignupStore that keeps signups in
+// Postgres, operating within a transaction.
+type PgxSignupStore struct {
+	tx pgx.Tx
+}
+
+// NewPgxSignupStore initializes a new PgxSignupStore that operates within the
+// given transaction.
+func NewPgxSignupStore(tx pgx.Tx) *PgxSignupStore {
+	return &PgxSignupStore{tx: tx}
+}
+
+// ClearCompleted clears a signup's completion.
+func (s *PgxSignupStore) ClearCompleted(ctx context.Context, id int64) error {
+	_, err := s.tx.Exec(ctx, `
+		UPDATE signup
+		SET completed_at = NULL
+		WHERE id = $1
+	`, id)
+	if err != nil {
+		return xerrors.Errorf("error clearing signup completed: %w", err)
+	}
+	return nil
+}
+
+// CountCreatedSince counts signups created at or after since.
+func (s *PgxSignupStore) CountCreatedSince(ctx context.Context, since time.Time) (int, error) {
+	var numSignups int
+	err := s.tx.QueryRow(ctx, `
+		SELECT count(*)
+		FROM signup
+		WHERE created_at >= $1
+	`, since).Scan(&numSignups)
+	if err != nil {
+		return 0, xerrors.Errorf("error counting signups: %w", err)
+	}
+	return numSignups, nil
+}
+
+// EnqueueMessage stores a message in the outbox.
+func (s *PgxSignupStore) EnqueueMessage(ctx context.Context, params *mailclient.SendMessageParams, sendAfter time.Time) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return xerrors.Errorf("error encoding outbox message: %w", err)
+	}
+
+	_, err = s.tx.Exec(ctx, `
+		INSERT INTO outbox_message
+			(params, send_after)
+		VALUES
+			($1, $2)
+	`, data, sendAfter)
+	if err != nil {
+		return xerrors.Errorf("error inserting outbox message: %w", err)
+	}
+	return nil
+}
+
+// EnqueueWebhook stores a webhook event in the outbox.
+func (s *PgxSignupStore) EnqueueWebhook(ctx context.Context, eventType, email string) error {
+	_, err := s.tx.Exec(ctx, `
+		INSERT INTO outbox_webhook
+			(email, event_type)
+		VALUES
+			($1, $2)
+	`, email, eventType)
+	if err != nil {
+		return xerrors.Errorf("error inserting outbox webhook: %w", err)
+	}
+	return nil
+}
+
+// FindByEmail finds a signup by email.
+func (s *PgxSignupStore) FindByEmail(ctx context.Context, email string) (*Signup, error) {
+	return scanSignup(s.tx.QueryRow(ctx, `
+		SELECT id, bounced_at, completed_at, created_at, email, last_sent_at,
+			member_add_deferred_at, member_added_at, num_attempts, token, unsubscribed_at,
+			utm_campaign, utm_medium, utm_source
+		FROM signup
+		WHERE email = $1
+	`, email))
+}
+
+// FindByID finds a signup by ID.
+func (s *PgxSignupStore) FindByID(ctx context.Context, id int64) (*Signup, error) {
+	return scanSignup(s.tx.QueryRow(ctx, `
+		SELECT id, bounced_at, completed_at, created_at, email, last_sent_at,
+			member_add_deferred_at, member_added_at, num_attempts, token, unsubscribed_at,
+			utm_campaign, utm_medium, utm_source
+		FROM signup
+		WHERE id = $1
+	`, id))
+}
+
+// FindByToken finds a signup by token.
+func (s *PgxSignupStore) FindByToken(ctx context.Context, token string) (*Signup, error) {
+	return scanSignup(s.tx.QueryRow(ctx, `
+		SELECT id, bounced_at, completed_at, created_at, email, last_sent_at,
+			member_add_deferred_at, member_added_at, num_attempts, token, unsubscribed_at,
+			utm_campaign, utm_medium, utm_source
+		FROM signup
+		WHERE token = $1
+	`, token))
+}
+
+// IsSuppressed checks whether an email is on the suppression list.
+func (s *PgxSignupStore) IsSuppressed(ctx context.Context, email string) (bool, error) {
+	var suppressed bool
+	err := s.tx.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1
+			FROM suppression
+			WHERE email = $1
+		)
+	`, email).Scan(&suppressed)
+	if err != nil {
+		return false, xerrors.Errorf("error checking suppression: %w", err)
+	}
+	return suppressed, nil
+}
+
+// Insert stores a new signup.
+func (s *PgxSignupStore) Insert(ctx context.Context, signup *Signup) error {
+	err := s.tx.QueryRow(ctx, `
+		INSERT INTO signup
+			(email, token, utm_campaign, utm_medium, utm_source)
+		VALUES
+			($1, $2, $3, $4, $5)
+		RETURNING id, created_at, last_sent_at, num_attempts
+	`, signup.Email, signup.Token, signup.UTMCampaign, signup.UTMMedium, signup.UTMSource).
+		Scan(&signup.ID, &signup.CreatedAt, &signup.LastSentAt, &signup.NumAttempts)
+	if err != nil {
+		return xerrors.Errorf("error inserting signup row: %w", err)
+	}
+	return nil
+}
+
+// MarkCompleted records a signup as having been completed now.
+func (s *PgxSignupStore) MarkCompleted(ctx context.Context, id int64) error {
+	_, err := s.tx.Exec(ctx, `
+		UPDATE signup
+		SET completed_at = NOW()
+		WHERE id = $1
+	`, id)
+	if err != nil {
+		return xerrors.Errorf("error marking signup completed: %w", err)
+	}
+	return nil
+}
+
+// MarkMemberAddDeferred records that adding a signup's email to the list has
+// been deferred.
+func (s *PgxSignupStore) MarkMemberAddDeferred(ctx context.Context, id int64) error {
+	_, err := s.tx.Exec(ctx, `
+		UPDATE signup
+		SET member_add_deferred_at = NOW()
+		WHERE id = $1
+	`, id)
+	if err != nil {
+		return xerrors.Errorf("error marking member add deferred: %w", err)
+	}
+	return nil
+}
+
+// MarkMemberAdded records that a signup's email was added to the list.
+func (s *PgxSignupStore) MarkMemberAdded(ctx context.Context, id int64) error {
+	_, err := s.tx.Exec(ctx, `
+		UPDATE signup
+		SET
+		  member_add_deferred_at = NULL,
+		  member_added_at = NOW(),
+		  unsubscribed_at = NULL
+		WHERE id = $1
+	`, id)
+	if err != nil {
+		return xerrors.Errorf("error recording member added: %w", err)
+	}
+	return nil
+}
+
+// UpdateSent records that a confirmation was sent now.
+func (s *PgxSignupStore) UpdateSent(ctx context.Context, id int64, numAttempts int64) error {
+	_, err := s.tx.Exec(ctx, `
+		UPDATE signup
+		SET
+		  last_sent_at = NOW(),
+		  member_added_at = NULL,
+		  num_attempts = $1
+		WHERE id = $2
+	`, numAttempts, id)
+	if err != nil {
+		return xerrors.Errorf("error updating sent: %w", err)
+	}
+	return nil
+}
+
+// UpdateToken replaces a signup's token.
+func (s *PgxSignupStore) UpdateToken(ctx context.Context, id int64, token string) error {
+	_, err := s.tx.Exec(ctx, `
+		UPDATE signup
+		SET token = $1
+		WHERE id = $2
+	`, token, id)
+	if err != nil {
+		return xerrors.Errorf("error updating token: %w", err)
+	}
+	return nil
+}
+
+// UpsertCompleted stores a new signup as already completed, or marks an
+// existing signup with the same email completed.
+func (s *PgxSignupStore) UpsertCompleted(ctx context.Context, signup *Signup) error {
+	err := s.tx.QueryRow(ctx, `
+		INSERT INTO signup
+			(completed_at, email, token, utm_campaign, utm_medium, utm_source)
+		VALUES
+			(NOW(), $1, $2, $3, $4, $5)
+		ON CONFLICT (email) DO UPDATE
+		SET completed_at = COALESCE(signup.completed_at, NOW())
+		RETURNING id, completed_at, created_at, last_sent_at, num_attempts, token
+	`, signup.Email, signup.Token, signup.UTMCampaign, signup.UTMMedium, signup.UTMSource).
+		Scan(&signup.ID, &signup.CompletedAt, &signup.CreatedAt, &signup.LastSentAt, &signup.NumAttempts, &signup.Token)
+	if err != nil {
+		return xerrors.Errorf("error upserting signup row: %w", err)
+	}
+	return nil
+}
+
+//
+// Private functions
+//
+
+func scanSignup(row pgx.Row) (*Signup, error) {
+	var signup Signup
+	err := row.Scan(
+		&signup.ID,
+		&signup.BouncedAt,
+		&signup.CompletedAt,
+		&signup.CreatedAt,
+		&signup.Email,
+		&signup.LastSentAt,
+		&signup.MemberAddDeferredAt,
+		&signup.MemberAddedAt,
+		&signup.NumAttempts,
+		&signup.Token,
+		&signup.UnsubscribedAt,
+		&signup.UTMCampaign,
+		&signup.UTMMedium,
+		&signup.UTMSource,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrSignupNotFound
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("error querying for signup: %w", err)
+	}
+	return &signup, nil
+}
@@ -0,0 +1,1031 @@
+package command
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brandur/passages-signup/mailclient"
+	"github.com/brandur/passages-signup/signedtoken"
+	"github.com/brandur/passages-signup/testhelpers"
+)
+
+// These tests run mediators against an in-memory store, so unlike most tests
+// in this package they don't need a database. Behavior that's specific to
+// Postgres is still covered by the database-backed tests.
+
+func TestSignupStarter_MemoryStore(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("NewSignup", func(t *testing.T) {
+		store := newMemorySignupStore()
+		mailAPI := mailclient.NewFakeClient()
+		mediator := signupStarter(mailAPI, testhelpers.TestEmail)
+		mediator.Attribution = NewAttribution("spring", "", "twitter")
+		mediator.Store = store
+
+		res, err := mediator.Run(ctx, nil)
+		require.NoError(t, err)
+		require.True(t, res.NewSignup)
+
+		require.Len(t, mailAPI.MessagesSent, 1)
+		require.Equal(t, testhelpers.TestEmail, mailAPI.MessagesSent[0].Recipient)
+		require.Equal(t, testReplyToAddress, mailAPI.MessagesSent[0].ReplyTo)
+
+		signup, err := store.FindByEmail(ctx, testhelpers.TestEmail)
+		require.NoError(t, err)
+		require.Equal(t, int64(1), signup.NumAttempts)
+		require.Equal(t, "spring", *signup.UTMCampaign)
+		require.Nil(t, signup.UTMMedium)
+		require.Equal(t, "twitter", *signup.UTMSource)
+		require.Contains(t, mailAPI.MessagesSent[0].ContentsPlain, signup.Token)
+	})
+
+	t.Run("NewSignupLogsSignupID", func(t *testing.T) {
+		store := newMemorySignupStore()
+		hook := captureLogs(t)
+
+		mediator := signupStarter(mailclient.NewFakeClient(), testhelpers.TestEmail)
+		mediator.Store = store
+
+		_, err := mediator.Run(ctx, nil)
+		require.NoError(t, err)
+
+		signup, err := store.FindByEmail(ctx, testhelpers.TestEmail)
+		require.NoError(t, err)
+
+		entry := hook.LastEntry()
+		require.NotNil(t, entry)
+		require.Contains(t, entry.Message, "Sending confirmation mail")
+		require.Equal(t, signup.ID, entry.Data[logFieldSignupID])
+	})
+
+	t.Run("NoMailServers", func(t *testing.T) {
+		store := newMemorySignupStore()
+		mailAPI := mailclient.NewFakeClient()
+		mediator := signupStarter(mailAPI, testhelpers.TestEmail)
+		mediator.MXResolver = &stubMXResolver{}
+		mediator.Store = store
+
+		_, err := mediator.Run(ctx, nil)
+		require.ErrorIs(t, err, ErrNoMailServers)
+		require.Empty(t, mailAPI.MessagesSent)
+
+		_, err = store.FindByEmail(ctx, testhelpers.TestEmail)
+		require.ErrorIs(t, err, ErrSignupNotFound)
+	})
+
+	t.Run("NewSignupNoReplyTo", func(t *testing.T) {
+		mailAPI := mailclient.NewFakeClient()
+		mediator := signupStarter(mailAPI, testhelpers.TestEmail)
+		mediator.ReplyToAddress = ""
+		mediator.Store = newMemorySignupStore()
+
+		res, err := mediator.Run(ctx, nil)
+		require.NoError(t, err)
+		require.True(t, res.NewSignup)
+
+		require.Len(t, mailAPI.MessagesSent, 1)
+		require.Empty(t, mailAPI.MessagesSent[0].ReplyTo)
+	})
+
+	t.Run("NewSignupFromAddress", func(t *testing.T) {
+		mailAPI := mailclient.NewFakeClient()
+		mediator := signupStarter(mailAPI, testhelpers.TestEmail)
+		mediator.FromAddress = "brandur@example.com"
+		mediator.Store = newMemorySignupStore()
+
+		res, err := mediator.Run(ctx, nil)
+		require.NoError(t, err)
+		require.True(t, res.NewSignup)
+
+		require.Len(t, mailAPI.MessagesSent, 1)
+		require.Equal(t, "brandur@example.com", mailAPI.MessagesSent[0].FromAddress)
+	})
+
+	t.Run("NewSignupSignedToken", func(t *testing.T) {
+		store := newMemorySignupStore()
+		signer := signedtoken.NewSigner(testTokenSecret)
+		mailAPI := mailclient.NewFakeClient()
+		mediator := signupStarter(mailAPI, testhelpers.TestEmail)
+		mediator.Store = store
+		mediator.TokenSigner = signer
+		mediator.TokenTTL = time.Hour
+
+		_, err := mediator.Run(ctx, nil)
+		require.NoError(t, err)
+
+		signup, err := store.FindByEmail(ctx, testhelpers.TestEmail)
+		require.NoError(t, err)
+
+		id, err := signer.Decode(signup.Token)
+		require.NoError(t, err)
+		require.Equal(t, signup.ID, id)
+	})
+
+	t.Run("ConfirmationResent", func(t *testing.T) {
+		store := newMemorySignupStore()
+		signup := store.insertTestSignup(testhelpers.TestEmail, "test-token")
+		signup.LastSentAt = time.Now().Add(-2 * defaultResendWindow)
+
+		mailAPI := mailclient.NewFakeClient()
+		mediator := signupStarter(mailAPI, testhelpers.TestEmail)
+		mediator.Store = store
+
+		res, err := mediator.Run(ctx, nil)
+		require.NoError(t, err)
+		require.True(t, res.ConfirmationResent)
+
+		require.Len(t, mailAPI.MessagesSent, 1)
+		require.Equal(t, int64(2), signup.NumAttempts)
+		require.WithinDuration(t, time.Now(), signup.LastSentAt, time.Minute)
+	})
+
+	t.Run("ConfirmationRateLimited", func(t *testing.T) {
+		store := newMemorySignupStore()
+		store.insertTestSignup(testhelpers.TestEmail, "test-token")
+
+		mailAPI := mailclient.NewFakeClient()
+		mediator := signupStarter(mailAPI, testhelpers.TestEmail)
+		mediator.Store = store
+
+		res, err := mediator.Run(ctx, nil)
+		require.NoError(t, err)
+		require.True(t, res.ConfirmationRateLimited)
+		require.Empty(t, mailAPI.MessagesSent)
+	})
+
+	t.Run("ResendWindow", func(t *testing.T) {
+		store := newMemorySignupStore()
+		signup := store.insertTestSignup(testhelpers.TestEmail, "test-token")
+		signup.LastSentAt = time.Now().Add(-30 * time.Minute)
+
+		mailAPI := mailclient.NewFakeClient()
+		mediator := signupStarter(mailAPI, testhelpers.TestEmail)
+		mediator.ResendWindow = time.Hour
+		mediator.Store = store
+
+		// Still inside the window.
+		res, err := mediator.Run(ctx, nil)
+		require.NoError(t, err)
+		require.True(t, res.ConfirmationRateLimited)
+		require.Empty(t, mailAPI.MessagesSent)
+
+		// Outside the window, although well within the default one.
+		signup.LastSentAt = time.Now().Add(-2 * time.Hour)
+
+		res, err = mediator.Run(ctx, nil)
+		require.NoError(t, err)
+		require.True(t, res.ConfirmationResent)
+		require.Len(t, mailAPI.MessagesSent, 1)
+	})
+
+	t.Run("AttemptsWindow", func(t *testing.T) {
+		store := newMemorySignupStore()
+		signup := store.insertTestSignup(testhelpers.TestEmail, "test-token")
+		signup.LastSentAt = time.Now().Add(-2 * time.Hour)
+		signup.NumAttempts = maxNumSignupAttempts
+
+		mailAPI := mailclient.NewFakeClient()
+		mediator := signupStarter(mailAPI, testhelpers.TestEmail)
+		mediator.AttemptsWindow = 3 * time.Hour
+		mediator.ResendWindow = time.Hour
+		mediator.Store = store
+
+		// Attempts inside the window still count.
+		res, err := mediator.Run(ctx, nil)
+		require.NoError(t, err)
+		require.True(t, res.MaxNumAttempts)
+		require.Empty(t, mailAPI.MessagesSent)
+
+		// Once the last attempt is outside the window, they start over.
+		signup.LastSentAt = time.Now().Add(-4 * time.Hour)
+
+		res, err = mediator.Run(ctx, nil)
+		require.NoError(t, err)
+		require.True(t, res.ConfirmationResent)
+		require.Len(t, mailAPI.MessagesSent, 1)
+		require.Equal(t, int64(1), signup.NumAttempts)
+	})
+
+	t.Run("AttemptsWindowUnset", func(t *testing.T) {
+		store := newMemorySignupStore()
+		signup := store.insertTestSignup(testhelpers.TestEmail, "test-token")
+		signup.LastSentAt = time.Now().Add(-365 * 24 * time.Hour)
+		signup.NumAttempts = maxNumSignupAttempts
+
+		mailAPI := mailclient.NewFakeClient()
+		mediator := signupStarter(mailAPI, testhelpers.TestEmail)
+		mediator.Store = store
+
+		// Without a window, attempts never go stale.
+		res, err := mediator.Run(ctx, nil)
+		require.NoError(t, err)
+		require.True(t, res.MaxNumAttempts)
+		require.Empty(t, mailAPI.MessagesSent)
+	})
+
+	t.Run("Resubscribe", func(t *testing.T) {
+		store := newMemorySignupStore()
+		signup := store.insertTestSignup(testhelpers.TestEmail, "test-token")
+		signup.CompletedAt = ptr(time.Now().Add(-2 * defaultResendWindow))
+		signup.LastSentAt = time.Now().Add(-2 * defaultResendWindow)
+		signup.MemberAddedAt = signup.CompletedAt
+		signup.NumAttempts = maxNumSignupAttempts
+		signup.UnsubscribedAt = ptr(time.Now().Add(-defaultResendWindow))
+
+		mailAPI := mailclient.NewFakeClient()
+		mediator := signupStarter(mailAPI, testhelpers.TestEmail)
+		mediator.Store = store
+
+		res, err := mediator.Run(ctx, nil)
+		require.NoError(t, err)
+		require.Equal(t, &SignupStarterResult{ConfirmationResent: true, Resubscribing: true}, res)
+		require.Len(t, mailAPI.MessagesSent, 1)
+
+		// Needs confirmation again, and isn't resubscribed until then.
+		require.Nil(t, signup.CompletedAt)
+		require.Nil(t, signup.MemberAddedAt)
+		require.Equal(t, int64(1), signup.NumAttempts)
+		require.NotNil(t, signup.UnsubscribedAt)
+		require.Empty(t, mailAPI.MembersAdded)
+
+		// Submitting again later is counted as a normal attempt rather than
+		// starting over again.
+		signup.LastSentAt = time.Now().Add(-2 * defaultResendWindow)
+
+		res, err = mediator.Run(ctx, nil)
+		require.NoError(t, err)
+		require.Equal(t, &SignupStarterResult{ConfirmationResent: true}, res)
+		require.Equal(t, int64(2), signup.NumAttempts)
+
+		finisher := signupFinisher(mailAPI, "test-token")
+		finisher.Store = store
+
+		finishRes, err := finisher.Run(ctx, nil)
+		require.NoError(t, err)
+		require.True(t, finishRes.SignupFinished)
+		require.Len(t, mailAPI.MembersAdded, 1)
+
+		require.NotNil(t, signup.CompletedAt)
+		require.NotNil(t, signup.MemberAddedAt)
+		require.Nil(t, signup.UnsubscribedAt)
+	})
+
+	t.Run("GlobalLimit", func(t *testing.T) {
+		store := newMemorySignupStore()
+		store.insertTestSignup("other@example.com", "other-token")
+
+		// A signup from before today doesn't count toward the limit.
+		yesterday := store.insertTestSignup("yesterday@example.com", "yesterday-token")
+		yesterday.CreatedAt = time.Now().UTC().Truncate(24 * time.Hour).Add(-time.Minute)
+
+		mailAPI := mailclient.NewFakeClient()
+		mediator := signupStarter(mailAPI, testhelpers.TestEmail)
+		mediator.MaxSignupsPerDay = 1
+		mediator.Store = store
+
+		hook := captureLogs(t)
+
+		res, err := mediator.Run(ctx, nil)
+		require.NoError(t, err)
+		require.True(t, res.GlobalLimitReached)
+		require.Empty(t, mailAPI.MessagesSent)
+
+		// Reaching the limit is expected throttling rather than a failure.
+		entry := hook.LastEntry()
+		require.Equal(t, logrus.WarnLevel, entry.Level)
+		require.Contains(t, entry.Message, "Global limit of 1 signup(s) per day reached")
+
+		_, err = store.FindByEmail(ctx, testhelpers.TestEmail)
+		require.ErrorIs(t, err, ErrSignupNotFound)
+	})
+
+	// Delayed confirmations go in the store's outbox rather than needing a
+	// transaction
+	t.Run("ConfirmationDelay", func(t *testing.T) {
+		store := newMemorySignupStore()
+		mailAPI := mailclient.NewFakeClient()
+		mediator := signupStarter(mailAPI, testhelpers.TestEmail)
+		mediator.ConfirmationDelayMin = 10 * time.Second
+		mediator.ConfirmationDelayMax = 30 * time.Second
+		mediator.Store = store
+
+		res, err := mediator.Run(ctx, nil)
+		require.NoError(t, err)
+		require.True(t, res.NewSignup)
+		require.Empty(t, mailAPI.MessagesSent)
+
+		require.Len(t, store.messagesEnqueued, 1)
+		require.Equal(t, testhelpers.TestEmail, store.messagesEnqueued[0].params.Recipient)
+		require.WithinRange(t, store.messagesEnqueued[0].sendAfter,
+			time.Now().Add(10*time.Second-time.Minute), time.Now().Add(30*time.Second))
+	})
+
+	t.Run("SingleOptIn", func(t *testing.T) {
+		store := newMemorySignupStore()
+		mailAPI := mailclient.NewFakeClient()
+		mediator := signupStarter(mailAPI, testhelpers.TestEmail)
+		mediator.SingleOptIn = true
+		mediator.Store = store
+
+		res, err := mediator.Run(ctx, nil)
+		require.NoError(t, err)
+		require.True(t, res.DirectlySubscribed)
+
+		require.Len(t, mailAPI.MembersAdded, 1)
+		require.Empty(t, mailAPI.MessagesSent)
+
+		signup, err := store.FindByEmail(ctx, testhelpers.TestEmail)
+		require.NoError(t, err)
+		require.NotNil(t, signup.CompletedAt)
+		require.NotNil(t, signup.MemberAddedAt)
+	})
+
+	t.Run("SingleOptInWebhookEnqueued", func(t *testing.T) {
+		store := newMemorySignupStore()
+		mediator := signupStarter(mailclient.NewFakeClient(), testhelpers.TestEmail)
+		mediator.NotifyWebhook = true
+		mediator.SingleOptIn = true
+		mediator.Store = store
+
+		res, err := mediator.Run(ctx, nil)
+		require.NoError(t, err)
+		require.True(t, res.DirectlySubscribed)
+		require.True(t, res.WebhookEnqueued)
+		require.Equal(t, []*memoryOutboxWebhook{
+			{email: testhelpers.TestEmail, eventType: WebhookEventSubscriberConfirmed},
+		}, store.webhooksEnqueued)
+
+		// Submitting the form again for a subscribed email doesn't notify
+		// again.
+		res, err = mediator.Run(ctx, nil)
+		require.NoError(t, err)
+		require.True(t, res.DirectlySubscribed)
+		require.False(t, res.WebhookEnqueued)
+		require.Len(t, store.webhooksEnqueued, 1)
+	})
+
+	// Another request inserted the same email after it was looked up, so
+	// the existing signup is completed rather than failing on uniqueness.
+	t.Run("SingleOptInConcurrentSignup", func(t *testing.T) {
+		store := newMemorySignupStore()
+		require.NoError(t, store.Insert(ctx, &Signup{Email: testhelpers.TestEmail, Token: "existing-token"}))
+
+		mailAPI := mailclient.NewFakeClient()
+		mediator := signupStarter(mailAPI, testhelpers.TestEmail)
+		mediator.SingleOptIn = true
+		mediator.Store = &lookupMissingSignupStore{store}
+
+		res, err := mediator.Run(ctx, nil)
+		require.NoError(t, err)
+		require.True(t, res.DirectlySubscribed)
+		require.Len(t, mailAPI.MembersAdded, 1)
+
+		require.Len(t, store.signups, 1)
+		require.Equal(t, "existing-token", store.signups[0].Token)
+		require.NotNil(t, store.signups[0].CompletedAt)
+		require.NotNil(t, store.signups[0].MemberAddedAt)
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		store := newMemorySignupStore()
+		mailAPI := mailclient.NewFakeClient()
+		mediator := signupStarter(mailAPI, testhelpers.TestEmail)
+		mediator.Store = store
+
+		res, err := mediator.Run(ctx, nil)
+		require.NoError(t, err)
+		require.Equal(t, &SignupStarterResult{Cancelled: true}, res)
+
+		require.Empty(t, mailAPI.MessagesSent)
+
+		_, err = store.FindByEmail(ctx, testhelpers.TestEmail)
+		require.ErrorIs(t, err, ErrSignupNotFound)
+	})
+
+	t.Run("CancelledResend", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		store := newMemorySignupStore()
+		signup := store.insertTestSignup(testhelpers.TestEmail, "test-token")
+		signup.LastSentAt = time.Now().Add(-2 * defaultResendWindow)
+
+		mailAPI := mailclient.NewFakeClient()
+		mediator := signupStarter(mailAPI, testhelpers.TestEmail)
+		mediator.Store = store
+
+		res, err := mediator.Run(ctx, nil)
+		require.NoError(t, err)
+		require.Equal(t, &SignupStarterResult{Cancelled: true}, res)
+
+		require.Empty(t, mailAPI.MessagesSent)
+		require.Equal(t, int64(1), signup.NumAttempts)
+	})
+
+	t.Run("CancelledSingleOptIn", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		mailAPI := mailclient.NewFakeClient()
+		mediator := signupStarter(mailAPI, testhelpers.TestEmail)
+		mediator.SingleOptIn = true
+		mediator.Store = newMemorySignupStore()
+
+		res, err := mediator.Run(ctx, nil)
+		require.NoError(t, err)
+		require.Equal(t, &SignupStarterResult{Cancelled: true}, res)
+
+		require.Empty(t, mailAPI.MembersAdded)
+	})
+
+	t.Run("EmailCasePolicy", func(t *testing.T) {
+		const email = "Brandur@Example.COM"
+
+		testCases := []struct {
+			policy EmailCasePolicy
+			stored string
+		}{
+			{"", "Brandur@example.com"},
+			{EmailCasePolicyLowerAll, "brandur@example.com"},
+			{EmailCasePolicyLowerDomain, "Brandur@example.com"},
+			{EmailCasePolicyPreserve, "Brandur@Example.COM"},
+		}
+
+		for _, tc := range testCases {
+			store := newMemorySignupStore()
+			mailAPI := mailclient.NewFakeClient()
+			mediator := signupStarter(mailAPI, email)
+			mediator.EmailCasePolicy = tc.policy
+			mediator.Store = store
+
+			res, err := mediator.Run(ctx, nil)
+			require.NoError(t, err)
+			require.True(t, res.NewSignup)
+
+			signup, err := store.FindByEmail(ctx, tc.stored)
+			require.NoError(t, err)
+			require.Equal(t, tc.stored, signup.Email)
+
+			require.Len(t, mailAPI.MessagesSent, 1)
+			require.Equal(t, tc.stored, mailAPI.MessagesSent[0].Recipient)
+		}
+	})
+
+	t.Run("EmailCasePolicyMatchesExisting", func(t *testing.T) {
+		testCases := []struct {
+			policy      EmailCasePolicy
+			secondEmail string
+			newSignup   bool
+		}{
+			{EmailCasePolicyLowerAll, "BRANDUR@EXAMPLE.COM", false},
+			{EmailCasePolicyLowerDomain, "brandur@EXAMPLE.com", false},
+			{EmailCasePolicyLowerDomain, "Brandur@example.com", true},
+			{EmailCasePolicyPreserve, "brandur@EXAMPLE.com", true},
+		}
+
+		for _, tc := range testCases {
+			store := newMemorySignupStore()
+
+			mediator := signupStarter(mailclient.NewFakeClient(), "brandur@example.com")
+			mediator.EmailCasePolicy = tc.policy
+			mediator.Store = store
+
+			_, err := mediator.Run(ctx, nil)
+			require.NoError(t, err)
+
+			mediator = signupStarter(mailclient.NewFakeClient(), tc.secondEmail)
+			mediator.EmailCasePolicy = tc.policy
+			mediator.Store = store
+
+			res, err := mediator.Run(ctx, nil)
+			require.NoError(t, err)
+			require.Equal(t, tc.newSignup, res.NewSignup, "policy %q, email %q", tc.policy, tc.secondEmail)
+		}
+	})
+
+	t.Run("Suppressed", func(t *testing.T) {
+		store := newMemorySignupStore()
+		store.suppress(testhelpers.TestEmail)
+
+		mailAPI := mailclient.NewFakeClient()
+		mediator := signupStarter(mailAPI, testhelpers.TestEmail)
+		mediator.Store = store
+
+		res, err := mediator.Run(ctx, nil)
+		require.NoError(t, err)
+		require.True(t, res.Suppressed)
+		require.False(t, res.NewSignup)
+		require.Empty(t, mailAPI.MessagesSent)
+
+		// No signup is left behind.
+		require.Empty(t, store.signups)
+	})
+
+	t.Run("SuppressedResend", func(t *testing.T) {
+		store := newMemorySignupStore()
+		signup := store.insertTestSignup(testhelpers.TestEmail, "test-token")
+		signup.LastSentAt = time.Now().Add(-2 * defaultResendWindow)
+		store.suppress(testhelpers.TestEmail)
+
+		mailAPI := mailclient.NewFakeClient()
+		mediator := signupStarter(mailAPI, testhelpers.TestEmail)
+		mediator.Store = store
+
+		res, err := mediator.Run(ctx, nil)
+		require.NoError(t, err)
+		require.True(t, res.Suppressed)
+		require.False(t, res.ConfirmationResent)
+		require.Empty(t, mailAPI.MessagesSent)
+
+		// The existing signup is left unchanged.
+		require.Equal(t, int64(1), signup.NumAttempts)
+		require.True(t, signup.LastSentAt.Before(time.Now().Add(-defaultResendWindow)))
+		require.Equal(t, "test-token", signup.Token)
+	})
+
+	t.Run("SuppressedSingleOptIn", func(t *testing.T) {
+		store := newMemorySignupStore()
+		store.suppress(testhelpers.TestEmail)
+
+		mailAPI := mailclient.NewFakeClient()
+		mediator := signupStarter(mailAPI, testhelpers.TestEmail)
+		mediator.SingleOptIn = true
+		mediator.Store = store
+
+		res, err := mediator.Run(ctx, nil)
+		require.NoError(t, err)
+		require.True(t, res.Suppressed)
+		require.False(t, res.DirectlySubscribed)
+		require.Empty(t, mailAPI.MembersAdded)
+		require.Empty(t, store.signups)
+	})
+
+	t.Run("NotSuppressed", func(t *testing.T) {
+		store := newMemorySignupStore()
+		store.suppress("other@example.com")
+
+		mailAPI := mailclient.NewFakeClient()
+		mediator := signupStarter(mailAPI, testhelpers.TestEmail)
+		mediator.Store = store
+
+		res, err := mediator.Run(ctx, nil)
+		require.NoError(t, err)
+		require.False(t, res.Suppressed)
+		require.True(t, res.NewSignup)
+		require.Len(t, mailAPI.MessagesSent, 1)
+	})
+}
+
+func TestSignupFinisher_MemoryStore(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("FinishSignup", func(t *testing.T) {
+		store := newMemorySignupStore()
+		signup := store.insertTestSignup(testhelpers.TestEmail, "test-token")
+
+		mailAPI := mailclient.NewFakeClient()
+		mediator := signupFinisher(mailAPI, "test-token")
+		mediator.Store = store
+
+		res, err := mediator.Run(ctx, nil)
+		require.NoError(t, err)
+		require.Equal(t, testhelpers.TestEmail, res.Email)
+		require.True(t, res.SignupFinished)
+
+		require.Len(t, mailAPI.MembersAdded, 1)
+		require.NotNil(t, signup.CompletedAt)
+		require.NotNil(t, signup.MemberAddedAt)
+
+		// Idempotent, and the member isn't added a second time.
+		res, err = mediator.Run(ctx, nil)
+		require.NoError(t, err)
+		require.True(t, res.SignupFinished)
+		require.Len(t, mailAPI.MembersAdded, 1)
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		store := newMemorySignupStore()
+		store.insertTestSignup(testhelpers.TestEmail, "test-token")
+
+		mailAPI := mailclient.NewFakeClient()
+		mediator := signupFinisher(mailAPI, "test-token")
+		mediator.SendWelcome = true
+		mediator.Renderer = renderer
+		mediator.Store = store
+
+		res, err := mediator.Run(ctx, nil)
+		require.NoError(t, err)
+		require.Equal(t, &SignupFinisherResult{Cancelled: true}, res)
+
+		require.Empty(t, mailAPI.MembersAdded)
+		require.Empty(t, mailAPI.MessagesSent)
+	})
+
+	t.Run("WebhookEnqueued", func(t *testing.T) {
+		store := newMemorySignupStore()
+		store.insertTestSignup(testhelpers.TestEmail, "test-token")
+
+		mediator := signupFinisher(mailclient.NewFakeClient(), "test-token")
+		mediator.NotifyWebhook = true
+		mediator.Store = store
+
+		res, err := mediator.Run(ctx, nil)
+		require.NoError(t, err)
+		require.True(t, res.WebhookEnqueued)
+		require.Equal(t, []*memoryOutboxWebhook{
+			{email: testhelpers.TestEmail, eventType: WebhookEventSubscriberConfirmed},
+		}, store.webhooksEnqueued)
+
+		// A reused confirmation link doesn't notify again.
+		res, err = mediator.Run(ctx, nil)
+		require.NoError(t, err)
+		require.True(t, res.SignupFinished)
+		require.False(t, res.WebhookEnqueued)
+		require.Len(t, store.webhooksEnqueued, 1)
+	})
+
+	t.Run("WebhookNotEnqueuedByDefault", func(t *testing.T) {
+		store := newMemorySignupStore()
+		store.insertTestSignup(testhelpers.TestEmail, "test-token")
+
+		mediator := signupFinisher(mailclient.NewFakeClient(), "test-token")
+		mediator.Store = store
+
+		res, err := mediator.Run(ctx, nil)
+		require.NoError(t, err)
+		require.True(t, res.SignupFinished)
+		require.False(t, res.WebhookEnqueued)
+		require.Empty(t, store.webhooksEnqueued)
+	})
+
+	t.Run("LogsSignupID", func(t *testing.T) {
+		store := newMemorySignupStore()
+		signup := store.insertTestSignup(testhelpers.TestEmail, "test-token")
+		hook := captureLogs(t)
+
+		mediator := signupFinisher(mailclient.NewFakeClient(), "test-token")
+		mediator.Store = store
+
+		_, err := mediator.Run(ctx, nil)
+		require.NoError(t, err)
+
+		entry := hook.LastEntry()
+		require.NotNil(t, entry)
+		require.Contains(t, entry.Message, "to the list")
+		require.Equal(t, signup.ID, entry.Data[logFieldSignupID])
+	})
+
+	t.Run("MemberAddDeferredOnQuotaExceeded", func(t *testing.T) {
+		store := newMemorySignupStore()
+		signup := store.insertTestSignup(testhelpers.TestEmail, "test-token")
+
+		mailAPI := &listFailingClient{
+			FakeClient:  mailclient.NewFakeClient(),
+			err:         mailclient.ErrQuotaExceeded,
+			failingList: testListAddress,
+		}
+		mediator := signupFinisher(mailAPI, "test-token")
+		mediator.Store = store
+
+		res, err := mediator.Run(ctx, nil)
+		require.NoError(t, err)
+		require.True(t, res.MemberAddDeferred)
+
+		require.NotNil(t, signup.CompletedAt)
+		require.NotNil(t, signup.MemberAddDeferredAt)
+		require.Nil(t, signup.MemberAddedAt)
+	})
+
+	t.Run("SignedToken", func(t *testing.T) {
+		store := newMemorySignupStore()
+		signup := store.insertTestSignup(testhelpers.TestEmail, "test-token")
+
+		signer := signedtoken.NewSigner(testTokenSecret)
+		signup.Token = signer.Encode(signup.ID, time.Now().Add(time.Hour))
+
+		mailAPI := mailclient.NewFakeClient()
+		mediator := signupFinisher(mailAPI, signup.Token)
+		mediator.Store = store
+		mediator.TokenSigner = signer
+
+		res, err := mediator.Run(ctx, nil)
+		require.NoError(t, err)
+		require.True(t, res.SignupFinished)
+		require.Len(t, mailAPI.MembersAdded, 1)
+	})
+
+	t.Run("UnknownToken", func(t *testing.T) {
+		mailAPI := mailclient.NewFakeClient()
+		mediator := signupFinisher(mailAPI, "not-a-token")
+		mediator.Store = newMemorySignupStore()
+
+		res, err := mediator.Run(ctx, nil)
+		require.NoError(t, err)
+		require.True(t, res.TokenNotFound)
+		require.Empty(t, mailAPI.MembersAdded)
+	})
+}
+
+func TestSignupTokenRotator_MemoryStore(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("RotatesPendingSignup", func(t *testing.T) {
+		store := newMemorySignupStore()
+		signup := store.insertTestSignup(testhelpers.TestEmail, "test-token")
+
+		mailAPI := mailclient.NewFakeClient()
+		mediator := signupTokenRotator(mailAPI, testhelpers.TestEmail)
+		mediator.Store = store
+
+		res, err := mediator.Run(ctx, nil)
+		require.NoError(t, err)
+		require.True(t, res.ConfirmationResent)
+
+		require.NotEqual(t, "test-token", signup.Token)
+		require.Equal(t, int64(1), signup.NumAttempts)
+		require.Len(t, mailAPI.MessagesSent, 1)
+		require.Contains(t, mailAPI.MessagesSent[0].ContentsPlain, signup.Token)
+
+		// The old link no longer confirms anything.
+		finisher := signupFinisher(mailAPI, "test-token")
+		finisher.Store = store
+
+		finishRes, err := finisher.Run(ctx, nil)
+		require.NoError(t, err)
+		require.True(t, finishRes.TokenNotFound)
+	})
+
+	t.Run("SignedToken", func(t *testing.T) {
+		store := newMemorySignupStore()
+		signup := store.insertTestSignup(testhelpers.TestEmail, "test-token")
+
+		signer := signedtoken.NewSigner(testTokenSecret)
+		mailAPI := mailclient.NewFakeClient()
+		mediator := signupTokenRotator(mailAPI, testhelpers.TestEmail)
+		mediator.Store = store
+		mediator.TokenSigner = signer
+		mediator.TokenTTL = time.Hour
+
+		_, err := mediator.Run(ctx, nil)
+		require.NoError(t, err)
+
+		id, err := signer.Decode(signup.Token)
+		require.NoError(t, err)
+		require.Equal(t, signup.ID, id)
+
+		// A token signed before the rotation still carries a valid signature
+		// for the signup's ID, but no longer confirms anything.
+		oldToken := signer.Encode(signup.ID, time.Now().Add(2*time.Hour))
+		finisher := signupFinisher(mailAPI, oldToken)
+		finisher.Store = store
+		finisher.TokenSigner = signer
+
+		finishRes, err := finisher.Run(ctx, nil)
+		require.NoError(t, err)
+		require.True(t, finishRes.TokenNotFound)
+		require.Nil(t, signup.CompletedAt)
+
+		finisher.Token = signup.Token
+		finishRes, err = finisher.Run(ctx, nil)
+		require.NoError(t, err)
+		require.True(t, finishRes.SignupFinished)
+	})
+
+	t.Run("SignupCompleted", func(t *testing.T) {
+		store := newMemorySignupStore()
+		signup := store.insertTestSignup(testhelpers.TestEmail, "test-token")
+		signup.CompletedAt = ptr(time.Now())
+
+		mailAPI := mailclient.NewFakeClient()
+		mediator := signupTokenRotator(mailAPI, testhelpers.TestEmail)
+		mediator.Store = store
+
+		res, err := mediator.Run(ctx, nil)
+		require.NoError(t, err)
+		require.True(t, res.SignupCompleted)
+		require.Equal(t, "test-token", signup.Token)
+		require.Empty(t, mailAPI.MessagesSent)
+	})
+}
+
+//
+// Private types
+//
+
+// memorySignupStore is a SignupStore that keeps signups in memory. Signups
+// are returned by reference so that tests can inspect and tweak them
+// directly.
+type memorySignupStore struct {
+	// messagesEnqueued are messages put in the outbox.
+	messagesEnqueued []*memoryOutboxMessage
+
+	signups []*Signup
+
+	// suppressed is the set of emails on the suppression list.
+	suppressed map[string]struct{}
+
+	// webhooksEnqueued are webhook events put in the outbox.
+	webhooksEnqueued []*memoryOutboxWebhook
+}
+
+func newMemorySignupStore() *memorySignupStore {
+	return &memorySignupStore{}
+}
+
+func (s *memorySignupStore) ClearCompleted(_ context.Context, id int64) error {
+	return s.update(id, func(signup *Signup) {
+		signup.CompletedAt = nil
+	})
+}
+
+func (s *memorySignupStore) CountCreatedSince(_ context.Context, since time.Time) (int, error) {
+	var numSignups int
+	for _, signup := range s.signups {
+		if !signup.CreatedAt.Before(since) {
+			numSignups++
+		}
+	}
+	return numSignups, nil
+}
+
+func (s *memorySignupStore) EnqueueMessage(_ context.Context, params *mailclient.SendMessageParams, sendAfter time.Time) error {
+	s.messagesEnqueued = append(s.messagesEnqueued, &memoryOutboxMessage{params: params, sendAfter: sendAfter})
+	return nil
+}
+
+func (s *memorySignupStore) EnqueueWebhook(_ context.Context, eventType, email string) error {
+	s.webhooksEnqueued = append(s.webhooksEnqueued, &memoryOutboxWebhook{email: email, eventType: eventType})
+	return nil
+}
+
+func (s *memorySignupStore) FindByEmail(_ context.Context, email string) (*Signup, error) {
+	return s.find(func(signup *Signup) bool { return signup.Email == email })
+}
+
+func (s *memorySignupStore) FindByID(_ context.Context, id int64) (*Signup, error) {
+	return s.find(func(signup *Signup) bool { return signup.ID == id })
+}
+
+func (s *memorySignupStore) FindByToken(_ context.Context, token string) (*Signup, error) {
+	return s.find(func(signup *Signup) bool { return signup.Token == token })
+}
+
+func (s *memorySignupStore) IsSuppressed(_ context.Context, email string) (bool, error) {
+	_, ok := s.suppressed[email]
+	return ok, nil
+}
+
+func (s *memorySignupStore) Insert(_ context.Context, signup *Signup) error {
+	now := time.Now()
+
+	signup.CreatedAt = now
+	signup.ID = int64(len(s.signups) + 1)
+	signup.LastSentAt = now
+	signup.NumAttempts = 1
+
+	s.signups = append(s.signups, signup)
+	return nil
+}
+
+func (s *memorySignupStore) MarkCompleted(_ context.Context, id int64) error {
+	return s.update(id, func(signup *Signup) {
+		signup.CompletedAt = ptr(time.Now())
+	})
+}
+
+func (s *memorySignupStore) MarkMemberAddDeferred(_ context.Context, id int64) error {
+	return s.update(id, func(signup *Signup) {
+		signup.MemberAddDeferredAt = ptr(time.Now())
+	})
+}
+
+func (s *memorySignupStore) MarkMemberAdded(_ context.Context, id int64) error {
+	return s.update(id, func(signup *Signup) {
+		signup.MemberAddDeferredAt = nil
+		signup.MemberAddedAt = ptr(time.Now())
+		signup.UnsubscribedAt = nil
+	})
+}
+
+func (s *memorySignupStore) UpdateSent(_ context.Context, id int64, numAttempts int64) error {
+	return s.update(id, func(signup *Signup) {
+		signup.LastSentAt = time.Now()
+		signup.MemberAddedAt = nil
+		signup.NumAttempts = numAttempts
+	})
+}
+
+func (s *memorySignupStore) UpdateToken(_ context.Context, id int64, token string) error {
+	return s.update(id, func(signup *Signup) {
+		signup.Token = token
+	})
+}
+
+func (s *memorySignupStore) UpsertCompleted(ctx context.Context, signup *Signup) error {
+	existing, err := s.FindByEmail(ctx, signup.Email)
+	if errors.Is(err, ErrSignupNotFound) {
+		signup.CompletedAt = ptr(time.Now())
+		return s.Insert(ctx, signup)
+	}
+	if err != nil {
+		return err
+	}
+
+	if existing.CompletedAt == nil {
+		existing.CompletedAt = ptr(time.Now())
+	}
+	*signup = *existing
+	return nil
+}
+
+func (s *memorySignupStore) find(match func(*Signup) bool) (*Signup, error) {
+	for _, signup := range s.signups {
+		if match(signup) {
+			return signup, nil
+		}
+	}
+	return nil, ErrSignupNotFound
+}
+
+// insertTestSignup inserts a signup for email with token as if a
+// confirmation was just sent.
+func (s *memorySignupStore) insertTestSignup(email, token string) *Signup {
+	signup := &Signup{Email: email, Token: token}
+	_ = s.Insert(context.Background(), signup)
+	return signup
+}
+
+// suppress adds email to the suppression list.
+func (s *memorySignupStore) suppress(email string) {
+	if s.suppressed == nil {
+		s.suppressed = make(map[string]struct{})
+	}
+	s.suppressed[email] = struct{}{}
+}
+
+// memoryOutboxMessage is a message put in the outbox of a memorySignupStore.
+type memoryOutboxMessage struct {
+	params    *mailclient.SendMessageParams
+	sendAfter time.Time
+}
+
+// memoryOutboxWebhook is a webhook event put in the outbox of a
+// memorySignupStore.
+type memoryOutboxWebhook struct {
+	email     string
+	eventType string
+}
+
+// lookupMissingSignupStore is a memorySignupStore whose lookups by email never
+// find anything, like when a concurrent request inserts the same email after
+// it was looked up.
+type lookupMissingSignupStore struct {
+	*memorySignupStore
+}
+
+func (s *lookupMissingSignupStore) FindByEmail(_ context.Context, _ string) (*Signup, error) {
+	return nil, ErrSignupNotFound
+}
+
+func (s *memorySignupStore) update(id int64, f func(*Signup)) error {
+	signup, err := s.find(func(signup *Signup) bool { return signup.ID == id })
+	if err != nil {
+		return err
+	}
+	f(signup)
+	return nil
+}
+
+//
+// Private functions
+//
+
+// captureLogs hooks into the standard logger to capture entries logged
+// during a test, removing the hook again when the test finishes.
+func captureLogs(t *testing.T) *test.Hook {
+	t.Helper()
+
+	hook := new(test.Hook)
+	oldHooks := logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+	logrus.AddHook(hook)
+	t.Cleanup(func() { logrus.StandardLogger().ReplaceHooks(oldHooks) })
+
+	return hook
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
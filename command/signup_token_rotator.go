@@ -0,0 +1,133 @@
+package command
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/xerrors"
+
+	"github.com/brandur/passages-signup/mailclient"
+	"github.com/brandur/passages-signup/ptemplate"
+	"github.com/brandur/passages-signup/signedtoken"
+)
+
+// SignupTokenRotator replaces the confirmation token of a pending signup and
+// resends its confirmation email, like when a token has leaked or a user
+// reports that their link is broken. The old token stops working
+// immediately. It's only available to administrators.
+//
+// Signups that have already been completed are left alone because their
+// token no longer does anything.
+type SignupTokenRotator struct {
+	Email string `validate:"required"`
+
+	FromAddress string              `validate:"omitempty,email"`
+	ListAddress string              `validate:"required"`
+	MailAPI     mailclient.API      `validate:"required"`
+	PreviewLink bool                `validate:"-"`
+	Renderer    *ptemplate.Renderer `validate:"required"`
+
+	// ReplyToAddress is an optional address for replies. If empty, replies
+	// go to the list address.
+	ReplyToAddress string `validate:"-"`
+
+	// Store is where signups are read and written. Defaults to Postgres
+	// through the transaction that the command is run with.
+	Store SignupStore `validate:"-"`
+
+	// TokenSigner and TokenTTL are used to sign the new token the same way as
+	// SignupStarter. If TokenSigner isn't set, the new token is random.
+	TokenSigner *signedtoken.Signer `validate:"-"`
+	TokenTTL    time.Duration       `validate:"required_with=TokenSigner"`
+}
+
+// Run executes the mediator.
+func (c *SignupTokenRotator) Run(ctx context.Context, tx pgx.Tx) (*SignupTokenRotatorResult, error) {
+	logrus.Debugf("SignupTokenRotator running")
+
+	if err := validate.Struct(c); err != nil {
+		return nil, xerrors.Errorf("error validating command: %w", err)
+	}
+
+	store := signupStoreOrDefault(c.Store, tx)
+
+	signup, err := store.FindByEmail(ctx, c.Email)
+	if errors.Is(err, ErrSignupNotFound) {
+		return &SignupTokenRotatorResult{EmailNotFound: true}, nil
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("error finding signup: %w", err)
+	}
+
+	if signup.CompletedAt != nil {
+		return &SignupTokenRotatorResult{SignupCompleted: true}, nil
+	}
+
+	token := uuid.New().String()
+	if c.TokenSigner != nil {
+		token = c.TokenSigner.Encode(signup.ID, time.Now().Add(c.TokenTTL))
+	}
+
+	if err := store.UpdateToken(ctx, signup.ID, token); err != nil {
+		return nil, xerrors.Errorf("error updating token: %w", err)
+	}
+
+	// An administrator's resend doesn't count as one of the user's attempts,
+	// but still restarts the resend window.
+	if err := store.UpdateSent(ctx, signup.ID, signup.NumAttempts); err != nil {
+		return nil, xerrors.Errorf("error updating last sent: %w", err)
+	}
+
+	signupLogger(signup.ID).Infof("Rotated confirmation token for %v", c.Email)
+
+	suppressed, err := store.IsSuppressed(ctx, c.Email)
+	if err != nil {
+		return nil, err
+	}
+	if suppressed {
+		signupLogger(signup.ID).Infof("Email is on the suppression list so not sending confirmation: %s", c.Email)
+		return &SignupTokenRotatorResult{Email: c.Email, Suppressed: true}, nil
+	}
+
+	// Reuse SignupStarter's logic for sending messages so that the resent
+	// message is identical to the original.
+	starter := &SignupStarter{
+		Email:          c.Email,
+		FromAddress:    c.FromAddress,
+		ListAddress:    c.ListAddress,
+		MailAPI:        c.MailAPI,
+		PreviewLink:    c.PreviewLink,
+		Renderer:       c.Renderer,
+		ReplyToAddress: c.ReplyToAddress,
+	}
+
+	if err := starter.sendConfirmationMessage(ctx, store, signup.ID, token); err != nil {
+		return nil, xerrors.Errorf("error sending confirmation email: %w", err)
+	}
+
+	return &SignupTokenRotatorResult{ConfirmationResent: true, Email: c.Email}, nil
+}
+
+// SignupTokenRotatorResult holds the results of a successful run of
+// SignupTokenRotator.
+type SignupTokenRotatorResult struct {
+	ConfirmationResent bool   `json:"confirmation_resent"`
+	Email              string `json:"email"`
+
+	// EmailNotFound is set if no signup exists for the email, in which case
+	// no other fields are populated.
+	EmailNotFound bool `json:"-"`
+
+	// SignupCompleted is set if the signup has already been completed, in
+	// which case its token is left unchanged and no other fields are
+	// populated.
+	SignupCompleted bool `json:"-"`
+
+	// Suppressed is set if the email is on the suppression list, in which
+	// case the token was still rotated, but no confirmation was sent.
+	Suppressed bool `json:"suppressed"`
+}
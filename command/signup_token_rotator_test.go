@@ -0,0 +1,104 @@
+package command
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brandur/passages-signup/mailclient"
+	"github.com/brandur/passages-signup/testhelpers"
+)
+
+func TestSignupTokenRotator(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("RotatesPendingSignup", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			_, err := tx.Exec(ctx, `
+				INSERT INTO signup
+					(email, token)
+				VALUES
+					($1, 'not-a-real-token')
+			`, testhelpers.TestEmail)
+			require.NoError(t, err)
+
+			mailAPI := mailclient.NewFakeClient()
+			mediator := signupTokenRotator(mailAPI, testhelpers.TestEmail)
+
+			res, err := mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.True(t, res.ConfirmationResent)
+			require.Equal(t, testhelpers.TestEmail, res.Email)
+
+			var token string
+			err = tx.QueryRow(ctx, `
+				SELECT token
+				FROM signup
+				WHERE email = $1
+			`, testhelpers.TestEmail).Scan(&token)
+			require.NoError(t, err)
+			require.NotEqual(t, "not-a-real-token", token)
+
+			require.Len(t, mailAPI.MessagesSent, 1)
+			require.Contains(t, mailAPI.MessagesSent[0].ContentsPlain, token)
+		})
+	})
+
+	t.Run("SignupCompleted", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			_, err := tx.Exec(ctx, `
+				INSERT INTO signup
+					(email, token, completed_at)
+				VALUES
+					($1, 'not-a-real-token', NOW())
+			`, testhelpers.TestEmail)
+			require.NoError(t, err)
+
+			mailAPI := mailclient.NewFakeClient()
+			mediator := signupTokenRotator(mailAPI, testhelpers.TestEmail)
+
+			res, err := mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.True(t, res.SignupCompleted)
+			require.False(t, res.ConfirmationResent)
+			require.Empty(t, mailAPI.MessagesSent)
+
+			var token string
+			err = tx.QueryRow(ctx, `
+				SELECT token
+				FROM signup
+				WHERE email = $1
+			`, testhelpers.TestEmail).Scan(&token)
+			require.NoError(t, err)
+			require.Equal(t, "not-a-real-token", token)
+		})
+	})
+
+	t.Run("EmailNotFound", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			mailAPI := mailclient.NewFakeClient()
+			mediator := signupTokenRotator(mailAPI, testhelpers.TestEmail)
+
+			res, err := mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.True(t, res.EmailNotFound)
+			require.Empty(t, mailAPI.MessagesSent)
+		})
+	})
+}
+
+//
+// Private functions
+//
+
+func signupTokenRotator(mailAPI mailclient.API, email string) *SignupTokenRotator {
+	return &SignupTokenRotator{
+		Email:          email,
+		ListAddress:    testListAddress,
+		MailAPI:        mailAPI,
+		Renderer:       renderer,
+		ReplyToAddress: testReplyToAddress,
+	}
+}
@@ -0,0 +1,75 @@
+package command
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/xerrors"
+)
+
+// StatsGetter computes aggregate statistics on signups like how many
+// confirmation emails have been sent and how many of them were clicked
+// through to complete a signup.
+type StatsGetter struct{}
+
+// Run executes the mediator.
+func (c *StatsGetter) Run(ctx context.Context, tx pgx.Tx) (*StatsGetterResult, error) {
+	logrus.Debugf("StatsGetter running")
+
+	// Every attempt corresponds to a confirmation email sent, so the sum of
+	// attempts is the total number of emails sent.
+	var res StatsGetterResult
+	err := tx.QueryRow(ctx, `
+		SELECT
+			COUNT(*),
+			COUNT(completed_at),
+			COALESCE(SUM(num_attempts), 0)
+		FROM signup
+	`).Scan(&res.NumSignups, &res.NumCompleted, &res.NumConfirmationsSent)
+	if err != nil {
+		return nil, xerrors.Errorf("error querying stats: %w", err)
+	}
+
+	res.ClickThroughRate = clickThroughRate(res.NumCompleted, res.NumConfirmationsSent)
+
+	outboxRes, err := (&OutboxCounter{}).Run(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	res.NumOutboxAbandoned = outboxRes.NumAbandoned
+	res.NumOutboxPending = outboxRes.NumPending
+
+	return &res, nil
+}
+
+// StatsGetterResult holds the results of a successful run of StatsGetter.
+type StatsGetterResult struct {
+	// ClickThroughRate is the ratio of completed signups to confirmation
+	// emails sent. Zero if no emails have been sent.
+	ClickThroughRate float64 `json:"click_through_rate"`
+
+	NumCompleted         int64 `json:"num_completed"`
+	NumConfirmationsSent int64 `json:"num_confirmations_sent"`
+
+	// NumOutboxAbandoned is the number of outbox messages that failed to
+	// send too many times and won't be tried again.
+	NumOutboxAbandoned int64 `json:"num_outbox_abandoned"`
+
+	// NumOutboxPending is the number of outbox messages waiting to be sent,
+	// including ones being retried.
+	NumOutboxPending int64 `json:"num_outbox_pending"`
+
+	NumSignups int64 `json:"num_signups"`
+}
+
+//
+// Private functions
+//
+
+func clickThroughRate(numCompleted, numSent int64) float64 {
+	if numSent == 0 {
+		return 0
+	}
+	return float64(numCompleted) / float64(numSent)
+}
@@ -0,0 +1,84 @@
+package command
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brandur/passages-signup/testhelpers"
+)
+
+func TestStatsGetter(t *testing.T) {
+	ctx := context.Background()
+
+	// No signups at all, which must not divide by zero
+	t.Run("Empty", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			// Start from a clean slate in case the test database has data
+			_, err := tx.Exec(ctx, `DELETE FROM signup`)
+			require.NoError(t, err)
+			_, err = tx.Exec(ctx, `DELETE FROM outbox_message`)
+			require.NoError(t, err)
+
+			res, err := (&StatsGetter{}).Run(ctx, tx)
+			require.NoError(t, err)
+
+			require.Equal(t, &StatsGetterResult{}, res)
+		})
+	})
+
+	// Seeded sent and completed counts
+	t.Run("SeededCounts", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			_, err := tx.Exec(ctx, `DELETE FROM signup`)
+			require.NoError(t, err)
+			_, err = tx.Exec(ctx, `DELETE FROM outbox_message`)
+			require.NoError(t, err)
+
+			// One sent, one pending, one being retried, and one abandoned.
+			_, err = tx.Exec(ctx, `
+				INSERT INTO outbox_message
+					(params, send_after, num_attempts, sent_at)
+				VALUES
+					('{}', NOW(), 1, NOW()),
+					('{}', NOW(), 0, NULL),
+					('{}', NOW(), 2, NULL),
+					('{}', NOW(), $1, NULL)
+			`, maxOutboxAttempts)
+			require.NoError(t, err)
+
+			// Four confirmation emails sent in total, one of which was
+			// completed.
+			_, err = tx.Exec(ctx, `
+				INSERT INTO signup
+					(email, token, num_attempts, completed_at)
+				VALUES
+					('a@example.com', 'token-a', 1, NOW()),
+					('b@example.com', 'token-b', 2, NULL),
+					('c@example.com', 'token-c', 1, NULL)
+			`)
+			require.NoError(t, err)
+
+			res, err := (&StatsGetter{}).Run(ctx, tx)
+			require.NoError(t, err)
+
+			require.Equal(t, &StatsGetterResult{
+				ClickThroughRate:     0.25,
+				NumCompleted:         1,
+				NumConfirmationsSent: 4,
+				NumOutboxAbandoned:   1,
+				NumOutboxPending:     2,
+				NumSignups:           3,
+			}, res)
+		})
+	})
+}
+
+func TestClickThroughRate(t *testing.T) {
+	require.InDelta(t, 0.0, clickThroughRate(0, 0), 0.0001)
+	require.InDelta(t, 0.0, clickThroughRate(5, 0), 0.0001)
+	require.InDelta(t, 0.5, clickThroughRate(1, 2), 0.0001)
+	require.InDelta(t, 1.0, clickThroughRate(3, 3), 0.0001)
+}
@@ -0,0 +1,127 @@
+package command
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/xerrors"
+)
+
+// subscriberExportHeader is the header row of a subscriber export.
+var subscriberExportHeader = []string{"email", "completed_at", "utm_campaign", "utm_medium", "utm_source"}
+
+// SubscriberExporter writes a page of confirmed subscribers that haven't
+// unsubscribed to a CSV, starting after AfterID.
+//
+// It's meant to be run repeatedly, each time in a new transaction, with
+// AfterID set to the previous result's LastID until Done is set. Paging keeps
+// each transaction short even when the export is streamed to a slow client,
+// so a large export never holds a transaction open for its whole duration.
+type SubscriberExporter struct {
+	// AfterID is the ID of the last subscriber that was exported. Subscribers
+	// are exported in order of ID, so zero starts from the beginning, in which
+	// case the CSV's header row is written first.
+	AfterID int64 `validate:"min=0"`
+
+	// PageSize is the maximum number of subscribers written per run.
+	PageSize int `validate:"required,min=1"`
+
+	Writer io.Writer `validate:"required"`
+}
+
+// Run executes the mediator.
+func (c *SubscriberExporter) Run(ctx context.Context, tx pgx.Tx) (*SubscriberExporterResult, error) {
+	logrus.Debugf("SubscriberExporter running")
+
+	if err := validate.Struct(c); err != nil {
+		return nil, xerrors.Errorf("error validating command: %w", err)
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT id, email, completed_at, utm_campaign, utm_medium, utm_source
+		FROM signup
+		WHERE id > $1
+		  AND completed_at IS NOT NULL
+		  AND unsubscribed_at IS NULL
+		ORDER BY id
+		LIMIT $2
+	`, c.AfterID, c.PageSize)
+	if err != nil {
+		return nil, xerrors.Errorf("error querying subscribers: %w", err)
+	}
+	defer rows.Close()
+
+	csvWriter := csv.NewWriter(c.Writer)
+	if c.AfterID == 0 {
+		if err := csvWriter.Write(subscriberExportHeader); err != nil {
+			return nil, xerrors.Errorf("error writing export header: %w", err)
+		}
+	}
+
+	res := SubscriberExporterResult{LastID: c.AfterID}
+	for rows.Next() {
+		var (
+			completedAt                       time.Time
+			email                             string
+			utmCampaign, utmMedium, utmSource *string
+		)
+		if err := rows.Scan(&res.LastID, &email, &completedAt, &utmCampaign, &utmMedium, &utmSource); err != nil {
+			return nil, xerrors.Errorf("error scanning subscriber: %w", err)
+		}
+
+		if err := csvWriter.Write([]string{
+			email,
+			completedAt.UTC().Format(time.RFC3339),
+			stringOrEmpty(utmCampaign),
+			stringOrEmpty(utmMedium),
+			stringOrEmpty(utmSource),
+		}); err != nil {
+			return nil, xerrors.Errorf("error writing subscriber: %w", err)
+		}
+
+		res.NumExported++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, xerrors.Errorf("error iterating subscribers: %w", err)
+	}
+
+	csvWriter.Flush()
+	if err := csvWriter.Error(); err != nil {
+		return nil, xerrors.Errorf("error flushing export: %w", err)
+	}
+
+	res.Done = res.NumExported < int64(c.PageSize)
+
+	logrus.Debugf("Exported page of %d subscriber(s)", res.NumExported)
+	return &res, nil
+}
+
+// SubscriberExporterResult holds the results of a successful run of
+// SubscriberExporter.
+type SubscriberExporterResult struct {
+	// Done is set if there are no subscribers left to export after this page.
+	Done bool
+
+	// LastID is the ID of the last subscriber exported, to be used as AfterID
+	// on the next run. It's AfterID if none were.
+	LastID int64
+
+	NumExported int64
+}
+
+//
+// Private functions
+//
+
+// stringOrEmpty dereferences a nullable string, returning an empty string for
+// nil.
+func stringOrEmpty(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,85 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brandur/passages-signup/testhelpers"
+)
+
+func TestSubscriberExporter(t *testing.T) {
+	ctx := context.Background()
+
+	const expectedCSV = "email,completed_at,utm_campaign,utm_medium,utm_source\n" +
+		"confirmed@example.com,2024-01-02T03:04:05Z,spring,,twitter\n" +
+		"other@example.com,2024-01-03T03:04:05Z,,,\n"
+
+	insertSignups := func(t *testing.T, tx pgx.Tx) {
+		t.Helper()
+
+		_, err := tx.Exec(ctx, `
+			INSERT INTO signup
+				(email, token, completed_at, unsubscribed_at, utm_campaign, utm_source)
+			VALUES
+				('confirmed@example.com', 'token-1', '2024-01-02T03:04:05Z', NULL, 'spring', 'twitter'),
+				('pending@example.com', 'token-2', NULL, NULL, NULL, NULL),
+				('unsubscribed@example.com', 'token-3', '2024-01-02T03:04:05Z', NOW(), NULL, NULL),
+				('other@example.com', 'token-4', '2024-01-03T03:04:05Z', NULL, NULL, NULL)
+		`)
+		require.NoError(t, err)
+	}
+
+	t.Run("Plain", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			insertSignups(t, tx)
+
+			var buf bytes.Buffer
+			res, err := (&SubscriberExporter{PageSize: 10, Writer: &buf}).Run(ctx, tx)
+			require.NoError(t, err)
+			require.True(t, res.Done)
+			require.Equal(t, int64(2), res.NumExported)
+			require.Equal(t, expectedCSV, buf.String())
+		})
+	})
+
+	t.Run("Paged", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			insertSignups(t, tx)
+
+			var (
+				buf bytes.Buffer
+				res = &SubscriberExporterResult{}
+			)
+			for i := 0; !res.Done; i++ {
+				require.Less(t, i, 3, "export should have finished")
+
+				var err error
+				res, err = (&SubscriberExporter{AfterID: res.LastID, PageSize: 1, Writer: &buf}).Run(ctx, tx)
+				require.NoError(t, err)
+			}
+
+			// Only the header is written on the first page.
+			require.Equal(t, expectedCSV, buf.String())
+		})
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			var buf bytes.Buffer
+			res, err := (&SubscriberExporter{PageSize: 10, Writer: &buf}).Run(ctx, tx)
+			require.NoError(t, err)
+			require.True(t, res.Done)
+			require.Equal(t, int64(0), res.NumExported)
+			require.Equal(t, "email,completed_at,utm_campaign,utm_medium,utm_source\n", buf.String())
+		})
+	})
+
+	t.Run("MissingWriter", func(t *testing.T) {
+		_, err := (&SubscriberExporter{PageSize: 10}).Run(ctx, nil)
+		require.ErrorContains(t, err, "Writer is a required field")
+	})
+}
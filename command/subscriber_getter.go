@@ -0,0 +1,89 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/xerrors"
+)
+
+// SubscriberStatus is the derived state of a signup.
+type SubscriberStatus string
+
+// The possible values of SubscriberStatus.
+const (
+	SubscriberStatusConfirmed    SubscriberStatus = "confirmed"
+	SubscriberStatusPending      SubscriberStatus = "pending"
+	SubscriberStatusUnsubscribed SubscriberStatus = "unsubscribed"
+)
+
+// SubscriberGetter looks up the state of a single email's signup. Intended
+// for support purposes.
+type SubscriberGetter struct {
+	Email string `validate:"required"`
+}
+
+// Run executes the mediator.
+func (c *SubscriberGetter) Run(ctx context.Context, tx pgx.Tx) (*SubscriberGetterResult, error) {
+	logrus.Debugf("SubscriberGetter running")
+
+	if err := validate.Struct(c); err != nil {
+		return nil, xerrors.Errorf("error validating command: %w", err)
+	}
+
+	var res SubscriberGetterResult
+	var unsubscribedAt *time.Time
+	err := tx.QueryRow(ctx, `
+		SELECT email, completed_at, created_at, last_sent_at, num_attempts, unsubscribed_at
+		FROM signup
+		WHERE email = $1
+	`, c.Email).Scan(&res.Email, &res.CompletedAt, &res.CreatedAt, &res.LastSentAt, &res.NumAttempts, &unsubscribedAt)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return &SubscriberGetterResult{SubscriberNotFound: true}, nil
+	}
+
+	if err != nil {
+		return nil, xerrors.Errorf("error querying for subscriber: %w", err)
+	}
+
+	res.Status = subscriberStatus(res.CompletedAt, unsubscribedAt)
+
+	return &res, nil
+}
+
+// SubscriberGetterResult holds the results of a successful run of
+// SubscriberGetter.
+type SubscriberGetterResult struct {
+	CompletedAt *time.Time       `json:"completed_at"`
+	CreatedAt   time.Time        `json:"created_at"`
+	Email       string           `json:"email"`
+	LastSentAt  time.Time        `json:"last_sent_at"`
+	NumAttempts int64            `json:"num_attempts"`
+	Status      SubscriberStatus `json:"status"`
+
+	// SubscriberNotFound is set if no signup exists for the email, in which
+	// case no other fields are populated.
+	SubscriberNotFound bool `json:"-"`
+}
+
+//
+// Private functions
+//
+
+// subscriberStatus derives a subscriber's status from their signup's
+// timestamps. An unsubscribe takes precedence, but a user who confirms again
+// after unsubscribing has their unsubscribe cleared.
+func subscriberStatus(completedAt, unsubscribedAt *time.Time) SubscriberStatus {
+	switch {
+	case unsubscribedAt != nil:
+		return SubscriberStatusUnsubscribed
+	case completedAt != nil:
+		return SubscriberStatusConfirmed
+	default:
+		return SubscriberStatusPending
+	}
+}
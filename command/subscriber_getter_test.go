@@ -0,0 +1,85 @@
+package command
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brandur/passages-signup/testhelpers"
+)
+
+func TestSubscriberGetter(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Found", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			_, err := tx.Exec(ctx, `
+				INSERT INTO signup
+					(email, token, num_attempts, completed_at)
+				VALUES
+					($1, 'not-a-real-token', 2, NOW())
+			`, testhelpers.TestEmail)
+			require.NoError(t, err)
+
+			res, err := (&SubscriberGetter{Email: testhelpers.TestEmail}).Run(ctx, tx)
+			require.NoError(t, err)
+
+			require.False(t, res.SubscriberNotFound)
+			require.Equal(t, testhelpers.TestEmail, res.Email)
+			require.NotNil(t, res.CompletedAt)
+			require.False(t, res.CreatedAt.IsZero())
+			require.False(t, res.LastSentAt.IsZero())
+			require.Equal(t, int64(2), res.NumAttempts)
+			require.Equal(t, SubscriberStatusConfirmed, res.Status)
+		})
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			res, err := (&SubscriberGetter{Email: testhelpers.TestEmail}).Run(ctx, tx)
+			require.NoError(t, err)
+			require.True(t, res.SubscriberNotFound)
+		})
+	})
+
+	t.Run("Unsubscribed", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			_, err := tx.Exec(ctx, `
+				INSERT INTO signup
+					(email, token, completed_at, unsubscribed_at)
+				VALUES
+					($1, 'not-a-real-token', NOW(), NOW())
+			`, testhelpers.TestEmail)
+			require.NoError(t, err)
+
+			res, err := (&SubscriberGetter{Email: testhelpers.TestEmail}).Run(ctx, tx)
+			require.NoError(t, err)
+			require.Equal(t, SubscriberStatusUnsubscribed, res.Status)
+		})
+	})
+}
+
+func TestSubscriberStatus(t *testing.T) {
+	now := time.Now()
+
+	testCases := []struct {
+		name           string
+		completedAt    *time.Time
+		unsubscribedAt *time.Time
+		want           SubscriberStatus
+	}{
+		{"Pending", nil, nil, SubscriberStatusPending},
+		{"Confirmed", &now, nil, SubscriberStatusConfirmed},
+		{"Unsubscribed", &now, &now, SubscriberStatusUnsubscribed},
+		{"UnsubscribedWithoutCompleted", nil, &now, SubscriberStatusUnsubscribed},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.want, subscriberStatus(tc.completedAt, tc.unsubscribedAt))
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package command
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/xerrors"
+)
+
+// Reasons that an email is added to the suppression list.
+const (
+	SuppressionReasonBounce    = "bounce"
+	SuppressionReasonComplaint = "complaint"
+)
+
+// SuppressionRecorder adds an email to the suppression list so that no
+// further mail is sent to it, which protects the sender's reputation from
+// addresses that have bounced or complained. Unlike a signup's bounce, a
+// suppression is recorded whether or not the email has signed up.
+type SuppressionRecorder struct {
+	Email  string `validate:"required"`
+	Reason string `validate:"required,oneof=bounce complaint"`
+}
+
+// Run executes the mediator.
+func (c *SuppressionRecorder) Run(ctx context.Context, tx pgx.Tx) (*SuppressionRecorderResult, error) {
+	logrus.Debugf("SuppressionRecorder running")
+
+	if err := validate.Struct(c); err != nil {
+		return nil, xerrors.Errorf("error validating command: %w", err)
+	}
+
+	// Keep the original reason and time if the email was already suppressed.
+	tag, err := tx.Exec(ctx, `
+		INSERT INTO suppression
+			(email, reason)
+		VALUES
+			($1, $2)
+		ON CONFLICT (email) DO NOTHING
+	`, c.Email, c.Reason)
+	if err != nil {
+		return nil, xerrors.Errorf("error recording suppression: %w", err)
+	}
+
+	if tag.RowsAffected() < 1 {
+		return &SuppressionRecorderResult{AlreadySuppressed: true}, nil
+	}
+
+	logrus.Infof("Suppressed email after %s: %s", c.Reason, c.Email)
+	return &SuppressionRecorderResult{SuppressionRecorded: true}, nil
+}
+
+// SuppressionRecorderResult holds the results of a successful run of
+// SuppressionRecorder.
+type SuppressionRecorderResult struct {
+	AlreadySuppressed   bool
+	SuppressionRecorded bool
+}
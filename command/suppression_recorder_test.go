@@ -0,0 +1,76 @@
+package command
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brandur/passages-signup/testhelpers"
+)
+
+func TestSuppressionRecorder(t *testing.T) {
+	ctx := context.Background()
+
+	selectReason := func(t *testing.T, tx pgx.Tx) string {
+		t.Helper()
+
+		var reason string
+		err := tx.QueryRow(ctx, `
+			SELECT reason
+			FROM suppression
+			WHERE email = $1
+		`, testhelpers.TestEmail).Scan(&reason)
+		require.NoError(t, err)
+		return reason
+	}
+
+	t.Run("RecordsSuppression", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			mediator := &SuppressionRecorder{
+				Email:  testhelpers.TestEmail,
+				Reason: SuppressionReasonComplaint,
+			}
+			res, err := mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.True(t, res.SuppressionRecorded)
+			require.False(t, res.AlreadySuppressed)
+
+			require.Equal(t, SuppressionReasonComplaint, selectReason(t, tx))
+
+			suppressed, err := NewPgxSignupStore(tx).IsSuppressed(ctx, testhelpers.TestEmail)
+			require.NoError(t, err)
+			require.True(t, suppressed)
+		})
+	})
+
+	t.Run("AlreadySuppressed", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			mediator := &SuppressionRecorder{
+				Email:  testhelpers.TestEmail,
+				Reason: SuppressionReasonBounce,
+			}
+			_, err := mediator.Run(ctx, tx)
+			require.NoError(t, err)
+
+			// The original reason is kept.
+			mediator.Reason = SuppressionReasonComplaint
+			res, err := mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.True(t, res.AlreadySuppressed)
+			require.False(t, res.SuppressionRecorded)
+
+			require.Equal(t, SuppressionReasonBounce, selectReason(t, tx))
+		})
+	})
+
+	t.Run("InvalidReason", func(t *testing.T) {
+		mediator := &SuppressionRecorder{
+			Email:  testhelpers.TestEmail,
+			Reason: "unknown",
+		}
+		_, err := mediator.Run(ctx, nil)
+		require.ErrorContains(t, err, "error validating command")
+	})
+}
@@ -0,0 +1,97 @@
+package command
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/xerrors"
+
+	"github.com/brandur/passages-signup/mailclient"
+)
+
+// Unsubscriber unsubscribes an email from the mailing list based on Token,
+// which is received through a secret URL.
+//
+// If LookupOnly is set, the signup is only looked up so that the user can be
+// asked to confirm before anything changes. Link scanners in mail clients
+// follow links, so following one mustn't be enough to unsubscribe.
+type Unsubscriber struct {
+	// ConfirmedEmailCache is an optional cache of recently confirmed emails.
+	// If set, the email is removed from it so that signing up again goes
+	// through the full flow.
+	ConfirmedEmailCache *ConfirmedEmailCache `validate:"-"`
+
+	ListAddress string         `validate:"required"`
+	LookupOnly  bool           `validate:"-"`
+	MailAPI     mailclient.API `validate:"required"`
+	Token       string         `validate:"required"`
+}
+
+// Run executes the mediator.
+func (c *Unsubscriber) Run(ctx context.Context, tx pgx.Tx) (*UnsubscriberResult, error) {
+	logrus.Debugf("Unsubscriber running")
+
+	if err := validate.Struct(c); err != nil {
+		return nil, xerrors.Errorf("error validating command: %w", err)
+	}
+
+	var id int64
+	var email string
+	var unsubscribedAt *time.Time
+	err := tx.QueryRow(ctx, `
+		SELECT id, email, unsubscribed_at
+		FROM signup
+		WHERE token = $1
+	`, c.Token).Scan(&id, &email, &unsubscribedAt)
+
+	// No such token.
+	if errors.Is(err, pgx.ErrNoRows) {
+		return &UnsubscriberResult{TokenNotFound: true}, nil
+	}
+
+	if err != nil {
+		return nil, xerrors.Errorf("error querying for token: %w", err)
+	}
+
+	if unsubscribedAt != nil {
+		logrus.Infof("%v already unsubscribed; skipping\n", email)
+		return &UnsubscriberResult{AlreadyUnsubscribed: true, Email: email}, nil
+	}
+
+	if c.LookupOnly {
+		return &UnsubscriberResult{Email: email}, nil
+	}
+
+	// Record the unsubscribe before calling out to the mail service so that if
+	// the call fails, the transaction is rolled back and the user can retry.
+	_, err = tx.Exec(ctx, `
+		UPDATE signup
+		SET unsubscribed_at = NOW()
+		WHERE id = $1
+	`, id)
+	if err != nil {
+		return nil, xerrors.Errorf("error recording unsubscribe: %w", err)
+	}
+
+	logrus.Infof("Unsubscribing %v from the list\n", email)
+	if err := c.MailAPI.UnsubscribeMember(ctx, c.ListAddress, email); err != nil {
+		return nil, xerrors.Errorf("error unsubscribing email from list: %w", err)
+	}
+
+	if c.ConfirmedEmailCache != nil {
+		c.ConfirmedEmailCache.Remove(email)
+	}
+
+	return &UnsubscriberResult{Email: email, Unsubscribed: true}, nil
+}
+
+// UnsubscriberResult holds the results of a successful run of Unsubscriber.
+type UnsubscriberResult struct {
+	AlreadyUnsubscribed bool
+	Email               string
+	TokenNotFound       bool
+	Unsubscribed        bool
+}
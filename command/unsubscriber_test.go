@@ -0,0 +1,140 @@
+package command
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brandur/passages-signup/mailclient"
+	"github.com/brandur/passages-signup/testhelpers"
+)
+
+func TestUnsubscriber(t *testing.T) {
+	ctx := context.Background()
+
+	const token = "test-token"
+
+	t.Run("Unsubscribes", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			_, err := tx.Exec(ctx, `
+				INSERT INTO signup
+					(email, token, completed_at)
+				VALUES
+					($1, $2, NOW())
+			`, testhelpers.TestEmail, token)
+			require.NoError(t, err)
+
+			mailAPI := mailclient.NewFakeClient()
+			mediator := &Unsubscriber{
+				ListAddress: testListAddress,
+				MailAPI:     mailAPI,
+				Token:       token,
+			}
+
+			res, err := mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.Equal(t, &UnsubscriberResult{Email: testhelpers.TestEmail, Unsubscribed: true}, res)
+
+			require.Equal(t, []*mailclient.FakeClientAPIMember{
+				{List: testListAddress, Email: testhelpers.TestEmail},
+			}, mailAPI.MembersUnsubscribed)
+
+			var unsubscribedAt *time.Time
+			err = tx.QueryRow(ctx, `
+				SELECT unsubscribed_at
+				FROM signup
+				WHERE email = $1
+			`, testhelpers.TestEmail).Scan(&unsubscribedAt)
+			require.NoError(t, err)
+			require.NotNil(t, unsubscribedAt)
+
+			// A second run finds the email already unsubscribed and doesn't
+			// call the mail service again.
+			res, err = mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.Equal(t, &UnsubscriberResult{AlreadyUnsubscribed: true, Email: testhelpers.TestEmail}, res)
+			require.Len(t, mailAPI.MembersUnsubscribed, 1)
+		})
+	})
+
+	// Unsubscribing removes the email from the confirmed email cache so that
+	// signing up again isn't short circuited
+	t.Run("LookupOnly", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			_, err := tx.Exec(ctx, `
+				INSERT INTO signup
+					(email, token, completed_at)
+				VALUES
+					($1, $2, NOW())
+			`, testhelpers.TestEmail, token)
+			require.NoError(t, err)
+
+			mailAPI := mailclient.NewFakeClient()
+			mediator := &Unsubscriber{
+				ListAddress: testListAddress,
+				LookupOnly:  true,
+				MailAPI:     mailAPI,
+				Token:       token,
+			}
+
+			res, err := mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.Equal(t, &UnsubscriberResult{Email: testhelpers.TestEmail}, res)
+			require.Empty(t, mailAPI.MembersUnsubscribed)
+
+			var unsubscribedAt *time.Time
+			err = tx.QueryRow(ctx, `
+				SELECT unsubscribed_at
+				FROM signup
+				WHERE email = $1
+			`, testhelpers.TestEmail).Scan(&unsubscribedAt)
+			require.NoError(t, err)
+			require.Nil(t, unsubscribedAt)
+		})
+	})
+
+	t.Run("RemovesFromCache", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			_, err := tx.Exec(ctx, `
+				INSERT INTO signup
+					(email, token, completed_at)
+				VALUES
+					($1, $2, NOW())
+			`, testhelpers.TestEmail, token)
+			require.NoError(t, err)
+
+			cache := NewConfirmedEmailCache(10, time.Hour)
+			cache.Add(testhelpers.TestEmail)
+
+			mediator := &Unsubscriber{
+				ConfirmedEmailCache: cache,
+				ListAddress:         testListAddress,
+				MailAPI:             mailclient.NewFakeClient(),
+				Token:               token,
+			}
+
+			_, err = mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.False(t, cache.Contains(testhelpers.TestEmail))
+		})
+	})
+
+	t.Run("TokenNotFound", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			mailAPI := mailclient.NewFakeClient()
+			mediator := &Unsubscriber{
+				ListAddress: testListAddress,
+				MailAPI:     mailAPI,
+				Token:       token,
+			}
+
+			res, err := mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.Equal(t, &UnsubscriberResult{TokenNotFound: true}, res)
+			require.Empty(t, mailAPI.MembersUnsubscribed)
+		})
+	})
+}
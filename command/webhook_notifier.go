@@ -0,0 +1,124 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/xerrors"
+
+	"github.com/brandur/passages-signup/signing"
+)
+
+// WebhookEventSubscriberConfirmed is the type of the event sent when a
+// subscriber confirms their signup.
+const WebhookEventSubscriberConfirmed = "subscriber.confirmed"
+
+// WebhookSignatureHeader is the header carrying a webhook's signature. Its
+// value is `sha256=` followed by the hex-encoded HMAC-SHA256 of the request
+// body keyed with the shared secret. Receivers should compute the same and
+// compare in constant time.
+const WebhookSignatureHeader = "Passages-Signature"
+
+// webhookTimeout is how long a webhook request is given to complete. Webhooks
+// are sent while their outbox row is locked, so it should stay well under the
+// database's idle in transaction timeout.
+const webhookTimeout = 2 * time.Second
+
+// errWebhookRejected is returned when a webhook is rejected with a 4xx status
+// other than 429, in which case it's unlikely to succeed on a second try.
+var errWebhookRejected = errors.New("webhook rejected")
+
+// WebhookEvent is the JSON payload that WebhookNotifier sends.
+type WebhookEvent struct {
+	Email string `json:"email"`
+
+	// NewsletterID is the ID of the newsletter that the event happened on,
+	// like `passages`.
+	NewsletterID string `json:"newsletter_id"`
+
+	// OccurredAt is when the event happened. Receivers can use it to reject
+	// old events that are being replayed.
+	OccurredAt time.Time `json:"occurred_at"`
+
+	Type string `json:"type"`
+}
+
+// WebhookNotifier notifies an external system of events like a newly
+// confirmed subscriber by POSTing a signed JSON payload to a URL.
+//
+// It makes a single attempt. Events are enqueued in the outbox by the
+// mediators that produce them and sent by WebhookOutboxSender, which retries
+// failed deliveries with backoff.
+type WebhookNotifier struct {
+	httpClient   *http.Client
+	newsletterID string
+	secret       []byte
+	timeout      time.Duration
+	url          string
+}
+
+// NewWebhookNotifier initializes a new WebhookNotifier that sends events for
+// the given newsletter to url, signed with secret.
+func NewWebhookNotifier(url, secret, newsletterID string) *WebhookNotifier {
+	return &WebhookNotifier{
+		httpClient:   &http.Client{},
+		newsletterID: newsletterID,
+		secret:       []byte(secret),
+		timeout:      webhookTimeout,
+		url:          url,
+	}
+}
+
+// Notify sends an event of the given type that occurred at occurredAt.
+// Returns an error wrapping errWebhookRejected if the receiver rejected it in
+// a way that's not worth retrying.
+func (n *WebhookNotifier) Notify(ctx context.Context, eventType, email string, occurredAt time.Time) error {
+	body, err := json.Marshal(&WebhookEvent{
+		Email:        email,
+		NewsletterID: n.newsletterID,
+		OccurredAt:   occurredAt.UTC(),
+		Type:         eventType,
+	})
+	if err != nil {
+		return xerrors.Errorf("error encoding webhook event: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, n.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return xerrors.Errorf("error building webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookSignatureHeader, n.Sign(body))
+
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		return xerrors.Errorf("error requesting webhook URL: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Drain the body so that the connection can be reused.
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests:
+		return xerrors.Errorf("unexpected webhook response status: %d: %w", resp.StatusCode, errWebhookRejected)
+	}
+
+	return xerrors.Errorf("unexpected webhook response status: %d", resp.StatusCode)
+}
+
+// Sign produces the value of WebhookSignatureHeader for the given body.
+func (n *WebhookNotifier) Sign(body []byte) string {
+	return "sha256=" + hex.EncodeToString(signing.Sign(n.secret, body))
+}
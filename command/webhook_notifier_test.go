@@ -0,0 +1,143 @@
+package command
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/brandur/passages-signup/testhelpers"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	const secret = "webhook-secret"
+
+	ctx := context.Background()
+	occurredAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	// Starts a server that responds to each request with the next of the
+	// given statuses, repeating the last one once they run out.
+	startServer := func(t *testing.T, statuses ...int) (*WebhookNotifier, *webhookRecorder) {
+		t.Helper()
+
+		recorder := &webhookRecorder{statuses: statuses}
+		server := httptest.NewServer(recorder)
+		t.Cleanup(server.Close)
+
+		return NewWebhookNotifier(server.URL, secret, "passages"), recorder
+	}
+
+	t.Run("Delivered", func(t *testing.T) {
+		notifier, recorder := startServer(t, http.StatusOK)
+
+		err := notifier.Notify(ctx, WebhookEventSubscriberConfirmed, testhelpers.TestEmail, occurredAt)
+		require.NoError(t, err)
+
+		require.Len(t, recorder.sent(), 1)
+		req := recorder.sent()[0]
+		require.Equal(t, "application/json", req.contentType)
+
+		// Verify the signature the way a receiver would.
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(req.body)
+		require.Equal(t, "sha256="+hex.EncodeToString(mac.Sum(nil)), req.signature)
+
+		var event WebhookEvent
+		require.NoError(t, json.Unmarshal(req.body, &event))
+		require.Equal(t, WebhookEvent{
+			Email:        testhelpers.TestEmail,
+			NewsletterID: "passages",
+			OccurredAt:   occurredAt,
+			Type:         WebhookEventSubscriberConfirmed,
+		}, event)
+	})
+
+	t.Run("ServerError", func(t *testing.T) {
+		for _, status := range []int{http.StatusServiceUnavailable, http.StatusTooManyRequests} {
+			notifier, recorder := startServer(t, status)
+
+			err := notifier.Notify(ctx, WebhookEventSubscriberConfirmed, testhelpers.TestEmail, occurredAt)
+			require.Error(t, err)
+			require.NotErrorIs(t, err, errWebhookRejected)
+			require.Len(t, recorder.sent(), 1)
+		}
+	})
+
+	t.Run("Rejected", func(t *testing.T) {
+		notifier, recorder := startServer(t, http.StatusBadRequest)
+
+		err := notifier.Notify(ctx, WebhookEventSubscriberConfirmed, testhelpers.TestEmail, occurredAt)
+		require.ErrorIs(t, err, errWebhookRejected)
+		require.EqualError(t, err, "unexpected webhook response status: 400: webhook rejected")
+		require.Len(t, recorder.sent(), 1)
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		notifier, recorder := startServer(t, http.StatusOK)
+		notifier.timeout = 10 * time.Millisecond
+		recorder.delay = time.Second
+
+		err := notifier.Notify(ctx, WebhookEventSubscriberConfirmed, testhelpers.TestEmail, occurredAt)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.NotErrorIs(t, err, errWebhookRejected)
+	})
+}
+
+//
+// Private types
+//
+
+type webhookRequest struct {
+	body        []byte
+	contentType string
+	signature   string
+}
+
+// webhookRecorder is an http.Handler that records webhook requests.
+type webhookRecorder struct {
+	delay    time.Duration
+	mut      sync.Mutex
+	requests []*webhookRequest
+	statuses []int
+}
+
+func (h *webhookRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	h.mut.Lock()
+	h.requests = append(h.requests, &webhookRequest{
+		body:        body,
+		contentType: r.Header.Get("Content-Type"),
+		signature:   r.Header.Get(WebhookSignatureHeader),
+	})
+	status := h.statuses[0]
+	if len(h.statuses) > 1 {
+		h.statuses = h.statuses[1:]
+	}
+	h.mut.Unlock()
+
+	if h.delay > 0 {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(h.delay):
+		}
+	}
+
+	w.WriteHeader(status)
+}
+
+func (h *webhookRecorder) sent() []*webhookRequest {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+
+	return append([]*webhookRequest(nil), h.requests...)
+}
@@ -0,0 +1,117 @@
+package command
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/xerrors"
+)
+
+// WebhookOutboxSender sends the next webhook event from the outbox whose
+// send_after time has passed. Like OutboxSender, it's meant to be run
+// repeatedly by a worker, each time in a new transaction, until it reports
+// that no events are due.
+//
+// Events are only enqueued by the transactions that produce them, so one is
+// never sent for a change that was rolled back. A failed event is retried with
+// the same backoff as outbox messages up to maxOutboxAttempts times, except
+// that one rejected by the receiver is abandoned right away.
+type WebhookOutboxSender struct {
+	WebhookNotifier *WebhookNotifier `validate:"required"`
+}
+
+// Run executes the mediator.
+func (c *WebhookOutboxSender) Run(ctx context.Context, tx pgx.Tx) (*WebhookOutboxSenderResult, error) {
+	logrus.Debugf("WebhookOutboxSender running")
+
+	if err := validate.Struct(c); err != nil {
+		return nil, xerrors.Errorf("error validating command: %w", err)
+	}
+
+	var (
+		createdAt   time.Time
+		email       string
+		eventType   string
+		id          int64
+		numAttempts int
+	)
+	err := tx.QueryRow(ctx, `
+		SELECT id, created_at, email, event_type, num_attempts
+		FROM outbox_webhook
+		WHERE sent_at IS NULL
+		  AND send_after <= NOW()
+		  AND num_attempts < $1
+		ORDER BY send_after, id
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	`, maxOutboxAttempts).Scan(&id, &createdAt, &email, &eventType, &numAttempts)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return &WebhookOutboxSenderResult{NoneDue: true}, nil
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("error querying outbox webhooks: %w", err)
+	}
+
+	numAttempts++
+
+	sendErr := c.WebhookNotifier.Notify(ctx, eventType, email, createdAt)
+	if sendErr == nil {
+		_, err := tx.Exec(ctx, `
+			UPDATE outbox_webhook
+			SET
+			  num_attempts = $1,
+			  sent_at = NOW()
+			WHERE id = $2
+		`, numAttempts, id)
+		if err != nil {
+			return nil, xerrors.Errorf("error updating outbox webhook: %w", err)
+		}
+
+		return &WebhookOutboxSenderResult{Sent: true}, nil
+	}
+
+	// A rejected event is never going to succeed, so use up its attempts.
+	if errors.Is(sendErr, errWebhookRejected) {
+		numAttempts = maxOutboxAttempts
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE outbox_webhook
+		SET
+		  num_attempts = $1,
+		  send_after = $2
+		WHERE id = $3
+	`, numAttempts, time.Now().Add(outboxRetryBackoff<<(numAttempts-1)), id)
+	if err != nil {
+		return nil, xerrors.Errorf("error updating outbox webhook: %w", err)
+	}
+
+	if numAttempts >= maxOutboxAttempts {
+		logrus.Errorf("Abandoning %v webhook %d for %v after %d attempt(s): %v",
+			eventType, id, email, numAttempts, sendErr)
+		return &WebhookOutboxSenderResult{Abandoned: true}, nil
+	}
+
+	logrus.Errorf("Error sending %v webhook %d for %v (attempt %d); will retry: %v",
+		eventType, id, email, numAttempts, sendErr)
+	return &WebhookOutboxSenderResult{Failed: true}, nil
+}
+
+// WebhookOutboxSenderResult holds the results of a successful run of
+// WebhookOutboxSender.
+type WebhookOutboxSenderResult struct {
+	// Abandoned is set if the event failed to send for the last time, or was
+	// rejected by the receiver, so it won't be tried again.
+	Abandoned bool
+
+	// Failed is set if the event failed to send, but will be retried.
+	Failed bool
+
+	// NoneDue is set if there were no events due to be sent.
+	NoneDue bool
+
+	Sent bool
+}
@@ -0,0 +1,125 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brandur/passages-signup/testhelpers"
+)
+
+func TestWebhookOutboxSender(t *testing.T) {
+	ctx := context.Background()
+
+	startServer := func(t *testing.T, statuses ...int) (*WebhookNotifier, *webhookRecorder) {
+		t.Helper()
+
+		recorder := &webhookRecorder{statuses: statuses}
+		server := httptest.NewServer(recorder)
+		t.Cleanup(server.Close)
+
+		return NewWebhookNotifier(server.URL, "webhook-secret", "passages"), recorder
+	}
+
+	enqueue := func(t *testing.T, tx pgx.Tx) {
+		t.Helper()
+
+		// Start from a clean slate in case the test database has data
+		_, err := tx.Exec(ctx, `DELETE FROM outbox_webhook`)
+		require.NoError(t, err)
+
+		require.NoError(t, NewPgxSignupStore(tx).EnqueueWebhook(ctx, WebhookEventSubscriberConfirmed,
+			testhelpers.TestEmail))
+	}
+
+	t.Run("Sent", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			enqueue(t, tx)
+
+			notifier, recorder := startServer(t, http.StatusOK)
+			mediator := &WebhookOutboxSender{WebhookNotifier: notifier}
+
+			res, err := mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.Equal(t, &WebhookOutboxSenderResult{Sent: true}, res)
+
+			require.Len(t, recorder.sent(), 1)
+			var event WebhookEvent
+			require.NoError(t, json.Unmarshal(recorder.sent()[0].body, &event))
+			require.Equal(t, testhelpers.TestEmail, event.Email)
+			require.Equal(t, WebhookEventSubscriberConfirmed, event.Type)
+
+			// Sent webhooks aren't sent again.
+			res, err = mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.Equal(t, &WebhookOutboxSenderResult{NoneDue: true}, res)
+			require.Len(t, recorder.sent(), 1)
+		})
+	})
+
+	// A failed webhook is retried with backoff, but only so many times
+	t.Run("Failure", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			enqueue(t, tx)
+
+			notifier, _ := startServer(t, http.StatusServiceUnavailable)
+			mediator := &WebhookOutboxSender{WebhookNotifier: notifier}
+
+			for i := 1; i <= maxOutboxAttempts; i++ {
+				res, err := mediator.Run(ctx, tx)
+				require.NoError(t, err)
+				if i < maxOutboxAttempts {
+					require.Equal(t, &WebhookOutboxSenderResult{Failed: true}, res)
+				} else {
+					require.Equal(t, &WebhookOutboxSenderResult{Abandoned: true}, res)
+				}
+
+				// Not retried until its backoff has passed.
+				res, err = mediator.Run(ctx, tx)
+				require.NoError(t, err)
+				require.Equal(t, &WebhookOutboxSenderResult{NoneDue: true}, res)
+
+				_, err = tx.Exec(ctx, `
+					UPDATE outbox_webhook
+					SET send_after = NOW() - '1 second'::interval
+				`)
+				require.NoError(t, err)
+			}
+
+			// Abandoned, so never tried again.
+			res, err := mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.Equal(t, &WebhookOutboxSenderResult{NoneDue: true}, res)
+		})
+	})
+
+	// A webhook rejected by the receiver isn't retried
+	t.Run("Rejected", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			enqueue(t, tx)
+
+			notifier, recorder := startServer(t, http.StatusBadRequest)
+			mediator := &WebhookOutboxSender{WebhookNotifier: notifier}
+
+			res, err := mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.Equal(t, &WebhookOutboxSenderResult{Abandoned: true}, res)
+
+			_, err = tx.Exec(ctx, `
+				UPDATE outbox_webhook
+				SET send_after = NOW() - '1 second'::interval
+			`)
+			require.NoError(t, err)
+
+			res, err = mediator.Run(ctx, tx)
+			require.NoError(t, err)
+			require.Equal(t, &WebhookOutboxSenderResult{NoneDue: true}, res)
+			require.Len(t, recorder.sent(), 1)
+		})
+	})
+}
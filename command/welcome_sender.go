@@ -0,0 +1,78 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/xerrors"
+
+	"github.com/brandur/passages-signup/mailclient"
+	"github.com/brandur/passages-signup/ptemplate"
+)
+
+// WelcomeSender re-sends the welcome message to a confirmed subscriber. Used
+// by administrators in case the original didn't arrive.
+type WelcomeSender struct {
+	Email string `validate:"required"`
+
+	FromAddress string              `validate:"omitempty,email"`
+	ListAddress string              `validate:"required"`
+	MailAPI     mailclient.API      `validate:"required"`
+	Renderer    *ptemplate.Renderer `validate:"required"`
+
+	// ReplyToAddress is an optional address for replies. If empty, replies
+	// go to the list address.
+	ReplyToAddress string `validate:"-"`
+}
+
+// Run executes the mediator.
+func (c *WelcomeSender) Run(ctx context.Context, tx pgx.Tx) (*WelcomeSenderResult, error) {
+	logrus.Debugf("WelcomeSender running")
+
+	if err := validate.Struct(c); err != nil {
+		return nil, xerrors.Errorf("error validating command: %w", err)
+	}
+
+	var completedAt *time.Time
+	var token string
+	var unsubscribedAt *time.Time
+	err := tx.QueryRow(ctx, `
+		SELECT completed_at, token, unsubscribed_at
+		FROM signup
+		WHERE email = $1
+	`, c.Email).Scan(&completedAt, &token, &unsubscribedAt)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return &WelcomeSenderResult{SubscriberNotConfirmed: true}, nil
+	}
+
+	if err != nil {
+		return nil, xerrors.Errorf("error querying for subscriber: %w", err)
+	}
+
+	if subscriberStatus(completedAt, unsubscribedAt) != SubscriberStatusConfirmed {
+		logrus.Infof("Not sending welcome to unconfirmed subscriber: %s", c.Email)
+		return &WelcomeSenderResult{SubscriberNotConfirmed: true}, nil
+	}
+
+	logrus.Infof("Sending welcome mail to %v\n", c.Email)
+	err = sendWelcomeMessage(ctx, c.MailAPI, c.Renderer, c.ListAddress, c.FromAddress, c.ReplyToAddress,
+		c.Email, token)
+	if err != nil {
+		return nil, xerrors.Errorf("error sending welcome email: %w", err)
+	}
+
+	return &WelcomeSenderResult{WelcomeSent: true}, nil
+}
+
+// WelcomeSenderResult holds the results of a successful run of WelcomeSender.
+type WelcomeSenderResult struct {
+	// SubscriberNotConfirmed is set if the email has no signup, or one that
+	// hasn't been confirmed or has since been unsubscribed.
+	SubscriberNotConfirmed bool `json:"-"`
+
+	WelcomeSent bool `json:"welcome_sent"`
+}
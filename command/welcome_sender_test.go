@@ -0,0 +1,78 @@
+package command
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brandur/passages-signup/mailclient"
+	"github.com/brandur/passages-signup/testhelpers"
+)
+
+func TestWelcomeSender(t *testing.T) {
+	ctx := context.Background()
+
+	welcomeSender := func(mailAPI mailclient.API) *WelcomeSender {
+		return &WelcomeSender{
+			Email:          testhelpers.TestEmail,
+			ListAddress:    testListAddress,
+			MailAPI:        mailAPI,
+			Renderer:       renderer,
+			ReplyToAddress: testReplyToAddress,
+		}
+	}
+
+	t.Run("Confirmed", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			_, err := tx.Exec(ctx, `
+				INSERT INTO signup
+					(email, token, completed_at)
+				VALUES
+					($1, 'not-a-real-token', NOW())
+			`, testhelpers.TestEmail)
+			require.NoError(t, err)
+
+			mailAPI := mailclient.NewFakeClient()
+			res, err := welcomeSender(mailAPI).Run(ctx, tx)
+			require.NoError(t, err)
+			require.False(t, res.SubscriberNotConfirmed)
+			require.True(t, res.WelcomeSent)
+
+			require.Len(t, mailAPI.MessagesSent, 1)
+			require.Equal(t, testhelpers.TestEmail, mailAPI.MessagesSent[0].Recipient)
+			require.Contains(t, mailAPI.MessagesSent[0].ContentsPlain, "Thanks for confirming!")
+			require.Equal(t, renderer.PublicURL+"/unsubscribe/not-a-real-token", mailAPI.MessagesSent[0].UnsubscribeURL)
+		})
+	})
+
+	t.Run("Unconfirmed", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			_, err := tx.Exec(ctx, `
+				INSERT INTO signup
+					(email, token)
+				VALUES
+					($1, 'not-a-real-token')
+			`, testhelpers.TestEmail)
+			require.NoError(t, err)
+
+			mailAPI := mailclient.NewFakeClient()
+			res, err := welcomeSender(mailAPI).Run(ctx, tx)
+			require.NoError(t, err)
+			require.True(t, res.SubscriberNotConfirmed)
+			require.False(t, res.WelcomeSent)
+			require.Empty(t, mailAPI.MessagesSent)
+		})
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		testhelpers.WithTestTransaction(ctx, t, func(tx pgx.Tx) {
+			mailAPI := mailclient.NewFakeClient()
+			res, err := welcomeSender(mailAPI).Run(ctx, tx)
+			require.NoError(t, err)
+			require.True(t, res.SubscriberNotConfirmed)
+			require.Empty(t, mailAPI.MessagesSent)
+		})
+	})
+}
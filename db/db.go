@@ -3,17 +3,31 @@ package db
 import (
 	"context"
 	"errors"
+	"regexp"
 	"strconv"
 	"time"
 
-	"github.com/go-playground/validator/v10"
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/xerrors"
+
+	"github.com/brandur/passages-signup/validation"
 )
 
-var validate = validator.New()
+var validate = validation.New()
+
+// Bounds for the time waited between attempts to connect when retrying.
+// Doubles after each failed attempt.
+const (
+	connectRetryInitialBackoff = 250 * time.Millisecond
+	connectRetryMaxBackoff     = 5 * time.Second
+)
+
+// searchPathRE matches a search path of one or more comma-separated unquoted
+// identifiers. The search path is sent to Postgres verbatim, so anything more
+// exotic is rejected.
+var searchPathRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*(\s*,\s*[a-zA-Z_][a-zA-Z0-9_]*)*$`)
 
 // ConnectConfig contains configuration option to create a Postgres connection
 // pool. We mandate some configuration that's not normally required (e.g.
@@ -25,39 +39,41 @@ type ConnectConfig struct {
 	// origin of a problematic query.
 	ApplicationName string `validate:"required"`
 
+	// ConnectRetryTimeout is the total time to keep retrying the initial
+	// connection with backoff if it fails, like when Postgres isn't ready yet
+	// during a deploy. If zero, the first failure is returned immediately.
+	ConnectRetryTimeout time.Duration `validate:"min=0"`
+
 	// DatabaseURL is a typical connection string of the form `postgres://`.
 	DatabaseURL string `validate:"required"`
+
+	// Role, if set, is appended to ApplicationName with a hyphen to identify
+	// the kind of process that owns the connections, like `web` or
+	// `reconcile`, so that they can be told apart in `pg_stat_activity`.
+	Role string `validate:"-"`
+
+	// SearchPath sets the schema search path of connections so that queries
+	// target a schema other than `public` without qualifying tables. May be a
+	// comma-separated list of schemas. Postgres' default is used if empty.
+	SearchPath string `validate:"-"`
 }
 
 func Connect(ctx context.Context, config *ConnectConfig) (*pgxpool.Pool, error) {
-	if err := validate.Struct(config); err != nil {
-		return nil, xerrors.Errorf("invalid database config: %w", err)
-	}
-
-	// Acquire the connection parameters from the standard set of PostgreSQL
-	// connection parameters
-	pgxConfig, err := pgxpool.ParseConfig(config.DatabaseURL)
+	pgxConfig, err := parseConfig(config)
 	if err != nil {
-		return nil, xerrors.Errorf("error parsing config: %w", err)
+		return nil, err
 	}
 
-	pgxConfig.MaxConns = 20
-	pgxConfig.ConnConfig.RuntimeParams["application_name"] = config.ApplicationName
-
-	// Idle in transaction should always be longer than statement timeout
-	// because a statement executing also increments in the idle in transaction
-	// timer.
-	pgxConfig.ConnConfig.RuntimeParams["idle_in_transaction_session_timeout"] = strconv.Itoa(int((15 * time.Second).Milliseconds()))
-	pgxConfig.ConnConfig.RuntimeParams["statement_timeout"] = strconv.Itoa(int((10 * time.Second).Milliseconds()))
-
 	// Load the connection configuration into the connection pool and open the
 	// pool
-	pool, err := pgxpool.ConnectConfig(ctx, pgxConfig)
-	if err != nil {
-		return nil, xerrors.Errorf("error connecting to Postgres: %w", err)
+	connector := &poolConnector{
+		connect:        pgxpool.ConnectConfig,
+		initialBackoff: connectRetryInitialBackoff,
+		maxBackoff:     connectRetryMaxBackoff,
+		timeNow:        time.Now,
+		timeout:        config.ConnectRetryTimeout,
 	}
-
-	return pool, nil
+	return connector.Connect(ctx, pgxConfig)
 }
 
 // TXStarter allows a transaction to be started on either a pool or another
@@ -95,3 +111,94 @@ func WithTransaction(ctx context.Context, starter TXStarter, f func(ctx context.
 
 	return nil
 }
+
+//
+// Private types
+//
+
+// poolConnector opens a connection pool, retrying with backoff until timeout
+// has elapsed.
+type poolConnector struct {
+	connect        func(ctx context.Context, config *pgxpool.Config) (*pgxpool.Pool, error)
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	timeNow        func() time.Time
+	timeout        time.Duration
+}
+
+func (c *poolConnector) Connect(ctx context.Context, pgxConfig *pgxpool.Config) (*pgxpool.Pool, error) {
+	deadline := c.timeNow().Add(c.timeout)
+	backoff := c.initialBackoff
+
+	for attempt := 1; ; attempt++ {
+		pool, err := c.connect(ctx, pgxConfig)
+		if err == nil {
+			return pool, nil
+		}
+
+		if ctx.Err() != nil || c.timeNow().Add(backoff).After(deadline) {
+			return nil, xerrors.Errorf("error connecting to Postgres (after %d attempt(s)): %w", attempt, err)
+		}
+
+		logrus.Errorf("Error connecting to Postgres (attempt %d); retrying in %v: %v", attempt, backoff, err)
+
+		select {
+		case <-ctx.Done():
+			return nil, xerrors.Errorf("error connecting to Postgres (after %d attempt(s)): %w", attempt, ctx.Err())
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > c.maxBackoff {
+			backoff = c.maxBackoff
+		}
+	}
+}
+
+//
+// Private functions
+//
+
+// applicationName returns the name that connections are registered with in
+// Postgres, which includes the role if there is one.
+func applicationName(config *ConnectConfig) string {
+	if config.Role == "" {
+		return config.ApplicationName
+	}
+	return config.ApplicationName + "-" + config.Role
+}
+
+// parseConfig validates the given configuration and produces a pool
+// configuration from it.
+func parseConfig(config *ConnectConfig) (*pgxpool.Config, error) {
+	if err := validate.Struct(config); err != nil {
+		return nil, xerrors.Errorf("invalid database config: %w", err)
+	}
+
+	if config.SearchPath != "" && !searchPathRE.MatchString(config.SearchPath) {
+		return nil, xerrors.Errorf("invalid database config: SearchPath must be a comma-separated list of schema names: %q",
+			config.SearchPath)
+	}
+
+	// Acquire the connection parameters from the standard set of PostgreSQL
+	// connection parameters
+	pgxConfig, err := pgxpool.ParseConfig(config.DatabaseURL)
+	if err != nil {
+		return nil, xerrors.Errorf("error parsing config: %w", err)
+	}
+
+	pgxConfig.MaxConns = 20
+	pgxConfig.ConnConfig.RuntimeParams["application_name"] = applicationName(config)
+
+	// Idle in transaction should always be longer than statement timeout
+	// because a statement executing also increments in the idle in transaction
+	// timer.
+	pgxConfig.ConnConfig.RuntimeParams["idle_in_transaction_session_timeout"] = strconv.Itoa(int((15 * time.Second).Milliseconds()))
+	pgxConfig.ConnConfig.RuntimeParams["statement_timeout"] = strconv.Itoa(int((10 * time.Second).Milliseconds()))
+
+	if config.SearchPath != "" {
+		pgxConfig.ConnConfig.RuntimeParams["search_path"] = config.SearchPath
+	}
+
+	return pgxConfig, nil
+}
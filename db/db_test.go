@@ -0,0 +1,147 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPoolConnector(t *testing.T) {
+	ctx := context.Background()
+	errConnect := errors.New("connection refused")
+
+	// Returns a connector whose connect function fails numFailures times
+	// before succeeding, along with a pointer to the number of attempts made.
+	connector := func(numFailures int, timeout time.Duration) (*poolConnector, *int) {
+		var numAttempts int
+		return &poolConnector{
+			connect: func(_ context.Context, _ *pgxpool.Config) (*pgxpool.Pool, error) {
+				numAttempts++
+				if numAttempts <= numFailures {
+					return nil, errConnect
+				}
+				return &pgxpool.Pool{}, nil
+			},
+			initialBackoff: 1 * time.Millisecond,
+			maxBackoff:     4 * time.Millisecond,
+			timeNow:        time.Now,
+			timeout:        timeout,
+		}, &numAttempts
+	}
+
+	t.Run("SucceedsImmediately", func(t *testing.T) {
+		connector, numAttempts := connector(0, 0)
+
+		pool, err := connector.Connect(ctx, nil)
+		require.NoError(t, err)
+		require.NotNil(t, pool)
+		require.Equal(t, 1, *numAttempts)
+	})
+
+	t.Run("NoRetryWithoutTimeout", func(t *testing.T) {
+		connector, numAttempts := connector(1, 0)
+
+		_, err := connector.Connect(ctx, nil)
+		require.ErrorIs(t, err, errConnect)
+		require.Equal(t, 1, *numAttempts)
+	})
+
+	t.Run("SucceedsAfterRetries", func(t *testing.T) {
+		connector, numAttempts := connector(5, 1*time.Minute)
+
+		pool, err := connector.Connect(ctx, nil)
+		require.NoError(t, err)
+		require.NotNil(t, pool)
+		require.Equal(t, 6, *numAttempts)
+	})
+
+	t.Run("GivesUpAfterTimeout", func(t *testing.T) {
+		connector, numAttempts := connector(1000, 1*time.Minute)
+
+		// Simulate 15 seconds passing every time the time is checked so that
+		// the minute long timeout is reached after a few attempts.
+		now := time.Now()
+		connector.timeNow = func() time.Time {
+			now = now.Add(15 * time.Second)
+			return now
+		}
+
+		_, err := connector.Connect(ctx, nil)
+		require.ErrorIs(t, err, errConnect)
+		require.ErrorContains(t, err, "after 4 attempt(s)")
+		require.Equal(t, 4, *numAttempts)
+	})
+
+	t.Run("ContextCanceled", func(t *testing.T) {
+		connector, numAttempts := connector(1000, 1*time.Minute)
+
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		_, err := connector.Connect(ctx, nil)
+		require.Error(t, err)
+		require.Equal(t, 1, *numAttempts)
+	})
+}
+
+func TestParseConfig(t *testing.T) {
+	const databaseURL = "postgres://localhost/passages-signup-test"
+
+	t.Run("Defaults", func(t *testing.T) {
+		pgxConfig, err := parseConfig(&ConnectConfig{
+			ApplicationName: "passages-signup-tests",
+			DatabaseURL:     databaseURL,
+		})
+		require.NoError(t, err)
+		require.Equal(t, "passages-signup-tests", pgxConfig.ConnConfig.RuntimeParams["application_name"])
+		require.NotContains(t, pgxConfig.ConnConfig.RuntimeParams, "search_path")
+	})
+
+	t.Run("Role", func(t *testing.T) {
+		pgxConfig, err := parseConfig(&ConnectConfig{
+			ApplicationName: "passages-signup-tests",
+			DatabaseURL:     databaseURL,
+			Role:            "worker",
+		})
+		require.NoError(t, err)
+		require.Equal(t, "passages-signup-tests-worker", pgxConfig.ConnConfig.RuntimeParams["application_name"])
+	})
+
+	t.Run("SearchPath", func(t *testing.T) {
+		for _, searchPath := range []string{"signup", "signup, public", "Signup_2,public"} {
+			pgxConfig, err := parseConfig(&ConnectConfig{
+				ApplicationName: "passages-signup-tests",
+				DatabaseURL:     databaseURL,
+				SearchPath:      searchPath,
+			})
+			require.NoError(t, err)
+			require.Equal(t, searchPath, pgxConfig.ConnConfig.RuntimeParams["search_path"])
+		}
+	})
+
+	t.Run("InvalidSearchPath", func(t *testing.T) {
+		for _, searchPath := range []string{
+			"signup; DROP TABLE signup",
+			`"quoted"`,
+			"1signup",
+			"signup,",
+			"$user",
+		} {
+			_, err := parseConfig(&ConnectConfig{
+				ApplicationName: "passages-signup-tests",
+				DatabaseURL:     databaseURL,
+				SearchPath:      searchPath,
+			})
+			require.ErrorContains(t, err, "SearchPath must be a comma-separated list of schema names", searchPath)
+		}
+	})
+
+	t.Run("InvalidConfig", func(t *testing.T) {
+		_, err := parseConfig(&ConnectConfig{DatabaseURL: databaseURL})
+		require.EqualError(t, err, "invalid database config: ApplicationName is a required field")
+	})
+}
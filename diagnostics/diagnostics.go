@@ -0,0 +1,225 @@
+package diagnostics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+	"golang.org/x/xerrors"
+
+	"github.com/brandur/passages-signup/ptemplate"
+)
+
+// Formats that a report can be written in.
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// Statuses of a check in a JSON report.
+const (
+	StatusFail = "fail"
+	StatusPass = "pass"
+)
+
+// Check is a single diagnostic check. Run should return nil if the check
+// passed, and an error describing the problem otherwise.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// CheckResult is the result of running a single Check.
+type CheckResult struct {
+	Name string
+	Err  error
+}
+
+// CredentialsVerifier is implemented by mail clients that can verify that
+// their credentials are valid with a lightweight API call.
+type CredentialsVerifier interface {
+	VerifyCredentials(ctx context.Context) error
+}
+
+// Querier is able to run a query that returns a single row. It's implemented
+// by pools, connections, and transactions.
+type Querier interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// Run runs each of the given checks in order and returns their results. All
+// checks are run even if an earlier one fails.
+func Run(ctx context.Context, checks []Check) []*CheckResult {
+	results := make([]*CheckResult, len(checks))
+	for i, check := range checks {
+		results[i] = &CheckResult{Name: check.Name, Err: check.Run(ctx)}
+	}
+	return results
+}
+
+// JSONReport is a machine-readable report of check results, suitable for
+// parsing in CI.
+type JSONReport struct {
+	AllPassed bool               `json:"all_passed"`
+	Checks    []*JSONCheckResult `json:"checks"`
+}
+
+// JSONCheckResult is the result of a single check in a JSONReport.
+type JSONCheckResult struct {
+	Error  string `json:"error,omitempty"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+// WriteFormattedReport writes a report for the given results to w in the
+// given format, which should be one of FormatJSON or FormatText. It returns
+// true if all checks passed.
+func WriteFormattedReport(w io.Writer, results []*CheckResult, format string) (bool, error) {
+	switch format {
+	case FormatJSON:
+		return WriteJSONReport(w, results)
+	case FormatText:
+		return WriteReport(w, results)
+	}
+
+	return false, xerrors.Errorf("unknown report format: %q", format)
+}
+
+// WriteJSONReport writes a JSON report for the given results to w. It returns
+// true if all checks passed.
+func WriteJSONReport(w io.Writer, results []*CheckResult) (bool, error) {
+	report := &JSONReport{
+		AllPassed: true,
+		Checks:    make([]*JSONCheckResult, len(results)),
+	}
+
+	for i, result := range results {
+		checkResult := &JSONCheckResult{Name: result.Name, Status: StatusPass}
+		if result.Err != nil {
+			report.AllPassed = false
+			checkResult.Error = result.Err.Error()
+			checkResult.Status = StatusFail
+		}
+		report.Checks[i] = checkResult
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return false, xerrors.Errorf("error writing report: %w", err)
+	}
+
+	return report.AllPassed, nil
+}
+
+// WriteReport writes a human-readable pass/fail report for the given results
+// to w. It returns true if all checks passed.
+func WriteReport(w io.Writer, results []*CheckResult) (bool, error) {
+	allPassed := true
+	for _, result := range results {
+		var err error
+		if result.Err == nil {
+			_, err = fmt.Fprintf(w, "[PASS] %s\n", result.Name)
+		} else {
+			allPassed = false
+			_, err = fmt.Fprintf(w, "[FAIL] %s: %v\n", result.Name, result.Err)
+		}
+		if err != nil {
+			return false, xerrors.Errorf("error writing report: %w", err)
+		}
+	}
+
+	return allPassed, nil
+}
+
+//
+// Checks
+//
+
+// DatabaseCheck verifies connectivity to Postgres by running a trivial query.
+func DatabaseCheck(querier Querier) Check {
+	return Check{
+		Name: "Database connectivity",
+		Run: func(ctx context.Context) error {
+			var one int
+			if err := querier.QueryRow(ctx, "SELECT 1").Scan(&one); err != nil {
+				return xerrors.Errorf("error querying database: %w", err)
+			}
+			return nil
+		},
+	}
+}
+
+// MailCredentialsCheck verifies that credentials for the mail service are
+// valid.
+func MailCredentialsCheck(verifier CredentialsVerifier) Check {
+	return Check{
+		Name: "Mail credentials",
+		Run: func(ctx context.Context) error {
+			if err := verifier.VerifyCredentials(ctx); err != nil {
+				return xerrors.Errorf("error verifying mail credentials: %w", err)
+			}
+			return nil
+		},
+	}
+}
+
+// SchemaCheck verifies that migrations have been applied by checking that each
+// of the given tables exists with at least the given columns.
+func SchemaCheck(querier Querier, tables map[string][]string) Check {
+	return Check{
+		Name: "Database schema",
+		Run: func(ctx context.Context) error {
+			tableNames := make([]string, 0, len(tables))
+			for table := range tables {
+				tableNames = append(tableNames, table)
+			}
+			sort.Strings(tableNames)
+
+			var missing []string
+			for _, table := range tableNames {
+				var columns []string
+				err := querier.QueryRow(ctx, `
+					SELECT COALESCE(array_agg(column_name::text), '{}')
+					FROM information_schema.columns
+					WHERE table_name = $1
+					  AND table_schema = ANY(current_schemas(false))
+				`, table).Scan(&columns)
+				if err != nil {
+					return xerrors.Errorf("error querying columns of %q: %w", table, err)
+				}
+
+				existing := make(map[string]struct{}, len(columns))
+				for _, column := range columns {
+					existing[column] = struct{}{}
+				}
+
+				for _, column := range tables[table] {
+					if _, ok := existing[column]; !ok {
+						missing = append(missing, table+"."+column)
+					}
+				}
+			}
+
+			if len(missing) > 0 {
+				return xerrors.Errorf("missing column(s), have migrations been applied?: %s",
+					strings.Join(missing, ", "))
+			}
+			return nil
+		},
+	}
+}
+
+// TemplatesCheck verifies that all of the renderer's templates compile.
+func TemplatesCheck(renderer *ptemplate.Renderer) Check {
+	return Check{
+		Name: "Template compilation",
+		Run: func(_ context.Context) error {
+			return renderer.Validate()
+		},
+	}
+}
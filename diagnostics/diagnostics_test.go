@@ -0,0 +1,195 @@
+package diagnostics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/brandur/passages-signup/newslettermeta"
+	"github.com/brandur/passages-signup/ptemplate"
+)
+
+func TestRunAndWriteReport(t *testing.T) {
+	ctx := context.Background()
+
+	renderer, err := ptemplate.NewRenderer(&ptemplate.RendererConfig{
+		DynamicReload:  true,
+		NewsletterMeta: newslettermeta.MustMetaFor("list.brandur.org", newslettermeta.PassagesID),
+		PublicURL:      "https://passages.example.com",
+		Templates:      os.DirFS(".."),
+	})
+	require.NoError(t, err)
+
+	templatesCheck := TemplatesCheck(renderer)
+
+	t.Run("AllPass", func(t *testing.T) {
+		results := Run(ctx, []Check{
+			DatabaseCheck(&fakeQuerier{}),
+			MailCredentialsCheck(&fakeVerifier{}),
+			templatesCheck,
+		})
+
+		var buf bytes.Buffer
+		allPassed, err := WriteReport(&buf, results)
+		require.NoError(t, err)
+		require.True(t, allPassed)
+		require.Equal(t, "[PASS] Database connectivity\n"+
+			"[PASS] Mail credentials\n"+
+			"[PASS] Template compilation\n", buf.String())
+	})
+
+	t.Run("FailingDatabase", func(t *testing.T) {
+		results := Run(ctx, []Check{
+			DatabaseCheck(&fakeQuerier{err: xerrors.New("connection refused")}),
+			MailCredentialsCheck(&fakeVerifier{}),
+			templatesCheck,
+		})
+
+		var buf bytes.Buffer
+		allPassed, err := WriteReport(&buf, results)
+		require.NoError(t, err)
+		require.False(t, allPassed)
+		require.Equal(t, "[FAIL] Database connectivity: error querying database: connection refused\n"+
+			"[PASS] Mail credentials\n"+
+			"[PASS] Template compilation\n", buf.String())
+	})
+}
+
+func TestSchemaCheck(t *testing.T) {
+	ctx := context.Background()
+
+	tables := map[string][]string{
+		"signup":         {"email", "unsubscribed_at"},
+		"outbox_message": {"params"},
+	}
+
+	t.Run("Applied", func(t *testing.T) {
+		check := SchemaCheck(&fakeQuerier{columns: map[string][]string{
+			"outbox_message": {"id", "params"},
+			"signup":         {"email", "id", "unsubscribed_at"},
+		}}, tables)
+		require.NoError(t, check.Run(ctx))
+	})
+
+	t.Run("MissingColumns", func(t *testing.T) {
+		check := SchemaCheck(&fakeQuerier{columns: map[string][]string{
+			"signup": {"email", "id"},
+		}}, tables)
+		require.EqualError(t, check.Run(ctx), "missing column(s), have migrations been applied?: "+
+			"outbox_message.params, signup.unsubscribed_at")
+	})
+
+	t.Run("QueryError", func(t *testing.T) {
+		check := SchemaCheck(&fakeQuerier{err: xerrors.New("connection refused")}, tables)
+		require.EqualError(t, check.Run(ctx), `error querying columns of "outbox_message": connection refused`)
+	})
+}
+
+func TestWriteFormattedReport(t *testing.T) {
+	ctx := context.Background()
+
+	results := Run(ctx, []Check{
+		DatabaseCheck(&fakeQuerier{}),
+		MailCredentialsCheck(&fakeVerifier{err: xerrors.New("unauthorized")}),
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		var buf bytes.Buffer
+		allPassed, err := WriteFormattedReport(&buf, results, FormatJSON)
+		require.NoError(t, err)
+		require.False(t, allPassed)
+		require.JSONEq(t, `{
+			"all_passed": false,
+			"checks": [
+				{"name": "Database connectivity", "status": "pass"},
+				{"name": "Mail credentials", "status": "fail", "error": "error verifying mail credentials: unauthorized"}
+			]
+		}`, buf.String())
+
+		var report JSONReport
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &report))
+		require.Len(t, report.Checks, 2)
+		require.Equal(t, StatusPass, report.Checks[0].Status)
+		require.Equal(t, StatusFail, report.Checks[1].Status)
+	})
+
+	t.Run("JSONAllPass", func(t *testing.T) {
+		var buf bytes.Buffer
+		allPassed, err := WriteFormattedReport(&buf, results[:1], FormatJSON)
+		require.NoError(t, err)
+		require.True(t, allPassed)
+		require.JSONEq(t, `{
+			"all_passed": true,
+			"checks": [
+				{"name": "Database connectivity", "status": "pass"}
+			]
+		}`, buf.String())
+	})
+
+	t.Run("Text", func(t *testing.T) {
+		var buf bytes.Buffer
+		allPassed, err := WriteFormattedReport(&buf, results, FormatText)
+		require.NoError(t, err)
+		require.False(t, allPassed)
+		require.Equal(t, "[PASS] Database connectivity\n"+
+			"[FAIL] Mail credentials: error verifying mail credentials: unauthorized\n", buf.String())
+	})
+
+	t.Run("UnknownFormat", func(t *testing.T) {
+		var buf bytes.Buffer
+		_, err := WriteFormattedReport(&buf, results, "xml")
+		require.EqualError(t, err, `unknown report format: "xml"`)
+		require.Empty(t, buf.String())
+	})
+}
+
+//
+// Private types
+//
+
+type fakeQuerier struct {
+	// columns are returned for queries scanning into a string slice, keyed by
+	// the query's first argument.
+	columns map[string][]string
+
+	err error
+}
+
+func (q *fakeQuerier) QueryRow(_ context.Context, _ string, args ...interface{}) pgx.Row {
+	row := &fakeRow{err: q.err}
+	if len(args) > 0 {
+		if key, ok := args[0].(string); ok {
+			row.columns = q.columns[key]
+		}
+	}
+	return row
+}
+
+type fakeRow struct {
+	columns []string
+	err     error
+}
+
+func (r *fakeRow) Scan(dest ...interface{}) error {
+	if r.err != nil {
+		return r.err
+	}
+	if columns, ok := dest[0].(*[]string); ok {
+		*columns = r.columns
+	}
+	return nil
+}
+
+type fakeVerifier struct {
+	err error
+}
+
+func (v *fakeVerifier) VerifyCredentials(_ context.Context) error {
+	return v.err
+}
@@ -0,0 +1,220 @@
+package emailvalidator
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// Limits on the length of an email address and its parts as specified by RFC
+// 5321.
+const (
+	maxDomainLength = 253
+	maxLabelLength  = 63
+	maxLength       = 254
+	maxLocalLength  = 64
+	minTLDLength    = 2
+)
+
+var (
+	// ErrInvalid is returned when an email address is malformed.
+	ErrInvalid = errors.New("invalid email address")
+
+	// ErrNoMX is returned when an email address's domain has no mail servers,
+	// so mail sent to it could never be delivered.
+	ErrNoMX = errors.New("email domain has no mail servers")
+
+	// ErrTooLong is returned when an email address or one of its parts exceeds
+	// the length allowed by RFC 5321.
+	ErrTooLong = errors.New("email address too long")
+)
+
+// Validate checks that the given string looks like a valid email address,
+// returning an error describing the problem if it doesn't. Returned errors
+// wrap either ErrInvalid or ErrTooLong.
+//
+// This is stricter than a simple regexp, but we know that no amount of
+// validation will catch every undeliverable address, so to some extent we
+// still rely on the mail provider to do some of that work for us. IP address
+// literals in the domain aren't supported.
+func Validate(email string) error {
+	if len(email) > maxLength {
+		return xerrors.Errorf("%w: longer than %d characters", ErrTooLong, maxLength)
+	}
+
+	// Use the last `@` because quoted local parts are allowed to contain one.
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return xerrors.Errorf("%w: missing @", ErrInvalid)
+	}
+
+	local, domain := email[:at], email[at+1:]
+
+	if err := validateLocal(local); err != nil {
+		return err
+	}
+
+	return validateDomain(domain)
+}
+
+// MXResolver looks up a domain's MX records. It's implemented by
+// *net.Resolver, and exists so that lookups can be stubbed in tests.
+type MXResolver interface {
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+}
+
+// ValidateMX checks that the domain of an already validated email address has
+// mail servers, returning an error wrapping ErrNoMX if it definitely doesn't.
+//
+// Other lookup failures, like timeouts, return an error that doesn't wrap
+// ErrNoMX. Callers will usually want to let those addresses through rather
+// than reject real users because of a DNS hiccup.
+func ValidateMX(ctx context.Context, resolver MXResolver, email string) error {
+	domain := email[strings.LastIndex(email, "@")+1:]
+
+	records, err := resolver.LookupMX(ctx, domain)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return xerrors.Errorf("%w: %s", ErrNoMX, domain)
+		}
+		return xerrors.Errorf("error looking up MX records for %s: %w", domain, err)
+	}
+
+	// A single MX record of "." is a null MX (RFC 7505), which explicitly
+	// declares that the domain doesn't accept mail.
+	if len(records) == 0 || (len(records) == 1 && records[0].Host == ".") {
+		return xerrors.Errorf("%w: %s", ErrNoMX, domain)
+	}
+
+	return nil
+}
+
+//
+// Private functions
+//
+
+// isAtext returns true if the character is allowed unquoted in the local part
+// of an address ("atext" in RFC 5322).
+func isAtext(c byte) bool {
+	return isAlphanumeric(c) || strings.IndexByte("!#$%&'*+-/=?^_`{|}~", c) >= 0
+}
+
+func isAlpha(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+func isAlphanumeric(c byte) bool {
+	return isAlpha(c) || ('0' <= c && c <= '9')
+}
+
+func validateDomain(domain string) error {
+	if domain == "" {
+		return xerrors.Errorf("%w: empty domain", ErrInvalid)
+	}
+
+	if len(domain) > maxDomainLength {
+		return xerrors.Errorf("%w: domain longer than %d characters", ErrTooLong, maxDomainLength)
+	}
+
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return xerrors.Errorf("%w: domain has no top-level domain", ErrInvalid)
+	}
+
+	for _, label := range labels {
+		if label == "" {
+			return xerrors.Errorf("%w: domain contains an empty label", ErrInvalid)
+		}
+
+		if len(label) > maxLabelLength {
+			return xerrors.Errorf("%w: domain label longer than %d characters", ErrTooLong, maxLabelLength)
+		}
+
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return xerrors.Errorf("%w: domain label starts or ends with a hyphen", ErrInvalid)
+		}
+
+		for i := 0; i < len(label); i++ {
+			if !isAlphanumeric(label[i]) && label[i] != '-' {
+				return xerrors.Errorf("%w: domain contains invalid character %q", ErrInvalid, label[i])
+			}
+		}
+	}
+
+	// Top-level domains are alphabetic, except for internationalized ones
+	// which are punycode encoded with an `xn--` prefix.
+	tld := labels[len(labels)-1]
+	if len(tld) < minTLDLength {
+		return xerrors.Errorf("%w: top-level domain shorter than %d characters", ErrInvalid, minTLDLength)
+	}
+	if !strings.HasPrefix(strings.ToLower(tld), "xn--") {
+		for i := 0; i < len(tld); i++ {
+			if !isAlpha(tld[i]) {
+				return xerrors.Errorf("%w: top-level domain must be alphabetic", ErrInvalid)
+			}
+		}
+	}
+
+	return nil
+}
+
+func validateLocal(local string) error {
+	if local == "" {
+		return xerrors.Errorf("%w: empty local part", ErrInvalid)
+	}
+
+	if len(local) > maxLocalLength {
+		return xerrors.Errorf("%w: local part longer than %d characters", ErrTooLong, maxLocalLength)
+	}
+
+	if local[0] == '"' {
+		return validateQuotedLocal(local)
+	}
+
+	if local[0] == '.' || local[len(local)-1] == '.' {
+		return xerrors.Errorf("%w: local part starts or ends with a dot", ErrInvalid)
+	}
+
+	if strings.Contains(local, "..") {
+		return xerrors.Errorf("%w: local part contains consecutive dots", ErrInvalid)
+	}
+
+	for i := 0; i < len(local); i++ {
+		if !isAtext(local[i]) && local[i] != '.' {
+			return xerrors.Errorf("%w: local part contains invalid character %q", ErrInvalid, local[i])
+		}
+	}
+
+	return nil
+}
+
+// validateQuotedLocal validates a local part in quoted string form like
+// `"john doe"`. Within quotes most printable characters are allowed, and
+// quotes and backslashes must be escaped with a backslash.
+func validateQuotedLocal(local string) error {
+	if len(local) < 2 || local[len(local)-1] != '"' {
+		return xerrors.Errorf("%w: unterminated quoted local part", ErrInvalid)
+	}
+
+	inner := local[1 : len(local)-1]
+	for i := 0; i < len(inner); i++ {
+		c := inner[i]
+		switch {
+		case c == '\\':
+			i++
+			if i >= len(inner) || inner[i] < ' ' || inner[i] > '~' {
+				return xerrors.Errorf("%w: invalid escape in quoted local part", ErrInvalid)
+			}
+		case c == '"':
+			return xerrors.Errorf("%w: unescaped quote in quoted local part", ErrInvalid)
+		case c < ' ' || c > '~':
+			return xerrors.Errorf("%w: quoted local part contains invalid character %q", ErrInvalid, c)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package emailvalidator
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	testCases := []struct {
+		name    string
+		email   string
+		wantErr error
+	}{
+		// Valid
+		{"Simple", "foo@example.com", nil},
+		{"Subdomain", "foo@mail.example.co.uk", nil},
+		{"DotsInLocal", "foo.bar.baz@example.com", nil},
+		{"PlusTag", "foo+newsletter@example.com", nil},
+		{"SpecialCharacters", "!#$%&'*+-/=?^_`{|}~@example.com", nil},
+		{"HyphenatedDomain", "foo@my-example.com", nil},
+		{"PunycodeTLD", "foo@example.xn--p1ai", nil},
+		{"QuotedLocal", `"foo bar"@example.com`, nil},
+		{"QuotedLocalWithAt", `"foo@bar"@example.com`, nil},
+		{"QuotedLocalWithEscapedQuote", `"foo\"bar"@example.com`, nil},
+		{"MaxLocalLength", strings.Repeat("a", 64) + "@example.com", nil},
+		{"MaxLength", strings.Repeat("a", 64) + "@" + strings.Repeat("b", 63) + "." + strings.Repeat("c", 63) + "." + strings.Repeat("d", 57) + ".com", nil},
+
+		// Invalid
+		{"Empty", "", ErrInvalid},
+		{"NoAt", "foo.example.com", ErrInvalid},
+		{"EmptyLocal", "@example.com", ErrInvalid},
+		{"EmptyDomain", "foo@", ErrInvalid},
+		{"NoTLD", "foo@localhost", ErrInvalid},
+		{"ShortTLD", "foo@example.c", ErrInvalid},
+		{"NumericTLD", "foo@example.123", ErrInvalid},
+		{"IPLiteral", "foo@[127.0.0.1]", ErrInvalid},
+		{"LeadingDot", ".foo@example.com", ErrInvalid},
+		{"TrailingDot", "foo.@example.com", ErrInvalid},
+		{"ConsecutiveDots", "foo..bar@example.com", ErrInvalid},
+		{"ConsecutiveDotsInDomain", "foo@example..com", ErrInvalid},
+		{"LeadingHyphenInDomain", "foo@-example.com", ErrInvalid},
+		{"TrailingHyphenInDomain", "foo@example-.com", ErrInvalid},
+		{"SpaceInLocal", "foo bar@example.com", ErrInvalid},
+		{"UnderscoreInDomain", "foo@exa_mple.com", ErrInvalid},
+		{"UnterminatedQuote", `"foo@example.com`, ErrInvalid},
+		{"UnescapedQuote", `"foo"bar"@example.com`, ErrInvalid},
+		{"DoubleAt", "foo@bar@example.com", ErrInvalid},
+
+		// Too long
+		{"LocalTooLong", strings.Repeat("a", 65) + "@example.com", ErrTooLong},
+		{"LabelTooLong", "foo@" + strings.Repeat("a", 64) + ".com", ErrTooLong},
+		{"TooLong", strings.Repeat("a", 64) + "@" + strings.Repeat("b", 63) + "." + strings.Repeat("c", 63) + "." + strings.Repeat("d", 58) + ".com", ErrTooLong},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Validate(tc.email)
+			if tc.wantErr == nil {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateMX(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("HasMX", func(t *testing.T) {
+		resolver := &stubMXResolver{records: []*net.MX{{Host: "mx.example.com.", Pref: 10}}}
+		require.NoError(t, ValidateMX(ctx, resolver, "foo@example.com"))
+		require.Equal(t, "example.com", resolver.domain)
+	})
+
+	t.Run("NoRecords", func(t *testing.T) {
+		err := ValidateMX(ctx, &stubMXResolver{}, "foo@example.com")
+		require.ErrorIs(t, err, ErrNoMX)
+	})
+
+	t.Run("NullMX", func(t *testing.T) {
+		resolver := &stubMXResolver{records: []*net.MX{{Host: ".", Pref: 0}}}
+		err := ValidateMX(ctx, resolver, "foo@example.com")
+		require.ErrorIs(t, err, ErrNoMX)
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		resolver := &stubMXResolver{err: &net.DNSError{Err: "no such host", Name: "example.com", IsNotFound: true}}
+		err := ValidateMX(ctx, resolver, "foo@example.com")
+		require.ErrorIs(t, err, ErrNoMX)
+	})
+
+	t.Run("LookupError", func(t *testing.T) {
+		resolver := &stubMXResolver{err: errors.New("timeout")}
+		err := ValidateMX(ctx, resolver, "foo@example.com")
+		require.Error(t, err)
+		require.NotErrorIs(t, err, ErrNoMX)
+	})
+
+	t.Run("QuotedLocalWithAt", func(t *testing.T) {
+		resolver := &stubMXResolver{records: []*net.MX{{Host: "mx.example.com.", Pref: 10}}}
+		require.NoError(t, ValidateMX(ctx, resolver, `"foo@bar"@example.com`))
+		require.Equal(t, "example.com", resolver.domain)
+	})
+}
+
+// stubMXResolver is an MXResolver that returns canned results and records the
+// domain that it was asked about.
+type stubMXResolver struct {
+	domain  string
+	err     error
+	records []*net.MX
+}
+
+func (r *stubMXResolver) LookupMX(_ context.Context, name string) ([]*net.MX, error) {
+	r.domain = name
+	return r.records, r.err
+}
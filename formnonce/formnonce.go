@@ -0,0 +1,214 @@
+// Package formnonce issues signed, single-use nonces that are embedded in the
+// signup form and consumed when it's submitted. A captured submission can't be
+// replayed because its nonce will already have been used.
+package formnonce
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/brandur/passages-signup/signing"
+)
+
+// FieldName is the name of the form field that carries a nonce.
+const FieldName = "form_nonce"
+
+// MaxAge is how long an issued nonce can be used for. Used nonces only need to
+// be remembered for this long because older ones are rejected as expired.
+const MaxAge = 24 * time.Hour
+
+const (
+	randomLength    = 16
+	payloadLength   = randomLength + 8 // random bytes and issue time
+	signatureLength = signing.Size
+
+	// memoryStorePruneInterval is how often MemoryStore prunes expired
+	// nonces. Pruning walks every used nonce, so it's not done on every use.
+	memoryStorePruneInterval = 1 * time.Minute
+)
+
+var (
+	// ErrExpired is returned when a nonce's signature is valid, but it was
+	// issued longer than MaxAge ago.
+	ErrExpired = errors.New("form nonce expired")
+
+	// ErrInvalidSignature is returned when a nonce is well-formed, but its
+	// signature doesn't match, which means that it was tampered with or signed
+	// with a different secret.
+	ErrInvalidSignature = errors.New("form nonce signature invalid")
+
+	// ErrMalformed is returned when a nonce can't be decoded.
+	ErrMalformed = errors.New("form nonce malformed")
+
+	// ErrMissing is returned when a submission doesn't carry a nonce.
+	ErrMissing = errors.New("form nonce missing")
+
+	// ErrReused is returned when a nonce is valid, but has already been used.
+	ErrReused = errors.New("form nonce already used")
+)
+
+// Store tracks nonces that have been used.
+type Store interface {
+	// MarkUsed records that nonce was used, remembering it until expiresAt.
+	// Returns false if it had already been marked as used.
+	MarkUsed(ctx context.Context, nonce string, expiresAt time.Time) (bool, error)
+
+	// Release forgets that nonce was used so that it can be used again.
+	Release(ctx context.Context, nonce string) error
+}
+
+// Manager issues and consumes nonces.
+type Manager struct {
+	secret []byte
+	store  Store
+
+	// timeNow returns the current time. Can be overridden in tests.
+	timeNow func() time.Time
+}
+
+// NewManager initializes a new Manager that signs nonces with the given
+// secret and tracks used ones in store.
+func NewManager(secret string, store Store) *Manager {
+	return &Manager{
+		secret:  []byte(secret),
+		store:   store,
+		timeNow: time.Now,
+	}
+}
+
+// Issue generates a new signed nonce to be embedded in a form.
+func (m *Manager) Issue() (string, error) {
+	payload := make([]byte, payloadLength)
+	if _, err := rand.Read(payload[:randomLength]); err != nil {
+		return "", xerrors.Errorf("error generating form nonce: %w", err)
+	}
+	binary.BigEndian.PutUint64(payload[randomLength:], uint64(m.timeNow().Unix()))
+
+	return base64.RawURLEncoding.EncodeToString(append(payload, signing.Sign(m.secret, payload)...)), nil
+}
+
+// Consume checks a nonce and marks it as used so that it can't be used again.
+// Returns ErrMissing, ErrMalformed, ErrInvalidSignature, ErrExpired, or
+// ErrReused if the nonce can't be used.
+func (m *Manager) Consume(ctx context.Context, nonce string) error {
+	payload, err := m.decode(nonce)
+	if err != nil {
+		return err
+	}
+
+	expiresAt := time.Unix(int64(binary.BigEndian.Uint64(payload[randomLength:])), 0).Add(MaxAge)
+	if m.timeNow().After(expiresAt) {
+		return ErrExpired
+	}
+
+	// Key on the payload rather than the full nonce so that a nonce can't be
+	// reused by finding another encoding of it.
+	firstUse, err := m.store.MarkUsed(ctx, string(payload), expiresAt)
+	if err != nil {
+		return xerrors.Errorf("error marking form nonce used: %w", err)
+	}
+	if !firstUse {
+		return ErrReused
+	}
+
+	return nil
+}
+
+// Release makes a consumed nonce usable again. It's used when a submission
+// fails, like because of a typo in an email, so that the user can correct it
+// and resubmit the same form. Returns the same errors as Consume for a nonce
+// that's missing or invalid.
+func (m *Manager) Release(ctx context.Context, nonce string) error {
+	payload, err := m.decode(nonce)
+	if err != nil {
+		return err
+	}
+
+	if err := m.store.Release(ctx, string(payload)); err != nil {
+		return xerrors.Errorf("error releasing form nonce: %w", err)
+	}
+
+	return nil
+}
+
+// decode decodes a nonce and checks its signature, returning its payload.
+func (m *Manager) decode(nonce string) ([]byte, error) {
+	if nonce == "" {
+		return nil, ErrMissing
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(data) != payloadLength+signatureLength {
+		return nil, ErrMalformed
+	}
+
+	payload, signature := data[:payloadLength], data[payloadLength:]
+	if !signing.Verify(m.secret, payload, signature) {
+		return nil, ErrInvalidSignature
+	}
+
+	return payload, nil
+}
+
+//
+// MemoryStore
+//
+
+// MemoryStore is a Store that keeps used nonces in memory. Used nonces aren't
+// shared between processes, so it's only suitable when running a single one.
+type MemoryStore struct {
+	mut      sync.Mutex
+	prunedAt time.Time
+	used     map[string]time.Time
+
+	// timeNow returns the current time. Can be overridden in tests.
+	timeNow func() time.Time
+}
+
+// NewMemoryStore initializes a new MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		used:    make(map[string]time.Time),
+		timeNow: time.Now,
+	}
+}
+
+// MarkUsed records that nonce was used. Expired nonces are pruned every
+// memoryStorePruneInterval so that the store doesn't grow without bound.
+func (s *MemoryStore) MarkUsed(_ context.Context, nonce string, expiresAt time.Time) (bool, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	now := s.timeNow()
+	if now.Sub(s.prunedAt) >= memoryStorePruneInterval {
+		for usedNonce, usedExpiresAt := range s.used {
+			if now.After(usedExpiresAt) {
+				delete(s.used, usedNonce)
+			}
+		}
+		s.prunedAt = now
+	}
+
+	if _, ok := s.used[nonce]; ok {
+		return false, nil
+	}
+
+	s.used[nonce] = expiresAt
+	return true, nil
+}
+
+// Release forgets that nonce was used.
+func (s *MemoryStore) Release(_ context.Context, nonce string) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	delete(s.used, nonce)
+	return nil
+}
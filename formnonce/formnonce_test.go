@@ -0,0 +1,182 @@
+package formnonce
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestManager(t *testing.T) {
+	const secret = "a-secret-that-is-at-least-32-chars"
+
+	var (
+		ctx     context.Context
+		manager *Manager
+		now     time.Time
+	)
+
+	setup := func(test func(*testing.T)) func(*testing.T) {
+		return func(t *testing.T) {
+			t.Helper()
+
+			ctx = context.Background()
+			now = time.Unix(1700000000, 0)
+			store := NewMemoryStore()
+			store.timeNow = func() time.Time { return now }
+
+			manager = NewManager(secret, store)
+			manager.timeNow = func() time.Time { return now }
+
+			test(t)
+		}
+	}
+
+	issue := func(t *testing.T, manager *Manager) string {
+		t.Helper()
+
+		nonce, err := manager.Issue()
+		require.NoError(t, err)
+		return nonce
+	}
+
+	t.Run("Issue", setup(func(t *testing.T) { //nolint:thelper
+		nonce := issue(t, manager)
+		require.NotEmpty(t, nonce)
+
+		// Every form gets a different nonce.
+		require.NotEqual(t, nonce, issue(t, manager))
+	}))
+
+	t.Run("FirstUse", setup(func(t *testing.T) { //nolint:thelper
+		require.NoError(t, manager.Consume(ctx, issue(t, manager)))
+	}))
+
+	t.Run("Replay", setup(func(t *testing.T) { //nolint:thelper
+		nonce := issue(t, manager)
+		require.NoError(t, manager.Consume(ctx, nonce))
+
+		require.ErrorIs(t, manager.Consume(ctx, nonce), ErrReused)
+
+		// Other nonces are unaffected.
+		require.NoError(t, manager.Consume(ctx, issue(t, manager)))
+	}))
+
+	t.Run("Release", setup(func(t *testing.T) { //nolint:thelper
+		nonce := issue(t, manager)
+		require.NoError(t, manager.Consume(ctx, nonce))
+
+		// A released nonce can be used again, but only once.
+		require.NoError(t, manager.Release(ctx, nonce))
+		require.NoError(t, manager.Consume(ctx, nonce))
+		require.ErrorIs(t, manager.Consume(ctx, nonce), ErrReused)
+	}))
+
+	t.Run("ReleaseInvalid", setup(func(t *testing.T) { //nolint:thelper
+		nonce := issue(t, NewManager("a-different-secret-also-32-chars-long", NewMemoryStore()))
+
+		require.ErrorIs(t, manager.Release(ctx, nonce), ErrInvalidSignature)
+		require.ErrorIs(t, manager.Release(ctx, ""), ErrMissing)
+	}))
+
+	t.Run("Expired", setup(func(t *testing.T) { //nolint:thelper
+		nonce := issue(t, manager)
+		now = now.Add(MaxAge + 1*time.Second)
+
+		require.ErrorIs(t, manager.Consume(ctx, nonce), ErrExpired)
+	}))
+
+	t.Run("Missing", setup(func(t *testing.T) { //nolint:thelper
+		require.ErrorIs(t, manager.Consume(ctx, ""), ErrMissing)
+	}))
+
+	t.Run("Tampered", setup(func(t *testing.T) { //nolint:thelper
+		nonce := issue(t, manager)
+		otherNonce := issue(t, manager)
+
+		// Swap in the payload of a different nonce, keeping the original
+		// signature.
+		tampered := otherNonce[:len(otherNonce)-43] + nonce[len(nonce)-43:]
+
+		require.ErrorIs(t, manager.Consume(ctx, tampered), ErrInvalidSignature)
+	}))
+
+	t.Run("DifferentSecret", setup(func(t *testing.T) { //nolint:thelper
+		nonce := issue(t, NewManager("a-different-secret-also-32-chars-long", NewMemoryStore()))
+
+		require.ErrorIs(t, manager.Consume(ctx, nonce), ErrInvalidSignature)
+	}))
+
+	t.Run("Malformed", setup(func(t *testing.T) { //nolint:thelper
+		for _, nonce := range []string{
+			"not base64!",
+			"dG9vLXNob3J0",
+		} {
+			require.ErrorIs(t, manager.Consume(ctx, nonce), ErrMalformed)
+		}
+	}))
+
+	t.Run("StoreError", setup(func(t *testing.T) { //nolint:thelper
+		manager.store = &erroringStore{}
+
+		err := manager.Consume(ctx, issue(t, manager))
+		require.EqualError(t, err, "error marking form nonce used: store unavailable")
+	}))
+}
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+
+	store := NewMemoryStore()
+	store.timeNow = func() time.Time { return now }
+
+	firstUse, err := store.MarkUsed(ctx, "nonce", now.Add(time.Hour))
+	require.NoError(t, err)
+	require.True(t, firstUse)
+
+	firstUse, err = store.MarkUsed(ctx, "nonce", now.Add(time.Hour))
+	require.NoError(t, err)
+	require.False(t, firstUse)
+
+	// Expired nonces are pruned, but only once the prune interval has passed
+	// since the last time.
+	firstUse, err = store.MarkUsed(ctx, "short-lived-nonce", now.Add(time.Second))
+	require.NoError(t, err)
+	require.True(t, firstUse)
+
+	now = now.Add(2 * time.Second)
+	firstUse, err = store.MarkUsed(ctx, "other-nonce", now.Add(time.Hour))
+	require.NoError(t, err)
+	require.True(t, firstUse)
+	require.Contains(t, store.used, "short-lived-nonce")
+
+	now = now.Add(memoryStorePruneInterval)
+	firstUse, err = store.MarkUsed(ctx, "another-nonce", now.Add(time.Hour))
+	require.NoError(t, err)
+	require.True(t, firstUse)
+	require.NotContains(t, store.used, "short-lived-nonce")
+	require.Contains(t, store.used, "nonce")
+
+	// Released nonces are forgotten.
+	require.NoError(t, store.Release(ctx, "nonce"))
+	firstUse, err = store.MarkUsed(ctx, "nonce", now.Add(time.Hour))
+	require.NoError(t, err)
+	require.True(t, firstUse)
+}
+
+//
+// Private types
+//
+
+type erroringStore struct{}
+
+func (s *erroringStore) MarkUsed(_ context.Context, _ string, _ time.Time) (bool, error) {
+	return false, errors.New("store unavailable")
+}
+
+func (s *erroringStore) Release(_ context.Context, _ string) error {
+	return errors.New("store unavailable")
+}
@@ -2,16 +2,38 @@ package mailclient
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/go-playground/validator/v10"
 	"github.com/mailgun/mailgun-go/v3"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/xerrors"
+
+	"github.com/brandur/passages-signup/validation"
 )
 
-var validate = validator.New()
+var validate = validation.New()
+
+// ErrUnavailable is returned by BreakerClient when its circuit breaker has
+// tripped because of repeated failures, and calls are being short-circuited
+// until the mail service has had some time to recover.
+var ErrUnavailable = errors.New("mail service temporarily unavailable")
+
+// ErrQuotaExceeded is returned (wrapped) when the mail service rejects a call
+// because an account quota or limit has been reached. Callers may want to
+// defer the operation and retry it later rather than fail outright.
+var ErrQuotaExceeded = errors.New("mail service quota exceeded")
+
+// listMembersPageSize is the number of members requested per page when
+// listing a mailing list's members.
+const listMembersPageSize = 100
 
 //
 // API
@@ -24,18 +46,332 @@ type API interface {
 	// AddMember adds a new member to a mailing list.
 	AddMember(ctx context.Context, list, email string) error
 
+	// AddMemberWithVars adds a new member to a mailing list, tagging them with
+	// the given vars in addition to any that the implementation adds by
+	// default. Useful for recording things like a member's source or campaign.
+	AddMemberWithVars(ctx context.Context, list, email string, vars map[string]interface{}) error
+
+	// GetMember checks whether an email is a member of a mailing list.
+	GetMember(ctx context.Context, list, email string) (bool, error)
+
+	// ListMembers lists a page of a mailing list's members, including ones
+	// who've unsubscribed. The first page is listed with an empty cursor, and
+	// following ones with the cursor returned along with the previous page.
+	// The returned cursor is empty when there are no more pages.
+	ListMembers(ctx context.Context, list, cursor string) ([]*Member, string, error)
+
 	// SendMessage sends a message an email address.
 	SendMessage(ctx context.Context, params *SendMessageParams) error
+
+	// UnsubscribeMember unsubscribes an email from a mailing list. It's not an
+	// error if the email isn't a member.
+	UnsubscribeMember(ctx context.Context, list, email string) error
+}
+
+// Member is a member of a mailing list.
+type Member struct {
+	Email string
+
+	// Subscribed is false if the member has unsubscribed, in which case
+	// they're kept on the list, but not sent mail.
+	Subscribed bool
+}
+
+// Attachment is a file attached to a message, like a calendar invite.
+type Attachment struct {
+	Content []byte `json:"content" validate:"required"`
+
+	// Name is the attachment's filename, like `invite.ics`. Mailgun doesn't
+	// accept a content type through its API and instead infers one from the
+	// name's extension, so it should have one that matches Content.
+	Name string `json:"name" validate:"required"`
 }
 
 type SendMessageParams struct {
-	ContentsHTML   string `validate:"required"`
-	ContentsPlain  string `validate:"required"`
+	// Attachments are optional files attached to the message.
+	Attachments []*Attachment `validate:"dive,required"`
+
+	ContentsHTML  string `validate:"required"`
+	ContentsPlain string `validate:"required"`
+
+	// FromAddress is an optional address that the message is sent from, for
+	// deliverability setups where it should differ from the list address. If
+	// empty, it's sent from the list address. The list address is still used
+	// for the List-Post header either way. Commands that send mail take their
+	// own FromAddress, which is passed through to here.
+	FromAddress string `validate:"omitempty,email"`
+
 	ListAddress    string `validate:"required"`
 	NewsletterName string `validate:"required"`
 	Recipient      string `validate:"required"`
-	ReplyTo        string `validate:"required"`
-	Subject        string `validate:"required"`
+
+	// ReplyTo is an optional address that replies should go to. If empty, the
+	// Reply-To header isn't set and replies go to the list address.
+	ReplyTo string `validate:"-"`
+
+	Subject string `validate:"required"`
+
+	// UnsubscribeURL is an optional link that unsubscribes the recipient in
+	// one click. It's only set on mail to members of the list, and is
+	// advertised with List-Unsubscribe and List-Unsubscribe-Post headers
+	// (RFC 8058) so that mail clients can show their own unsubscribe button.
+	// Other mail, like confirmations, gets no List-Unsubscribe header.
+	UnsubscribeURL string `validate:"omitempty,url"`
+}
+
+// fromAddress returns the address that the message should be sent from.
+func (p *SendMessageParams) fromAddress() string {
+	if p.FromAddress != "" {
+		return p.FromAddress
+	}
+	return p.ListAddress
+}
+
+//
+// BreakerClient
+//
+
+// BreakerClient is an implementation of API that wraps another API with a
+// circuit breaker. After a number of consecutive failures the breaker trips,
+// and further calls fail immediately with ErrUnavailable for a cooldown
+// period. After the cooldown, a single call is let through as a probe. If it
+// succeeds the breaker closes again, and if it fails the cooldown restarts.
+//
+// This prevents hammering a mail service that's having an outage, and lets
+// callers show a graceful message instead of an error.
+type BreakerClient struct {
+	api       API
+	cooldown  time.Duration
+	threshold int
+	timeNow   func() time.Time
+
+	mut                 sync.Mutex
+	consecutiveFailures int
+	openedAt            time.Time
+	probing             bool
+	state               breakerState
+}
+
+type breakerState int
+
+const (
+	breakerStateClosed breakerState = iota
+	breakerStateOpen
+	breakerStateHalfOpen
+)
+
+// NewBreakerClient initializes a new BreakerClient that trips after threshold
+// consecutive failures and stays open for cooldown.
+func NewBreakerClient(api API, threshold int, cooldown time.Duration) *BreakerClient {
+	return &BreakerClient{
+		api:       api,
+		cooldown:  cooldown,
+		threshold: threshold,
+		timeNow:   time.Now,
+	}
+}
+
+// AddMember adds a new member to a mailing list.
+func (a *BreakerClient) AddMember(ctx context.Context, list, email string) error {
+	return a.withBreaker(func() error {
+		return a.api.AddMember(ctx, list, email)
+	})
+}
+
+// AddMemberWithVars adds a new member to a mailing list with the given vars.
+func (a *BreakerClient) AddMemberWithVars(ctx context.Context, list, email string, vars map[string]interface{}) error {
+	return a.withBreaker(func() error {
+		return a.api.AddMemberWithVars(ctx, list, email, vars)
+	})
+}
+
+// GetMember checks whether an email is a member of a mailing list.
+func (a *BreakerClient) GetMember(ctx context.Context, list, email string) (bool, error) {
+	var exists bool
+	err := a.withBreaker(func() error {
+		var err error
+		exists, err = a.api.GetMember(ctx, list, email)
+		return err
+	})
+	return exists, err
+}
+
+// ListMembers lists a page of a mailing list's members.
+func (a *BreakerClient) ListMembers(ctx context.Context, list, cursor string) ([]*Member, string, error) {
+	var members []*Member
+	var nextCursor string
+	err := a.withBreaker(func() error {
+		var err error
+		members, nextCursor, err = a.api.ListMembers(ctx, list, cursor)
+		return err
+	})
+	return members, nextCursor, err
+}
+
+// SendMessage sends a message an email address.
+func (a *BreakerClient) SendMessage(ctx context.Context, params *SendMessageParams) error {
+	return a.withBreaker(func() error {
+		return a.api.SendMessage(ctx, params)
+	})
+}
+
+// UnsubscribeMember unsubscribes an email from a mailing list.
+func (a *BreakerClient) UnsubscribeMember(ctx context.Context, list, email string) error {
+	return a.withBreaker(func() error {
+		return a.api.UnsubscribeMember(ctx, list, email)
+	})
+}
+
+// allow determines whether a call should be let through, transitioning an
+// open breaker to half-open if its cooldown has elapsed.
+func (a *BreakerClient) allow() bool {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+
+	switch a.state {
+	case breakerStateOpen:
+		if a.timeNow().Before(a.openedAt.Add(a.cooldown)) {
+			return false
+		}
+		a.state = breakerStateHalfOpen
+		a.probing = true
+		return true
+
+	case breakerStateHalfOpen:
+		// Only one probe at a time.
+		if a.probing {
+			return false
+		}
+		a.probing = true
+		return true
+
+	default:
+		return true
+	}
+}
+
+// record updates the breaker's state with the result of a call.
+func (a *BreakerClient) record(err error) {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+
+	a.probing = false
+
+	// Errors that aren't retriable (e.g. a bad email address) don't indicate a
+	// problem with the mail service, so they don't count as failures.
+	if err == nil || !isRetriableError(err) {
+		if a.state != breakerStateClosed {
+			logrus.Infof("Mail circuit breaker closed")
+		}
+		a.consecutiveFailures = 0
+		a.state = breakerStateClosed
+		return
+	}
+
+	a.consecutiveFailures++
+
+	if a.state == breakerStateHalfOpen || a.consecutiveFailures >= a.threshold {
+		if a.state != breakerStateOpen {
+			logrus.Errorf("Mail circuit breaker open after %d consecutive failure(s); last error: %v",
+				a.consecutiveFailures, err)
+		}
+		a.openedAt = a.timeNow()
+		a.state = breakerStateOpen
+	}
+}
+
+func (a *BreakerClient) withBreaker(f func() error) error {
+	if !a.allow() {
+		return ErrUnavailable
+	}
+
+	err := f()
+	a.record(err)
+	return err
+}
+
+//
+// ConcurrencyLimitClient
+//
+
+// ConcurrencyLimitClient is an implementation of API that wraps another API
+// and caps the number of calls to it that are in flight at once, which keeps
+// bulk operations under the mail service's concurrent request limit. Calls
+// beyond the cap block until another finishes or their context is done.
+type ConcurrencyLimitClient struct {
+	api API
+	sem chan struct{}
+}
+
+// NewConcurrencyLimitClient initializes a new ConcurrencyLimitClient that
+// allows at most maxConcurrent calls at once.
+func NewConcurrencyLimitClient(api API, maxConcurrent int) *ConcurrencyLimitClient {
+	return &ConcurrencyLimitClient{
+		api: api,
+		sem: make(chan struct{}, maxConcurrent),
+	}
+}
+
+// AddMember adds a new member to a mailing list.
+func (a *ConcurrencyLimitClient) AddMember(ctx context.Context, list, email string) error {
+	return a.withLimit(ctx, func() error {
+		return a.api.AddMember(ctx, list, email)
+	})
+}
+
+// AddMemberWithVars adds a new member to a mailing list with the given vars.
+func (a *ConcurrencyLimitClient) AddMemberWithVars(ctx context.Context, list, email string, vars map[string]interface{}) error {
+	return a.withLimit(ctx, func() error {
+		return a.api.AddMemberWithVars(ctx, list, email, vars)
+	})
+}
+
+// GetMember checks whether an email is a member of a mailing list.
+func (a *ConcurrencyLimitClient) GetMember(ctx context.Context, list, email string) (bool, error) {
+	var exists bool
+	err := a.withLimit(ctx, func() error {
+		var err error
+		exists, err = a.api.GetMember(ctx, list, email)
+		return err
+	})
+	return exists, err
+}
+
+// ListMembers lists a page of a mailing list's members.
+func (a *ConcurrencyLimitClient) ListMembers(ctx context.Context, list, cursor string) ([]*Member, string, error) {
+	var members []*Member
+	var nextCursor string
+	err := a.withLimit(ctx, func() error {
+		var err error
+		members, nextCursor, err = a.api.ListMembers(ctx, list, cursor)
+		return err
+	})
+	return members, nextCursor, err
+}
+
+// SendMessage sends a message an email address.
+func (a *ConcurrencyLimitClient) SendMessage(ctx context.Context, params *SendMessageParams) error {
+	return a.withLimit(ctx, func() error {
+		return a.api.SendMessage(ctx, params)
+	})
+}
+
+// UnsubscribeMember unsubscribes an email from a mailing list.
+func (a *ConcurrencyLimitClient) UnsubscribeMember(ctx context.Context, list, email string) error {
+	return a.withLimit(ctx, func() error {
+		return a.api.UnsubscribeMember(ctx, list, email)
+	})
+}
+
+func (a *ConcurrencyLimitClient) withLimit(ctx context.Context, f func() error) error {
+	select {
+	case a.sem <- struct{}{}:
+	case <-ctx.Done():
+		return xerrors.Errorf("error waiting for mail concurrency slot: %w", ctx.Err())
+	}
+	defer func() { <-a.sem }()
+
+	return f()
 }
 
 //
@@ -45,22 +381,45 @@ type SendMessageParams struct {
 // FakeClient is a really primitive mock that we can use to verify that
 // certain mail-related calls were made without reaching out to Mailgun.
 type FakeClient struct {
-	MembersAdded []*FakeClientAPIMemberAdded
-	MessagesSent []*FakeClientAPIMessageSent
+	MembersAdded        []*FakeClientAPIMemberAdded
+	MembersUnsubscribed []*FakeClientAPIMember
+	MessagesSent        []*FakeClientAPIMessageSent
+
+	// ListMembersPageSize is the number of members returned per page by
+	// ListMembers. Defaults to the same page size used with Mailgun.
+	ListMembersPageSize int
+
+	// NumAddsToDrop is a number of upcoming member adds that will report
+	// success without actually making the email a member, for simulating the
+	// mail service losing an add. Decremented as adds are dropped.
+	NumAddsToDrop int
+
+	// members is the set of current list members, keyed by list and email.
+	members map[FakeClientAPIMember]struct{}
+}
+
+// FakeClientAPIMember identifies a member of a list in a FakeClient.
+type FakeClientAPIMember struct {
+	List, Email string
 }
 
 // FakeClientAPIMemberAdded records a mailing list member being added to a
 // FakeClient.
 type FakeClientAPIMemberAdded struct {
 	List, Email string
+	Vars        map[string]interface{}
 }
 
 // FakeClientAPIMessageSent records a message being sent from a FakeClient.
 type FakeClientAPIMessageSent struct {
-	ContentsHTML  string
-	ContentsPlain string
-	Recipient     string
-	Subject       string
+	Attachments    []*Attachment `json:"attachments"`
+	ContentsHTML   string        `json:"contents_html"`
+	ContentsPlain  string        `json:"contents_plain"`
+	FromAddress    string        `json:"from_address"`
+	Recipient      string        `json:"recipient"`
+	ReplyTo        string        `json:"reply_to"`
+	Subject        string        `json:"subject"`
+	UnsubscribeURL string        `json:"unsubscribe_url"`
 }
 
 // NewFakeClient initializes a new FakeClient.
@@ -69,12 +428,88 @@ func NewFakeClient() *FakeClient {
 }
 
 // AddMember adds a new member to a mailing list.
-func (a *FakeClient) AddMember(_ context.Context, list, email string) error {
+func (a *FakeClient) AddMember(ctx context.Context, list, email string) error {
+	return a.AddMemberWithVars(ctx, list, email, nil)
+}
+
+// AddMemberWithVars adds a new member to a mailing list with the given vars.
+func (a *FakeClient) AddMemberWithVars(_ context.Context, list, email string, vars map[string]interface{}) error {
 	a.MembersAdded = append(a.MembersAdded,
-		&FakeClientAPIMemberAdded{list, email})
+		&FakeClientAPIMemberAdded{list, email, vars})
+
+	if a.NumAddsToDrop > 0 {
+		a.NumAddsToDrop--
+		return nil
+	}
+
+	if a.members == nil {
+		a.members = make(map[FakeClientAPIMember]struct{})
+	}
+	a.members[FakeClientAPIMember{list, email}] = struct{}{}
 	return nil
 }
 
+// GetMember checks whether an email is a member of a mailing list.
+func (a *FakeClient) GetMember(_ context.Context, list, email string) (bool, error) {
+	_, ok := a.members[FakeClientAPIMember{list, email}]
+	return ok, nil
+}
+
+// ListMembers lists a page of a mailing list's members in the order that they
+// were first added. Members who unsubscribed are included, but ones whose adds
+// were dropped aren't. The cursor is the offset of the page's first member.
+func (a *FakeClient) ListMembers(_ context.Context, list, cursor string) ([]*Member, string, error) {
+	offset := 0
+	if cursor != "" {
+		var err error
+		offset, err = strconv.Atoi(cursor)
+		if err != nil {
+			return nil, "", xerrors.Errorf("invalid cursor %q: %w", cursor, err)
+		}
+	}
+
+	unsubscribed := make(map[FakeClientAPIMember]struct{})
+	for _, member := range a.MembersUnsubscribed {
+		unsubscribed[*member] = struct{}{}
+	}
+
+	var members []*Member
+	seen := make(map[string]struct{})
+	for _, added := range a.MembersAdded {
+		member := FakeClientAPIMember{added.List, added.Email}
+		if member.List != list {
+			continue
+		}
+		if _, ok := seen[member.Email]; ok {
+			continue
+		}
+
+		_, subscribed := a.members[member]
+		_, wasUnsubscribed := unsubscribed[member]
+		if !subscribed && !wasUnsubscribed {
+			continue
+		}
+
+		seen[member.Email] = struct{}{}
+		members = append(members, &Member{Email: member.Email, Subscribed: subscribed})
+	}
+
+	pageSize := a.ListMembersPageSize
+	if pageSize <= 0 {
+		pageSize = listMembersPageSize
+	}
+
+	if offset >= len(members) {
+		return nil, "", nil
+	}
+
+	end := offset + pageSize
+	if end >= len(members) {
+		return members[offset:], "", nil
+	}
+	return members[offset:end], strconv.Itoa(end), nil
+}
+
 // SendMessage sends a message an email address.
 func (a *FakeClient) SendMessage(_ context.Context, params *SendMessageParams) error {
 	if err := validate.Struct(params); err != nil {
@@ -83,15 +518,151 @@ func (a *FakeClient) SendMessage(_ context.Context, params *SendMessageParams) e
 
 	a.MessagesSent = append(a.MessagesSent,
 		&FakeClientAPIMessageSent{
-			ContentsHTML:  params.ContentsHTML,
-			ContentsPlain: params.ContentsPlain,
-			Recipient:     params.Recipient,
-			Subject:       params.Subject,
+			Attachments:    params.Attachments,
+			ContentsHTML:   params.ContentsHTML,
+			ContentsPlain:  params.ContentsPlain,
+			FromAddress:    params.fromAddress(),
+			Recipient:      params.Recipient,
+			ReplyTo:        params.ReplyTo,
+			Subject:        params.Subject,
+			UnsubscribeURL: params.UnsubscribeURL,
 		})
 
 	return nil
 }
 
+// UnsubscribeMember unsubscribes an email from a mailing list.
+func (a *FakeClient) UnsubscribeMember(_ context.Context, list, email string) error {
+	member := FakeClientAPIMember{list, email}
+	a.MembersUnsubscribed = append(a.MembersUnsubscribed, &member)
+	delete(a.members, member)
+	return nil
+}
+
+//
+// FailoverClient
+//
+
+// FailoverClient is an implementation of API that wraps a primary and secondary
+// API. Calls go to the primary, but if it fails with an error that looks
+// retriable, they're retried against the secondary.
+type FailoverClient struct {
+	primary   API
+	secondary API
+
+	mut          sync.Mutex
+	handledCount map[FailoverBackend]int
+}
+
+// FailoverBackend identifies which backend of a FailoverClient handled a call.
+type FailoverBackend string
+
+// The possible backends of a FailoverClient.
+const (
+	FailoverBackendPrimary   FailoverBackend = "primary"
+	FailoverBackendSecondary FailoverBackend = "secondary"
+)
+
+// NewFailoverClient initializes a new FailoverClient.
+func NewFailoverClient(primary, secondary API) *FailoverClient {
+	return &FailoverClient{
+		primary:      primary,
+		secondary:    secondary,
+		handledCount: make(map[FailoverBackend]int),
+	}
+}
+
+// AddMember adds a new member to a mailing list.
+func (a *FailoverClient) AddMember(ctx context.Context, list, email string) error {
+	return a.withFailover("AddMember", func(api API) error {
+		return api.AddMember(ctx, list, email)
+	})
+}
+
+// AddMemberWithVars adds a new member to a mailing list with the given vars.
+func (a *FailoverClient) AddMemberWithVars(ctx context.Context, list, email string, vars map[string]interface{}) error {
+	return a.withFailover("AddMemberWithVars", func(api API) error {
+		return api.AddMemberWithVars(ctx, list, email, vars)
+	})
+}
+
+// GetMember checks whether an email is a member of a mailing list.
+func (a *FailoverClient) GetMember(ctx context.Context, list, email string) (bool, error) {
+	var exists bool
+	err := a.withFailover("GetMember", func(api API) error {
+		var err error
+		exists, err = api.GetMember(ctx, list, email)
+		return err
+	})
+	return exists, err
+}
+
+// HandledCount returns the number of calls that were successfully handled by
+// the given backend.
+func (a *FailoverClient) HandledCount(backend FailoverBackend) int {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+
+	return a.handledCount[backend]
+}
+
+// ListMembers lists a page of a mailing list's members.
+func (a *FailoverClient) ListMembers(ctx context.Context, list, cursor string) ([]*Member, string, error) {
+	var members []*Member
+	var nextCursor string
+	err := a.withFailover("ListMembers", func(api API) error {
+		var err error
+		members, nextCursor, err = api.ListMembers(ctx, list, cursor)
+		return err
+	})
+	return members, nextCursor, err
+}
+
+// SendMessage sends a message an email address.
+func (a *FailoverClient) SendMessage(ctx context.Context, params *SendMessageParams) error {
+	return a.withFailover("SendMessage", func(api API) error {
+		return api.SendMessage(ctx, params)
+	})
+}
+
+// UnsubscribeMember unsubscribes an email from a mailing list.
+func (a *FailoverClient) UnsubscribeMember(ctx context.Context, list, email string) error {
+	return a.withFailover("UnsubscribeMember", func(api API) error {
+		return api.UnsubscribeMember(ctx, list, email)
+	})
+}
+
+func (a *FailoverClient) recordHandled(op string, backend FailoverBackend) {
+	a.mut.Lock()
+	a.handledCount[backend]++
+	a.mut.Unlock()
+
+	logrus.Debugf("Mail %s handled by %s backend", op, backend)
+}
+
+func (a *FailoverClient) withFailover(op string, f func(api API) error) error {
+	primaryErr := f(a.primary)
+	if primaryErr == nil {
+		a.recordHandled(op, FailoverBackendPrimary)
+		return nil
+	}
+
+	if !isRetriableError(primaryErr) {
+		return primaryErr
+	}
+
+	logrus.Errorf("Mail %s failed on primary backend; failing over to secondary: %v",
+		op, primaryErr)
+
+	if err := f(a.secondary); err != nil {
+		return xerrors.Errorf("error on both primary and secondary backends (primary: %v): %w",
+			primaryErr, err)
+	}
+
+	a.recordHandled(op, FailoverBackendSecondary)
+	return nil
+}
+
 //
 // MailgunClient
 //
@@ -112,35 +683,105 @@ func NewMailgunClient(mailDomain, apiKey string) *MailgunClient {
 
 // AddMember adds a new member to a mailing list.
 func (a *MailgunClient) AddMember(ctx context.Context, list, email string) error {
-	timestamp := time.Now().UTC().Format("2006-01-02T15:04:05-0700")
+	return a.AddMemberWithVars(ctx, list, email, nil)
+}
+
+// AddMemberWithVars adds a new member to a mailing list with the given vars.
+// Members are always tagged as having come from this app along with a
+// timestamp, but these defaults can be overridden by the given vars.
+func (a *MailgunClient) AddMemberWithVars(ctx context.Context, list, email string, vars map[string]interface{}) error {
 	err := a.mg.CreateMember(ctx, true, list, mailgun.Member{
 		Address: email,
-		Vars: map[string]interface{}{
-			"passages-signup":           true,
-			"passages-signup-timestamp": timestamp,
-		},
+		Vars:    mailgunMemberVars(time.Now(), vars),
 	})
 	return interpretMailgunError(err)
 }
 
+// GetMember checks whether an email is a member of a mailing list. Mailgun
+// responds with a 404 for an email that isn't a member.
+func (a *MailgunClient) GetMember(ctx context.Context, list, email string) (bool, error) {
+	_, err := a.mg.GetMember(ctx, email, list)
+	if mailgun.GetStatusFromErr(err) == http.StatusNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, interpretMailgunError(err)
+	}
+	return true, nil
+}
+
+// ListMembers lists a page of a mailing list's members. Mailgun pages through
+// members in order of address, so the cursor is the address of the last
+// member of the previous page.
+func (a *MailgunClient) ListMembers(ctx context.Context, list, cursor string) ([]*Member, string, error) {
+	params := url.Values{"limit": {strconv.Itoa(listMembersPageSize)}}
+	if cursor == "" {
+		params.Set("page", "first")
+	} else {
+		params.Set("page", "next")
+		params.Set("address", cursor)
+	}
+	pageURL := a.mg.APIBase() + "/lists/" + url.PathEscape(list) + "/members/pages?" + params.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return nil, "", xerrors.Errorf("error building list members request: %w", err)
+	}
+	req.SetBasicAuth("api", a.mg.APIKey())
+
+	resp, err := a.mg.Client().Do(req)
+	if err != nil {
+		return nil, "", xerrors.Errorf("error listing members: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", xerrors.Errorf("error reading list members response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", interpretMailgunError(&mailgun.UnexpectedResponseError{
+			Actual:   resp.StatusCode,
+			Data:     body,
+			Expected: []int{http.StatusOK},
+			URL:      pageURL,
+		})
+	}
+
+	var page struct {
+		Items []mailgun.Member `json:"items"`
+	}
+	if err := json.Unmarshal(body, &page); err != nil {
+		return nil, "", xerrors.Errorf("error decoding list members response: %w", err)
+	}
+
+	members := make([]*Member, len(page.Items))
+	for i, item := range page.Items {
+		members[i] = &Member{
+			Email:      item.Address,
+			Subscribed: item.Subscribed == nil || *item.Subscribed,
+		}
+	}
+
+	// A short page is the last one, which saves a request for an empty page.
+	if len(page.Items) < listMembersPageSize {
+		return members, "", nil
+	}
+	return members, page.Items[len(page.Items)-1].Address, nil
+}
+
 // SendMessage sends a message an email address.
 func (a *MailgunClient) SendMessage(ctx context.Context, params *SendMessageParams) error {
 	if err := validate.Struct(params); err != nil {
 		return xerrors.Errorf("error validating params: %w", err)
 	}
 
-	message := a.mg.NewMessage(
-		params.NewsletterName+" <"+params.ListAddress+">",
-		params.Subject,
-		params.ContentsPlain)
-
-	if err := message.AddRecipient(params.Recipient); err != nil {
-		return xerrors.Errorf("error adding recipient: %w", err)
+	message, err := a.newMessage(params)
+	if err != nil {
+		return err
 	}
 
-	message.SetHtml(params.ContentsHTML)
-	message.SetReplyTo(params.ReplyTo)
-
 	resp, _, err := a.mg.Send(ctx, message)
 	if err != nil {
 		logrus.Errorf("Mailgun error while sending to %q (response: %q): %v",
@@ -153,6 +794,64 @@ func (a *MailgunClient) SendMessage(ctx context.Context, params *SendMessagePara
 	return nil
 }
 
+// UnsubscribeMember unsubscribes an email from a mailing list. The member is
+// kept on the list, but marked as unsubscribed, which is the same thing that
+// happens when they click Mailgun's own unsubscribe link. Mailgun responds
+// with a 404 for an email that isn't a member, which is treated as success.
+func (a *MailgunClient) UnsubscribeMember(ctx context.Context, list, email string) error {
+	subscribed := false
+	_, err := a.mg.UpdateMember(ctx, email, list, mailgun.Member{
+		Subscribed: &subscribed,
+	})
+	if mailgun.GetStatusFromErr(err) == http.StatusNotFound {
+		return nil
+	}
+	return interpretMailgunError(err)
+}
+
+// VerifyCredentials checks that the client's API key is valid by making a
+// lightweight authenticated request for information on its domain.
+func (a *MailgunClient) VerifyCredentials(ctx context.Context) error {
+	_, err := a.mg.GetDomain(ctx, a.mg.Domain())
+	return interpretMailgunError(err)
+}
+
+// newMessage builds a Mailgun message from the given params. The Reply-To
+// and List-Unsubscribe headers are only set if the params include a reply-to
+// address and unsubscribe URL respectively.
+//
+// Attachments are uploaded under their names, which Mailgun uses to infer
+// their content types.
+func (a *MailgunClient) newMessage(params *SendMessageParams) (*mailgun.Message, error) {
+	message := a.mg.NewMessage(
+		params.NewsletterName+" <"+params.fromAddress()+">",
+		params.Subject,
+		params.ContentsPlain)
+
+	if err := message.AddRecipient(params.Recipient); err != nil {
+		return nil, xerrors.Errorf("error adding recipient: %w", err)
+	}
+
+	message.AddHeader("List-Post", "<mailto:"+params.ListAddress+">")
+
+	if params.UnsubscribeURL != "" {
+		message.AddHeader("List-Unsubscribe", "<"+params.UnsubscribeURL+">")
+		message.AddHeader("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+	}
+
+	message.SetHtml(params.ContentsHTML)
+
+	if params.ReplyTo != "" {
+		message.SetReplyTo(params.ReplyTo)
+	}
+
+	for _, attachment := range params.Attachments {
+		message.AddBufferAttachment(attachment.Name, attachment.Content)
+	}
+
+	return message, nil
+}
+
 //
 // Private functions
 //
@@ -165,9 +864,65 @@ func interpretMailgunError(err error) error {
 			message = "(empty)"
 		}
 
+		if isMailgunQuotaError(unexpectedErr.Actual, message) {
+			return xerrors.Errorf("Got status code %v from Mailgun. Message: %v: %w",
+				unexpectedErr.Actual, message, ErrQuotaExceeded)
+		}
+
 		return xerrors.Errorf("Got unexpected status code %v from Mailgun. Message: %v",
 			unexpectedErr.Actual, message)
 	}
 
 	return err
 }
+
+// isMailgunQuotaError determines whether a Mailgun response indicates that an
+// account quota or limit has been reached. Mailgun uses 402 Payment Required
+// for plan limits, but may also describe them in the body of other errors.
+func isMailgunQuotaError(status int, message string) bool {
+	if status == http.StatusPaymentRequired {
+		return true
+	}
+
+	if status < 400 {
+		return false
+	}
+
+	message = strings.ToLower(message)
+	return strings.Contains(message, "quota") ||
+		(strings.Contains(message, "limit") && strings.Contains(message, "exceeded"))
+}
+
+// isRetriableError determines whether an error returned by an API might
+// succeed if tried against another backend. Context cancellation and client
+// errors (4xx other than 429) are assumed to fail the same way everywhere.
+func isRetriableError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var unexpectedErr *mailgun.UnexpectedResponseError
+	if errors.As(err, &unexpectedErr) {
+		if unexpectedErr.Actual >= 400 && unexpectedErr.Actual < 500 &&
+			unexpectedErr.Actual != http.StatusTooManyRequests {
+			return false
+		}
+	}
+
+	return true
+}
+
+// mailgunMemberVars produces the vars sent to Mailgun for a new member by
+// merging the given vars over a set of defaults.
+func mailgunMemberVars(now time.Time, vars map[string]interface{}) map[string]interface{} {
+	memberVars := map[string]interface{}{
+		"passages-signup":           true,
+		"passages-signup-timestamp": now.UTC().Format("2006-01-02T15:04:05-0700"),
+	}
+
+	for k, v := range vars {
+		memberVars[k] = v
+	}
+
+	return memberVars
+}
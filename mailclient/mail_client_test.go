@@ -1,13 +1,539 @@
 package mailclient
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/mailgun/mailgun-go/v3"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/xerrors"
+
+	"github.com/brandur/passages-signup/validation"
 )
 
+func TestBreakerClient(t *testing.T) {
+	ctx := context.Background()
+
+	const (
+		cooldown  = 1 * time.Minute
+		threshold = 3
+	)
+
+	var (
+		api    *toggleableClient
+		client *BreakerClient
+		now    time.Time
+	)
+
+	setup := func(test func(*testing.T)) func(*testing.T) {
+		return func(t *testing.T) {
+			t.Helper()
+
+			api = &toggleableClient{FakeClient: NewFakeClient()}
+			client = NewBreakerClient(api, threshold, cooldown)
+
+			now = time.Now()
+			client.timeNow = func() time.Time { return now }
+
+			test(t)
+		}
+	}
+
+	addMember := func() error {
+		return client.AddMember(ctx, "passages@example.com", "foo@example.com")
+	}
+
+	t.Run("Trips", setup(func(t *testing.T) { //nolint:thelper
+		api.err = xerrors.New("mailgun down")
+
+		for i := 0; i < threshold; i++ {
+			require.EqualError(t, addMember(), "mailgun down")
+		}
+
+		// Now tripped, so calls are short-circuited without hitting the API.
+		numCalls := api.numCalls
+		require.ErrorIs(t, addMember(), ErrUnavailable)
+		require.Equal(t, numCalls, api.numCalls)
+	}))
+
+	t.Run("SuccessResetsFailures", setup(func(t *testing.T) { //nolint:thelper
+		api.err = xerrors.New("mailgun down")
+		for i := 0; i < threshold-1; i++ {
+			require.Error(t, addMember())
+		}
+
+		api.err = nil
+		require.NoError(t, addMember())
+
+		api.err = xerrors.New("mailgun down")
+		for i := 0; i < threshold-1; i++ {
+			require.EqualError(t, addMember(), "mailgun down")
+		}
+	}))
+
+	t.Run("NonRetriableErrorsDontTrip", setup(func(t *testing.T) { //nolint:thelper
+		api.err = &mailgun.UnexpectedResponseError{Actual: http.StatusBadRequest}
+		for i := 0; i < threshold*2; i++ {
+			require.NotErrorIs(t, addMember(), ErrUnavailable)
+		}
+	}))
+
+	t.Run("Cooldown", setup(func(t *testing.T) { //nolint:thelper
+		api.err = xerrors.New("mailgun down")
+		for i := 0; i < threshold; i++ {
+			require.Error(t, addMember())
+		}
+
+		now = now.Add(cooldown - time.Second)
+		require.ErrorIs(t, addMember(), ErrUnavailable)
+
+		// After the cooldown a probe is let through, but it fails, so the
+		// breaker opens again for another cooldown.
+		now = now.Add(2 * time.Second)
+		require.EqualError(t, addMember(), "mailgun down")
+		require.ErrorIs(t, addMember(), ErrUnavailable)
+	}))
+
+	t.Run("Recovery", setup(func(t *testing.T) { //nolint:thelper
+		api.err = xerrors.New("mailgun down")
+		for i := 0; i < threshold; i++ {
+			require.Error(t, addMember())
+		}
+
+		api.err = nil
+		now = now.Add(cooldown + time.Second)
+
+		// Probe succeeds and the breaker closes.
+		require.NoError(t, addMember())
+		require.NoError(t, addMember())
+		require.Len(t, api.MembersAdded, 2)
+	}))
+}
+
+func TestConcurrencyLimitClient(t *testing.T) {
+	ctx := context.Background()
+
+	const maxConcurrent = 3
+
+	params := &SendMessageParams{
+		ContentsHTML:   "<p>Hello.</p>",
+		ContentsPlain:  "Hello.",
+		ListAddress:    "passages@example.com",
+		NewsletterName: "Passages & Glass",
+		Recipient:      "foo@example.com",
+		ReplyTo:        "passages@example.com",
+		Subject:        "Hello",
+	}
+
+	t.Run("LimitsConcurrentCalls", func(t *testing.T) {
+		api := newBlockingClient()
+		client := NewConcurrencyLimitClient(api, maxConcurrent)
+
+		const numCalls = 10
+
+		var wg sync.WaitGroup
+		errs := make(chan error, numCalls)
+		for i := 0; i < numCalls; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if i%2 == 0 {
+					errs <- client.SendMessage(ctx, params)
+				} else {
+					errs <- client.AddMember(ctx, "passages@example.com", "foo@example.com")
+				}
+			}(i)
+		}
+
+		// Calls up to the limit get through while the rest wait.
+		require.Eventually(t, func() bool { return api.inFlight.Load() == maxConcurrent },
+			time.Second, time.Millisecond)
+		time.Sleep(10 * time.Millisecond)
+		require.Equal(t, int64(maxConcurrent), api.inFlight.Load())
+
+		close(api.release)
+		wg.Wait()
+
+		close(errs)
+		for err := range errs {
+			require.NoError(t, err)
+		}
+
+		require.Equal(t, int64(numCalls), api.numCalls.Load())
+		require.Equal(t, int64(maxConcurrent), api.maxInFlight.Load())
+	})
+
+	t.Run("ContextDoneWhileWaiting", func(t *testing.T) {
+		api := newBlockingClient()
+		client := NewConcurrencyLimitClient(api, 1)
+
+		done := make(chan error)
+		go func() {
+			done <- client.SendMessage(ctx, params)
+		}()
+		require.Eventually(t, func() bool { return api.inFlight.Load() == 1 },
+			time.Second, time.Millisecond)
+
+		timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+
+		err := client.SendMessage(timeoutCtx, params)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+
+		close(api.release)
+		require.NoError(t, <-done)
+
+		require.Equal(t, int64(1), api.numCalls.Load())
+	})
+
+	t.Run("PassesThroughErrors", func(t *testing.T) {
+		client := NewConcurrencyLimitClient(&failingClient{err: xerrors.New("mail down")}, maxConcurrent)
+
+		require.EqualError(t, client.SendMessage(ctx, params), "mail down")
+
+		// The slot is released after an error.
+		for i := 0; i < maxConcurrent+1; i++ {
+			_, err := client.GetMember(ctx, "passages@example.com", "foo@example.com")
+			require.EqualError(t, err, "mail down")
+		}
+	})
+}
+
+func TestFailoverClient(t *testing.T) {
+	ctx := context.Background()
+
+	params := &SendMessageParams{
+		ContentsHTML:   "<p>Hello.</p>",
+		ContentsPlain:  "Hello.",
+		ListAddress:    "passages@example.com",
+		NewsletterName: "Passages & Glass",
+		Recipient:      "foo@example.com",
+		ReplyTo:        "passages@example.com",
+		Subject:        "Hello",
+	}
+
+	t.Run("PrimarySucceeds", func(t *testing.T) {
+		primary, secondary := NewFakeClient(), NewFakeClient()
+		client := NewFailoverClient(primary, secondary)
+
+		require.NoError(t, client.SendMessage(ctx, params))
+
+		require.Len(t, primary.MessagesSent, 1)
+		require.Empty(t, secondary.MessagesSent)
+		require.Equal(t, 1, client.HandledCount(FailoverBackendPrimary))
+		require.Equal(t, 0, client.HandledCount(FailoverBackendSecondary))
+	})
+
+	t.Run("PrimaryFailsSecondarySucceeds", func(t *testing.T) {
+		secondary := NewFakeClient()
+		client := NewFailoverClient(&failingClient{err: xerrors.New("primary down")}, secondary)
+
+		require.NoError(t, client.SendMessage(ctx, params))
+		require.NoError(t, client.AddMember(ctx, "passages@example.com", "foo@example.com"))
+
+		require.Len(t, secondary.MessagesSent, 1)
+		require.Len(t, secondary.MembersAdded, 1)
+		require.Equal(t, 0, client.HandledCount(FailoverBackendPrimary))
+		require.Equal(t, 2, client.HandledCount(FailoverBackendSecondary))
+	})
+
+	t.Run("BothFail", func(t *testing.T) {
+		client := NewFailoverClient(
+			&failingClient{err: xerrors.New("primary down")},
+			&failingClient{err: xerrors.New("secondary down")},
+		)
+
+		err := client.SendMessage(ctx, params)
+		require.EqualError(t, err,
+			"error on both primary and secondary backends (primary: primary down): secondary down")
+		require.Equal(t, 0, client.HandledCount(FailoverBackendPrimary))
+		require.Equal(t, 0, client.HandledCount(FailoverBackendSecondary))
+	})
+
+	t.Run("PrimaryFailsNotRetriable", func(t *testing.T) {
+		secondary := NewFakeClient()
+		client := NewFailoverClient(&failingClient{
+			err: &mailgun.UnexpectedResponseError{Actual: http.StatusBadRequest},
+		}, secondary)
+
+		require.Error(t, client.SendMessage(ctx, params))
+		require.Empty(t, secondary.MessagesSent)
+	})
+}
+
+func TestFakeClientAddMemberWithVars(t *testing.T) {
+	ctx := context.Background()
+	client := NewFakeClient()
+
+	require.NoError(t, client.AddMember(ctx, "passages@example.com", "foo@example.com"))
+	require.NoError(t, client.AddMemberWithVars(ctx, "passages@example.com", "bar@example.com",
+		map[string]interface{}{"source": "brandur.org"}))
+
+	require.Len(t, client.MembersAdded, 2)
+	require.Nil(t, client.MembersAdded[0].Vars)
+	require.Equal(t, map[string]interface{}{"source": "brandur.org"}, client.MembersAdded[1].Vars)
+}
+
+func TestFakeClientGetMember(t *testing.T) {
+	ctx := context.Background()
+	client := NewFakeClient()
+
+	exists, err := client.GetMember(ctx, "passages@example.com", "foo@example.com")
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	require.NoError(t, client.AddMember(ctx, "passages@example.com", "foo@example.com"))
+
+	exists, err = client.GetMember(ctx, "passages@example.com", "foo@example.com")
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	// Membership is per list.
+	exists, err = client.GetMember(ctx, "nanoglyph@example.com", "foo@example.com")
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	t.Run("NumAddsToDrop", func(t *testing.T) {
+		client := NewFakeClient()
+		client.NumAddsToDrop = 1
+
+		// The first add is recorded, but the email doesn't become a member.
+		require.NoError(t, client.AddMember(ctx, "passages@example.com", "foo@example.com"))
+		require.Len(t, client.MembersAdded, 1)
+		require.Zero(t, client.NumAddsToDrop)
+
+		exists, err := client.GetMember(ctx, "passages@example.com", "foo@example.com")
+		require.NoError(t, err)
+		require.False(t, exists)
+
+		require.NoError(t, client.AddMember(ctx, "passages@example.com", "foo@example.com"))
+
+		exists, err = client.GetMember(ctx, "passages@example.com", "foo@example.com")
+		require.NoError(t, err)
+		require.True(t, exists)
+	})
+}
+
+func TestFakeClientListMembers(t *testing.T) {
+	ctx := context.Background()
+
+	// Lists every page of members, checking that each is no bigger than the
+	// client's page size.
+	listAll := func(t *testing.T, client *FakeClient, list string) []*Member {
+		t.Helper()
+
+		var members []*Member
+		var cursor string
+		for {
+			page, nextCursor, err := client.ListMembers(ctx, list, cursor)
+			require.NoError(t, err)
+			require.LessOrEqual(t, len(page), client.ListMembersPageSize)
+			members = append(members, page...)
+
+			if nextCursor == "" {
+				return members
+			}
+			cursor = nextCursor
+		}
+	}
+
+	t.Run("Pages", func(t *testing.T) {
+		client := NewFakeClient()
+		client.ListMembersPageSize = 2
+
+		for i := 0; i < 5; i++ {
+			require.NoError(t, client.AddMember(ctx, "passages@example.com", fmt.Sprintf("foo%d@example.com", i)))
+		}
+
+		page, cursor, err := client.ListMembers(ctx, "passages@example.com", "")
+		require.NoError(t, err)
+		require.Equal(t, []*Member{
+			{Email: "foo0@example.com", Subscribed: true},
+			{Email: "foo1@example.com", Subscribed: true},
+		}, page)
+		require.NotEmpty(t, cursor)
+
+		members := listAll(t, client, "passages@example.com")
+		require.Len(t, members, 5)
+		for i, member := range members {
+			require.Equal(t, fmt.Sprintf("foo%d@example.com", i), member.Email)
+		}
+	})
+
+	t.Run("ExactPage", func(t *testing.T) {
+		client := NewFakeClient()
+		client.ListMembersPageSize = 2
+
+		require.NoError(t, client.AddMember(ctx, "passages@example.com", "foo@example.com"))
+		require.NoError(t, client.AddMember(ctx, "passages@example.com", "bar@example.com"))
+
+		page, cursor, err := client.ListMembers(ctx, "passages@example.com", "")
+		require.NoError(t, err)
+		require.Len(t, page, 2)
+		require.Empty(t, cursor)
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		client := NewFakeClient()
+
+		page, cursor, err := client.ListMembers(ctx, "passages@example.com", "")
+		require.NoError(t, err)
+		require.Empty(t, page)
+		require.Empty(t, cursor)
+	})
+
+	t.Run("Membership", func(t *testing.T) {
+		client := NewFakeClient()
+		client.ListMembersPageSize = 10
+
+		require.NoError(t, client.AddMember(ctx, "passages@example.com", "foo@example.com"))
+		require.NoError(t, client.AddMember(ctx, "passages@example.com", "bar@example.com"))
+		require.NoError(t, client.UnsubscribeMember(ctx, "passages@example.com", "bar@example.com"))
+
+		// Adding again doesn't list a member twice.
+		require.NoError(t, client.AddMember(ctx, "passages@example.com", "foo@example.com"))
+
+		// Dropped adds never became members.
+		client.NumAddsToDrop = 1
+		require.NoError(t, client.AddMember(ctx, "passages@example.com", "dropped@example.com"))
+
+		// Membership is per list.
+		require.NoError(t, client.AddMember(ctx, "nanoglyph@example.com", "baz@example.com"))
+
+		require.Equal(t, []*Member{
+			{Email: "foo@example.com", Subscribed: true},
+			{Email: "bar@example.com", Subscribed: false},
+		}, listAll(t, client, "passages@example.com"))
+	})
+
+	t.Run("InvalidCursor", func(t *testing.T) {
+		client := NewFakeClient()
+
+		_, _, err := client.ListMembers(ctx, "passages@example.com", "not-a-cursor")
+		require.Error(t, err)
+	})
+}
+
+func TestFakeClientUnsubscribeMember(t *testing.T) {
+	ctx := context.Background()
+	client := NewFakeClient()
+
+	require.NoError(t, client.AddMember(ctx, "passages@example.com", "foo@example.com"))
+	require.NoError(t, client.UnsubscribeMember(ctx, "passages@example.com", "foo@example.com"))
+	require.Equal(t, []*FakeClientAPIMember{{"passages@example.com", "foo@example.com"}},
+		client.MembersUnsubscribed)
+
+	exists, err := client.GetMember(ctx, "passages@example.com", "foo@example.com")
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	// Unsubscribing an email that isn't a member isn't an error.
+	require.NoError(t, client.UnsubscribeMember(ctx, "passages@example.com", "bar@example.com"))
+}
+
+func TestFakeClientSendMessage(t *testing.T) {
+	ctx := context.Background()
+
+	params := SendMessageParams{
+		ContentsHTML:   "<p>Hello</p>",
+		ContentsPlain:  "Hello",
+		ListAddress:    "passages@example.com",
+		NewsletterName: "Passages & Glass",
+		Recipient:      "foo@example.com",
+		ReplyTo:        "brandur@example.com",
+		Subject:        "Hello",
+	}
+
+	t.Run("ReplyTo", func(t *testing.T) {
+		client := NewFakeClient()
+		require.NoError(t, client.SendMessage(ctx, &params))
+		require.Len(t, client.MessagesSent, 1)
+		require.Equal(t, "brandur@example.com", client.MessagesSent[0].ReplyTo)
+	})
+
+	t.Run("NoReplyTo", func(t *testing.T) {
+		params := params
+		params.ReplyTo = ""
+
+		client := NewFakeClient()
+		require.NoError(t, client.SendMessage(ctx, &params))
+		require.Len(t, client.MessagesSent, 1)
+		require.Empty(t, client.MessagesSent[0].ReplyTo)
+	})
+
+	t.Run("FromAddress", func(t *testing.T) {
+		params := params
+		params.FromAddress = "brandur@example.com"
+
+		client := NewFakeClient()
+		require.NoError(t, client.SendMessage(ctx, &params))
+		require.Len(t, client.MessagesSent, 1)
+		require.Equal(t, "brandur@example.com", client.MessagesSent[0].FromAddress)
+	})
+
+	t.Run("NoFromAddress", func(t *testing.T) {
+		client := NewFakeClient()
+		require.NoError(t, client.SendMessage(ctx, &params))
+		require.Len(t, client.MessagesSent, 1)
+		require.Equal(t, "passages@example.com", client.MessagesSent[0].FromAddress)
+	})
+
+	t.Run("Attachments", func(t *testing.T) {
+		params := params
+		params.Attachments = []*Attachment{
+			{Content: []byte("BEGIN:VCALENDAR"), Name: "invite.ics"},
+		}
+
+		client := NewFakeClient()
+		require.NoError(t, client.SendMessage(ctx, &params))
+		require.Len(t, client.MessagesSent, 1)
+		require.Equal(t, params.Attachments, client.MessagesSent[0].Attachments)
+	})
+
+	t.Run("AttachmentMissingName", func(t *testing.T) {
+		params := params
+		params.Attachments = []*Attachment{
+			{Content: []byte("BEGIN:VCALENDAR")},
+		}
+
+		client := NewFakeClient()
+		require.ErrorContains(t, client.SendMessage(ctx, &params), "Name is a required field")
+		require.Empty(t, client.MessagesSent)
+	})
+}
+
+func TestFakeClientSendMessageValidation(t *testing.T) {
+	ctx := context.Background()
+	client := NewFakeClient()
+
+	err := client.SendMessage(ctx, &SendMessageParams{
+		ContentsHTML:   "<p>Hello</p>",
+		ContentsPlain:  "Hello",
+		ListAddress:    "passages@example.com",
+		NewsletterName: "Passages & Glass",
+		Recipient:      "foo@example.com",
+		ReplyTo:        "brandur@example.com",
+	})
+	require.ErrorContains(t, err, "Subject is a required field")
+
+	// Send parameters are built internally, so a failure is a bug.
+	var validationErr *validation.Error
+	require.ErrorAs(t, err, &validationErr)
+	require.False(t, validationErr.UserFacing)
+
+	require.Empty(t, client.MessagesSent)
+}
+
 func TestInterpretMailgunError(t *testing.T) {
 	testCases := []struct {
 		name string
@@ -29,10 +555,343 @@ func TestInterpretMailgunError(t *testing.T) {
 			&mailgun.UnexpectedResponseError{Actual: 200, Data: []byte("")},
 			"Got unexpected status code 200 from Mailgun. Message: (empty)",
 		},
+		{
+			"QuotaPaymentRequired",
+			&mailgun.UnexpectedResponseError{Actual: 402, Data: []byte("upgrade your plan")},
+			"Got status code 402 from Mailgun. Message: upgrade your plan: mail service quota exceeded",
+		},
+		{
+			"QuotaInMessage",
+			&mailgun.UnexpectedResponseError{Actual: 400, Data: []byte("Mailing list member limit exceeded")},
+			"Got status code 400 from Mailgun. Message: Mailing list member limit exceeded: mail service quota exceeded",
+		},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			require.Equal(t, tc.want, interpretMailgunError(tc.err).Error())
 		})
 	}
+
+	t.Run("QuotaErrorIsClassified", func(t *testing.T) {
+		err := interpretMailgunError(&mailgun.UnexpectedResponseError{Actual: 402})
+		require.ErrorIs(t, err, ErrQuotaExceeded)
+
+		err = interpretMailgunError(&mailgun.UnexpectedResponseError{Actual: 500, Data: []byte("oops")})
+		require.NotErrorIs(t, err, ErrQuotaExceeded)
+	})
+}
+
+func TestMailgunClientListMembers(t *testing.T) {
+	ctx := context.Background()
+
+	// Serves a full page of members followed by a short one, keyed off the
+	// address that Mailgun pages after.
+	var requests []*http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+
+		if user, pass, _ := r.BasicAuth(); user != "api" || pass != "key-test" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var items []string
+		switch r.URL.Query().Get("address") {
+		case "":
+			for i := 0; i < listMembersPageSize; i++ {
+				items = append(items, fmt.Sprintf(`{"address": "foo%03d@example.com", "subscribed": true}`, i))
+			}
+		case fmt.Sprintf("foo%03d@example.com", listMembersPageSize-1):
+			items = append(items, `{"address": "unsubscribed@example.com", "subscribed": false}`)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		fmt.Fprintf(w, `{"items": [%s], "paging": {}}`, strings.Join(items, ","))
+	}))
+	defer server.Close()
+
+	client := NewMailgunClient("example.com", "key-test")
+	client.mg.SetAPIBase(server.URL)
+
+	members, cursor, err := client.ListMembers(ctx, "passages@example.com", "")
+	require.NoError(t, err)
+	require.Len(t, members, listMembersPageSize)
+	require.Equal(t, &Member{Email: "foo000@example.com", Subscribed: true}, members[0])
+	require.Equal(t, fmt.Sprintf("foo%03d@example.com", listMembersPageSize-1), cursor)
+
+	require.Equal(t, "/lists/passages@example.com/members/pages", requests[0].URL.Path)
+	require.Equal(t, "first", requests[0].URL.Query().Get("page"))
+
+	members, cursor, err = client.ListMembers(ctx, "passages@example.com", cursor)
+	require.NoError(t, err)
+	require.Equal(t, []*Member{{Email: "unsubscribed@example.com", Subscribed: false}}, members)
+	require.Empty(t, cursor)
+
+	require.Equal(t, "next", requests[1].URL.Query().Get("page"))
+
+	t.Run("Error", func(t *testing.T) {
+		client := NewMailgunClient("example.com", "key-wrong")
+		client.mg.SetAPIBase(server.URL)
+
+		_, _, err := client.ListMembers(ctx, "passages@example.com", "")
+		require.Error(t, err)
+	})
+}
+
+func TestMailgunClientNewMessage(t *testing.T) {
+	client := NewMailgunClient("example.com", "key-test")
+
+	params := SendMessageParams{
+		ContentsHTML:   "<p>Hello</p>",
+		ContentsPlain:  "Hello",
+		ListAddress:    "passages@example.com",
+		NewsletterName: "Passages & Glass",
+		Recipient:      "foo@example.com",
+		ReplyTo:        "brandur@example.com",
+		Subject:        "Hello",
+	}
+
+	t.Run("ReplyTo", func(t *testing.T) {
+		message, err := client.newMessage(&params)
+		require.NoError(t, err)
+		require.Equal(t, "brandur@example.com", message.GetHeaders()["Reply-To"])
+	})
+
+	t.Run("NoReplyTo", func(t *testing.T) {
+		params := params
+		params.ReplyTo = ""
+
+		message, err := client.newMessage(&params)
+		require.NoError(t, err)
+		require.NotContains(t, message.GetHeaders(), "Reply-To")
+	})
+
+	t.Run("ListHeaders", func(t *testing.T) {
+		params := params
+		params.FromAddress = "brandur@example.com"
+
+		message, err := client.newMessage(&params)
+		require.NoError(t, err)
+		require.Equal(t, "<mailto:passages@example.com>", message.GetHeaders()["List-Post"])
+		require.NotContains(t, message.GetHeaders(), "List-Unsubscribe")
+		require.NotContains(t, message.GetHeaders(), "List-Unsubscribe-Post")
+	})
+
+	t.Run("UnsubscribeURL", func(t *testing.T) {
+		params := params
+		params.UnsubscribeURL = "https://passages.example.com/unsubscribe/a-token"
+
+		message, err := client.newMessage(&params)
+		require.NoError(t, err)
+		require.Equal(t, "<https://passages.example.com/unsubscribe/a-token>",
+			message.GetHeaders()["List-Unsubscribe"])
+		require.Equal(t, "List-Unsubscribe=One-Click", message.GetHeaders()["List-Unsubscribe-Post"])
+	})
+}
+
+func TestMailgunClientSendMessage(t *testing.T) {
+	ctx := context.Background()
+
+	var (
+		files map[string][]*multipart.FileHeader
+		form  url.Values
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseMultipartForm(1 << 20)
+		files = r.MultipartForm.File
+		form = r.Form
+		fmt.Fprint(w, `{"id": "<message-id@example.com>", "message": "Queued. Thank you."}`)
+	}))
+	defer server.Close()
+
+	client := NewMailgunClient("example.com", "key-test")
+	client.mg.SetAPIBase(server.URL + "/v3")
+
+	params := SendMessageParams{
+		ContentsHTML:   "<p>Hello</p>",
+		ContentsPlain:  "Hello",
+		ListAddress:    "passages@example.com",
+		NewsletterName: "Passages & Glass",
+		Recipient:      "foo@example.com",
+		Subject:        "Hello",
+	}
+
+	t.Run("FromAddress", func(t *testing.T) {
+		params := params
+		params.FromAddress = "brandur@example.com"
+
+		require.NoError(t, client.SendMessage(ctx, &params))
+		require.Equal(t, "Passages & Glass <brandur@example.com>", form.Get("from"))
+		require.Equal(t, "<mailto:passages@example.com>", form.Get("h:List-Post"))
+		require.Empty(t, form.Get("h:List-Unsubscribe"))
+	})
+
+	t.Run("NoFromAddress", func(t *testing.T) {
+		require.NoError(t, client.SendMessage(ctx, &params))
+		require.Equal(t, "Passages & Glass <passages@example.com>", form.Get("from"))
+		require.Equal(t, "<mailto:passages@example.com>", form.Get("h:List-Post"))
+		require.Empty(t, files["attachment"])
+	})
+
+	t.Run("Attachments", func(t *testing.T) {
+		params := params
+		params.Attachments = []*Attachment{
+			{Content: []byte("BEGIN:VCALENDAR"), Name: "invite.ics"},
+			{Content: []byte("%PDF-1.7"), Name: "welcome.pdf"},
+		}
+
+		require.NoError(t, client.SendMessage(ctx, &params))
+		require.Len(t, files["attachment"], 2)
+
+		for i, attachment := range params.Attachments {
+			header := files["attachment"][i]
+			require.Equal(t, attachment.Name, header.Filename)
+
+			file, err := header.Open()
+			require.NoError(t, err)
+			content, err := io.ReadAll(file)
+			require.NoError(t, err)
+			require.NoError(t, file.Close())
+			require.Equal(t, attachment.Content, content)
+		}
+	})
+}
+
+func TestMailgunMemberVars(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	t.Run("Defaults", func(t *testing.T) {
+		require.Equal(t, map[string]interface{}{
+			"passages-signup":           true,
+			"passages-signup-timestamp": "2020-01-02T03:04:05+0000",
+		}, mailgunMemberVars(now, nil))
+	})
+
+	t.Run("CustomVars", func(t *testing.T) {
+		require.Equal(t, map[string]interface{}{
+			"campaign":                  "spring",
+			"newsletter-id":             "passages",
+			"passages-signup":           true,
+			"passages-signup-timestamp": "2020-01-02T03:04:05+0000",
+		}, mailgunMemberVars(now, map[string]interface{}{
+			"campaign":      "spring",
+			"newsletter-id": "passages",
+		}))
+	})
+
+	t.Run("OverridesDefaults", func(t *testing.T) {
+		require.Equal(t, map[string]interface{}{
+			"passages-signup":           false,
+			"passages-signup-timestamp": "2020-01-02T03:04:05+0000",
+		}, mailgunMemberVars(now, map[string]interface{}{
+			"passages-signup": false,
+		}))
+	})
+}
+
+// toggleableClient is a FakeClient that fails all calls with err while it's
+// set, and counts calls made.
+type toggleableClient struct {
+	*FakeClient
+	err      error
+	numCalls int
+}
+
+func (a *toggleableClient) AddMember(ctx context.Context, list, email string) error {
+	a.numCalls++
+	if a.err != nil {
+		return a.err
+	}
+	return a.FakeClient.AddMember(ctx, list, email)
+}
+
+// blockingClient is an API whose calls block until release is closed. It
+// counts calls and the maximum number that were in flight at once.
+type blockingClient struct {
+	release chan struct{}
+
+	inFlight    atomic.Int64
+	maxInFlight atomic.Int64
+	numCalls    atomic.Int64
+}
+
+func newBlockingClient() *blockingClient {
+	return &blockingClient{release: make(chan struct{})}
+}
+
+func (a *blockingClient) AddMember(_ context.Context, _, _ string) error {
+	a.call()
+	return nil
+}
+
+func (a *blockingClient) AddMemberWithVars(_ context.Context, _, _ string, _ map[string]interface{}) error {
+	a.call()
+	return nil
+}
+
+func (a *blockingClient) GetMember(_ context.Context, _, _ string) (bool, error) {
+	a.call()
+	return true, nil
+}
+
+func (a *blockingClient) ListMembers(_ context.Context, _, _ string) ([]*Member, string, error) {
+	a.call()
+	return nil, "", nil
+}
+
+func (a *blockingClient) SendMessage(_ context.Context, _ *SendMessageParams) error {
+	a.call()
+	return nil
+}
+
+func (a *blockingClient) UnsubscribeMember(_ context.Context, _, _ string) error {
+	a.call()
+	return nil
+}
+
+func (a *blockingClient) call() {
+	a.numCalls.Add(1)
+
+	inFlight := a.inFlight.Add(1)
+	defer a.inFlight.Add(-1)
+
+	for {
+		maxInFlight := a.maxInFlight.Load()
+		if inFlight <= maxInFlight || a.maxInFlight.CompareAndSwap(maxInFlight, inFlight) {
+			break
+		}
+	}
+
+	<-a.release
+}
+
+// failingClient is an API that fails every call with the same error.
+type failingClient struct {
+	err error
+}
+
+func (a *failingClient) AddMember(_ context.Context, _, _ string) error {
+	return a.err
+}
+
+func (a *failingClient) AddMemberWithVars(_ context.Context, _, _ string, _ map[string]interface{}) error {
+	return a.err
+}
+
+func (a *failingClient) GetMember(_ context.Context, _, _ string) (bool, error) {
+	return false, a.err
+}
+
+func (a *failingClient) ListMembers(_ context.Context, _, _ string) ([]*Member, string, error) {
+	return nil, "", a.err
+}
+
+func (a *failingClient) SendMessage(_ context.Context, _ *SendMessageParams) error {
+	return a.err
+}
+
+func (a *failingClient) UnsubscribeMember(_ context.Context, _, _ string) error {
+	return a.err
 }
@@ -0,0 +1,83 @@
+package mailclient
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// WebhookPayload is the JSON body that Mailgun posts to a webhook.
+type WebhookPayload struct {
+	EventData WebhookEventData `json:"event-data"`
+	Signature WebhookSignature `json:"signature"`
+}
+
+// WebhookEventData is the subset of a webhook's event data that we care
+// about.
+type WebhookEventData struct {
+	// Event is the type of event like `delivered` or `failed`.
+	Event string `json:"event"`
+
+	// Recipient is the email address that the event pertains to.
+	Recipient string `json:"recipient"`
+
+	// Severity distinguishes between `temporary` and `permanent` failures
+	// for `failed` events.
+	Severity string `json:"severity"`
+}
+
+// IsComplaint returns true if the event indicates that the recipient marked
+// a message as spam.
+func (e *WebhookEventData) IsComplaint() bool {
+	return e.Event == "complained"
+}
+
+// IsPermanentFailure returns true if the event indicates that mail to the
+// recipient hard bounced and further sends are pointless.
+//
+// Recognizes both the modern `failed` event with `permanent` severity and the
+// names used by Mailgun's legacy webhooks.
+func (e *WebhookEventData) IsPermanentFailure() bool {
+	switch e.Event {
+	case "bounced", "permanent_fail":
+		return true
+	case "failed":
+		return e.Severity == "permanent"
+	}
+	return false
+}
+
+// WebhookSignature is the signature included with a webhook payload, which
+// can be used to verify that it originated from Mailgun.
+type WebhookSignature struct {
+	Signature string `json:"signature"`
+	Timestamp string `json:"timestamp"`
+	Token     string `json:"token"`
+}
+
+// VerifyWebhookSignature checks that the given signature is a valid
+// HMAC-SHA256 of its timestamp and token under signingKey (Mailgun's "HTTP
+// webhook signing key").
+func VerifyWebhookSignature(signingKey string, sig *WebhookSignature) bool {
+	if signingKey == "" {
+		return false
+	}
+
+	signature, err := hex.DecodeString(sig.Signature)
+	if err != nil {
+		return false
+	}
+
+	return hmac.Equal(signature, computeWebhookSignature(signingKey, sig.Timestamp, sig.Token))
+}
+
+//
+// Private functions
+//
+
+func computeWebhookSignature(signingKey, timestamp, token string) []byte {
+	h := hmac.New(sha256.New, []byte(signingKey))
+	h.Write([]byte(timestamp))
+	h.Write([]byte(token))
+	return h.Sum(nil)
+}
@@ -0,0 +1,70 @@
+package mailclient
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	const signingKey = "key-123"
+
+	validSignature := func() *WebhookSignature {
+		return &WebhookSignature{
+			Signature: hex.EncodeToString(computeWebhookSignature(signingKey, "1700000000", "abc")),
+			Timestamp: "1700000000",
+			Token:     "abc",
+		}
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		require.True(t, VerifyWebhookSignature(signingKey, validSignature()))
+	})
+
+	t.Run("EmptySigningKey", func(t *testing.T) {
+		require.False(t, VerifyWebhookSignature("", validSignature()))
+	})
+
+	t.Run("WrongSigningKey", func(t *testing.T) {
+		require.False(t, VerifyWebhookSignature("key-other", validSignature()))
+	})
+
+	t.Run("TamperedToken", func(t *testing.T) {
+		sig := validSignature()
+		sig.Token = "abd"
+		require.False(t, VerifyWebhookSignature(signingKey, sig))
+	})
+
+	t.Run("NotHex", func(t *testing.T) {
+		sig := validSignature()
+		sig.Signature = "not-hex"
+		require.False(t, VerifyWebhookSignature(signingKey, sig))
+	})
+}
+
+func TestWebhookEventDataIsComplaint(t *testing.T) {
+	require.True(t, (&WebhookEventData{Event: "complained"}).IsComplaint())
+	require.False(t, (&WebhookEventData{Event: "unsubscribed"}).IsComplaint())
+}
+
+func TestWebhookEventDataIsPermanentFailure(t *testing.T) {
+	testCases := []struct {
+		event    string
+		severity string
+		want     bool
+	}{
+		{"bounced", "", true},
+		{"delivered", "", false},
+		{"failed", "permanent", true},
+		{"failed", "temporary", false},
+		{"permanent_fail", "", true},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.event+"_"+tc.severity, func(t *testing.T) {
+			data := &WebhookEventData{Event: tc.event, Severity: tc.severity}
+			require.Equal(t, tc.want, data.IsPermanentFailure())
+		})
+	}
+}
@@ -1,16 +1,33 @@
 package main
 
 import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
 	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"io/fs"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"reflect"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
-	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	"github.com/jackc/pgx/v4"
@@ -24,40 +41,322 @@ import (
 	"github.com/brandur/csrf"
 	"github.com/brandur/passages-signup/command"
 	"github.com/brandur/passages-signup/db"
+	"github.com/brandur/passages-signup/diagnostics"
+	"github.com/brandur/passages-signup/emailvalidator"
+	"github.com/brandur/passages-signup/formnonce"
 	"github.com/brandur/passages-signup/mailclient"
+	"github.com/brandur/passages-signup/messagecatalog"
 	"github.com/brandur/passages-signup/middleware"
 	"github.com/brandur/passages-signup/newslettermeta"
 	"github.com/brandur/passages-signup/ptemplate"
+	"github.com/brandur/passages-signup/session"
+	"github.com/brandur/passages-signup/signedtoken"
+	"github.com/brandur/passages-signup/validation"
 )
 
 const (
 	envProduction = "production"
 	envTesting    = "testing"
 
-	mailDomain     = "list.brandur.org"
-	replyToAddress = "brandur@brandur.org"
+	redactedValue         = "[redacted]"
+	defaultReplyToAddress = "brandur@brandur.org"
+
+	// Parameters for the circuit breaker around the mail service. After this
+	// many consecutive failures, calls are short-circuited for the cooldown.
+	mailBreakerCooldown  = 1 * time.Minute
+	mailBreakerThreshold = 5
+
+	// mailgunWebhookMaxBodyBytes is the maximum size of a Mailgun webhook
+	// body. Event data includes message headers, so these are considerably
+	// larger than any form submission.
+	mailgunWebhookMaxBodyBytes = 1024 * 1024
+
+	// Parameters for the cache of recently confirmed emails used to skip
+	// database work for users who resubmit the form after signing up.
+	confirmedEmailCacheSize = 10000
+	confirmedEmailCacheTTL  = 1 * time.Hour
+
+	// Parameters for the worker that retries list adds deferred because the
+	// mail service was over quota. Each tick retries at most the batch size.
+	deferredMemberAddBatchSize    = 100
+	deferredMemberAddPollInterval = 5 * time.Minute
+
+	// Parameters for the worker that sends delayed confirmations from the
+	// outbox. Each tick sends at most the batch size of due messages.
+	outboxBatchSize    = 100
+	outboxPollInterval = 5 * time.Second
+
+	// subscriberExportPageSize is the number of subscribers read in each
+	// transaction of an export.
+	subscriberExportPageSize = 1000
+
+	// shutdownTimeout is how long in-flight requests are given to finish
+	// when the server is shutting down.
+	shutdownTimeout = 10 * time.Second
+
+	// logFieldRequestID is the structured log field carrying the ID assigned
+	// to a request by middleware.RequestIDMiddleware.
+	logFieldRequestID = "request_id"
+
+	// minSigningSecretLength is the minimum length of SigningSecret. A shorter
+	// secret would weaken HMAC-SHA256 keys derived from it.
+	minSigningSecretLength = 32
 )
 
-var validate = validator.New()
+// Roles identifying the kind of process that the server is running in. Each
+// entry point sets one on Conf.Role.
+const (
+	roleBulkResend  = "bulk-resend"
+	roleDiagnostics = "diagnostics"
+	roleExport      = "export"
+	roleReconcile   = "reconcile"
+	roleWeb         = "web"
+)
+
+// Names of the server's rate limiters. Each limiter's keys are prefixed with
+// its name and newsletter so that quotas stay independent, even if their
+// limiters share a store.
+const (
+	rateLimiterConfirm  = "confirm"
+	rateLimiterGlobal   = "global"
+	rateLimiterValidate = "validate"
+)
+
+// Purposes that keys are derived for by secretProvider. Each feature gets its
+// own key so that a value signed by one can't be passed off to another.
+const (
+	secretPurposeConfirmationToken = "confirmation-token"
+	secretPurposeFormNonce         = "form-nonce"
+	secretPurposeSession           = "session"
+)
+
+var validate = validation.New()
+
+// errRequestCancelled is returned from a transaction to roll it back when a
+// mediator abandoned its work because the request's context was cancelled.
+var errRequestCancelled = errors.New("request cancelled")
+
+var (
+	// globalRateQuota is the rate limit applied to all requests by source IP,
+	// unless overridden for the newsletter in Conf.RateLimitQuotas.
+	globalRateQuota = throttled.RateQuota{
+		MaxBurst: 20,
+		MaxRate:  throttled.PerSec(5),
+	}
+
+	// rateLimitHeaders are the response headers in which rate limiters report
+	// a client's limit, remaining quota, and the seconds until it's fully
+	// replenished so that clients can throttle themselves.
+	rateLimitHeaders = []string{"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset"}
+
+	// redirectExemptPaths are paths that are never redirected to HTTPS so that
+	// metrics scrapers can reach them over plain HTTP. Only routes that exist
+	// should be listed.
+	redirectExemptPaths = []string{"/metrics"}
+
+	// validateRateQuota is a tight rate limit applied to the validate route by
+	// source IP so that it can't be used as an oracle for checking whether
+	// large numbers of emails are deliverable.
+	validateRateQuota = throttled.RateQuota{
+		MaxBurst: 10,
+		MaxRate:  throttled.PerMin(10),
+	}
+
+	// confirmRateQuota is a tighter rate limit applied to the confirm route by
+	// source IP to make guessing tokens impractical. Tokens are UUIDs so this
+	// is only defense in depth, but no legitimate user needs to confirm more
+	// than a few times a minute.
+	confirmRateQuota = throttled.RateQuota{
+		MaxBurst: 5,
+		MaxRate:  throttled.PerMin(10),
+	}
+
+	// schemaColumns are the tables and columns that the app expects to exist,
+	// checked on startup to make sure that migrations have been applied.
+	// Should be kept up to date as migrations are added.
+	schemaColumns = map[string][]string{
+		"admin_audit":    {"action", "admin_token_id", "created_at", "id", "target"},
+		"outbox_message": {"created_at", "id", "num_attempts", "params", "send_after", "sent_at"},
+		"outbox_webhook": {"created_at", "email", "event_type", "id", "num_attempts", "send_after", "sent_at"},
+		"signup": {
+			"bounced_at", "completed_at", "created_at", "email", "id", "last_sent_at",
+			"member_add_deferred_at", "member_added_at", "num_attempts", "token",
+			"unsubscribed_at", "utm_campaign", "utm_medium", "utm_source",
+		},
+		"suppression": {"created_at", "email", "reason"},
+	}
+
+	// templatePreviews are the templates rendered by the admin templates
+	// endpoint along with sample data to fill them in. Should be kept up to
+	// date as views and messages are added.
+	templatePreviews = []templatePreview{
+		{"views/error", map[string]interface{}{"error": "sample error message"}, false},
+		{"views/form", map[string]interface{}{"emailField": "email"}, true},
+		{"views/maintenance", map[string]interface{}{}, false},
+		{"views/messages/confirm", map[string]interface{}{"token": "sample-token"}, false},
+		{"views/messages/confirm_plain", map[string]interface{}{"token": "sample-token"}, false},
+		{"views/messages/welcome", map[string]interface{}{}, false},
+		{"views/messages/welcome_plain", map[string]interface{}{}, false},
+		{"views/ok", map[string]interface{}{
+			"message": "<p>Thank you for signing up!</p><p>I've sent a confirmation email to <strong>sample@example.com</strong>.</p>",
+		}, false},
+		{"views/show", map[string]interface{}{}, false},
+		{"views/unsubscribe", map[string]interface{}{"email": "sample@example.com", "token": "sample-token"}, false},
+		{"views/unsubscribed", map[string]interface{}{"email": "sample@example.com"}, false},
+	}
+)
 
 // Conf contains configuration information for the command. It's extracted from
 // environment variables.
 type Conf struct {
+	// AdminToken is a secret token that gates administrative endpoints. It
+	// should be sent as a bearer token in the `Authorization` header. Admin
+	// endpoints are disabled if it's not set.
+	AdminToken string `env:"ADMIN_TOKEN" redact:"true" validate:"-"`
+
+	// BlockedEmails are email addresses that aren't allowed to sign up, for
+	// blocking abusive addresses. An entry like `@spam.example` blocks a whole
+	// domain. Separate multiple entries with commas.
+	BlockedEmails emailList `env:"BLOCKED_EMAILS" validate:"-"`
+
+	// CheckEmailMX rejects signups for emails whose domains have no mail
+	// servers, as determined by an MX lookup. Lookups that fail for other
+	// reasons let the email through.
+	CheckEmailMX bool `env:"CHECK_EMAIL_MX" validate:"-"`
+
+	// ConfirmLinkBaseURL is the base URL used to build confirmation links in
+	// emails, which allows them to use a different (e.g. shorter) domain than
+	// PublicURL. Falls back to PublicURL if not set.
+	ConfirmLinkBaseURL string `env:"CONFIRM_LINK_BASE_URL" validate:"omitempty,url"`
+
+	// ConfirmationAttemptsWindow is how long confirmation attempts count
+	// towards the maximum number of attempts for an email. An unconfirmed
+	// signup whose last confirmation is older than this gets a fresh set of
+	// attempts. If zero, attempts never expire.
+	ConfirmationAttemptsWindow time.Duration `env:"CONFIRMATION_ATTEMPTS_WINDOW" validate:"min=0"`
+
+	// ConfirmationDelayMax and ConfirmationDelayMin, if set, delay
+	// confirmation emails by a random duration in their range because some
+	// spam filters distrust mail that arrives instantaneously. Delayed
+	// confirmations are put in an outbox and sent by a background worker so
+	// that requests aren't blocked. Off if ConfirmationDelayMax is zero.
+	ConfirmationDelayMax time.Duration `env:"CONFIRMATION_DELAY_MAX" validate:"gtefield=ConfirmationDelayMin"`
+	ConfirmationDelayMin time.Duration `env:"CONFIRMATION_DELAY_MIN" validate:"min=0"`
+
+	// ConfirmationResendWindow is how soon after sending a confirmation that
+	// a user submitting the form again will get it resent.
+	ConfirmationResendWindow time.Duration `env:"CONFIRMATION_RESEND_WINDOW,default=24h" validate:"min=0"`
+
+	// ConfirmationTokenSecret is a deprecated secret that confirmation tokens
+	// were signed with before keys were derived from SigningSecret. If set, it
+	// enables SignConfirmationTokens and is used as the token key in place of
+	// a derived one, so that links sent before upgrading keep working. Move to
+	// SignConfirmationTokens and SigningSecret once those links have expired.
+	ConfirmationTokenSecret string `env:"CONFIRMATION_TOKEN_SECRET" redact:"true" validate:"omitempty,min=32"`
+
+	// ConfirmationTokenTTL is how long signed confirmation links are valid
+	// for. Only used if SignConfirmationTokens is set.
+	ConfirmationTokenTTL time.Duration `env:"CONFIRMATION_TOKEN_TTL,default=168h" validate:"required_if=SignConfirmationTokens true,required_with=ConfirmationTokenSecret"`
+
+	// CORSAllowedHeaders are the request headers allowed in cross-origin
+	// posts to the submit endpoint. Defaults to only `Content-Type`.
+	CORSAllowedHeaders []string `env:"CORS_ALLOWED_HEADERS" validate:"-"`
+
+	// CORSMaxAge is how long browsers may cache the result of a CORS preflight
+	// request. If zero, browsers fall back to their own (short) default.
+	CORSMaxAge time.Duration `env:"CORS_MAX_AGE,default=10m" validate:"min=0"`
+
+	// DatabaseConnectRetryTimeout is how long to keep retrying the initial
+	// database connection at startup, so that the app survives Postgres being
+	// briefly unavailable during a deploy. If zero, startup fails immediately.
+	DatabaseConnectRetryTimeout time.Duration `env:"DATABASE_CONNECT_RETRY_TIMEOUT" validate:"min=0"`
+
+	// DatabaseSearchPath is the schema search path for database connections,
+	// for hosted databases where the app can't use the `public` schema. May
+	// be a comma-separated list of schemas.
+	DatabaseSearchPath string `env:"DATABASE_SEARCH_PATH" validate:"-"`
+
 	// DatabaseTXStarter is a special value used to inject a test transaction to
 	// the server. Will be used instead of DatabaseURL if specified.
 	DatabaseTXStarter db.TXStarter `env:"-" validate:"required_without=DatabaseURL"`
 
 	// DatabaseURL is the URL to the Postgres database used to store program
 	// state.
-	DatabaseURL string `env:"DATABASE_URL,required" validate:"required_without=DatabaseTXStarter"`
+	DatabaseURL string `env:"DATABASE_URL,required" redact:"url" validate:"required_without=DatabaseTXStarter"`
+
+	// DisableReplyTo stops outgoing messages from overriding their Reply-To
+	// header so that replies go to the list address instead of a personal
+	// one.
+	DisableReplyTo bool `env:"DISABLE_REPLY_TO" validate:"-"`
+
+	// EmailCasePolicy controls how the case of submitted email addresses is
+	// normalized before they're stored or compared with existing signups. One
+	// of `preserve`, `lower-domain` (the domain is lowercased, but the local
+	// part is left alone), or `lower-all`. It's applied the same way to
+	// emails from admin endpoints and Mailgun so that they match. Defaults to
+	// `lower-domain`, the only normalization that's always correct. Signups
+	// stored before it was applied aren't rewritten, so set `preserve` to keep
+	// matching them exactly, or merge differently cased duplicates by hand.
+	EmailCasePolicy string `env:"EMAIL_CASE_POLICY,default=lower-domain" validate:"omitempty,oneof=preserve lower-domain lower-all"`
+
+	// EnablePreviewLink includes a link in confirmation emails to a web
+	// version of the message served from `/admin/preview`. Useful for
+	// debugging rendering, but off by default. Like other admin endpoints, the
+	// preview requires AdminToken.
+	EnablePreviewLink bool `env:"ENABLE_PREVIEW_LINK" validate:"-"`
 
 	// EnableRateLimiter activates rate limiting on source IP to make it more
 	// difficult for attackers to burn through resource limits. It is on by
 	// default.
 	EnableRateLimiter bool `env:"ENABLE_RATE_LIMITER,default=true" validate:"-"`
 
+	// EnableWelcomeEmail sends a welcome message to new subscribers once
+	// they've confirmed their signup.
+	EnableWelcomeEmail bool `env:"ENABLE_WELCOME_EMAIL" validate:"-"`
+
+	// ExtraListAddresses are addresses of additional Mailgun lists that
+	// confirmed signups are added to along with the newsletter's main list.
+	// Separate multiple addresses with `;`.
+	ExtraListAddresses []string `env:"EXTRA_LIST_ADDRESSES" validate:"dive,email"`
+
+	// FormEmailField is the name of the signup form's email field. It can be
+	// changed for embedding the form in systems that prefix field names.
+	FormEmailField string `env:"FORM_EMAIL_FIELD,default=email" validate:"required"`
+
+	// ForwardedProtoHeader is the name of the header in which the proxy in
+	// front of the app reports the scheme of the original request. It's used
+	// to redirect plain HTTP requests to HTTPS in production. The standard
+	// `Forwarded` header is checked as a fallback if it's absent.
+	ForwardedProtoHeader string `env:"FORWARDED_PROTO_HEADER,default=X-Forwarded-Proto" validate:"required"`
+
+	// LogLevel is the minimum level of logs that are emitted, like `debug`,
+	// `info`, or `warn`. Per-request chatter like mediators starting is
+	// logged at debug.
+	LogLevel string `env:"LOG_LEVEL,default=info" validate:"omitempty,oneof=trace debug info warn warning error fatal panic"`
+
+	// MailDomain is the domain from which mail is sent and under which
+	// Mailgun lists are addressed (e.g. `passages@list.brandur.org`). Can be
+	// changed to use a sandbox domain for staging and testing.
+	MailDomain string `env:"MAIL_DOMAIN,default=list.brandur.org" validate:"required,fqdn"`
+
+	// MailFromAddress is an optional address that outgoing messages are sent
+	// from, for deliverability setups where it should differ from the list
+	// address. Messages are sent from the list address if it's not set.
+	MailFromAddress string `env:"MAIL_FROM_ADDRESS" validate:"omitempty,email"`
+
+	// MailMaxConcurrency caps the number of calls to Mailgun that are in
+	// flight at once so that bulk operations stay under its concurrent
+	// request limit. Calls over the cap wait for a free slot. Zero means no
+	// limit.
+	MailMaxConcurrency int `env:"MAIL_MAX_CONCURRENCY" validate:"min=0"`
+
 	// MailgunAPIKey is a key for Mailgun used to send email.
-	MailgunAPIKey string `env:"MAILGUN_API_KEY,required" validate:"required"`
+	MailgunAPIKey string `env:"MAILGUN_API_KEY,required" redact:"true" validate:"required"`
+
+	// MailgunWebhookSigningKey is Mailgun's "HTTP webhook signing key", used
+	// to verify that webhook calls came from Mailgun. The webhook endpoint is
+	// disabled if it's not set.
+	MailgunWebhookSigningKey string `env:"MAILGUN_WEBHOOK_SIGNING_KEY" redact:"true" validate:"-"`
 
 	// MaintenanceMode activates "maintenance mode" in which the service will be
 	// unavailable until maintenance mode has been turned back off again. This
@@ -65,11 +364,36 @@ type Conf struct {
 	// the main database needs to be migrated to another provider.
 	MaintenanceMode bool `env:"MAINTENANCE_MODE"`
 
+	// MaintenanceTemplate is the template rendered for the body of responses
+	// in maintenance mode, like for a branded maintenance page. Defaults to
+	// `views/maintenance`.
+	MaintenanceTemplate string `env:"MAINTENANCE_TEMPLATE" validate:"-"`
+
+	// MaxBodyBytes is the maximum size of a request body that the server will
+	// read. Requests with larger bodies are rejected with a 413. The only thing
+	// we ever expect to receive is an email address, so this can be small.
+	MaxBodyBytes int64 `env:"MAX_BODY_BYTES,default=4096" validate:"required"`
+
+	// MaxEmailLength is the maximum length of an email address that will be
+	// accepted for signup. Can be lowered from the RFC 5321 limit of 254
+	// characters, but not raised.
+	MaxEmailLength int `env:"MAX_EMAIL_LENGTH,default=254" validate:"required,max=254"`
+
+	// MaxSignupsPerDay is a ceiling on the number of new signups accepted each
+	// day (UTC) across all users, which caps costs during an attack that
+	// rotates through IPs and emails. Zero means no limit.
+	MaxSignupsPerDay int `env:"MAX_SIGNUPS_PER_DAY" validate:"min=0"`
+
 	// Newsletter is the newsletter to send. Should be either `nanoglyph` or
 	// `passages` and defaults to the latter. Along with one of the available
 	// values it should also be the identifier of the list in Mailgun.
 	NewsletterID string `env:"NEWSLETTER_ID,default=passages" validate:"required"`
 
+	// OutboxPendingWarnThreshold, if set, logs a warning whenever more than
+	// this many messages are waiting in the outbox, which means that sending
+	// is falling behind. Checked on every tick of the outbox worker.
+	OutboxPendingWarnThreshold int `env:"OUTBOX_PENDING_WARN_THRESHOLD" validate:"min=0"`
+
 	// PassagesEnv determines the running environment of the app. Set to
 	// development to disable template caching and CSRF protection.
 	PassagesEnv string `env:"PASSAGES_ENV,default=production" validate:"required"`
@@ -81,12 +405,207 @@ type Conf struct {
 	// This is needed in some places to generate absolute URLs. Also used for
 	// CSRF protection.
 	PublicURL string `env:"PUBLIC_URL,default=https://passages-signup.herokuapp.com" validate:"required"`
+
+	// RateLimiterFailClosed fails requests with an error if the rate
+	// limiter's store errors. By default, such errors are logged and requests
+	// are allowed through so that a problem with the store doesn't take down
+	// signups.
+	RateLimiterFailClosed bool `env:"RATE_LIMITER_FAIL_CLOSED" validate:"-"`
+
+	// RateLimiterMaxKeys is the maximum number of keys kept by each rate
+	// limiter's memory store, after which the least recently used ones are
+	// evicted. Zero means no limit. Unused if RateLimitStoreFactory is set.
+	RateLimiterMaxKeys int `env:"RATE_LIMITER_MAX_KEYS,default=65536" validate:"min=0"`
+
+	// RateLimitQuotas overrides the rate limit applied to all requests by
+	// source IP for specific newsletters, which is useful when one gets far
+	// more traffic than another. Separate entries with `;`, each like
+	// `passages=5/s:20`: the newsletter's ID, then the sustained rate per
+	// second (`s`), minute (`m`), or hour (`h`), then the burst. Newsletters
+	// without an entry use the default quota.
+	RateLimitQuotas newsletterRateQuotas `env:"RATE_LIMIT_QUOTAS" validate:"-"`
+
+	// RateLimitStoreFactory is a special value used to inject the stores that
+	// back the server's rate limiters, like ones shared between processes.
+	// It's called once for each rate limiter with the prefix of the keys that
+	// the limiter will use, made of its name and newsletter (like
+	// `confirm:passages`). Keys arrive at the store already prefixed, so one
+	// store can safely be shared between limiters. Defaults to memory stores
+	// holding up to RateLimiterMaxKeys keys each.
+	RateLimitStoreFactory func(keyPrefix string) (RateLimitStore, error) `env:"-" validate:"-"`
+
+	// RequestIDHeaders are names of headers injected by the hosting platform
+	// (e.g. `X-Amzn-Trace-Id`) that an existing request ID is read from, in
+	// priority order. A new ID is generated if none of them are present.
+	// Separate multiple names with `;`. Only list headers that the platform's
+	// proxy overwrites, because clients can send any header they like.
+	RequestIDHeaders []string `env:"REQUEST_ID_HEADERS" validate:"-"`
+
+	// RequireConfirmation requires that new signups confirm their email by
+	// following a link sent to them before they're added to the list (double
+	// opt-in). If disabled, signups are added immediately (single opt-in),
+	// which should only be used for low risk lists.
+	RequireConfirmation bool `env:"REQUIRE_CONFIRMATION,default=true" validate:"-"`
+
+	// RequireFormNonce embeds a signed, single-use nonce in the signup form
+	// and rejects submissions whose nonce is missing or was already used,
+	// which prevents a captured submission from being replayed. Used nonces
+	// are tracked in memory, so they're per process. Requires SigningSecret.
+	RequireFormNonce bool `env:"REQUIRE_FORM_NONCE" validate:"-"`
+
+	// RequireSession issues a signed session cookie with the signup form and
+	// rejects submissions that don't carry a valid one, which means they came
+	// from a browser that loaded the form. Forms embedded on other sites won't
+	// work with this enabled. Requires SigningSecret.
+	RequireSession bool `env:"REQUIRE_SESSION" validate:"-"`
+
+	// Role identifies the kind of process that the server is running in, like
+	// `web` or `reconcile`. It's set by each entry point rather than from the
+	// environment, and is added to the name that database connections are
+	// registered under so that they can be told apart.
+	Role string `env:"-" validate:"-"`
+
+	// SessionSecret is a deprecated secret that session cookies were signed
+	// with before keys were derived from SigningSecret. If set, it enables
+	// RequireSession and is used as the session key in place of a derived
+	// one, so that existing sessions stay valid. Move to RequireSession and
+	// SigningSecret at the next convenient time.
+	SessionSecret string `env:"SESSION_SECRET" redact:"true" validate:"omitempty,min=32"`
+
+	// SignConfirmationTokens signs confirmation tokens so that confirmation
+	// links expire after ConfirmationTokenTTL without any state in the
+	// database. If not set, tokens are random and don't expire. Requires
+	// SigningSecret.
+	SignConfirmationTokens bool `env:"SIGN_CONFIRMATION_TOKENS" validate:"-"`
+
+	// SigningSecret is the secret that keys for features that sign values
+	// (see RequireFormNonce, RequireSession, and SignConfirmationTokens) are
+	// derived from. It must be at least 32 characters long if any of them are
+	// enabled. Changing it invalidates outstanding confirmation links, form
+	// nonces, and sessions.
+	SigningSecret string `env:"SIGNING_SECRET" redact:"true" validate:"-"`
+
+	// SubscriberWebhookSecret is the secret shared with the receiver of
+	// SubscriberWebhookURL that webhooks are signed with.
+	SubscriberWebhookSecret string `env:"SUBSCRIBER_WEBHOOK_SECRET" redact:"true" validate:"required_with=SubscriberWebhookURL"`
+
+	// SubscriberWebhookURL, if set, is sent a POST with a JSON payload when a
+	// subscriber confirms their signup so that an external system can be
+	// notified. Webhooks go through the outbox, so they're sent shortly after
+	// the confirmation commits and retried if they fail. The payload is signed
+	// with SubscriberWebhookSecret, and the signature sent in the
+	// `Passages-Signature` header.
+	SubscriberWebhookURL string `env:"SUBSCRIBER_WEBHOOK_URL" validate:"omitempty,url"`
+
+	// SuccessCTAText and SuccessCTAURL, if set, add a call to action like
+	// "Read the latest edition" linking to the archive to the pages shown
+	// after signing up and confirming. The call to action is hidden if
+	// SuccessCTAURL isn't set.
+	SuccessCTAText string `env:"SUCCESS_CTA_TEXT" validate:"required_with=SuccessCTAURL"`
+	SuccessCTAURL  string `env:"SUCCESS_CTA_URL" validate:"omitempty,url"`
+
+	// TrustLocalSubscriptionState skips re-sending a confirmation to an email
+	// that's completed signup and hasn't been recorded as unsubscribed. Only
+	// enable this if all unsubscribes are recorded locally, like by routing
+	// them through the app's unsubscribe link or regularly running
+	// `-reconcile`. Otherwise, users who unsubscribed through Mailgun won't be
+	// able to sign up again.
+	TrustLocalSubscriptionState bool `env:"TRUST_LOCAL_SUBSCRIPTION_STATE" validate:"-"`
+
+	// VerifyMemberAdd checks with Mailgun that a confirmed email was actually
+	// added to the list, retrying the add if it wasn't. Costs an extra API
+	// call per confirmation.
+	VerifyMemberAdd bool `env:"VERIFY_MEMBER_ADD" validate:"-"`
+}
+
+// connectConfig returns the configuration for connecting to the database.
+// Connections are registered under the app's name suffixed with Role.
+func (c *Conf) connectConfig() *db.ConnectConfig {
+	return &db.ConnectConfig{
+		ApplicationName:     "passages-signup",
+		ConnectRetryTimeout: c.DatabaseConnectRetryTimeout,
+		DatabaseURL:         c.DatabaseURL,
+		Role:                c.Role,
+		SearchPath:          c.DatabaseSearchPath,
+	}
 }
 
 func (c *Conf) isProduction() bool {
 	return c.PassagesEnv == envProduction
 }
 
+// rateQuota returns the rate limit applied to all requests by source IP, which
+// is the configured newsletter's entry in RateLimitQuotas if it has one.
+func (c *Conf) rateQuota() throttled.RateQuota {
+	if quota, ok := c.RateLimitQuotas[c.NewsletterID]; ok {
+		return quota
+	}
+	return globalRateQuota
+}
+
+// replyToAddress returns the address that replies to outgoing messages should
+// go to, or an empty string if the Reply-To header shouldn't be overridden.
+func (c *Conf) replyToAddress() string {
+	if c.DisableReplyTo {
+		return ""
+	}
+	return defaultReplyToAddress
+}
+
+// requireSession returns whether signup submissions need a valid session
+// cookie, either because RequireSession is set or because the deprecated
+// SessionSecret is.
+func (c *Conf) requireSession() bool {
+	return c.RequireSession || c.SessionSecret != ""
+}
+
+// signConfirmationTokens returns whether confirmation tokens are signed, either
+// because SignConfirmationTokens is set or because the deprecated
+// ConfirmationTokenSecret is.
+func (c *Conf) signConfirmationTokens() bool {
+	return c.SignConfirmationTokens || c.ConfirmationTokenSecret != ""
+}
+
+// Redacted produces a loggable representation of the configuration with any
+// secrets masked. Fields tagged with `redact:"true"` are masked completely,
+// and those tagged with `redact:"url"` have only their password masked.
+func (c *Conf) Redacted() string {
+	val := reflect.ValueOf(c).Elem()
+	typ := val.Type()
+
+	parts := make([]string, 0, typ.NumField())
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+
+		// Skip values that aren't part of the environment like an injected
+		// test transaction.
+		if field.Tag.Get("env") == "-" {
+			continue
+		}
+
+		value := fmt.Sprintf("%v", val.Field(i).Interface())
+
+		switch field.Tag.Get("redact") {
+		case "true":
+			if value != "" {
+				value = redactedValue
+			}
+		case "url":
+			value = redactURL(value)
+		}
+
+		parts = append(parts, field.Name+"="+value)
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// String implements fmt.Stringer so that the configuration is always redacted
+// when printed.
+func (c *Conf) String() string {
+	return c.Redacted()
+}
+
 var (
 	//go:embed public/*
 	embeddedAssets embed.FS
@@ -95,148 +614,720 @@ var (
 	embeddedTemplates embed.FS
 )
 
+// BulkResendResult holds the results of a bulk resend of confirmation emails.
+type BulkResendResult struct {
+	NumFailed int
+	NumResent int
+
+	// NumSuppressed is the number of signups skipped because their emails
+	// are on the suppression list.
+	NumSuppressed int
+}
+
+// ExportSubscribersResult holds the results of exporting subscribers.
+type ExportSubscribersResult struct {
+	NumExported int64
+}
+
+// ReconcileMailgunResult holds the results of reconciling local subscription
+// state with the members of the list in Mailgun.
+type ReconcileMailgunResult struct {
+	NumMembers      int
+	NumNotFound     int
+	NumResubscribed int
+	NumUnsubscribed int
+}
+
+// SendOutboxResult holds the results of sending a batch of messages and
+// webhooks from the outbox.
+type SendOutboxResult struct {
+	// NumAbandoned is the number of messages and webhooks that failed to send
+	// for the last time and won't be tried again.
+	NumAbandoned int
+
+	NumFailed int
+	NumSent   int
+}
+
 type Server struct {
-	conf      *Conf
-	handler   http.Handler
-	mailAPI   mailclient.API
-	meta      *newslettermeta.Meta
-	renderer  *ptemplate.Renderer
-	txStarter db.TXStarter
+	conf                *Conf
+	confirmedEmailCache *command.ConfirmedEmailCache
+	emailBlocklist      *command.EmailBlocklist
+	emailChecker        *command.EmailChecker
+	formNonceManager    *formnonce.Manager
+	handler             http.Handler
+	mailAPI             mailclient.API
+
+	// mailVerifier verifies mail service credentials when checking whether
+	// the server is ready. Nil in testing, where mail goes to a fake client.
+	mailVerifier diagnostics.CredentialsVerifier
+
+	meta *newslettermeta.Meta
+
+	// newRateLimitStore initializes the store backing each of the server's
+	// rate limiters given the prefix of the limiter's keys.
+	newRateLimitStore func(keyPrefix string) (RateLimitStore, error)
+
+	// rateLimitStores are the stores backing the server's rate limiters,
+	// kept so that their size can be reported.
+	rateLimitStores []RateLimitStore
+
+	renderer       *ptemplate.Renderer
+	sessionManager *session.Manager
+
+	// templatesVersion is a hash of the templates used to produce an ETag for
+	// the show page. Empty if templates are reloaded dynamically, in which
+	// case ETags aren't used.
+	templatesVersion string
+
+	tokenSigner     *signedtoken.Signer
+	txStarter       db.TXStarter
+	webhookNotifier *command.WebhookNotifier
+
+	// numConfirmationsCompleted counts signups confirmed by following their
+	// link since this process started.
+	numConfirmationsCompleted atomic.Int64
+
+	// numOutboxPending is the number of messages waiting in the outbox as of
+	// the outbox worker's last tick, reported as the email_outbox_pending
+	// gauge.
+	numOutboxPending atomic.Int64
+}
+
+// adminErrorResponse is the response body for an error from an admin
+// endpoint.
+type adminErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// adminFunnelResponse is the response body for the admin funnel endpoint.
+type adminFunnelResponse struct {
+	*command.FunnelGetterResult
+
+	Window string `json:"window"`
+}
+
+// adminStatsResponse is the response body for the admin stats endpoint.
+type adminStatsResponse struct {
+	*command.StatsGetterResult
+
+	NewsletterID                       string `json:"newsletter_id"`
+	NumConfirmationsCompletedSinceBoot int64  `json:"num_confirmations_completed_since_boot"`
+	NumRateLimitKeys                   int    `json:"num_rate_limit_keys"`
+}
+
+// devMessagesSentResponse is the response body for the development endpoint
+// showing messages sent by the fake mail client.
+type devMessagesSentResponse struct {
+	MessagesSent []*mailclient.FakeClientAPIMessageSent `json:"messages_sent"`
+}
+
+// validateResponse is the response body for the validate endpoint.
+type validateResponse struct {
+	Reason string `json:"reason,omitempty"`
+	Valid  bool   `json:"valid"`
+}
+
+// emailList is a list of emails decoded from a comma-separated configuration
+// value. Semicolons are also accepted as separators to match other list
+// configuration.
+type emailList []string
+
+// Decode implements envdecode.Decoder.
+func (l *emailList) Decode(value string) error {
+	var emails emailList
+	for _, entry := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ';' }) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		emails = append(emails, entry)
+	}
+
+	*l = emails
+	return nil
+}
+
+// exportResponseWriter sets headers for an export response just before its
+// first write. Until then, nothing's been sent, so an error can still be
+// rendered as a normal error response.
+type exportResponseWriter struct {
+	setHeaders func()
+	w          http.ResponseWriter
+	written    bool
+}
+
+func (w *exportResponseWriter) Write(p []byte) (int, error) {
+	if !w.written {
+		w.setHeaders()
+		w.written = true
+	}
+	return w.w.Write(p)
+}
+
+// failOpenRateLimiter wraps a rate limiter so that if it errors, like because
+// its store is unavailable, the error is logged and the request is allowed
+// instead of being failed.
+type failOpenRateLimiter struct {
+	rateLimiter throttled.RateLimiter
+}
+
+func (l *failOpenRateLimiter) RateLimit(key string, quantity int) (bool, throttled.RateLimitResult, error) {
+	limited, result, err := l.rateLimiter.RateLimit(key, quantity)
+	if err != nil {
+		logrus.Errorf("Rate limiter error; allowing request: %v", err)
+
+		// Negative values leave rate limit headers out of the response.
+		return false, throttled.RateLimitResult{Limit: -1, Remaining: -1, ResetAfter: -1, RetryAfter: -1}, nil
+	}
+	return limited, result, nil
+}
+
+// RateLimitStore is a store backing a rate limiter. NumKeys reports the number
+// of keys in the store, which is roughly the number of distinct clients that
+// it's tracking.
+type RateLimitStore interface {
+	throttled.GCRAStore
+
+	NumKeys() int
+}
+
+// memoryRateLimitStore is a memory store for rate limiters that keeps count of
+// its keys so that its size can be reported.
+//
+// The underlying store never expires keys. Once it reaches its maximum, the
+// least recently used key is evicted for each new one, so the count stays
+// there from then on.
+type memoryRateLimitStore struct {
+	*memstore.MemStore
+
+	maxKeys int
+	numKeys atomic.Int64
+}
+
+// newMemoryRateLimitStore initializes a new memoryRateLimitStore that holds
+// up to maxKeys keys, or any number if maxKeys is zero.
+func newMemoryRateLimitStore(maxKeys int) (*memoryRateLimitStore, error) {
+	store, err := memstore.New(maxKeys)
+	if err != nil {
+		return nil, err
+	}
+	return &memoryRateLimitStore{MemStore: store, maxKeys: maxKeys}, nil
+}
+
+// NumKeys returns the number of keys in the store.
+func (s *memoryRateLimitStore) NumKeys() int {
+	return int(s.numKeys.Load())
+}
+
+// SetIfNotExistsWithTTL sets key to value if it doesn't already exist,
+// counting it as a new key if it was set.
+func (s *memoryRateLimitStore) SetIfNotExistsWithTTL(key string, value int64, ttl time.Duration) (bool, error) {
+	set, err := s.MemStore.SetIfNotExistsWithTTL(key, value, ttl)
+	if err != nil || !set {
+		return set, err
+	}
+
+	for {
+		numKeys := s.numKeys.Load()
+		if s.maxKeys > 0 && numKeys >= int64(s.maxKeys) {
+			break
+		}
+		if s.numKeys.CompareAndSwap(numKeys, numKeys+1) {
+			break
+		}
+	}
+
+	return true, nil
+}
+
+// newsletterRateQuotas are rate limit quotas keyed by newsletter ID. It's
+// decoded from configuration in the format described on
+// Conf.RateLimitQuotas.
+type newsletterRateQuotas map[string]throttled.RateQuota
+
+// Decode implements envdecode.Decoder.
+func (q *newsletterRateQuotas) Decode(value string) error {
+	quotas := make(newsletterRateQuotas)
+
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		newsletterID, spec, ok := strings.Cut(entry, "=")
+		rate, burst, ok2 := strings.Cut(spec, ":")
+		count, unit, ok3 := strings.Cut(rate, "/")
+		if !ok || !ok2 || !ok3 || newsletterID == "" {
+			return xerrors.Errorf("rate limit quota should look like `passages=5/s:20`: %q", entry)
+		}
+
+		if !newslettermeta.IsKnown(newsletterID) {
+			return xerrors.Errorf("rate limit quota is for an unknown newsletter: %q", entry)
+		}
+
+		numRequests, err := strconv.Atoi(count)
+		if err != nil || numRequests < 1 {
+			return xerrors.Errorf("rate limit quota should have a positive rate: %q", entry)
+		}
+
+		maxBurst, err := strconv.Atoi(burst)
+		if err != nil || maxBurst < 0 {
+			return xerrors.Errorf("rate limit quota should have a non-negative burst: %q", entry)
+		}
+
+		var maxRate throttled.Rate
+		switch unit {
+		case "s":
+			maxRate = throttled.PerSec(numRequests)
+		case "m":
+			maxRate = throttled.PerMin(numRequests)
+		case "h":
+			maxRate = throttled.PerHour(numRequests)
+		default:
+			return xerrors.Errorf("rate limit quota unit should be one of `s`, `m`, or `h`: %q", entry)
+		}
+
+		quotas[newsletterID] = throttled.RateQuota{MaxBurst: maxBurst, MaxRate: maxRate}
+	}
+
+	*q = quotas
+	return nil
+}
+
+// prefixedVaryBy keys rate limits on a prefix as well as the request's remote
+// IP so that the limits of different limiters and newsletters are independent,
+// even if their limiters share a store.
+type prefixedVaryBy struct {
+	prefix string
+}
+
+func (v *prefixedVaryBy) Key(r *http.Request) string {
+	return v.prefix + "\n" + (&throttled.VaryBy{RemoteAddr: true}).Key(r)
+}
+
+// secretProvider sources keys for features that sign values from the single
+// configured SigningSecret so that each feature doesn't need its own. A
+// distinct key is derived for each purpose.
+type secretProvider struct {
+	// legacyKeys are keys for purposes that were configured with their own
+	// deprecated secret, which are used as is instead of derived keys so
+	// that values signed before upgrading stay valid.
+	legacyKeys map[string]string
+
+	secret []byte
+}
+
+// key derives a key for the given purpose, which should be one of the
+// secretPurpose* constants. Panics if no secret was configured, which means
+// that the feature asking for a key wasn't checked by newSecretProvider.
+func (p *secretProvider) key(purpose string) string {
+	if key, ok := p.legacyKeys[purpose]; ok {
+		return key
+	}
+
+	if len(p.secret) < 1 {
+		panic("no signing secret configured for purpose: " + purpose)
+	}
+
+	h := hmac.New(sha256.New, p.secret)
+	h.Write([]byte(purpose))
+	return string(h.Sum(nil))
+}
+
+// templatePreview is a template to be rendered with sample data for design
+// review.
+type templatePreview struct {
+	templateFile string
+	locals       map[string]interface{}
+
+	// fragment renders the template without the layout.
+	fragment bool
 }
 
 func main() {
 	ctx := context.Background()
 
+	diagnose := flag.Bool("diagnose", false,
+		"check connectivity to dependencies, print a report, and exit")
+	diagnoseFormat := flag.String("format", diagnostics.FormatText,
+		"format of the report printed by -diagnose: text or json")
+	bulkResend := flag.Bool("bulk-resend", false,
+		"resend confirmation emails to all pending signups, then exit")
+	bulkResendRate := flag.Int("bulk-resend-rate", 5,
+		"maximum number of confirmation emails to send per second with -bulk-resend")
+	export := flag.Bool("export", false,
+		"write confirmed subscribers as CSV to stdout, then exit")
+	exportGzip := flag.Bool("gzip", false,
+		"compress the output of -export with gzip")
+	reconcile := flag.Bool("reconcile", false,
+		"reconcile local subscription state with the members of the Mailgun list, then exit")
+	flag.Parse()
+
 	var conf Conf
 	err := envdecode.Decode(&conf)
 	if err != nil {
 		logrus.Fatalf("Error decoding env configuration: %v", err)
 	}
 
+	if err := setLogLevel(conf.LogLevel); err != nil {
+		logrus.Fatalf("Error setting log level: %v", err)
+	}
+
+	logrus.Infof("Configuration: %s", conf.Redacted())
+
+	switch {
+	case *diagnose:
+		conf.Role = roleDiagnostics
+	case *bulkResend:
+		conf.Role = roleBulkResend
+	case *export:
+		conf.Role = roleExport
+	case *reconcile:
+		conf.Role = roleReconcile
+	default:
+		conf.Role = roleWeb
+	}
+
+	if *diagnose {
+		allPassed, err := runDiagnostics(ctx, &conf, os.Stdout, *diagnoseFormat)
+		if err != nil {
+			logrus.Fatalf("Error running diagnostics: %v", err)
+		}
+		if !allPassed {
+			os.Exit(1)
+		}
+		return
+	}
+
 	server, err := NewServer(ctx, &conf)
 	if err != nil {
 		logrus.Fatalf("Error initiaizing server: %v", err)
 	}
 
-	if err := server.Start(); err != nil {
+	if *bulkResend {
+		// Allow an in-progress bulk resend to be stopped with Ctrl+C.
+		ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+		defer stop()
+
+		res, err := server.BulkResend(ctx, *bulkResendRate)
+		if err != nil {
+			logrus.Fatalf("Error running bulk resend: %v", err)
+		}
+		logrus.Infof("Bulk resend complete: %d resent, %d failed, %d suppressed",
+			res.NumResent, res.NumFailed, res.NumSuppressed)
+		return
+	}
+
+	if *export {
+		if _, err := server.ExportSubscribers(ctx, os.Stdout, *exportGzip); err != nil {
+			logrus.Fatalf("Error exporting subscribers: %v", err)
+		}
+		return
+	}
+
+	if *reconcile {
+		res, err := server.ReconcileMailgun(ctx)
+		if err != nil {
+			logrus.Fatalf("Error reconciling with Mailgun: %v", err)
+		}
+		logrus.Infof("Reconcile complete: %d member(s), %d unsubscribed, %d resubscribed, %d not found",
+			res.NumMembers, res.NumUnsubscribed, res.NumResubscribed, res.NumNotFound)
+		return
+	}
+
+	if err := server.Ready(ctx); err != nil {
+		logrus.Fatalf("Error checking server readiness: %v", err)
+	}
+
+	// Stop gracefully when the process is asked to, like on a deploy.
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := server.Start(ctx); err != nil {
 		logrus.Fatalf("Error starting server: %v", err)
 	}
 }
 
 func NewServer(ctx context.Context, conf *Conf) (*Server, error) {
 	if err := validate.Struct(conf); err != nil {
-		return nil, xerrors.Errorf("error validating server config: %w", conf)
+		return nil, xerrors.Errorf("error validating server config: %w", err)
 	}
 
-	meta, err := newslettermeta.MetaFor(mailDomain, conf.NewsletterID)
+	secrets, err := newSecretProvider(conf)
+	if err != nil {
+		return nil, xerrors.Errorf("error validating server config: %w", err)
+	}
+
+	meta, err := newslettermeta.MetaFor(conf.MailDomain, conf.NewsletterID)
 	if err != nil {
 		return nil, err
 	}
 
 	var mailAPI mailclient.API
+	var mailVerifier diagnostics.CredentialsVerifier
 	if conf.PassagesEnv == envTesting {
 		mailAPI = mailclient.NewFakeClient()
 	} else {
-		mailAPI = mailclient.NewMailgunClient(mailDomain, conf.MailgunAPIKey)
-	}
+		mailgunClient := mailclient.NewMailgunClient(conf.MailDomain, conf.MailgunAPIKey)
+		mailVerifier = mailgunClient
 
-	// Use templates embedded with `go:embed` in production, but local
-	// filesystem otherwise so we can easily iterate in development.
-	var templates fs.FS
-	if conf.isProduction() {
-		templates = embeddedTemplates
-	} else {
-		templates = os.DirFS(".")
+		var mailgunAPI mailclient.API = mailgunClient
+		if conf.MailMaxConcurrency > 0 {
+			mailgunAPI = mailclient.NewConcurrencyLimitClient(mailgunAPI, conf.MailMaxConcurrency)
+		}
+
+		mailAPI = mailclient.NewBreakerClient(mailgunAPI, mailBreakerThreshold, mailBreakerCooldown)
 	}
 
-	renderer, err := ptemplate.NewRenderer(&ptemplate.RendererConfig{
-		DynamicReload:  !conf.isProduction(),
-		NewsletterMeta: meta,
-		PublicURL:      conf.PublicURL,
-		Templates:      templates,
-	})
+	renderer, err := newRenderer(conf, meta)
 	if err != nil {
 		return nil, err
 	}
 
+	// Compile all templates up front so that a broken one prevents boot rather
+	// than producing errors when a request tries to render it.
+	if err := renderer.Validate(); err != nil {
+		return nil, err
+	}
+
+	// Likewise for the maintenance template, which may be a view outside of
+	// the ones that Validate checks. A typo in it would otherwise only show
+	// up once maintenance mode is turned on, which is the worst time to find
+	// out.
+	if conf.MaintenanceTemplate != "" {
+		if err := renderer.RenderTemplate(io.Discard, conf.MaintenanceTemplate, map[string]interface{}{}); err != nil {
+			return nil, xerrors.Errorf("error rendering maintenance template %q: %w", conf.MaintenanceTemplate, err)
+		}
+	}
+
 	txStarter := conf.DatabaseTXStarter
 	if txStarter == nil {
-		txStarter, err = db.Connect(ctx, &db.ConnectConfig{
-			ApplicationName: "passages-signup",
-			DatabaseURL:     conf.DatabaseURL,
-		})
+		txStarter, err = db.Connect(ctx, conf.connectConfig())
 		if err != nil {
 			return nil, err
 		}
 	}
 
 	s := &Server{
-		conf:      conf,
-		mailAPI:   mailAPI,
-		meta:      meta,
-		renderer:  renderer,
-		txStarter: txStarter,
+		conf:                conf,
+		confirmedEmailCache: command.NewConfirmedEmailCache(confirmedEmailCacheSize, confirmedEmailCacheTTL),
+		emailBlocklist:      command.NewEmailBlocklist(conf.BlockedEmails),
+		emailChecker:        &command.EmailChecker{MaxEmailLength: conf.MaxEmailLength},
+		mailAPI:             mailAPI,
+		mailVerifier:        mailVerifier,
+		meta:                meta,
+		newRateLimitStore:   conf.RateLimitStoreFactory,
+		renderer:            renderer,
+		txStarter:           txStarter,
 	}
 
-	r := mux.NewRouter()
-
-	// Keep static assets on a clean router so that they can be served even in
-	// maintenance mode.
+	// We use memory stores instead of something like Redis by default because
+	// for the time being we know that this app will only ever run on a single
+	// dyno. If that invariant ever changes, inject shared stores instead.
 	//
-	// In production serves assets that have been slurped up with go:embed. In
-	// other environments, reads directly from disk for reasy reloading.
-	r.PathPrefix("/public/").Handler(staticAssetsHandler(conf.isProduction()))
+	// All state is lost when the dyno goes to sleep, but since we're using
+	// small time scales anyway, that's fine.
+	if s.newRateLimitStore == nil {
+		s.newRateLimitStore = func(_ string) (RateLimitStore, error) {
+			return newMemoryRateLimitStore(conf.RateLimiterMaxKeys)
+		}
+	}
 
-	innerRouter := r.NewRoute().Subrouter()
-	innerRouter.Use(middleware.NewMaintenanceModeMiddleware(conf.MaintenanceMode, renderer).Wrapper)
+	if conf.CheckEmailMX {
+		s.emailChecker.MXResolver = net.DefaultResolver
+	}
 
-	innerRouter.HandleFunc("/", s.handleShow)
-	innerRouter.HandleFunc("/confirm/{token}", s.handleConfirm)
-	innerRouter.HandleFunc("/submit", s.handleSubmit)
+	if conf.signConfirmationTokens() {
+		s.tokenSigner = signedtoken.NewSigner(secrets.key(secretPurposeConfirmationToken))
+	}
 
-	// Easy message previews for development.
-	if !conf.isProduction() {
-		innerRouter.HandleFunc("/dev/messages/confirm", s.handleShowConfirmMessagePreview)
-		innerRouter.HandleFunc("/dev/messages/confirm_plain", s.handleShowConfirmMessagePlainPreview)
-		innerRouter.HandleFunc("/dev/maintenance", s.handleShowMaintenance)
+	if conf.RequireFormNonce {
+		s.formNonceManager = formnonce.NewManager(secrets.key(secretPurposeFormNonce), formnonce.NewMemoryStore())
 	}
 
-	s.handler = r
+	if conf.requireSession() {
+		s.sessionManager = session.NewManager(secrets.key(secretPurposeSession), conf.isProduction())
+	}
+
+	if conf.SubscriberWebhookURL != "" {
+		s.webhookNotifier = command.NewWebhookNotifier(conf.SubscriberWebhookURL, conf.SubscriberWebhookSecret, meta.ID)
+	}
+
+	if !renderer.DynamicReload {
+		s.templatesVersion, err = renderer.Version()
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	options := []csrf.Option{
-		csrf.AllowedOrigin(conf.PublicURL),
+	// Origins allowed to post to this app. Shared between CORS and CSRF
+	// protection so that the two can't drift apart.
+	allowedOrigins := []string{
+		conf.PublicURL,
 
 		// And also allow the special origin from `brandur.org` which will
 		// cross-post to this app.
-		csrf.AllowedOrigin("https://brandur.org"),
+		"https://brandur.org",
 	}
 
 	if !conf.isProduction() {
 		logrus.Infof("Allowing localhost origin for non-production environment")
-		options = append(options,
-			csrf.AllowedOrigin("http://localhost:"+conf.Port))
+		allowedOrigins = append(allowedOrigins, "http://localhost:"+conf.Port)
 	}
-	s.handler = csrf.Protect(options...)(s.handler)
 
-	// Use a rate limiter to prevent enumeration of email addresses and so it's
-	// harder to maliciously burn through my Mailgun API limit.
-	if conf.EnableRateLimiter {
-		logrus.Infof("Enabling memory-backed rate limiting")
-		rateLimiter, err := getRateLimiter()
-		if err != nil {
-			logrus.Fatal(err)
+	r := mux.NewRouter()
+	r.NotFoundHandler = http.HandlerFunc(s.handleNotFound)
+
+	// Keep static assets on a clean router so that they can be served even in
+	// maintenance mode.
+	//
+	// In production serves assets that have been slurped up with go:embed. In
+	// other environments, reads directly from disk for reasy reloading.
+	r.PathPrefix("/public/").Handler(staticAssetsHandler(conf.isProduction()))
+
+	// Metrics are also kept available in maintenance mode so that they can
+	// still be scraped.
+	r.HandleFunc("/metrics", s.handleMetrics)
+
+	maintenanceMode := middleware.NewMaintenanceModeMiddleware(conf.MaintenanceMode, renderer,
+		conf.MaintenanceTemplate)
+
+	// Admin endpoints are authenticated by a bearer token rather than cookies,
+	// so they're not susceptible to CSRF and are kept off of the CSRF
+	// protected router. This lets them be called with tools like cURL, which
+	// don't send an `Origin`.
+	adminRouter := r.NewRoute().Subrouter()
+	adminRouter.Use(maintenanceMode.Wrapper)
+
+	// Every route on this router requires the admin token, so new admin
+	// endpoints should be added here rather than wrapped individually.
+	if conf.AdminToken != "" {
+		requireAdmin := adminRouter.NewRoute().Subrouter()
+		requireAdmin.Use(middleware.NewAdminAuthMiddleware(conf.AdminToken).Wrapper)
+
+		requireAdmin.HandleFunc("/admin/confirm", s.handleAdminConfirm)
+		requireAdmin.HandleFunc("/admin/export.csv", s.handleAdminExport)
+		requireAdmin.HandleFunc("/admin/funnel", s.handleAdminFunnel)
+
+		if conf.EnablePreviewLink {
+			requireAdmin.HandleFunc("/admin/preview/{token}", s.handleAdminPreview)
 		}
-		s.handler = rateLimiter.RateLimit(s.handler)
+
+		requireAdmin.HandleFunc("/admin/rotate-token", s.handleAdminRotateToken)
+		requireAdmin.HandleFunc("/admin/stats", s.handleAdminStats)
+		requireAdmin.HandleFunc("/admin/subscriber", s.handleAdminSubscriber)
+		requireAdmin.HandleFunc("/admin/templates.zip", s.handleAdminTemplates)
+		requireAdmin.HandleFunc("/admin/test-send", s.handleAdminTestSend)
+		requireAdmin.HandleFunc("/admin/welcome", s.handleAdminWelcome)
 	}
 
-	if conf.isProduction() {
-		s.handler = redirectToHTTPS(s.handler)
+	csrfOptions := make([]csrf.Option, 0, len(allowedOrigins))
+	for _, origin := range allowedOrigins {
+		csrfOptions = append(csrfOptions, csrf.AllowedOrigin(origin))
 	}
 
-	return s, nil
+	innerRouter := r.NewRoute().Subrouter()
+	innerRouter.Use(maintenanceMode.Wrapper)
+	innerRouter.Use(csrf.Protect(csrfOptions...))
+
+	innerRouter.HandleFunc("/", s.handleShow)
+	innerRouter.HandleFunc("/form", s.handleForm)
+
+	// The confirm route gets its own, tighter rate limit on top of the global
+	// one to make token enumeration impractical.
+	var confirmHandler http.Handler = http.HandlerFunc(s.handleConfirm)
+	if conf.EnableRateLimiter {
+		confirmRateLimiter, err := s.getRateLimiter(rateLimiterConfirm, confirmRateQuota)
+		if err != nil {
+			return nil, err
+		}
+		confirmHandler = replaceRateLimitHeaders(confirmRateLimiter.RateLimit(confirmHandler))
+	}
+	innerRouter.Handle("/confirm/{token}", confirmHandler)
+
+	innerRouter.HandleFunc("/unsubscribe/{token}", s.handleUnsubscribe)
+
+	innerRouter.Handle("/submit",
+		middleware.NewCORSMiddleware(allowedOrigins, &middleware.CORSOptions{
+			AllowedHeaders: conf.CORSAllowedHeaders,
+			ExposedHeaders: rateLimitHeaders,
+			MaxAge:         conf.CORSMaxAge,
+		}).Wrapper(http.HandlerFunc(s.handleSubmit)))
+
+	// Validating an email gets its own, tighter rate limit so that it can't
+	// be used to cheaply check the deliverability of many addresses.
+	var validateHandler http.Handler = http.HandlerFunc(s.handleValidate)
+	if conf.EnableRateLimiter {
+		validateRateLimiter, err := s.getRateLimiter(rateLimiterValidate, validateRateQuota)
+		if err != nil {
+			return nil, err
+		}
+		validateHandler = replaceRateLimitHeaders(validateRateLimiter.RateLimit(validateHandler))
+	}
+	innerRouter.Handle("/validate",
+		middleware.NewCORSMiddleware(allowedOrigins, &middleware.CORSOptions{
+			AllowedHeaders: conf.CORSAllowedHeaders,
+			ExposedHeaders: rateLimitHeaders,
+			MaxAge:         conf.CORSMaxAge,
+		}).Wrapper(validateHandler))
+
+	// Easy message previews for development.
+	if !conf.isProduction() {
+		innerRouter.HandleFunc("/dev/messages/confirm", s.handleShowConfirmMessagePreview)
+		innerRouter.HandleFunc("/dev/messages/confirm_plain", s.handleShowConfirmMessagePlainPreview)
+		innerRouter.HandleFunc("/dev/maintenance", s.handleShowMaintenance)
+		innerRouter.HandleFunc("/dev/messages/sent", s.handleShowMessagesSent)
+	}
+
+	s.handler = r
+
+	// Limit the size of request bodies so that a large POST can't be used to
+	// exhaust memory while parsing a form.
+	s.handler = limitRequestBody(s.handler, conf.MaxBodyBytes)
+
+	// Use a rate limiter to prevent enumeration of email addresses and so it's
+	// harder to maliciously burn through my Mailgun API limit.
+	if conf.EnableRateLimiter {
+		logrus.Infof("Enabling rate limiting")
+		rateLimiter, err := s.getRateLimiter(rateLimiterGlobal, conf.rateQuota())
+		if err != nil {
+			return nil, err
+		}
+		s.handler = rateLimiter.RateLimit(s.handler)
+	}
+
+	// Mailgun's webhook is called server to server and is authenticated by
+	// signature, so it's routed around CSRF protection, the form body limit,
+	// and the per-IP rate limiter (Mailgun may send bounces in bursts). It's
+	// also available in maintenance mode so that bounces aren't lost.
+	if conf.MailgunWebhookSigningKey != "" {
+		webhookRouter := mux.NewRouter()
+		webhookRouter.Handle("/mailgun/webhook",
+			limitRequestBody(http.HandlerFunc(s.handleMailgunWebhook), mailgunWebhookMaxBodyBytes))
+		webhookRouter.PathPrefix("/").Handler(s.handler)
+		s.handler = webhookRouter
+	}
+
+	if conf.isProduction() {
+		s.handler = redirectToHTTPS(s.handler, conf.ForwardedProtoHeader)
+	}
+
+	// Outermost so that every response, including redirects and rate limited
+	// requests, carries a request ID.
+	s.handler = middleware.NewRequestIDMiddleware(conf.RequestIDHeaders).Wrapper(s.handler)
+
+	return s, nil
 }
 
-func (s *Server) Start() error {
+// Start starts the server's background workers and serves requests until
+// ctx is done, at which point the workers are stopped and the server shuts
+// down gracefully, letting in-flight requests finish.
+func (s *Server) Start(ctx context.Context) error {
+	go s.runDeferredMemberAddWorker(ctx)
+	go s.runOutboxWorker(ctx)
+
 	logrus.Infof("Listening on port %v", s.conf.Port)
 
 	server := &http.Server{
@@ -244,43 +1335,939 @@ func (s *Server) Start() error {
 		Handler:           s.handler,
 		ReadHeaderTimeout: 3 * time.Second,
 	}
-	if err := server.ListenAndServe(); err != nil {
+
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+
+		<-ctx.Done()
+		logrus.Infof("Shutting down")
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logrus.Errorf("Error shutting down server: %v", err)
+		}
+	}()
+
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
 		return xerrors.Errorf("error listening on port %q: %w", s.conf.Port, err)
 	}
+
+	<-shutdownDone
 	return nil
 }
 
+// AddDeferredMembers retries list adds that were deferred because the mail
+// service was over quota, up to deferredMemberAddBatchSize of them. Each is
+// retried in its own transaction so that one failing doesn't undo the others.
+// Stops early if the quota is still exceeded.
+func (s *Server) AddDeferredMembers(ctx context.Context) (int, error) {
+	var numAdded int
+	for numAdded < deferredMemberAddBatchSize {
+		var res *command.DeferredMemberAdderResult
+		err := db.WithTransaction(ctx, s.txStarter, func(ctx context.Context, tx pgx.Tx) error {
+			mediator := &command.DeferredMemberAdder{
+				ListAddress: s.meta.ListAddress,
+				MailAPI:     s.mailAPI,
+			}
+
+			var err error
+			res, err = mediator.Run(ctx, tx)
+			return err
+		})
+		if err != nil {
+			return numAdded, err
+		}
+
+		if !res.MemberAdded {
+			break
+		}
+
+		numAdded++
+	}
+
+	return numAdded, nil
+}
+
+// BulkResend resends confirmation emails to all pending signups at a maximum
+// of ratePerSecond emails per second.
+//
+// Each signup gets its own short transaction, and its message is only sent
+// once that transaction has committed so that a send never goes out with a
+// token that was rolled back. A failure to send to one signup is logged and
+// counted, but doesn't stop the others.
+func (s *Server) BulkResend(ctx context.Context, ratePerSecond int) (*BulkResendResult, error) {
+	if ratePerSecond < 1 {
+		return nil, xerrors.Errorf("bulk resend rate must be at least 1 per second; got %d", ratePerSecond)
+	}
+
+	logrus.Infof("Resending confirmations to pending signups at %d/sec", ratePerSecond)
+
+	ticker := time.NewTicker(time.Second / time.Duration(ratePerSecond))
+	defer ticker.Stop()
+
+	var (
+		afterID int64
+		res     = &BulkResendResult{}
+	)
+	for {
+		select {
+		case <-ctx.Done():
+			return res, xerrors.Errorf("bulk resend interrupted after %d signup(s): %w",
+				res.NumResent+res.NumFailed, ctx.Err())
+		case <-ticker.C:
+		}
+
+		var resenderRes *command.BulkResenderResult
+		err := db.WithTransaction(ctx, s.txStarter, func(ctx context.Context, tx pgx.Tx) error {
+			mediator := &command.BulkResender{
+				AfterID:         afterID,
+				EmailCasePolicy: command.EmailCasePolicy(s.conf.EmailCasePolicy),
+				FromAddress:     s.conf.MailFromAddress,
+				ListAddress:     s.meta.ListAddress,
+				Renderer:        s.renderer,
+				ReplyToAddress:  s.conf.replyToAddress(),
+				TokenSigner:     s.tokenSigner,
+				TokenTTL:        s.conf.ConfirmationTokenTTL,
+			}
+
+			var err error
+			resenderRes, err = mediator.Run(ctx, tx)
+			return err
+		})
+		if err != nil {
+			return res, err
+		}
+
+		if resenderRes.Done {
+			return res, nil
+		}
+
+		afterID = resenderRes.SignupID
+
+		if resenderRes.Suppressed {
+			res.NumSuppressed++
+			continue
+		}
+
+		if err := s.mailAPI.SendMessage(ctx, resenderRes.Message); err != nil {
+			logrus.Errorf("Error resending confirmation to %v: %v", resenderRes.Email, err)
+			res.NumFailed++
+			continue
+		}
+
+		res.NumResent++
+	}
+}
+
+// ExportSubscribers writes confirmed subscribers as CSV to w, optionally
+// compressed with gzip. Subscribers are read a page at a time, each in its own
+// transaction, so that a slow writer doesn't hold a transaction open for the
+// whole export.
+func (s *Server) ExportSubscribers(ctx context.Context, w io.Writer, useGzip bool) (*ExportSubscribersResult, error) {
+	var gzipWriter *gzip.Writer
+	if useGzip {
+		gzipWriter = gzip.NewWriter(w)
+		w = gzipWriter
+	}
+
+	var (
+		afterID int64
+		res     ExportSubscribersResult
+	)
+	for {
+		var pageRes *command.SubscriberExporterResult
+		err := db.WithTransaction(ctx, s.txStarter, func(ctx context.Context, tx pgx.Tx) error {
+			mediator := &command.SubscriberExporter{
+				AfterID:  afterID,
+				PageSize: subscriberExportPageSize,
+				Writer:   w,
+			}
+
+			var err error
+			pageRes, err = mediator.Run(ctx, tx)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		afterID = pageRes.LastID
+		res.NumExported += pageRes.NumExported
+
+		if pageRes.Done {
+			break
+		}
+	}
+
+	if gzipWriter != nil {
+		if err := gzipWriter.Close(); err != nil {
+			return nil, xerrors.Errorf("error closing gzip writer: %w", err)
+		}
+	}
+
+	logrus.Infof("Exported %d subscriber(s)", res.NumExported)
+	return &res, nil
+}
+
+// Ready checks that the server's dependencies are usable: that the database is
+// reachable and migrated, that mail credentials are valid, and that templates
+// compile. It's run before listening so that a misconfigured process exits
+// instead of serving broken responses. The returned error describes every
+// check that failed.
+func (s *Server) Ready(ctx context.Context) error {
+	err := db.WithTransaction(ctx, s.txStarter, func(ctx context.Context, tx pgx.Tx) error {
+		checks := []diagnostics.Check{
+			diagnostics.DatabaseCheck(tx),
+			diagnostics.SchemaCheck(tx, schemaColumns),
+		}
+		if s.mailVerifier != nil {
+			checks = append(checks, diagnostics.MailCredentialsCheck(s.mailVerifier))
+		}
+		checks = append(checks, diagnostics.TemplatesCheck(s.renderer))
+
+		var failures []string
+		for _, result := range diagnostics.Run(ctx, checks) {
+			if result.Err != nil {
+				failures = append(failures, result.Name+": "+result.Err.Error())
+			}
+		}
+		if len(failures) > 0 {
+			return xerrors.New(strings.Join(failures, "; "))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return xerrors.Errorf("server not ready: %w", err)
+	}
+
+	return nil
+}
+
+// ReconcileMailgun brings local subscription state in line with the members
+// of the list in Mailgun. Each page of members is reconciled in its own
+// transaction.
+func (s *Server) ReconcileMailgun(ctx context.Context) (*ReconcileMailgunResult, error) {
+	var (
+		cursor string
+		res    ReconcileMailgunResult
+	)
+	for {
+		var pageRes *command.MailgunReconcilerResult
+		err := db.WithTransaction(ctx, s.txStarter, func(ctx context.Context, tx pgx.Tx) error {
+			mediator := &command.MailgunReconciler{
+				ConfirmedEmailCache: s.confirmedEmailCache,
+				Cursor:              cursor,
+				EmailCasePolicy:     command.EmailCasePolicy(s.conf.EmailCasePolicy),
+				ListAddress:         s.meta.ListAddress,
+				MailAPI:             s.mailAPI,
+			}
+
+			var err error
+			pageRes, err = mediator.Run(ctx, tx)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		res.NumMembers += pageRes.NumMembers
+		res.NumNotFound += pageRes.NumNotFound
+		res.NumResubscribed += pageRes.NumResubscribed
+		res.NumUnsubscribed += pageRes.NumUnsubscribed
+
+		if pageRes.Done {
+			break
+		}
+		cursor = pageRes.NextCursor
+	}
+
+	return &res, nil
+}
+
+// RecordOutboxDepth counts the messages waiting in the outbox for the
+// email_outbox_pending gauge, logging a warning if there are more than
+// OutboxPendingWarnThreshold of them.
+func (s *Server) RecordOutboxDepth(ctx context.Context) error {
+	var res *command.OutboxCounterResult
+	err := db.WithTransaction(ctx, s.txStarter, func(ctx context.Context, tx pgx.Tx) error {
+		mediator := &command.OutboxCounter{}
+
+		var err error
+		res, err = mediator.Run(ctx, tx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	s.numOutboxPending.Store(res.NumPending)
+
+	if s.conf.OutboxPendingWarnThreshold > 0 && res.NumPending > int64(s.conf.OutboxPendingWarnThreshold) {
+		logrus.Warnf("Outbox backlog of %d pending message(s) exceeds warning threshold of %d",
+			res.NumPending, s.conf.OutboxPendingWarnThreshold)
+	}
+
+	return nil
+}
+
+// SendOutbox sends messages in the outbox whose send_after has passed, up to
+// outboxBatchSize of them, followed by webhooks in the same way if a
+// subscriber webhook is configured. Each is sent in its own transaction so
+// that one failing doesn't undo the others, and so that a message is never
+// sent again because a later one in the batch failed.
+func (s *Server) SendOutbox(ctx context.Context) (*SendOutboxResult, error) {
+	res := &SendOutboxResult{}
+	if err := s.sendOutboxMessages(ctx, res); err != nil {
+		return res, err
+	}
+
+	if s.webhookNotifier != nil {
+		if err := s.sendOutboxWebhooks(ctx, res); err != nil {
+			return res, err
+		}
+	}
+
+	return res, nil
+}
+
+// runDeferredMemberAddWorker retries deferred list adds every
+// deferredMemberAddPollInterval until ctx is done. Errors are logged, and
+// adding is tried again on the next tick.
+func (s *Server) runDeferredMemberAddWorker(ctx context.Context) {
+	logrus.Infof("Starting deferred member add worker polling every %v", deferredMemberAddPollInterval)
+
+	ticker := time.NewTicker(deferredMemberAddPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		numAdded, err := s.AddDeferredMembers(ctx)
+		if err != nil {
+			logrus.Errorf("Error adding deferred members: %v", err)
+		}
+
+		if numAdded > 0 {
+			logrus.Infof("Added %d deferred member(s) to the list", numAdded)
+		}
+	}
+}
+
+// runOutboxWorker sends due messages from the outbox every
+// outboxPollInterval until ctx is done. Errors are logged, and sending is
+// tried again on the next tick.
+func (s *Server) runOutboxWorker(ctx context.Context) {
+	logrus.Infof("Starting outbox worker polling every %v", outboxPollInterval)
+
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		res, err := s.SendOutbox(ctx)
+		if err != nil {
+			logrus.Errorf("Error sending outbox: %v", err)
+		}
+
+		if res.NumSent > 0 || res.NumFailed > 0 || res.NumAbandoned > 0 {
+			logrus.Infof("Sent outbox: %d sent, %d failed, %d abandoned",
+				res.NumSent, res.NumFailed, res.NumAbandoned)
+		}
+
+		if err := s.RecordOutboxDepth(ctx); err != nil {
+			logrus.Errorf("Error recording outbox depth: %v", err)
+		}
+	}
+}
+
+// runDiagnostics checks connectivity to the database and mail service, that
+// migrations have been applied, and that templates compile, writing a
+// pass/fail report to w in the given format.
+// Returns true if all checks passed.
+func runDiagnostics(ctx context.Context, conf *Conf, w io.Writer, format string) (bool, error) {
+	// Check the format before running anything so that a typo doesn't waste
+	// a round of checks.
+	if format != diagnostics.FormatJSON && format != diagnostics.FormatText {
+		return false, xerrors.Errorf("unknown diagnostics format: %q", format)
+	}
+
+	meta, err := newslettermeta.MetaFor(conf.MailDomain, conf.NewsletterID)
+	if err != nil {
+		return false, err
+	}
+
+	renderer, err := newRenderer(conf, meta)
+	if err != nil {
+		return false, err
+	}
+
+	var databaseChecks []diagnostics.Check
+	// Diagnostics report on the database as it is now, so the connection isn't
+	// retried.
+	connectConfig := conf.connectConfig()
+	connectConfig.ConnectRetryTimeout = 0
+	pool, err := db.Connect(ctx, connectConfig)
+	if err != nil {
+		connectErr := err
+		databaseChecks = []diagnostics.Check{{
+			Name: "Database connectivity",
+			Run:  func(_ context.Context) error { return connectErr },
+		}}
+	} else {
+		defer pool.Close()
+		databaseChecks = []diagnostics.Check{
+			diagnostics.DatabaseCheck(pool),
+			diagnostics.SchemaCheck(pool, schemaColumns),
+		}
+	}
+
+	results := diagnostics.Run(ctx, append(databaseChecks,
+		diagnostics.MailCredentialsCheck(mailclient.NewMailgunClient(conf.MailDomain, conf.MailgunAPIKey)),
+		diagnostics.TemplatesCheck(renderer),
+	))
+
+	return diagnostics.WriteFormattedReport(w, results, format)
+}
+
 //
 // Handlers ---
 //
 
+func (s *Server) handleAdminConfirm(w http.ResponseWriter, r *http.Request) {
+	s.withErrorHandling(w, r, func() error {
+		if !s.allowMethods(w, r, http.MethodPost) {
+			return nil
+		}
+
+		email := s.normalizeEmail(r.URL.Query().Get("email"))
+		if email == "" {
+			return s.renderJSON(w, http.StatusBadRequest, &adminErrorResponse{
+				Error: "expected query parameter email",
+			})
+		}
+
+		var res *command.SignupForceConfirmerResult
+		err := db.WithTransaction(r.Context(), s.txStarter, func(ctx context.Context, tx pgx.Tx) error {
+			mediator := &command.SignupForceConfirmer{
+				ConfirmedEmailCache: s.confirmedEmailCache,
+				Email:               email,
+				ExtraListAddresses:  s.conf.ExtraListAddresses,
+				FromAddress:         s.conf.MailFromAddress,
+				ListAddress:         s.meta.ListAddress,
+				MailAPI:             s.mailAPI,
+				NotifyWebhook:       s.webhookNotifier != nil,
+				Renderer:            s.renderer,
+				ReplyToAddress:      s.conf.replyToAddress(),
+				SendWelcome:         s.conf.EnableWelcomeEmail,
+			}
+
+			var err error
+			res, err = mediator.Run(ctx, tx)
+			if err != nil {
+				return err
+			}
+
+			if res.EmailNotFound {
+				return nil
+			}
+
+			return recordAdminAction(ctx, tx, r, command.AdminActionForceConfirm, email)
+		})
+		if err != nil {
+			return xerrors.Errorf("error force confirming signup: %w", err)
+		}
+
+		if res.EmailNotFound {
+			return s.renderJSON(w, http.StatusNotFound, &adminErrorResponse{
+				Error: "subscriber not found",
+			})
+		}
+
+		logrus.Infof("Force confirmed signup for %v", email)
+		return s.renderJSON(w, http.StatusOK, res)
+	})
+}
+
+// handleAdminExport streams confirmed subscribers as CSV, compressed with
+// gzip if the client accepts it.
+func (s *Server) handleAdminExport(w http.ResponseWriter, r *http.Request) {
+	s.withErrorHandling(w, r, func() error {
+		if !s.allowMethods(w, r, http.MethodGet) {
+			return nil
+		}
+
+		// The export is recorded up front in its own transaction rather than
+		// with the export because rows are streamed to the client as they're
+		// read, so even an export that fails partway has disclosed data.
+		err := db.WithTransaction(r.Context(), s.txStarter, func(ctx context.Context, tx pgx.Tx) error {
+			return recordAdminAction(ctx, tx, r, command.AdminActionExport, "subscribers")
+		})
+		if err != nil {
+			return xerrors.Errorf("error recording export: %w", err)
+		}
+
+		useGzip := acceptsGzip(r)
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		exportWriter := &exportResponseWriter{
+			setHeaders: func() {
+				w.Header().Set("Content-Disposition", `attachment; filename="subscribers.csv"`)
+				w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+				if useGzip {
+					w.Header().Set("Content-Encoding", "gzip")
+				}
+			},
+			w: w,
+		}
+
+		_, err = s.ExportSubscribers(r.Context(), exportWriter, useGzip)
+		if err != nil {
+			if !exportWriter.written {
+				return xerrors.Errorf("error exporting subscribers: %w", err)
+			}
+
+			// Rows are written as they're read, so an error partway through
+			// can't be turned into an error response. The client gets a
+			// truncated export.
+			logrus.Errorf("Error exporting subscribers after response started: %v", err)
+		}
+
+		return nil
+	})
+}
+
+// handleAdminFunnel reports on signups started and confirmed within a recent
+// window, given by the `window` query parameter as a duration like `72h`.
+// Defaults to the last 24 hours.
+func (s *Server) handleAdminFunnel(w http.ResponseWriter, r *http.Request) {
+	s.withErrorHandling(w, r, func() error {
+		if !s.allowMethods(w, r, http.MethodGet) {
+			return nil
+		}
+
+		window := 24 * time.Hour
+		if windowStr := r.URL.Query().Get("window"); windowStr != "" {
+			var err error
+			window, err = time.ParseDuration(windowStr)
+			if err != nil || window <= 0 {
+				return s.renderJSON(w, http.StatusBadRequest, &adminErrorResponse{
+					Error: "expected query parameter window to be a positive duration like 24h",
+				})
+			}
+		}
+
+		var res *command.FunnelGetterResult
+		err := db.WithTransaction(r.Context(), s.txStarter, func(ctx context.Context, tx pgx.Tx) error {
+			mediator := &command.FunnelGetter{
+				Window: window,
+			}
+
+			var err error
+			res, err = mediator.Run(ctx, tx)
+			return err
+		})
+		if err != nil {
+			return xerrors.Errorf("error getting funnel: %w", err)
+		}
+
+		return s.renderJSON(w, http.StatusOK, &adminFunnelResponse{
+			FunnelGetterResult: res,
+			Window:             window.String(),
+		})
+	})
+}
+
+// handleAdminPreview renders the confirmation message for a token as it was
+// sent, for debugging rendering through the link included by
+// EnablePreviewLink.
+func (s *Server) handleAdminPreview(w http.ResponseWriter, r *http.Request) {
+	s.withErrorHandling(w, r, func() error {
+		if !s.allowMethods(w, r, http.MethodGet) {
+			return nil
+		}
+
+		vars := mux.Vars(r)
+		return s.renderer.RenderTemplate(w, "views/messages/confirm",
+			command.ConfirmationLocals(s.renderer, vars["token"], s.conf.EnablePreviewLink))
+	})
+}
+
+// handleAdminRotateToken replaces the confirmation token of a pending signup
+// and resends its confirmation, like when a token has leaked.
+func (s *Server) handleAdminRotateToken(w http.ResponseWriter, r *http.Request) {
+	s.withErrorHandling(w, r, func() error {
+		if !s.allowMethods(w, r, http.MethodPost) {
+			return nil
+		}
+
+		email := s.normalizeEmail(r.URL.Query().Get("email"))
+		if email == "" {
+			return s.renderJSON(w, http.StatusBadRequest, &adminErrorResponse{
+				Error: "expected query parameter email",
+			})
+		}
+
+		var res *command.SignupTokenRotatorResult
+		err := db.WithTransaction(r.Context(), s.txStarter, func(ctx context.Context, tx pgx.Tx) error {
+			mediator := &command.SignupTokenRotator{
+				Email:          email,
+				FromAddress:    s.conf.MailFromAddress,
+				ListAddress:    s.meta.ListAddress,
+				MailAPI:        s.mailAPI,
+				PreviewLink:    s.conf.EnablePreviewLink,
+				Renderer:       s.renderer,
+				ReplyToAddress: s.conf.replyToAddress(),
+				TokenSigner:    s.tokenSigner,
+				TokenTTL:       s.conf.ConfirmationTokenTTL,
+			}
+
+			var err error
+			res, err = mediator.Run(ctx, tx)
+			if err != nil {
+				return err
+			}
+
+			if res.EmailNotFound || res.SignupCompleted {
+				return nil
+			}
+
+			return recordAdminAction(ctx, tx, r, command.AdminActionRotateToken, email)
+		})
+		if err != nil {
+			return xerrors.Errorf("error rotating token: %w", err)
+		}
+
+		if res.EmailNotFound || res.SignupCompleted {
+			return s.renderJSON(w, http.StatusNotFound, &adminErrorResponse{
+				Error: "pending signup not found",
+			})
+		}
+
+		return s.renderJSON(w, http.StatusOK, res)
+	})
+}
+
+func (s *Server) handleAdminStats(w http.ResponseWriter, r *http.Request) {
+	s.withErrorHandling(w, r, func() error {
+		if !s.allowMethods(w, r, http.MethodGet) {
+			return nil
+		}
+
+		var res *command.StatsGetterResult
+		err := db.WithTransaction(r.Context(), s.txStarter, func(ctx context.Context, tx pgx.Tx) error {
+			mediator := &command.StatsGetter{}
+
+			var err error
+			res, err = mediator.Run(ctx, tx)
+			return err
+		})
+		if err != nil {
+			return xerrors.Errorf("error getting stats: %w", err)
+		}
+
+		return s.renderJSON(w, http.StatusOK, &adminStatsResponse{
+			StatsGetterResult:                  res,
+			NewsletterID:                       s.meta.ID,
+			NumConfirmationsCompletedSinceBoot: s.numConfirmationsCompleted.Load(),
+			NumRateLimitKeys:                   s.numRateLimitKeys(),
+		})
+	})
+}
+
+func (s *Server) handleAdminSubscriber(w http.ResponseWriter, r *http.Request) {
+	s.withErrorHandling(w, r, func() error {
+		if !s.allowMethods(w, r, http.MethodGet) {
+			return nil
+		}
+
+		email := s.normalizeEmail(r.URL.Query().Get("email"))
+		if email == "" {
+			return s.renderJSON(w, http.StatusBadRequest, &adminErrorResponse{
+				Error: "expected query parameter email",
+			})
+		}
+
+		var res *command.SubscriberGetterResult
+		err := db.WithTransaction(r.Context(), s.txStarter, func(ctx context.Context, tx pgx.Tx) error {
+			mediator := &command.SubscriberGetter{Email: email}
+
+			var err error
+			res, err = mediator.Run(ctx, tx)
+			return err
+		})
+		if err != nil {
+			return xerrors.Errorf("error getting subscriber: %w", err)
+		}
+
+		if res.SubscriberNotFound {
+			return s.renderJSON(w, http.StatusNotFound, &adminErrorResponse{
+				Error: "subscriber not found",
+			})
+		}
+
+		return s.renderJSON(w, http.StatusOK, res)
+	})
+}
+
+// handleAdminTemplates renders every view and message with sample data and
+// returns them as a zip file so that all states can be reviewed at once.
+func (s *Server) handleAdminTemplates(w http.ResponseWriter, r *http.Request) {
+	s.withErrorHandling(w, r, func() error {
+		if !s.allowMethods(w, r, http.MethodGet) {
+			return nil
+		}
+
+		// Render everything before writing any of the response so that a
+		// broken template still produces a normal error.
+		rendered := make([][]byte, len(templatePreviews))
+		for i, preview := range templatePreviews {
+			render := s.renderer.RenderTemplate
+			if preview.fragment {
+				render = s.renderer.RenderFragment
+			}
+
+			var buf bytes.Buffer
+			if err := render(&buf, preview.templateFile, preview.locals); err != nil {
+				return xerrors.Errorf("error rendering template %q: %w", preview.templateFile, err)
+			}
+			rendered[i] = buf.Bytes()
+		}
+
+		w.Header().Set("Content-Disposition", `attachment; filename="templates.zip"`)
+		w.Header().Set("Content-Type", "application/zip")
+
+		zipWriter := zip.NewWriter(w)
+		for i, preview := range templatePreviews {
+			name := preview.templateFile + ".html"
+			if strings.HasSuffix(preview.templateFile, "_plain") {
+				name = preview.templateFile + ".txt"
+			}
+
+			entryWriter, err := zipWriter.Create(name)
+			if err != nil {
+				return xerrors.Errorf("error creating zip entry: %w", err)
+			}
+			if _, err := entryWriter.Write(rendered[i]); err != nil {
+				return xerrors.Errorf("error writing zip entry: %w", err)
+			}
+		}
+
+		if err := zipWriter.Close(); err != nil {
+			return xerrors.Errorf("error closing zip: %w", err)
+		}
+		return nil
+	})
+}
+
+// handleAdminTestSend sends one of the messages normally sent to subscribers,
+// filled in with sample data, to any address so that it can be checked in a
+// real mail client.
+func (s *Server) handleAdminTestSend(w http.ResponseWriter, r *http.Request) {
+	s.withErrorHandling(w, r, func() error {
+		if !s.allowMethods(w, r, http.MethodPost) {
+			return nil
+		}
+
+		template := r.URL.Query().Get("template")
+		if template == "" {
+			return s.renderJSON(w, http.StatusBadRequest, &adminErrorResponse{
+				Error: "expected query parameter template",
+			})
+		}
+
+		to := strings.TrimSpace(r.URL.Query().Get("to"))
+		if to == "" {
+			return s.renderJSON(w, http.StatusBadRequest, &adminErrorResponse{
+				Error: "expected query parameter to",
+			})
+		}
+		if err := emailvalidator.Validate(to); err != nil {
+			return s.renderJSON(w, http.StatusBadRequest, &adminErrorResponse{
+				Error: fmt.Sprintf("query parameter to isn't a valid email: %v", err),
+			})
+		}
+
+		var res *command.SampleMessageSenderResult
+		err := db.WithTransaction(r.Context(), s.txStarter, func(ctx context.Context, tx pgx.Tx) error {
+			mediator := &command.SampleMessageSender{
+				FromAddress:    s.conf.MailFromAddress,
+				ListAddress:    s.meta.ListAddress,
+				MailAPI:        s.mailAPI,
+				Recipient:      to,
+				Renderer:       s.renderer,
+				ReplyToAddress: s.conf.replyToAddress(),
+				Template:       template,
+			}
+
+			var err error
+			res, err = mediator.Run(ctx, tx)
+			if err != nil {
+				return err
+			}
+
+			if res.UnknownTemplate {
+				return nil
+			}
+
+			return recordAdminAction(ctx, tx, r, command.AdminActionTestSend, template+" to "+to)
+		})
+		if err != nil {
+			return xerrors.Errorf("error sending test message: %w", err)
+		}
+
+		if res.UnknownTemplate {
+			return s.renderJSON(w, http.StatusBadRequest, &adminErrorResponse{
+				Error: fmt.Sprintf("unknown template %q (expected %q or %q)",
+					template, command.SampleMessageConfirm, command.SampleMessageWelcome),
+			})
+		}
+
+		return s.renderJSON(w, http.StatusOK, res)
+	})
+}
+
+func (s *Server) handleAdminWelcome(w http.ResponseWriter, r *http.Request) {
+	s.withErrorHandling(w, r, func() error {
+		if !s.allowMethods(w, r, http.MethodPost) {
+			return nil
+		}
+
+		email := s.normalizeEmail(r.URL.Query().Get("email"))
+		if email == "" {
+			return s.renderJSON(w, http.StatusBadRequest, &adminErrorResponse{
+				Error: "expected query parameter email",
+			})
+		}
+
+		var res *command.WelcomeSenderResult
+		err := db.WithTransaction(r.Context(), s.txStarter, func(ctx context.Context, tx pgx.Tx) error {
+			mediator := &command.WelcomeSender{
+				Email:          email,
+				FromAddress:    s.conf.MailFromAddress,
+				ListAddress:    s.meta.ListAddress,
+				MailAPI:        s.mailAPI,
+				Renderer:       s.renderer,
+				ReplyToAddress: s.conf.replyToAddress(),
+			}
+
+			var err error
+			res, err = mediator.Run(ctx, tx)
+			if err != nil {
+				return err
+			}
+
+			if res.SubscriberNotConfirmed {
+				return nil
+			}
+
+			return recordAdminAction(ctx, tx, r, command.AdminActionSendWelcome, email)
+		})
+		if err != nil {
+			return xerrors.Errorf("error sending welcome: %w", err)
+		}
+
+		if res.SubscriberNotConfirmed {
+			return s.renderJSON(w, http.StatusNotFound, &adminErrorResponse{
+				Error: "confirmed subscriber not found",
+			})
+		}
+
+		return s.renderJSON(w, http.StatusOK, res)
+	})
+}
+
 func (s *Server) handleConfirm(w http.ResponseWriter, r *http.Request) {
-	s.withErrorHandling(w, func() error {
+	s.withErrorHandling(w, r, func() error {
+		if !s.allowMethods(w, r, http.MethodGet) {
+			return nil
+		}
+
 		vars := mux.Vars(r)
 		token := vars["token"]
 
+		// Reject obvious garbage without a trip to the database.
+		if !wellFormedConfirmToken(token) {
+			// Resolve the catalog first because it sets headers, which
+			// would be dropped once the status is written.
+			catalog := messageCatalogFor(w, r)
+
+			w.WriteHeader(http.StatusBadRequest)
+			return s.renderer.RenderTemplate(w, "views/ok", map[string]interface{}{
+				"message": catalog.Format(messagecatalog.ConfirmTokenMalformed, s.conf.PublicURL),
+			})
+		}
+
 		var res *command.SignupFinisherResult
 		err := db.WithTransaction(r.Context(), s.txStarter, func(ctx context.Context, tx pgx.Tx) error {
 			mediator := &command.SignupFinisher{
-				ListAddress: s.meta.ListAddress,
-				MailAPI:     s.mailAPI,
-				Token:       token,
+				ConfirmedEmailCache: s.confirmedEmailCache,
+				ExtraListAddresses:  s.conf.ExtraListAddresses,
+				FromAddress:         s.conf.MailFromAddress,
+				ListAddress:         s.meta.ListAddress,
+				MailAPI:             s.mailAPI,
+				NotifyWebhook:       s.webhookNotifier != nil,
+				Renderer:            s.renderer,
+				ReplyToAddress:      s.conf.replyToAddress(),
+				SendWelcome:         s.conf.EnableWelcomeEmail,
+				Token:               token,
+				TokenSigner:         s.tokenSigner,
+				VerifyMemberAdd:     s.conf.VerifyMemberAdd,
 			}
 
 			var err error
 			res, err = mediator.Run(ctx, tx)
+			if err == nil && res.Cancelled {
+				return errRequestCancelled
+			}
 			return err
 		})
+
+		// The client is gone, so there's nobody to respond to.
+		if errors.Is(err, errRequestCancelled) {
+			return nil
+		}
+
+		if errors.Is(err, mailclient.ErrUnavailable) {
+			return s.renderUnavailable(w)
+		}
 		if err != nil {
 			return xerrors.Errorf("error finishing signup: %w", err)
 		}
 
+		if res.SignupFinished {
+			s.numConfirmationsCompleted.Add(1)
+		}
+
+		catalog := messageCatalogFor(w, r)
+
 		var message string
-		if res.TokenNotFound {
+		switch {
+		case res.TokenExpired:
+			w.WriteHeader(http.StatusGone)
+			message = catalog.Format(messagecatalog.ConfirmTokenExpired, s.conf.PublicURL)
+		case res.TokenNotFound:
 			w.WriteHeader(http.StatusNotFound)
-			message = "We couldn't find that confirmation token."
-		} else {
-			message = fmt.Sprintf(`<p>You've been signed up successfully.</p><p>You'll receive your first edition of <em>%s</em> at <strong>%s</strong> the next time one is published.</p>`, s.meta.Name, res.Email)
+			message = catalog.Format(messagecatalog.ConfirmTokenNotFound)
+		case res.MemberAddDeferred:
+			message = catalog.Format(messagecatalog.ConfirmMemberAddDeferred, s.meta.Name, res.Email)
+		default:
+			message = catalog.Format(messagecatalog.ConfirmSuccess, s.meta.Name, res.Email)
+		}
+
+		if res.SignupFinished {
+			return s.renderer.RenderTemplate(w, "views/ok", s.successLocals(message))
 		}
 
 		return s.renderer.RenderTemplate(w, "views/ok", map[string]interface{}{
@@ -289,87 +2276,351 @@ func (s *Server) handleConfirm(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func (s *Server) handleShow(w http.ResponseWriter, _ *http.Request) {
-	s.withErrorHandling(w, func() error {
-		return s.renderer.RenderTemplate(w, "views/show", map[string]interface{}{})
+// handleForm renders only the signup form without the page's layout so that
+// it can be embedded elsewhere, like by fetching it with JavaScript.
+func (s *Server) handleForm(w http.ResponseWriter, r *http.Request) {
+	s.withErrorHandling(w, r, func() error {
+		if !s.allowMethods(w, r, http.MethodGet) {
+			return nil
+		}
+
+		locals, err := s.formLocals(w, r)
+		if err != nil {
+			return err
+		}
+
+		return s.renderer.RenderFragment(w, "views/form", locals)
+	})
+}
+
+func (s *Server) handleMailgunWebhook(w http.ResponseWriter, r *http.Request) {
+	s.withErrorHandling(w, r, func() error {
+		if !s.allowMethods(w, r, http.MethodPost) {
+			return nil
+		}
+
+		var payload mailclient.WebhookPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			s.renderError(w, http.StatusBadRequest,
+				xerrors.Errorf("error decoding webhook payload: %w", err))
+			return nil
+		}
+
+		if !mailclient.VerifyWebhookSignature(s.conf.MailgunWebhookSigningKey, &payload.Signature) {
+			s.renderError(w, http.StatusUnauthorized,
+				xerrors.Errorf("invalid webhook signature"))
+			return nil
+		}
+
+		// Events other than hard bounces and complaints are acknowledged
+		// without action so that Mailgun doesn't retry them.
+		eventData := &payload.EventData
+		if eventData.Recipient == "" || (!eventData.IsPermanentFailure() && !eventData.IsComplaint()) {
+			w.WriteHeader(http.StatusOK)
+			return nil
+		}
+
+		err := db.WithTransaction(r.Context(), s.txStarter, func(ctx context.Context, tx pgx.Tx) error {
+			reason := command.SuppressionReasonComplaint
+			if eventData.IsPermanentFailure() {
+				reason = command.SuppressionReasonBounce
+
+				mediator := &command.BounceRecorder{
+					Email: s.normalizeEmail(eventData.Recipient),
+				}
+
+				if _, err := mediator.Run(ctx, tx); err != nil {
+					return err
+				}
+			}
+
+			mediator := &command.SuppressionRecorder{
+				Email:  s.normalizeEmail(eventData.Recipient),
+				Reason: reason,
+			}
+
+			_, err := mediator.Run(ctx, tx)
+			return err
+		})
+		if err != nil {
+			return xerrors.Errorf("error handling webhook event %q: %w", eventData.Event, err)
+		}
+
+		w.WriteHeader(http.StatusOK)
+		return nil
+	})
+}
+
+// handleMetrics reports gauges in Prometheus' text format. Values are as of
+// the last tick of the worker that updates them, so serving them doesn't touch
+// the database.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	fmt.Fprintf(w, "# HELP email_outbox_pending Messages waiting in the outbox to be sent.\n")
+	fmt.Fprintf(w, "# TYPE email_outbox_pending gauge\n")
+	fmt.Fprintf(w, "email_outbox_pending %d\n", s.numOutboxPending.Load())
+}
+
+// handleNotFound renders a styled error page for any path that doesn't match a
+// route instead of the mux's default plain text response.
+func (s *Server) handleNotFound(w http.ResponseWriter, _ *http.Request) {
+	s.renderError(w, http.StatusNotFound, xerrors.New("page not found"))
+}
+
+func (s *Server) handleShow(w http.ResponseWriter, r *http.Request) {
+	s.withErrorHandling(w, r, func() error {
+		if !s.allowMethods(w, r, http.MethodGet, http.MethodHead) {
+			return nil
+		}
+
+		// The page only varies by newsletter, templates, and attribution, so
+		// repeat visitors can use their cached copy. Sessions and form nonces
+		// need to be fresh on every load though, so they can't be cached.
+		if s.templatesVersion != "" && s.sessionManager == nil && s.formNonceManager == nil {
+			etag := s.showETag(attributionFromValues(r.URL.Query()))
+			w.Header().Set("ETag", etag)
+
+			if etagMatches(r.Header.Get("If-None-Match"), etag) {
+				w.WriteHeader(http.StatusNotModified)
+				return nil
+			}
+		}
+
+		locals, err := s.formLocals(w, r)
+		if err != nil {
+			return err
+		}
+
+		// HEAD requests, like those from uptime monitors, get the same
+		// headers as GET, but there's no sense rendering a body that'd be
+		// thrown away.
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			return nil
+		}
+
+		return s.renderer.RenderTemplate(w, "views/show", locals)
 	})
 }
 
-func (s *Server) handleShowConfirmMessagePreview(w http.ResponseWriter, _ *http.Request) {
-	s.withErrorHandling(w, func() error {
+func (s *Server) handleShowConfirmMessagePreview(w http.ResponseWriter, r *http.Request) {
+	s.withErrorHandling(w, r, func() error {
 		return s.renderer.RenderTemplate(w, "views/messages/confirm", map[string]interface{}{
 			"token": "bc492bd9-2aea-458a-aea1-cd7861c334d1",
 		})
 	})
 }
 
-func (s *Server) handleShowConfirmMessagePlainPreview(w http.ResponseWriter, _ *http.Request) {
-	s.withErrorHandling(w, func() error {
+func (s *Server) handleShowConfirmMessagePlainPreview(w http.ResponseWriter, r *http.Request) {
+	s.withErrorHandling(w, r, func() error {
 		return s.renderer.RenderTemplate(w, "views/messages/confirm_plain", map[string]interface{}{
 			"token": "bc492bd9-2aea-458a-aea1-cd7861c334d1",
 		})
 	})
 }
 
-func (s *Server) handleShowMaintenance(w http.ResponseWriter, _ *http.Request) {
-	s.withErrorHandling(w, func() error {
+func (s *Server) handleShowMaintenance(w http.ResponseWriter, r *http.Request) {
+	s.withErrorHandling(w, r, func() error {
 		return s.renderer.RenderTemplate(w, "views/maintenance", map[string]interface{}{})
 	})
 }
 
+// handleShowMessagesSent shows messages recorded by the fake mail client so
+// that they can be inspected in development without sending real email.
+func (s *Server) handleShowMessagesSent(w http.ResponseWriter, r *http.Request) {
+	s.withErrorHandling(w, r, func() error {
+		if !s.allowMethods(w, r, http.MethodGet) {
+			return nil
+		}
+
+		fakeClient, ok := s.mailAPI.(*mailclient.FakeClient)
+		if !ok {
+			return s.renderJSON(w, http.StatusNotFound, &adminErrorResponse{
+				Error: "sent messages are only recorded by the fake mail client",
+			})
+		}
+
+		messagesSent := fakeClient.MessagesSent
+		if messagesSent == nil {
+			messagesSent = []*mailclient.FakeClientAPIMessageSent{}
+		}
+
+		return s.renderJSON(w, http.StatusOK, &devMessagesSentResponse{
+			MessagesSent: messagesSent,
+		})
+	})
+}
+
 func (s *Server) handleSubmit(w http.ResponseWriter, r *http.Request) {
-	s.withErrorHandling(w, func() error {
+	s.withErrorHandling(w, r, func() error {
 		// Only accept form POSTs.
-		if r.Method != http.MethodPost {
-			http.NotFound(w, r)
+		if !s.allowMethods(w, r, http.MethodPost) {
 			return nil
 		}
 
+		if s.sessionManager != nil {
+			if _, err := s.sessionManager.Validate(r); err != nil {
+				logrus.Infof("Rejecting submission with invalid session: %v", err)
+				s.renderError(w, http.StatusBadRequest,
+					xerrors.New("your session is missing or has expired; please reload the page and try again"))
+				return nil
+			}
+		}
+
 		err := r.ParseForm()
 		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				s.renderError(w, http.StatusRequestEntityTooLarge,
+					xerrors.Errorf("request body too large (maximum is %d bytes)", maxBytesErr.Limit))
+				return nil
+			}
+
 			s.renderError(w, http.StatusBadRequest,
 				xerrors.Errorf("error parsing form input: %w", err))
 			return nil
 		}
 
-		email := r.Form.Get("email")
+		email := r.Form.Get(s.conf.FormEmailField)
 		if email == "" {
 			s.renderError(w, http.StatusUnprocessableEntity,
-				xerrors.Errorf("expected input parameter email"))
+				xerrors.Errorf("expected input parameter %s", s.conf.FormEmailField))
 			return nil
 		}
 
+		// Set once a signup goes ahead, like by sending a confirmation. Until
+		// then, the form nonce is released on return.
+		var signupWentAhead bool
+
+		if s.formNonceManager != nil {
+			nonce := r.Form.Get(formnonce.FieldName)
+			err := s.formNonceManager.Consume(r.Context(), nonce)
+			switch {
+			case errors.Is(err, formnonce.ErrReused):
+				logrus.Infof("Rejecting replayed submission: %v", err)
+				s.renderError(w, http.StatusBadRequest,
+					xerrors.New("this form was already submitted; please reload the page to submit it again"))
+				return nil
+			case errors.Is(err, formnonce.ErrExpired) || errors.Is(err, formnonce.ErrInvalidSignature) ||
+				errors.Is(err, formnonce.ErrMalformed) || errors.Is(err, formnonce.ErrMissing):
+				logrus.Infof("Rejecting submission with invalid form nonce: %v", err)
+				s.renderError(w, http.StatusBadRequest,
+					xerrors.New("your form is missing or has expired; please reload the page and try again"))
+				return nil
+			case err != nil:
+				return err
+			}
+
+			// The nonce is consumed up front so that concurrent replays are
+			// rejected, but released again if the signup doesn't go ahead so
+			// that the user can fix a mistake, like a typo in their email, and
+			// resubmit the same form.
+			defer func() {
+				if signupWentAhead {
+					return
+				}
+				if err := s.formNonceManager.Release(context.WithoutCancel(r.Context()), nonce); err != nil {
+					logrus.Errorf("Error releasing form nonce: %v", err)
+				}
+			}()
+		}
+
 		email = strings.TrimSpace(email)
 
 		var res *command.SignupStarterResult
 		err = db.WithTransaction(r.Context(), s.txStarter, func(ctx context.Context, tx pgx.Tx) error {
-			logrus.Infof("starting mediator ...")
+			logrus.Debugf("starting mediator ...")
 
 			mediator := &command.SignupStarter{
-				Email:          email,
-				ListAddress:    s.meta.ListAddress,
-				MailAPI:        s.mailAPI,
-				Renderer:       s.renderer,
-				ReplyToAddress: replyToAddress,
+				AttemptsWindow:              s.conf.ConfirmationAttemptsWindow,
+				Attribution:                 attributionFromValues(r.Form),
+				ConfirmationDelayMax:        s.conf.ConfirmationDelayMax,
+				ConfirmationDelayMin:        s.conf.ConfirmationDelayMin,
+				ConfirmedEmailCache:         s.confirmedEmailCache,
+				Email:                       email,
+				EmailBlocklist:              s.emailBlocklist,
+				EmailCasePolicy:             command.EmailCasePolicy(s.conf.EmailCasePolicy),
+				FromAddress:                 s.conf.MailFromAddress,
+				ListAddress:                 s.meta.ListAddress,
+				MailAPI:                     s.mailAPI,
+				MaxEmailLength:              s.conf.MaxEmailLength,
+				MaxSignupsPerDay:            s.conf.MaxSignupsPerDay,
+				MXResolver:                  s.emailChecker.MXResolver,
+				NotifyWebhook:               s.webhookNotifier != nil,
+				PreviewLink:                 s.conf.EnablePreviewLink,
+				Renderer:                    s.renderer,
+				ReplyToAddress:              s.conf.replyToAddress(),
+				ResendWindow:                s.conf.ConfirmationResendWindow,
+				SingleOptIn:                 !s.conf.RequireConfirmation,
+				TokenSigner:                 s.tokenSigner,
+				TokenTTL:                    s.conf.ConfirmationTokenTTL,
+				TrustLocalSubscriptionState: s.conf.TrustLocalSubscriptionState,
 			}
 
 			var err error
 			res, err = mediator.Run(ctx, tx)
+			if err == nil && res.Cancelled {
+				return errRequestCancelled
+			}
 			return err
 		})
 
+		// The client is gone, so there's nobody to respond to.
+		if errors.Is(err, errRequestCancelled) {
+			return nil
+		}
+
 		var message string
+		if errors.Is(err, command.ErrEmailTooLong) || errors.Is(err, command.ErrInvalidEmail) ||
+			errors.Is(err, command.ErrNoMailServers) {
+			s.renderError(w, http.StatusUnprocessableEntity, err)
+			return nil
+		}
+		if errors.Is(err, command.ErrBlockedEmail) {
+			s.renderError(w, http.StatusForbidden, err)
+			return nil
+		}
+		if errors.Is(err, mailclient.ErrUnavailable) {
+			return s.renderUnavailable(w)
+		}
 		if err != nil {
 			return xerrors.Errorf("error sending confirmation email: %w", err)
 		}
 
+		catalog := messageCatalogFor(w, r)
+
 		switch {
+		case res.AlreadySubscribed:
+			message = catalog.Format(messagecatalog.SubmitAlreadySubscribed, email, s.meta.Name)
+		case res.Bounced:
+			message = catalog.Format(messagecatalog.SubmitBounced, email, s.meta.Name)
+		case res.GlobalLimitReached:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			message = catalog.Format(messagecatalog.SubmitGlobalLimitReached, s.meta.Name)
+		case res.DirectlySubscribed:
+			message = catalog.Format(messagecatalog.SubmitDirectlySubscribed, s.meta.Name, email)
 		case res.ConfirmationRateLimited:
-			message = fmt.Sprintf("<p>Thank you for signing up!</p><p>I recently sent a confirmation email to <strong>%s</strong> and don't want to send another one so soon after. Please try to find the message and click the enclosed link to finish signing up for <em>%s</em>. If you can't find it, try checking your spam folder.</p>", email, s.meta.Name)
+			message = catalog.Format(messagecatalog.SubmitConfirmationRateLimited, email, s.meta.Name)
 		case res.MaxNumAttempts:
-			message = fmt.Sprintf("<p>Thank you for signing up!</p><p>I've hit the maximum number of confirmation tries for this email address. Please try to find the message and click the enclosed link to finish signing up for <em>%s</em>. If you can't find it, try checking your spam folder.</p>", s.meta.Name)
+			message = catalog.Format(messagecatalog.SubmitMaxNumAttempts, s.meta.Name)
+		case res.Suppressed:
+			message = catalog.Format(messagecatalog.SubmitSuppressed, email, s.meta.Name)
 		default:
-			message = fmt.Sprintf("<p>Thank you for signing up!</p><p>I've sent a confirmation email to <strong>%s</strong>. Please click the enclosed link to finish signing up for <em>%s</em>.</p>", email, s.meta.Name)
+			message = catalog.Format(messagecatalog.SubmitConfirmationSent, email, s.meta.Name)
+		}
+
+		signupWentAhead = res.NewSignup || res.ConfirmationResent || res.DirectlySubscribed
+
+		// Only a signup that actually went ahead gets the call to action. Other
+		// outcomes, like being rate limited or suppressed, need the user's
+		// attention first.
+		if signupWentAhead {
+			return s.renderer.RenderTemplate(w, "views/ok", s.successLocals(message))
 		}
 
 		return s.renderer.RenderTemplate(w, "views/ok", map[string]interface{}{
@@ -378,10 +2629,182 @@ func (s *Server) handleSubmit(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// handleUnsubscribe unsubscribes the email of the signup identified by the
+// token in the URL.
+//
+// A GET only renders a page asking the user to confirm, because mail clients
+// and link scanners prefetch links. The unsubscribe itself happens on POST,
+// which is either the confirmation page's form or a one-click unsubscribe
+// from a mail client per RFC 8058.
+func (s *Server) handleUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	s.withErrorHandling(w, r, func() error {
+		if !s.allowMethods(w, r, http.MethodGet, http.MethodPost) {
+			return nil
+		}
+
+		vars := mux.Vars(r)
+		token := vars["token"]
+
+		var res *command.UnsubscriberResult
+		err := db.WithTransaction(r.Context(), s.txStarter, func(ctx context.Context, tx pgx.Tx) error {
+			mediator := &command.Unsubscriber{
+				ConfirmedEmailCache: s.confirmedEmailCache,
+				ListAddress:         s.meta.ListAddress,
+				LookupOnly:          r.Method == http.MethodGet,
+				MailAPI:             s.mailAPI,
+				Token:               token,
+			}
+
+			var err error
+			res, err = mediator.Run(ctx, tx)
+			return err
+		})
+		if errors.Is(err, mailclient.ErrUnavailable) {
+			return s.renderUnavailable(w)
+		}
+		if err != nil {
+			return xerrors.Errorf("error unsubscribing: %w", err)
+		}
+
+		if res.TokenNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			return s.renderer.RenderTemplate(w, "views/ok", map[string]interface{}{
+				"message": "We couldn't find that unsubscribe token.",
+			})
+		}
+
+		if !res.AlreadyUnsubscribed && !res.Unsubscribed {
+			return s.renderer.RenderTemplate(w, "views/unsubscribe", map[string]interface{}{
+				"email": res.Email,
+				"token": token,
+			})
+		}
+
+		return s.renderer.RenderTemplate(w, "views/unsubscribed", map[string]interface{}{
+			"alreadyUnsubscribed": res.AlreadyUnsubscribed,
+			"email":               res.Email,
+		})
+	})
+}
+
+// handleValidate checks an email in the same way as a signup would, but
+// without storing or sending anything, so that a frontend can give inline
+// feedback.
+func (s *Server) handleValidate(w http.ResponseWriter, r *http.Request) {
+	s.withErrorHandling(w, r, func() error {
+		if !s.allowMethods(w, r, http.MethodPost) {
+			return nil
+		}
+
+		err := r.ParseForm()
+		if err != nil {
+			return s.renderJSON(w, http.StatusBadRequest, &validateResponse{
+				Reason: "error parsing form input",
+			})
+		}
+
+		email := strings.TrimSpace(r.Form.Get(s.conf.FormEmailField))
+		if email == "" {
+			return s.renderJSON(w, http.StatusUnprocessableEntity, &validateResponse{
+				Reason: "expected input parameter " + s.conf.FormEmailField,
+			})
+		}
+
+		err = s.emailChecker.Check(r.Context(), email)
+		if errors.Is(err, command.ErrEmailTooLong) || errors.Is(err, command.ErrInvalidEmail) ||
+			errors.Is(err, command.ErrNoMailServers) {
+			return s.renderJSON(w, http.StatusOK, &validateResponse{Reason: err.Error()})
+		}
+		if err != nil {
+			return xerrors.Errorf("error checking email: %w", err)
+		}
+
+		return s.renderJSON(w, http.StatusOK, &validateResponse{Valid: true})
+	})
+}
+
 //
 // Private functions
 //
 
+// allowMethods checks that the request's method is one of the given methods.
+// If it isn't, a 405 is rendered along with an `Allow` header listing the
+// acceptable methods, and false is returned so that the caller can bail.
+func (s *Server) allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	s.renderError(w, http.StatusMethodNotAllowed,
+		xerrors.Errorf("method %s not allowed", r.Method))
+	return false
+}
+
+// formLocals prepares to render the signup form, issuing a session and form
+// nonce if they're enabled and returning locals for the template.
+func (s *Server) formLocals(w http.ResponseWriter, r *http.Request) (map[string]interface{}, error) {
+	if s.sessionManager != nil {
+		if _, err := s.sessionManager.Issue(w); err != nil {
+			return nil, err
+		}
+	}
+
+	// UTM parameters are carried through the form as hidden fields so that
+	// they're submitted with the signup.
+	locals := map[string]interface{}{
+		"attribution": attributionFromValues(r.URL.Query()),
+		"emailField":  s.conf.FormEmailField,
+	}
+
+	if s.formNonceManager != nil {
+		nonce, err := s.formNonceManager.Issue()
+		if err != nil {
+			return nil, err
+		}
+		locals["formNonce"] = nonce
+		locals["formNonceField"] = formnonce.FieldName
+	}
+
+	return locals, nil
+}
+
+// getRateLimiter initializes the named rate limiter for the given quota backed
+// by a new store from newRateLimitStore. It varies by remote IP under a prefix
+// of the limiter's name and newsletter. Requests are allowed through when the
+// limiter errors unless RateLimiterFailClosed is set.
+func (s *Server) getRateLimiter(name string, quota throttled.RateQuota) (*throttled.HTTPRateLimiter, error) {
+	keyPrefix := name + ":" + s.conf.NewsletterID
+
+	store, err := s.newRateLimitStore(keyPrefix)
+	if err != nil {
+		return nil, xerrors.Errorf("error initializing rate limit store: %w", err)
+	}
+	s.rateLimitStores = append(s.rateLimitStores, store)
+
+	return newRateLimiter(store, keyPrefix, quota, !s.conf.RateLimiterFailClosed)
+}
+
+// normalizeEmail trims an email from outside the app, like from an admin
+// endpoint or a Mailgun webhook, and normalizes its case with EmailCasePolicy
+// so that it matches the signup stored for it.
+func (s *Server) normalizeEmail(email string) string {
+	return command.EmailCasePolicy(s.conf.EmailCasePolicy).Apply(strings.TrimSpace(email))
+}
+
+// numRateLimitKeys returns the number of keys across the stores of all of
+// the server's rate limiters, which is roughly the number of distinct
+// clients that they're tracking.
+func (s *Server) numRateLimitKeys() int {
+	var numKeys int
+	for _, store := range s.rateLimitStores {
+		numKeys += store.NumKeys()
+	}
+	return numKeys
+}
+
 func (s *Server) renderError(w http.ResponseWriter, status int, renderErr error) {
 	w.WriteHeader(status)
 
@@ -394,66 +2817,427 @@ func (s *Server) renderError(w http.ResponseWriter, status int, renderErr error)
 	}
 }
 
-func (s *Server) withErrorHandling(w http.ResponseWriter, fn func() error) {
+func (s *Server) renderJSON(w http.ResponseWriter, status int, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return xerrors.Errorf("error encoding JSON: %w", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(data); err != nil {
+		return xerrors.Errorf("error writing response: %w", err)
+	}
+	return nil
+}
+
+// renderUnavailable renders a graceful message asking the user to try again
+// later for when the mail service is having trouble. Any transaction will have
+// been rolled back, so trying again is safe.
+func (s *Server) renderUnavailable(w http.ResponseWriter) error {
+	w.WriteHeader(http.StatusServiceUnavailable)
+	return s.renderer.RenderTemplate(w, "views/ok", map[string]interface{}{
+		"message": fmt.Sprintf("<p>Sorry, the mail service for <em>%s</em> is temporarily unavailable.</p><p>Please try again in a few minutes.</p>", s.meta.Name),
+	})
+}
+
+// sendOutboxMessages sends up to outboxBatchSize due messages from the
+// outbox, adding to the counts in res.
+func (s *Server) sendOutboxMessages(ctx context.Context, res *SendOutboxResult) error {
+	for i := 0; i < outboxBatchSize; i++ {
+		var senderRes *command.OutboxSenderResult
+		err := db.WithTransaction(ctx, s.txStarter, func(ctx context.Context, tx pgx.Tx) error {
+			mediator := &command.OutboxSender{
+				MailAPI: s.mailAPI,
+			}
+
+			var err error
+			senderRes, err = mediator.Run(ctx, tx)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case senderRes.NoneDue:
+			return nil
+		case senderRes.Abandoned:
+			res.NumAbandoned++
+		case senderRes.Failed:
+			res.NumFailed++
+		case senderRes.Sent:
+			res.NumSent++
+		}
+	}
+
+	return nil
+}
+
+// sendOutboxWebhooks sends up to outboxBatchSize due webhooks from the outbox,
+// adding to the counts in res.
+func (s *Server) sendOutboxWebhooks(ctx context.Context, res *SendOutboxResult) error {
+	for i := 0; i < outboxBatchSize; i++ {
+		var senderRes *command.WebhookOutboxSenderResult
+		err := db.WithTransaction(ctx, s.txStarter, func(ctx context.Context, tx pgx.Tx) error {
+			mediator := &command.WebhookOutboxSender{
+				WebhookNotifier: s.webhookNotifier,
+			}
+
+			var err error
+			senderRes, err = mediator.Run(ctx, tx)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case senderRes.NoneDue:
+			return nil
+		case senderRes.Abandoned:
+			res.NumAbandoned++
+		case senderRes.Failed:
+			res.NumFailed++
+		case senderRes.Sent:
+			res.NumSent++
+		}
+	}
+
+	return nil
+}
+
+// showETag produces an ETag for the show page from everything that it varies
+// by. Configuration that affects rendering is included so that a deploy that
+// changes it invalidates cached copies even if the templates didn't change.
+func (s *Server) showETag(attribution *command.Attribution) string {
+	hash := sha256.New()
+	for _, part := range []string{
+		s.meta.ID,
+		s.templatesVersion,
+		s.conf.FormEmailField,
+		s.conf.PublicURL,
+		strconv.FormatBool(s.conf.RequireFormNonce),
+		strconv.FormatBool(s.conf.requireSession()),
+		s.conf.SuccessCTAText,
+		s.conf.SuccessCTAURL,
+		attribution.Campaign,
+		attribution.Medium,
+		attribution.Source,
+	} {
+		hash.Write([]byte(part + "\x00"))
+	}
+
+	return `"` + hex.EncodeToString(hash.Sum(nil))[:32] + `"`
+}
+
+// successLocals returns locals for rendering the ok page after a successful
+// signup or confirmation, which include the configured call to action.
+func (s *Server) successLocals(message string) map[string]interface{} {
+	return map[string]interface{}{
+		"message":        message,
+		"successCTAText": s.conf.SuccessCTAText,
+		"successCTAURL":  s.conf.SuccessCTAURL,
+	}
+}
+
+// withErrorHandling runs fn and renders an error page if it fails. Errors are
+// logged with the request's ID so that a user reporting one can be matched
+// with its log line.
+func (s *Server) withErrorHandling(w http.ResponseWriter, r *http.Request, fn func() error) {
 	if err := fn(); err != nil {
-		logrus.Errorf("Internal server error: %v", err)
+		logger := logrus.WithField(logFieldRequestID, middleware.RequestID(r))
+
+		// Invalid user input is the user's to fix rather than a server error.
+		var validationErr *validation.Error
+		if errors.As(err, &validationErr) && validationErr.UserFacing {
+			logger.Infof("Validation error: %v", err)
+			s.renderError(w, http.StatusUnprocessableEntity, validationErr)
+			return
+		}
+
+		logger.Errorf("Internal server error: %v", err)
 		s.renderError(w, http.StatusInternalServerError, err)
 		return
 	}
 }
 
-func getRateLimiter() (*throttled.HTTPRateLimiter, error) {
-	// We use a memory store instead of something like Redis because for the
-	// time being we know that this app will only ever run on a single dyno. If
-	// that invariant ever changes, the decision should be revisited.
-	//
-	// All state is lost when the dyno goes to sleep, but since we're using
-	// small time scales anyway, that's fine.
-	//
-	// Note the argument here is the maximum number of allowed keys. Dynos are
-	// relatively large, so pick a number big enough to give us a lot of
-	// leeway.
-	store, err := memstore.New(65536)
-	if err != nil {
-		return nil, xerrors.Errorf("error initializing memory store: %w", err)
+// acceptsGzip checks whether a request's Accept-Encoding header allows a gzip
+// response. An encoding with a quality of zero is explicitly not acceptable.
+func acceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(encoding, ";")
+		name = strings.TrimSpace(name)
+		if name != "gzip" && name != "*" {
+			continue
+		}
+
+		return messagecatalog.ParseQuality(params) > 0
 	}
+	return false
+}
 
-	quota := throttled.RateQuota{
-		MaxBurst: 20,
-		MaxRate:  throttled.PerSec(5),
+// attributionFromValues extracts sanitized UTM parameters from a query string
+// or form.
+func attributionFromValues(values url.Values) *command.Attribution {
+	return command.NewAttribution(
+		values.Get("utm_campaign"),
+		values.Get("utm_medium"),
+		values.Get("utm_source"),
+	)
+}
+
+// etagMatches checks whether an If-None-Match header matches the given ETag.
+// The header may contain a list of ETags, which may be weak.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedProto extracts the scheme of the original request as reported by a
+// proxy, first from protoHeader (e.g. `X-Forwarded-Proto`), then from the
+// `proto` parameter of a standard `Forwarded` header (RFC 7239). The scheme is
+// normalized to lowercase, and if a header lists more than one, the first is
+// used. Returns an empty string if neither is present.
+func forwardedProto(req *http.Request, protoHeader string) string {
+	if proto := req.Header.Get(protoHeader); proto != "" {
+		// Chained proxies may append their own scheme to the list. The first
+		// one was added by the proxy closest to the client.
+		proto, _, _ = strings.Cut(proto, ",")
+		return strings.ToLower(strings.TrimSpace(proto))
+	}
+
+	forwarded := req.Header.Get("Forwarded")
+	if forwarded == "" {
+		return ""
+	}
+
+	// Multiple proxies append comma-separated elements. The first one was
+	// added by the proxy closest to the client.
+	element, _, _ := strings.Cut(forwarded, ",")
+	for _, pair := range strings.Split(element, ";") {
+		key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if ok && strings.EqualFold(key, "proto") {
+			return strings.ToLower(strings.TrimSpace(strings.Trim(value, `"`)))
+		}
 	}
 
-	rateLimiter, err := throttled.NewGCRARateLimiter(store, quota)
+	return ""
+}
+
+// limitRequestBody wraps request bodies in a reader that errors after maxBytes
+// have been read so that handlers can't be made to buffer arbitrarily large
+// inputs.
+func limitRequestBody(next http.Handler, maxBytes int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// messageCatalogFor selects the message catalog for the request's
+// Accept-Language header, noting the choice in the response's headers. Must be
+// called before the response's status is written.
+func messageCatalogFor(w http.ResponseWriter, r *http.Request) *messagecatalog.Catalog {
+	catalog := messagecatalog.ForAcceptLanguage(r.Header.Get("Accept-Language"))
+	w.Header().Set("Content-Language", catalog.Language)
+	w.Header().Add("Vary", "Accept-Language")
+	return catalog
+}
+
+// newRateLimiter initializes a rate limiter for the given quota backed by
+// store that varies by keyPrefix and remote IP. If failOpen is set, requests
+// are allowed through when the limiter errors.
+func newRateLimiter(store throttled.GCRAStore, keyPrefix string, quota throttled.RateQuota,
+	failOpen bool,
+) (*throttled.HTTPRateLimiter, error) {
+	gcraRateLimiter, err := throttled.NewGCRARateLimiter(store, quota)
 	if err != nil {
 		return nil, xerrors.Errorf("error initializing rate limiter: %w", err)
 	}
 
+	var rateLimiter throttled.RateLimiter = gcraRateLimiter
+	if failOpen {
+		rateLimiter = &failOpenRateLimiter{rateLimiter: rateLimiter}
+	}
+
 	deniedHandler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
 		http.Error(w, "Rate limit exceeded. Sorry about that -- please try again in a few seconds.", http.StatusTooManyRequests)
 	}))
 
-	// Vary based off of remote IP.
+	// Vary based off of key prefix and remote IP.
 	return &throttled.HTTPRateLimiter{
 		DeniedHandler: deniedHandler,
 		RateLimiter:   rateLimiter,
-		VaryBy:        &throttled.VaryBy{RemoteAddr: true},
+		VaryBy:        &prefixedVaryBy{prefix: keyPrefix},
 	}, nil
 }
 
-func redirectToHTTPS(next http.Handler) http.Handler {
+// newRenderer initializes a template renderer for the given configuration and
+// newsletter.
+func newRenderer(conf *Conf, meta *newslettermeta.Meta) (*ptemplate.Renderer, error) {
+	// Use templates embedded with `go:embed` in production, but local
+	// filesystem otherwise so we can easily iterate in development.
+	var templates fs.FS
+	if conf.isProduction() {
+		templates = embeddedTemplates
+	} else {
+		templates = os.DirFS(".")
+	}
+
+	return ptemplate.NewRenderer(&ptemplate.RendererConfig{
+		ConfirmLinkBaseURL: conf.ConfirmLinkBaseURL,
+		DynamicReload:      !conf.isProduction(),
+		NewsletterMeta:     meta,
+		PublicURL:          conf.PublicURL,
+		Templates:          templates,
+	})
+}
+
+// newSecretProvider initializes a secretProvider from configuration, checking
+// that SigningSecret is present and long enough if any feature that signs
+// values is enabled. Features configured with a deprecated secret of their own
+// use it instead, and don't need SigningSecret. New signing features should be
+// added to the check here.
+func newSecretProvider(conf *Conf) (*secretProvider, error) {
+	legacyKeys := make(map[string]string)
+	if conf.ConfirmationTokenSecret != "" {
+		logrus.Warnf("CONFIRMATION_TOKEN_SECRET is deprecated; " +
+			"move to SIGN_CONFIRMATION_TOKENS and SIGNING_SECRET once outstanding confirmation links have expired")
+		legacyKeys[secretPurposeConfirmationToken] = conf.ConfirmationTokenSecret
+	}
+	if conf.SessionSecret != "" {
+		logrus.Warnf("SESSION_SECRET is deprecated; move to REQUIRE_SESSION and SIGNING_SECRET")
+		legacyKeys[secretPurposeSession] = conf.SessionSecret
+	}
+
+	var features []string
+	if conf.RequireFormNonce {
+		features = append(features, "RequireFormNonce")
+	}
+	if conf.requireSession() && conf.SessionSecret == "" {
+		features = append(features, "RequireSession")
+	}
+	if conf.signConfirmationTokens() && conf.ConfirmationTokenSecret == "" {
+		features = append(features, "SignConfirmationTokens")
+	}
+
+	// Without any signing features, the secret isn't needed, and key will
+	// refuse to derive a key from it.
+	if len(features) < 1 {
+		return &secretProvider{legacyKeys: legacyKeys}, nil
+	}
+
+	if conf.SigningSecret == "" {
+		return nil, xerrors.Errorf("SigningSecret is required with %s", strings.Join(features, " and "))
+	}
+
+	if len(conf.SigningSecret) < minSigningSecretLength {
+		return nil, xerrors.Errorf("SigningSecret must be at least %d characters in length", minSigningSecretLength)
+	}
+
+	return &secretProvider{legacyKeys: legacyKeys, secret: []byte(conf.SigningSecret)}, nil
+}
+
+// recordAdminAction writes an audit record of an action taken through an admin
+// endpoint. It should be called in the action's transaction so that the
+// record is only committed if the action is, and only once the action has
+// taken effect. Attempts that didn't find their target aren't recorded.
+func recordAdminAction(ctx context.Context, tx pgx.Tx, r *http.Request, action, target string) error {
+	mediator := &command.AdminAuditRecorder{
+		Action:       action,
+		AdminTokenID: middleware.AdminTokenID(r),
+		Target:       target,
+	}
+
+	_, err := mediator.Run(ctx, tx)
+	return err
+}
+
+// redactURL masks the password in a URL like a database connection string. If
+// the value doesn't parse as a URL, it's masked completely to be safe.
+func redactURL(value string) string {
+	if value == "" {
+		return value
+	}
+
+	u, err := url.Parse(value)
+	if err != nil {
+		return redactedValue
+	}
+
+	return u.Redacted()
+}
+
+func redirectToHTTPS(next http.Handler, protoHeader string) http.Handler {
 	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
-		proto := req.Header.Get("X-Forwarded-Proto")
-		if proto == "http" || proto == "HTTP" {
-			http.Redirect(res, req,
-				fmt.Sprintf("https://%s%s", req.Host, req.URL),
-				http.StatusPermanentRedirect)
+		if !shouldRedirectToHTTPS(req, protoHeader) {
+			next.ServeHTTP(res, req)
 			return
 		}
 
-		next.ServeHTTP(res, req)
+		// `req.URL` on a server request contains only the path and query
+		// string, so the latter is preserved through the redirect.
+		http.Redirect(res, req,
+			fmt.Sprintf("https://%s%s", req.Host, req.URL),
+			http.StatusPermanentRedirect)
+	})
+}
+
+// replaceRateLimitHeaders wraps a route's own rate limiter so that its headers
+// replace the ones already set by the global rate limiter rather than being
+// added alongside them. A route's limit is the tighter of the two, so it's the
+// one that clients should throttle themselves to.
+func replaceRateLimitHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, header := range rateLimitHeaders {
+			w.Header().Del(header)
+		}
+		next.ServeHTTP(w, r)
 	})
 }
 
+// setLogLevel sets the minimum level of logs emitted to the given level, like
+// `info` or `warn`. The level is left unchanged if it's empty.
+func setLogLevel(level string) error {
+	if level == "" {
+		return nil
+	}
+
+	parsedLevel, err := logrus.ParseLevel(level)
+	if err != nil {
+		return xerrors.Errorf("error parsing log level: %w", err)
+	}
+
+	logrus.SetLevel(parsedLevel)
+	return nil
+}
+
+// shouldRedirectToHTTPS determines whether a request should be redirected to
+// HTTPS:
+//
+//   - Paths in redirectExemptPaths are never redirected so that metrics
+//     scrapers work regardless of scheme.
+//   - Requests with a proto of `http` are redirected.
+//   - Requests with any other proto, including none (e.g. internal health
+//     checks that don't go through the proxy) and unexpected values like
+//     `wss`, are passed through. Redirecting those would send clients into a
+//     loop if the proxy reports the same value for the redirected request.
+func shouldRedirectToHTTPS(req *http.Request, protoHeader string) bool {
+	for _, path := range redirectExemptPaths {
+		if req.URL.Path == path {
+			return false
+		}
+	}
+
+	return forwardedProto(req, protoHeader) == "http"
+}
+
 func staticAssetsHandler(useEmbedded bool) http.Handler {
 	var handler http.Handler
 	if useEmbedded {
@@ -464,3 +3248,14 @@ func staticAssetsHandler(useEmbedded bool) http.Handler {
 	fmt.Printf("adding loggin handler, embedded = %v ...\n", useEmbedded)
 	return handlers.CombinedLoggingHandler(os.Stdout, handler)
 }
+
+// wellFormedConfirmToken checks whether a token has the shape of one that
+// could have been issued, either a random UUID or a signed token. It doesn't
+// check whether the token exists.
+func wellFormedConfirmToken(token string) bool {
+	if len(token) == 36 {
+		_, err := uuid.Parse(token)
+		return err == nil
+	}
+	return signedtoken.WellFormed(token)
+}
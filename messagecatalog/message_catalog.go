@@ -0,0 +1,153 @@
+// Package messagecatalog holds translations of the messages shown to users
+// after submitting the signup form and following a confirmation link. A
+// catalog is selected based on a request's Accept-Language header, falling
+// back to English.
+package messagecatalog
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Languages that have a catalog.
+const (
+	English = "en"
+	Spanish = "es"
+)
+
+// Key identifies a message in a catalog.
+type Key string
+
+// Keys of messages in each catalog. Messages are format strings that may
+// contain HTML, and each one takes the arguments noted next to its key in the
+// order listed.
+const (
+	ConfirmMemberAddDeferred Key = "confirm_member_add_deferred" // newsletter name, email
+	ConfirmSuccess           Key = "confirm_success"             // newsletter name, email
+	ConfirmTokenExpired      Key = "confirm_token_expired"       // signup URL
+	ConfirmTokenMalformed    Key = "confirm_token_malformed"     // signup URL
+	ConfirmTokenNotFound     Key = "confirm_token_not_found"
+
+	SubmitAlreadySubscribed       Key = "submit_already_subscribed"        // email, newsletter name
+	SubmitBounced                 Key = "submit_bounced"                   // email, newsletter name
+	SubmitConfirmationRateLimited Key = "submit_confirmation_rate_limited" // email, newsletter name
+	SubmitConfirmationSent        Key = "submit_confirmation_sent"         // email, newsletter name
+	SubmitDirectlySubscribed      Key = "submit_directly_subscribed"       // newsletter name, email
+	SubmitGlobalLimitReached      Key = "submit_global_limit_reached"      // newsletter name
+	SubmitMaxNumAttempts          Key = "submit_max_num_attempts"          // newsletter name
+	SubmitSuppressed              Key = "submit_suppressed"                // email, newsletter name
+)
+
+// Catalog is a set of messages in a single language.
+type Catalog struct {
+	// Language is the language of the catalog's messages, like "en".
+	Language string
+
+	messages map[Key]string
+}
+
+// ForAcceptLanguage selects the catalog best matching an Accept-Language
+// header, respecting quality values. Only the primary subtag of each language
+// range is considered, so "es-MX" selects the Spanish catalog. English is
+// returned if the header is empty or has no supported languages.
+func ForAcceptLanguage(header string) *Catalog {
+	best := catalogs[English]
+	bestQuality := 0.0
+
+	for _, languageRange := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(languageRange, ";")
+		primary, _, _ := strings.Cut(strings.TrimSpace(tag), "-")
+
+		catalog, ok := catalogs[strings.ToLower(primary)]
+		if !ok {
+			continue
+		}
+
+		quality := ParseQuality(params)
+
+		// Ranges of equal quality are preferred in the order they're listed.
+		if quality > bestQuality {
+			best = catalog
+			bestQuality = quality
+		}
+	}
+
+	return best
+}
+
+// Format renders the message for key with args. A key missing from the
+// catalog falls back to English, which has every message.
+func (c *Catalog) Format(key Key, args ...interface{}) string {
+	message, ok := c.messages[key]
+	if !ok {
+		message = catalogs[English].messages[key]
+	}
+	return fmt.Sprintf(message, args...)
+}
+
+// ParseQuality parses the quality value from the parameters of an entry in an
+// Accept-style header like Accept-Language or Accept-Encoding, like "q=0.8".
+// An entry without a quality has a quality of 1, and one with an unparseable
+// quality is treated as unacceptable.
+func ParseQuality(params string) float64 {
+	for _, param := range strings.Split(params, ";") {
+		name, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+		if !ok || strings.TrimSpace(name) != "q" {
+			continue
+		}
+
+		quality, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || quality < 0 || quality > 1 {
+			return 0
+		}
+		return quality
+	}
+
+	return 1
+}
+
+//
+// Private
+//
+
+var catalogs = map[string]*Catalog{
+	English: {
+		Language: English,
+		messages: map[Key]string{
+			ConfirmMemberAddDeferred: `<p>Your signup has been confirmed.</p><p>The <em>%s</em> list is temporarily unable to accept new members, so <strong>%s</strong> will be added shortly. There's no need to do anything else.</p>`,
+			ConfirmSuccess:           `<p>You've been signed up successfully.</p><p>You'll receive your first edition of <em>%s</em> at <strong>%s</strong> the next time one is published.</p>`,
+			ConfirmTokenExpired:      `<p>That confirmation link has expired.</p><p>Please <a href="%s">sign up again</a> to get a new one.</p>`,
+			ConfirmTokenMalformed:    `<p>That confirmation link isn't valid.</p><p>Please check that it was copied completely, or <a href="%s">sign up again</a> to get a new one.</p>`,
+			ConfirmTokenNotFound:     `We couldn't find that confirmation token.`,
+
+			SubmitAlreadySubscribed:       `<p>Thank you for signing up!</p><p>It looks like <strong>%s</strong> has already been confirmed, so you're all set to receive <em>%s</em>.</p>`,
+			SubmitBounced:                 `<p>Sorry, mail sent to <strong>%s</strong> previously bounced, so I can't send it a confirmation for <em>%s</em>. Please double-check the address or try a different one.</p>`,
+			SubmitConfirmationRateLimited: `<p>Thank you for signing up!</p><p>I recently sent a confirmation email to <strong>%s</strong> and don't want to send another one so soon after. Please try to find the message and click the enclosed link to finish signing up for <em>%s</em>. If you can't find it, try checking your spam folder.</p>`,
+			SubmitConfirmationSent:        `<p>Thank you for signing up!</p><p>I've sent a confirmation email to <strong>%s</strong>. Please click the enclosed link to finish signing up for <em>%s</em>.</p>`,
+			SubmitDirectlySubscribed:      `<p>Thank you for signing up!</p><p>You'll receive your first edition of <em>%s</em> at <strong>%s</strong> the next time one is published.</p>`,
+			SubmitGlobalLimitReached:      `<p>Sorry, signups for <em>%s</em> are temporarily closed. Please try again tomorrow.</p>`,
+			SubmitMaxNumAttempts:          `<p>Thank you for signing up!</p><p>I've hit the maximum number of confirmation tries for this email address. Please try to find the message and click the enclosed link to finish signing up for <em>%s</em>. If you can't find it, try checking your spam folder.</p>`,
+			SubmitSuppressed:              `<p>Sorry, I'm no longer able to send mail to <strong>%s</strong>, so I can't send it a confirmation for <em>%s</em>. Please try a different address.</p>`,
+		},
+	},
+	Spanish: {
+		Language: Spanish,
+		messages: map[Key]string{
+			ConfirmMemberAddDeferred: `<p>Tu suscripción ha sido confirmada.</p><p>La lista de <em>%s</em> no puede aceptar nuevos miembros temporalmente, así que <strong>%s</strong> se añadirá en breve. No hace falta hacer nada más.</p>`,
+			ConfirmSuccess:           `<p>Te has suscrito correctamente.</p><p>Recibirás tu primera edición de <em>%s</em> en <strong>%s</strong> la próxima vez que se publique una.</p>`,
+			ConfirmTokenExpired:      `<p>Ese enlace de confirmación ha caducado.</p><p>Por favor, <a href="%s">suscríbete de nuevo</a> para recibir uno nuevo.</p>`,
+			ConfirmTokenMalformed:    `<p>Ese enlace de confirmación no es válido.</p><p>Por favor, comprueba que se copió completo o <a href="%s">suscríbete de nuevo</a> para recibir uno nuevo.</p>`,
+			ConfirmTokenNotFound:     `No hemos encontrado ese código de confirmación.`,
+
+			SubmitAlreadySubscribed:       `<p>¡Gracias por suscribirte!</p><p>Parece que <strong>%s</strong> ya ha sido confirmado, así que ya recibirás <em>%s</em>.</p>`,
+			SubmitBounced:                 `<p>Lo siento, el correo enviado a <strong>%s</strong> fue rechazado anteriormente, así que no puedo enviarle una confirmación para <em>%s</em>. Por favor, revisa la dirección o prueba con otra.</p>`,
+			SubmitConfirmationRateLimited: `<p>¡Gracias por suscribirte!</p><p>Hace poco envié un correo de confirmación a <strong>%s</strong> y no quiero enviar otro tan pronto. Por favor, busca el mensaje y haz clic en el enlace para terminar de suscribirte a <em>%s</em>. Si no lo encuentras, revisa tu carpeta de spam.</p>`,
+			SubmitConfirmationSent:        `<p>¡Gracias por suscribirte!</p><p>He enviado un correo de confirmación a <strong>%s</strong>. Por favor, haz clic en el enlace para terminar de suscribirte a <em>%s</em>.</p>`,
+			SubmitDirectlySubscribed:      `<p>¡Gracias por suscribirte!</p><p>Recibirás tu primera edición de <em>%s</em> en <strong>%s</strong> la próxima vez que se publique una.</p>`,
+			SubmitGlobalLimitReached:      `<p>Lo siento, las suscripciones a <em>%s</em> están cerradas temporalmente. Por favor, inténtalo de nuevo mañana.</p>`,
+			SubmitMaxNumAttempts:          `<p>¡Gracias por suscribirte!</p><p>He alcanzado el número máximo de intentos de confirmación para esta dirección. Por favor, busca el mensaje y haz clic en el enlace para terminar de suscribirte a <em>%s</em>. Si no lo encuentras, revisa tu carpeta de spam.</p>`,
+			SubmitSuppressed:              `<p>Lo siento, ya no puedo enviar correos a <strong>%s</strong>, así que no puedo enviarle una confirmación para <em>%s</em>. Por favor, prueba con otra dirección.</p>`,
+		},
+	},
+}
@@ -0,0 +1,74 @@
+package messagecatalog
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestForAcceptLanguage(t *testing.T) {
+	testCases := []struct {
+		name     string
+		header   string
+		language string
+	}{
+		{"Empty", "", English},
+		{"English", "en", English},
+		{"EnglishRegion", "en-US,en;q=0.9", English},
+		{"Spanish", "es", Spanish},
+		{"SpanishRegion", "es-MX", Spanish},
+		{"SpanishUppercase", "ES-es", Spanish},
+		{"Unsupported", "fr-FR,fr;q=0.9", English},
+		{"UnsupportedThenSpanish", "fr-FR,fr;q=0.9,es;q=0.8", Spanish},
+		{"Wildcard", "*", English},
+		{"QualityPreferred", "en;q=0.5,es;q=0.9", Spanish},
+		{"QualityOrderTie", "es;q=0.8,en;q=0.8", Spanish},
+		{"QualityZero", "es;q=0", English},
+		{"QualityInvalid", "es;q=high", English},
+		{"Whitespace", " fr , es ; q=0.7 ", Spanish},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.language, ForAcceptLanguage(tc.header).Language)
+		})
+	}
+}
+
+func TestCatalogFormat(t *testing.T) {
+	t.Run("English", func(t *testing.T) {
+		catalog := ForAcceptLanguage("en")
+		require.Equal(t,
+			"<p>Thank you for signing up!</p><p>I've sent a confirmation email to <strong>foo@example.com</strong>. Please click the enclosed link to finish signing up for <em>Passages</em>.</p>",
+			catalog.Format(SubmitConfirmationSent, "foo@example.com", "Passages"))
+		require.Equal(t,
+			"We couldn't find that confirmation token.",
+			catalog.Format(ConfirmTokenNotFound))
+	})
+
+	t.Run("Spanish", func(t *testing.T) {
+		catalog := ForAcceptLanguage("es")
+		require.Equal(t,
+			"<p>¡Gracias por suscribirte!</p><p>He enviado un correo de confirmación a <strong>foo@example.com</strong>. Por favor, haz clic en el enlace para terminar de suscribirte a <em>Passages</em>.</p>",
+			catalog.Format(SubmitConfirmationSent, "foo@example.com", "Passages"))
+		require.Equal(t,
+			"No hemos encontrado ese código de confirmación.",
+			catalog.Format(ConfirmTokenNotFound))
+	})
+
+	t.Run("FallbackToEnglish", func(t *testing.T) {
+		catalog := &Catalog{Language: "xx", messages: map[Key]string{}}
+		require.Equal(t,
+			catalogs[English].Format(ConfirmTokenNotFound),
+			catalog.Format(ConfirmTokenNotFound))
+	})
+}
+
+// Every catalog should have every message that English does so that users
+// never get a mix of languages.
+func TestCatalogsComplete(t *testing.T) {
+	for language, catalog := range catalogs {
+		for key := range catalogs[English].messages {
+			require.Contains(t, catalog.messages, key, "catalog %q missing key %q", language, key)
+		}
+	}
+}
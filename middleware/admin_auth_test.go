@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminAuthMiddlewareWrapper(t *testing.T) {
+	const adminToken = "admin-token"
+
+	var handler http.Handler
+
+	setup := func(test func(*testing.T)) func(*testing.T) {
+		return func(t *testing.T) {
+			t.Helper()
+
+			handler = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte("ok."))
+			})
+			handler = NewAdminAuthMiddleware(adminToken).Wrapper(handler)
+
+			test(t)
+		}
+	}
+
+	t.Run("ValidToken", setup(func(t *testing.T) { //nolint:thelper
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "https://example.com/admin/stats", nil)
+		req.Header.Set("Authorization", "Bearer "+adminToken)
+		handler.ServeHTTP(recorder, req)
+
+		requireStatusOrPrintBody(t, http.StatusOK, recorder)
+	}))
+
+	t.Run("InvalidToken", setup(func(t *testing.T) { //nolint:thelper
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "https://example.com/admin/stats", nil)
+		req.Header.Set("Authorization", "Bearer not-the-token")
+		handler.ServeHTTP(recorder, req)
+
+		requireStatusOrPrintBody(t, http.StatusUnauthorized, recorder)
+	}))
+
+	t.Run("MissingToken", setup(func(t *testing.T) { //nolint:thelper
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "https://example.com/admin/stats", nil)
+		handler.ServeHTTP(recorder, req)
+
+		requireStatusOrPrintBody(t, http.StatusUnauthorized, recorder)
+	}))
+
+	t.Run("EmptyToken", setup(func(t *testing.T) { //nolint:thelper
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "https://example.com/admin/stats", nil)
+		req.Header.Set("Authorization", "Bearer ")
+		handler.ServeHTTP(recorder, req)
+
+		requireStatusOrPrintBody(t, http.StatusUnauthorized, recorder)
+	}))
+
+	t.Run("NotBearerToken", setup(func(t *testing.T) { //nolint:thelper
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "https://example.com/admin/stats", nil)
+		req.Header.Set("Authorization", adminToken)
+		handler.ServeHTTP(recorder, req)
+
+		requireStatusOrPrintBody(t, http.StatusUnauthorized, recorder)
+	}))
+
+	t.Run("TokenPrefix", setup(func(t *testing.T) { //nolint:thelper
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "https://example.com/admin/stats", nil)
+		req.Header.Set("Authorization", "Bearer "+adminToken[:len(adminToken)-1])
+		handler.ServeHTTP(recorder, req)
+
+		requireStatusOrPrintBody(t, http.StatusUnauthorized, recorder)
+	}))
+
+	t.Run("EmptyConfiguredToken", setup(func(t *testing.T) { //nolint:thelper
+		handler = NewAdminAuthMiddleware("").Wrapper(handler)
+
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "https://example.com/admin/stats", nil)
+		req.Header.Set("Authorization", "Bearer ")
+		handler.ServeHTTP(recorder, req)
+
+		requireStatusOrPrintBody(t, http.StatusUnauthorized, recorder)
+	}))
+}
+
+func TestAdminTokenID(t *testing.T) {
+	tokenID := func(token string) string {
+		req := httptest.NewRequest(http.MethodGet, "https://example.com/admin/stats", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return AdminTokenID(req)
+	}
+
+	id := tokenID("admin-token")
+	require.Len(t, id, adminTokenIDLength)
+	require.NotContains(t, id, "admin-token")
+
+	// Stable for the same token, but different between tokens.
+	require.Equal(t, id, tokenID("admin-token"))
+	require.NotEqual(t, id, tokenID("other-admin-token"))
+}
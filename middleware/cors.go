@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultCORSAllowedHeaders are the headers allowed in cross-origin requests
+// if none are configured.
+var defaultCORSAllowedHeaders = []string{"Content-Type"}
+
+// CORSMiddleware answers CORS preflight requests and adds CORS headers to
+// responses for requests coming from an allowed origin. This allows the signup
+// form to be embedded on other sites (e.g. `brandur.org`) and post to this
+// app.
+//
+// The list of allowed origins should be the same one given to CSRF protection
+// so that the two can't drift apart.
+type CORSMiddleware struct {
+	allowedHeaders []string
+	allowedOrigins []string
+	exposedHeaders []string
+	maxAge         time.Duration
+}
+
+// CORSOptions are optional settings for CORSMiddleware.
+type CORSOptions struct {
+	// AllowedHeaders are the request headers that may be sent with a
+	// cross-origin request. Defaults to only `Content-Type` if empty.
+	AllowedHeaders []string
+
+	// ExposedHeaders are response headers that scripts on an allowed origin
+	// may read beyond the handful that browsers expose by default. None are
+	// exposed if empty.
+	ExposedHeaders []string
+
+	// MaxAge is how long a browser may cache the result of a preflight
+	// request. If zero, no `Access-Control-Max-Age` is sent and browsers use
+	// their default, which is only a few seconds.
+	MaxAge time.Duration
+}
+
+// NewCORSMiddleware initializes a new CORSMiddleware. options may be nil to
+// use defaults.
+func NewCORSMiddleware(allowedOrigins []string, options *CORSOptions) *CORSMiddleware {
+	if options == nil {
+		options = &CORSOptions{}
+	}
+
+	allowedHeaders := options.AllowedHeaders
+	if len(allowedHeaders) == 0 {
+		allowedHeaders = defaultCORSAllowedHeaders
+	}
+
+	return &CORSMiddleware{
+		allowedHeaders: allowedHeaders,
+		allowedOrigins: allowedOrigins,
+		exposedHeaders: options.ExposedHeaders,
+		maxAge:         options.MaxAge,
+	}
+}
+
+func (m *CORSMiddleware) Wrapper(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Responses vary by origin regardless of whether it was allowed, so
+		// make sure caches take that into account.
+		w.Header().Add("Vary", "Origin")
+
+		origin := r.Header.Get("Origin")
+		if origin == "" || !m.isAllowedOrigin(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+
+		// A preflight is an OPTIONS request that includes the method that the
+		// browser intends to use for the real request.
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(m.allowedHeaders, ", "))
+			w.Header().Set("Access-Control-Allow-Methods", http.MethodPost)
+			if m.maxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(m.maxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if len(m.exposedHeaders) > 0 {
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(m.exposedHeaders, ", "))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (m *CORSMiddleware) isAllowedOrigin(origin string) bool {
+	for _, allowedOrigin := range m.allowedOrigins {
+		if origin == allowedOrigin {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCORSMiddlewareWrapper(t *testing.T) {
+	const allowedOrigin = "https://brandur.org"
+
+	var handler http.Handler
+
+	setup := func(test func(*testing.T)) func(*testing.T) {
+		return func(t *testing.T) {
+			t.Helper()
+
+			handler = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte("ok."))
+			})
+			handler = NewCORSMiddleware([]string{allowedOrigin}, nil).Wrapper(handler)
+
+			test(t)
+		}
+	}
+
+	t.Run("Preflight", setup(func(t *testing.T) { //nolint:thelper
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodOptions, "https://example.com/submit", nil)
+		req.Header.Set("Origin", allowedOrigin)
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		handler.ServeHTTP(recorder, req)
+
+		requireStatusOrPrintBody(t, http.StatusNoContent, recorder)
+		require.Equal(t, allowedOrigin, recorder.Header().Get("Access-Control-Allow-Origin"))
+		require.Equal(t, http.MethodPost, recorder.Header().Get("Access-Control-Allow-Methods"))
+		require.Equal(t, "Content-Type", recorder.Header().Get("Access-Control-Allow-Headers"))
+		require.Empty(t, recorder.Header().Get("Access-Control-Max-Age"))
+	}))
+
+	t.Run("PreflightCustomOptions", setup(func(t *testing.T) { //nolint:thelper
+		handler = NewCORSMiddleware([]string{allowedOrigin}, &CORSOptions{
+			AllowedHeaders: []string{"Content-Type", "Idempotency-Key"},
+			MaxAge:         10 * time.Minute,
+		}).Wrapper(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte("ok."))
+		}))
+
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodOptions, "https://example.com/submit", nil)
+		req.Header.Set("Origin", allowedOrigin)
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		handler.ServeHTTP(recorder, req)
+
+		requireStatusOrPrintBody(t, http.StatusNoContent, recorder)
+		require.Equal(t, "Content-Type, Idempotency-Key", recorder.Header().Get("Access-Control-Allow-Headers"))
+		require.Equal(t, "600", recorder.Header().Get("Access-Control-Max-Age"))
+	}))
+
+	t.Run("PreflightDisallowedOrigin", setup(func(t *testing.T) { //nolint:thelper
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodOptions, "https://example.com/submit", nil)
+		req.Header.Set("Origin", "https://evil.example.com")
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		handler.ServeHTTP(recorder, req)
+
+		require.Empty(t, recorder.Header().Get("Access-Control-Allow-Origin"))
+		require.Empty(t, recorder.Header().Get("Access-Control-Allow-Methods"))
+	}))
+
+	t.Run("AllowedCrossOriginPost", setup(func(t *testing.T) { //nolint:thelper
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "https://example.com/submit", nil)
+		req.Header.Set("Origin", allowedOrigin)
+		handler.ServeHTTP(recorder, req)
+
+		requireStatusOrPrintBody(t, http.StatusOK, recorder)
+		require.Equal(t, allowedOrigin, recorder.Header().Get("Access-Control-Allow-Origin"))
+		require.Equal(t, "ok.", recorder.Body.String())
+		require.Empty(t, recorder.Header().Get("Access-Control-Expose-Headers"))
+	}))
+
+	t.Run("AllowedCrossOriginPostExposedHeaders", setup(func(t *testing.T) { //nolint:thelper
+		handler = NewCORSMiddleware([]string{allowedOrigin}, &CORSOptions{
+			ExposedHeaders: []string{"X-RateLimit-Limit", "X-RateLimit-Remaining"},
+		}).Wrapper(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte("ok."))
+		}))
+
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "https://example.com/submit", nil)
+		req.Header.Set("Origin", allowedOrigin)
+		handler.ServeHTTP(recorder, req)
+
+		requireStatusOrPrintBody(t, http.StatusOK, recorder)
+		require.Equal(t, "X-RateLimit-Limit, X-RateLimit-Remaining",
+			recorder.Header().Get("Access-Control-Expose-Headers"))
+	}))
+
+	t.Run("NoOrigin", setup(func(t *testing.T) { //nolint:thelper
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "https://example.com/submit", nil)
+		handler.ServeHTTP(recorder, req)
+
+		requireStatusOrPrintBody(t, http.StatusOK, recorder)
+		require.Empty(t, recorder.Header().Get("Access-Control-Allow-Origin"))
+	}))
+}
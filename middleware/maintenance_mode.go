@@ -9,6 +9,10 @@ import (
 	"github.com/brandur/passages-signup/ptemplate"
 )
 
+// DefaultMaintenanceTemplate is the template rendered in maintenance mode if
+// no other one is given.
+const DefaultMaintenanceTemplate = "views/maintenance"
+
 // MaintenanceModeMiddleware sits above the server stack and allows the entire
 // service to be put into "maintenance mode" in which all API requests are
 // termined with a 503 Service Unavailable. This in turn allows us to carry out
@@ -17,12 +21,23 @@ import (
 type MaintenanceModeMiddleware struct {
 	maintenanceMode bool
 	renderer        *ptemplate.Renderer
+	template        string
 }
 
-func NewMaintenanceModeMiddleware(maintenanceMode bool, renderer *ptemplate.Renderer) *MaintenanceModeMiddleware {
+// NewMaintenanceModeMiddleware initializes a new MaintenanceModeMiddleware.
+// template is the template rendered for the body of responses in maintenance
+// mode, and defaults to DefaultMaintenanceTemplate if empty.
+func NewMaintenanceModeMiddleware(maintenanceMode bool, renderer *ptemplate.Renderer,
+	template string,
+) *MaintenanceModeMiddleware {
+	if template == "" {
+		template = DefaultMaintenanceTemplate
+	}
+
 	return &MaintenanceModeMiddleware{
 		maintenanceMode: maintenanceMode,
 		renderer:        renderer,
+		template:        template,
 	}
 }
 
@@ -30,7 +45,7 @@ func (m *MaintenanceModeMiddleware) Wrapper(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if m.maintenanceMode {
 			w.WriteHeader(http.StatusServiceUnavailable)
-			if err := m.renderer.RenderTemplate(w, "views/maintenance", map[string]interface{}{}); err != nil {
+			if err := m.renderer.RenderTemplate(w, m.template, map[string]interface{}{}); err != nil {
 				logrus.Errorf("Error rendering maintenance mode: %v", err)
 				_, _ = w.Write([]byte(fmt.Sprintf("Error rendering maintenance mode: %v", err)))
 			}
@@ -5,6 +5,7 @@ import (
 	"net/http/httptest"
 	"os"
 	"testing"
+	"testing/fstest"
 
 	"github.com/stretchr/testify/require"
 
@@ -40,7 +41,7 @@ func TestMaintenanceModeMiddlewareWrapper(t *testing.T) {
 	}
 
 	t.Run("MaintenanceOn", setup(func(t *testing.T) { //nolint:thelper
-		handler = NewMaintenanceModeMiddleware(true, renderer).Wrapper(handler)
+		handler = NewMaintenanceModeMiddleware(true, renderer, "").Wrapper(handler)
 
 		recorder := httptest.NewRecorder()
 		req := httptest.NewRequest(http.MethodGet, "https://example.com", nil)
@@ -54,7 +55,7 @@ func TestMaintenanceModeMiddlewareWrapper(t *testing.T) {
 	}))
 
 	t.Run("MaintenanceOff", setup(func(t *testing.T) { //nolint:thelper
-		handler = NewMaintenanceModeMiddleware(false, renderer).Wrapper(handler)
+		handler = NewMaintenanceModeMiddleware(false, renderer, "").Wrapper(handler)
 
 		recorder := httptest.NewRecorder()
 		req := httptest.NewRequest(http.MethodGet, "https://example.com", nil)
@@ -62,6 +63,40 @@ func TestMaintenanceModeMiddlewareWrapper(t *testing.T) {
 
 		requireStatusOrPrintBody(t, http.StatusOK, recorder)
 	}))
+
+	t.Run("CustomTemplate", setup(func(t *testing.T) { //nolint:thelper
+		layout, err := os.ReadFile("../layouts/passages.ace")
+		require.NoError(t, err)
+
+		twitterCard, err := os.ReadFile("../views/_twitter_card.ace")
+		require.NoError(t, err)
+
+		renderer, err := ptemplate.NewRenderer(&ptemplate.RendererConfig{
+			NewsletterMeta: renderer.NewsletterMeta,
+			PublicURL:      renderer.PublicURL,
+			Templates: fstest.MapFS{
+				"layouts/passages.ace":    &fstest.MapFile{Data: layout},
+				"views/_twitter_card.ace": &fstest.MapFile{Data: twitterCard},
+				"views/maintenance_branded.ace": &fstest.MapFile{Data: []byte(
+					"= content main\n  p Back soon with more {{.NewsletterMeta.Name}}.\n",
+				)},
+			},
+		})
+		require.NoError(t, err)
+
+		handler = NewMaintenanceModeMiddleware(true, renderer, "views/maintenance_branded").Wrapper(handler)
+
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "https://example.com", nil)
+		handler.ServeHTTP(recorder, req)
+
+		res := recorder.Result() //nolint:bodyclose
+		require.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+
+		data := recorder.Body.Bytes()
+		require.Contains(t, string(data), "Back soon with more Passages &amp; Glass.")
+		require.NotContains(t, string(data), "This application is currently in maintenance mode")
+	}))
 }
 
 func requireStatusOrPrintBody(t *testing.T, expectedStatusCode int, recorder *httptest.ResponseRecorder) {
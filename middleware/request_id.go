@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// RequestIDHeader is the response header that a request's ID is returned in.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the longest ID that will be inherited from a trusted
+// header. Longer values are ignored so that a misbehaving proxy can't bloat
+// logs and response headers.
+const maxRequestIDLength = 200
+
+// requestIDContextKey is the context key under which a request's ID is
+// stored.
+type requestIDContextKey struct{}
+
+// RequestIDMiddleware assigns each request an ID, which is stored in the
+// request's context and returned in the `X-Request-ID` response header.
+//
+// Some platforms already inject a trace ID of their own (e.g.
+// `X-Amzn-Trace-Id` or `X-Cloud-Trace-Context`). If trusted headers are
+// configured, the first of them in order that has a usable value is used as
+// the ID so that it can be correlated with the platform's logs. Otherwise a
+// random ID is generated.
+type RequestIDMiddleware struct {
+	trustedHeaders []string
+}
+
+// NewRequestIDMiddleware initializes a new RequestIDMiddleware. trustedHeaders
+// are the names of headers to read an existing ID from, in priority order,
+// and may be empty to always generate one. Only headers set by a proxy that
+// strips them from incoming requests should be trusted.
+func NewRequestIDMiddleware(trustedHeaders []string) *RequestIDMiddleware {
+	return &RequestIDMiddleware{
+		trustedHeaders: trustedHeaders,
+	}
+}
+
+func (m *RequestIDMiddleware) Wrapper(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := m.inheritedRequestID(r)
+		if requestID == "" {
+			requestID = generateRequestID()
+		}
+
+		w.Header().Set(RequestIDHeader, requestID)
+
+		ctx := context.WithValue(r.Context(), requestIDContextKey{}, requestID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// inheritedRequestID returns the value of the first trusted header that
+// contains a usable ID, or an empty string if there isn't one.
+func (m *RequestIDMiddleware) inheritedRequestID(r *http.Request) string {
+	for _, header := range m.trustedHeaders {
+		requestID := strings.TrimSpace(r.Header.Get(header))
+		if isValidRequestID(requestID) {
+			return requestID
+		}
+	}
+	return ""
+}
+
+// RequestID returns the ID assigned to a request by RequestIDMiddleware, or an
+// empty string if the middleware didn't run.
+func RequestID(r *http.Request) string {
+	requestID, _ := r.Context().Value(requestIDContextKey{}).(string)
+	return requestID
+}
+
+//
+// Private functions
+//
+
+// generateRequestID produces a new random request ID.
+func generateRequestID() string {
+	b := make([]byte, 16)
+
+	// crypto/rand never returns an error on supported platforms.
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// isValidRequestID checks that an inherited ID is non-empty, reasonably short,
+// and only contains printable ASCII so that it's safe to echo back in a
+// header and write to logs.
+func isValidRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+
+	for i := 0; i < len(requestID); i++ {
+		if requestID[i] < ' ' || requestID[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestIDMiddlewareWrapper(t *testing.T) {
+	const (
+		amznHeader  = "X-Amzn-Trace-Id"
+		cloudHeader = "X-Cloud-Trace-Context"
+	)
+
+	var (
+		handler   http.Handler
+		requestID string
+	)
+
+	setup := func(trustedHeaders []string, test func(*testing.T)) func(*testing.T) {
+		return func(t *testing.T) {
+			t.Helper()
+
+			requestID = ""
+			handler = NewRequestIDMiddleware(trustedHeaders).Wrapper(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					requestID = RequestID(r)
+					_, _ = w.Write([]byte("ok."))
+				}))
+
+			test(t)
+		}
+	}
+
+	serve := func(headers map[string]string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	t.Run("Generated", setup(nil, func(t *testing.T) { //nolint:thelper
+		recorder := serve(nil)
+		requireStatusOrPrintBody(t, http.StatusOK, recorder)
+		require.Len(t, requestID, 32)
+		require.Equal(t, requestID, recorder.Header().Get(RequestIDHeader))
+
+		// Each request gets a different ID.
+		firstRequestID := requestID
+		serve(nil)
+		require.NotEqual(t, firstRequestID, requestID)
+	}))
+
+	t.Run("UntrustedHeaderIgnored", setup(nil, func(t *testing.T) { //nolint:thelper
+		recorder := serve(map[string]string{
+			amznHeader:      "Root=1-67891233-abcdef012345678912345678",
+			RequestIDHeader: "client-chosen-id",
+		})
+		requireStatusOrPrintBody(t, http.StatusOK, recorder)
+		require.Len(t, requestID, 32)
+		require.Equal(t, requestID, recorder.Header().Get(RequestIDHeader))
+	}))
+
+	t.Run("TrustedHeader", setup([]string{amznHeader}, func(t *testing.T) { //nolint:thelper
+		recorder := serve(map[string]string{
+			amznHeader: "Root=1-67891233-abcdef012345678912345678",
+		})
+		requireStatusOrPrintBody(t, http.StatusOK, recorder)
+		require.Equal(t, "Root=1-67891233-abcdef012345678912345678", requestID)
+		require.Equal(t, requestID, recorder.Header().Get(RequestIDHeader))
+	}))
+
+	t.Run("TrustedHeaderPriority", setup([]string{cloudHeader, amznHeader}, func(t *testing.T) { //nolint:thelper
+		// The first configured header wins when both are present.
+		serve(map[string]string{
+			amznHeader:  "Root=1-67891233-abcdef012345678912345678",
+			cloudHeader: "105445aa7843bc8bf206b12000100000/1;o=1",
+		})
+		require.Equal(t, "105445aa7843bc8bf206b12000100000/1;o=1", requestID)
+
+		// Falls back to the next configured header.
+		serve(map[string]string{
+			amznHeader: "Root=1-67891233-abcdef012345678912345678",
+		})
+		require.Equal(t, "Root=1-67891233-abcdef012345678912345678", requestID)
+	}))
+
+	t.Run("TrustedHeaderInvalid", setup([]string{cloudHeader, amznHeader}, func(t *testing.T) { //nolint:thelper
+		// Unusable values are skipped in favor of the next header.
+		serve(map[string]string{
+			amznHeader:  "Root=1-67891233-abcdef012345678912345678",
+			cloudHeader: strings.Repeat("a", maxRequestIDLength+1),
+		})
+		require.Equal(t, "Root=1-67891233-abcdef012345678912345678", requestID)
+
+		// And an ID is generated if none are usable.
+		serve(map[string]string{
+			cloudHeader: "trace\x7f",
+		})
+		require.Len(t, requestID, 32)
+	}))
+}
+
+func TestRequestID_NoMiddleware(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	require.Empty(t, RequestID(req))
+}
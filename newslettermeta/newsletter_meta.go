@@ -3,11 +3,12 @@ package newslettermeta
 import (
 	"fmt"
 
-	"github.com/go-playground/validator/v10"
 	"golang.org/x/xerrors"
+
+	"github.com/brandur/passages-signup/validation"
 )
 
-var validate = validator.New()
+var validate = validation.New()
 
 type Meta struct {
 	ID                    string `validate:"required"`
@@ -15,7 +16,27 @@ type Meta struct {
 	Description           string `validate:"required"`
 	Description2          string `validate:"required"`
 	DescriptionAboutPhoto string `validate:"required"`
-	ListAddress           string `validate:"-"` // filled later
+
+	// ConfirmationIntro is optional plain text copy opening confirmation
+	// emails. A generic greeting is used if it's empty. It's rendered into
+	// both HTML and plain text messages, so it may not contain markup.
+	ConfirmationIntro string `validate:"omitempty,max=300,excludesall=<>"`
+
+	ListAddress string `validate:"-"` // filled later
+	Theme       Theme
+}
+
+// Theme holds styling for a newsletter's emails so that each newsletter's
+// messages are rendered in its own colors.
+type Theme struct {
+	// LinkColor is the color of links and their underlines.
+	LinkColor string `validate:"required,hexcolor"`
+
+	// LogoURL is the URL of an optional logo shown at the top of emails.
+	LogoURL string `validate:"omitempty,url"`
+
+	// TextColor is the color of body text.
+	TextColor string `validate:"required,hexcolor"`
 }
 
 const NanoglyphID = "nanoglyph"
@@ -26,6 +47,11 @@ var nanoglyphMeta = Meta{
 	Description:           `<em>Nanoglyph</em> is a weekly newsletter about software, with a focus on simplicity and sustainability. It usually consists of a few links with editorial. It's written by <a href="https://brandur.org">brandur</a>.`,
 	Description2:          `Check out a <a href="https://brandur.org/nanoglyphs/006-moma-rain">sample edition</a>. Sign up above to have new ones delivered fresh to your inbox whenever they're published.`,
 	DescriptionAboutPhoto: "Background photo is the <em>Blue Planet Sky</em> exhibit at the 21st Century Museum of Contemporary Art in Kanazawa, Japan. (And taken on a day that saw much more grey than blue.)",
+	ConfirmationIntro:     "Hello! Nanoglyph is a weekly newsletter about software, with a focus on simplicity and sustainability.",
+	Theme: Theme{
+		LinkColor: "#2a5db0",
+		TextColor: "#333333",
+	},
 }
 
 const PassagesID = "passages"
@@ -36,6 +62,10 @@ var passagesMeta = Meta{
 	Description:           `<em>Passages & Glass</em> is a personal newsletter about exploration, ideas, and software written by <a href="https://brandur.org">brandur</a>. It's sent rarely – just a few times a year.`,
 	Description2:          `Check out a <a href="https://brandur.org/passages/003-koya">sample edition</a>. Sign up above to have new ones sent to you. Easily unsubscribe at any time with a single click.`,
 	DescriptionAboutPhoto: "Background photo is a distorted selection of wild California grass. Taken along Mission Creek in San Francisco.",
+	Theme: Theme{
+		LinkColor: "#000000",
+		TextColor: "#4d4d4d",
+	},
 }
 
 var metaMap = map[string]Meta{
@@ -52,8 +82,21 @@ func init() {
 	}
 }
 
-// MetaFor returns metadata for the given newsletter.
+// IsKnown returns whether id is the ID of a newsletter that there's metadata
+// for.
+func IsKnown(id string) bool {
+	_, ok := metaMap[id]
+	return ok
+}
+
+// MetaFor returns metadata for the given newsletter. The list address is
+// built from the newsletter's ID and mailDomain, which should be a plausible
+// domain name.
 func MetaFor(mailDomain, name string) (*Meta, error) {
+	if err := validate.Var(mailDomain, "required,fqdn"); err != nil {
+		return nil, xerrors.Errorf("invalid mail domain %q: %w", mailDomain, err)
+	}
+
 	if meta, ok := metaMap[name]; ok {
 		meta.ListAddress = meta.ID + "@" + mailDomain
 		return &meta, nil // shallow copy
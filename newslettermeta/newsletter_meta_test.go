@@ -0,0 +1,60 @@
+package newslettermeta
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsKnown(t *testing.T) {
+	require.True(t, IsKnown(NanoglyphID))
+	require.True(t, IsKnown(PassagesID))
+	require.False(t, IsKnown("not-a-newsletter"))
+}
+
+func TestMetaFor(t *testing.T) {
+	t.Run("DefaultDomain", func(t *testing.T) {
+		meta, err := MetaFor("list.brandur.org", PassagesID)
+		require.NoError(t, err)
+		require.Equal(t, "passages@list.brandur.org", meta.ListAddress)
+	})
+
+	t.Run("CustomDomain", func(t *testing.T) {
+		meta, err := MetaFor("sandbox.example.com", NanoglyphID)
+		require.NoError(t, err)
+		require.Equal(t, "nanoglyph@sandbox.example.com", meta.ListAddress)
+	})
+
+	t.Run("InvalidDomain", func(t *testing.T) {
+		_, err := MetaFor("not a domain", PassagesID)
+		require.EqualError(t, err, `invalid mail domain "not a domain": Value must be a valid domain name`)
+
+		_, err = MetaFor("", PassagesID)
+		require.EqualError(t, err, `invalid mail domain "": Value is a required field`)
+	})
+
+	t.Run("UnknownNewsletter", func(t *testing.T) {
+		_, err := MetaFor("list.brandur.org", "not-a-newsletter")
+		require.EqualError(t, err, `unknown newsletter: "not-a-newsletter"`)
+	})
+}
+
+func TestMetaValidate(t *testing.T) {
+	for id, meta := range metaMap {
+		m := meta
+		require.NoError(t, validate.Struct(&m), "meta for %q should be valid", id)
+	}
+
+	t.Run("ConfirmationIntroMarkup", func(t *testing.T) {
+		meta := passagesMeta
+		meta.ConfirmationIntro = "Hello! <em>Passages</em> is a newsletter."
+		require.ErrorContains(t, validate.Struct(&meta), "ConfirmationIntro")
+	})
+
+	t.Run("ConfirmationIntroTooLong", func(t *testing.T) {
+		meta := passagesMeta
+		meta.ConfirmationIntro = strings.Repeat("a", 301)
+		require.ErrorContains(t, validate.Struct(&meta), "ConfirmationIntro")
+	})
+}
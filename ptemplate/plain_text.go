@@ -0,0 +1,173 @@
+package ptemplate
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/xerrors"
+)
+
+// HTMLToPlain derives readable plain text from an HTML message. It's a safety
+// net for when a message's hand-written plain text version can't be rendered,
+// so it aims for something a person can read rather than a faithful
+// conversion: paragraphs are separated by blank lines, list items are
+// bulleted, and links are followed by their URL in parentheses.
+//
+// Like stripHTML, it's not intended for use with user input.
+func HTMLToPlain(content string) (string, error) {
+	doc, err := html.Parse(strings.NewReader(content))
+	if err != nil {
+		return "", xerrors.Errorf("error parsing HTML: %w", err)
+	}
+
+	w := &plainTextWriter{}
+	w.walk(doc)
+	return strings.TrimSpace(string(w.buf)), nil
+}
+
+// plainTextSkippedElements are elements whose contents never appear in plain
+// text.
+var plainTextSkippedElements = map[atom.Atom]bool{
+	atom.Head:   true,
+	atom.Img:    true,
+	atom.Script: true,
+	atom.Style:  true,
+	atom.Title:  true,
+}
+
+// plainTextBlockElements are elements that are set apart from surrounding
+// text by a blank line.
+var plainTextBlockElements = map[atom.Atom]bool{
+	atom.Blockquote: true,
+	atom.Div:        true,
+	atom.H1:         true,
+	atom.H2:         true,
+	atom.H3:         true,
+	atom.H4:         true,
+	atom.H5:         true,
+	atom.H6:         true,
+	atom.Ol:         true,
+	atom.P:          true,
+	atom.Table:      true,
+	atom.Tr:         true,
+	atom.Ul:         true,
+}
+
+// plainTextWriter accumulates plain text while walking an HTML tree.
+// Whitespace is collapsed roughly as a browser would, and line breaks
+// requested by elements are deferred until more text is written so that they
+// never pile up or trail at the end.
+type plainTextWriter struct {
+	buf []byte
+
+	// pendingNewlines is the number of newlines to write before the next
+	// text.
+	pendingNewlines int
+
+	// pendingSpace is set if the last text ended in whitespace, which should
+	// separate it from the next text.
+	pendingSpace bool
+}
+
+func (w *plainTextWriter) walk(node *html.Node) {
+	switch node.Type {
+	case html.TextNode:
+		w.writeText(node.Data)
+		return
+
+	case html.ElementNode:
+		if plainTextSkippedElements[node.DataAtom] {
+			return
+		}
+
+		switch {
+		case node.DataAtom == atom.A:
+			w.walkLink(node)
+			return
+
+		case node.DataAtom == atom.Br:
+			w.breakLine(1)
+			return
+
+		case node.DataAtom == atom.Li:
+			w.breakLine(1)
+			w.writeText("- ")
+
+		case plainTextBlockElements[node.DataAtom]:
+			w.breakLine(2)
+			defer w.breakLine(2)
+		}
+	}
+
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		w.walk(child)
+	}
+}
+
+// walkLink writes a link's text followed by its URL, unless the URL is the
+// same as the text, or is a fragment that only makes sense in the HTML.
+func (w *plainTextWriter) walkLink(node *html.Node) {
+	start := len(w.buf)
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		w.walk(child)
+	}
+	text := strings.TrimSpace(string(w.buf[start:]))
+
+	var href string
+	for _, attr := range node.Attr {
+		if attr.Key == "href" {
+			href = strings.TrimSpace(attr.Val)
+		}
+	}
+
+	if href == "" || strings.HasPrefix(href, "#") || strings.TrimPrefix(href, "mailto:") == text {
+		return
+	}
+
+	if text == "" {
+		w.writeText(href)
+		return
+	}
+
+	w.writeText(" (" + href + ")")
+}
+
+// breakLine requests that the next text starts after at least n newlines.
+// Does nothing at the start of the document.
+func (w *plainTextWriter) breakLine(n int) {
+	if len(w.buf) > 0 && n > w.pendingNewlines {
+		w.pendingNewlines = n
+	}
+}
+
+// writeText writes text, collapsing its whitespace. A space that separates it
+// from the previous text is kept, but deferred like newlines so that lines
+// never end in one.
+func (w *plainTextWriter) writeText(text string) {
+	if text == "" {
+		return
+	}
+
+	collapsed := strings.Join(strings.Fields(text), " ")
+	if collapsed == "" {
+		w.pendingSpace = true
+		return
+	}
+
+	switch {
+	case w.pendingNewlines > 0:
+		w.buf = append(w.buf, strings.Repeat("\n", w.pendingNewlines)...)
+
+	case (w.pendingSpace || isSpace(text[0])) && len(w.buf) > 0:
+		w.buf = append(w.buf, ' ')
+	}
+
+	w.buf = append(w.buf, collapsed...)
+	w.pendingNewlines = 0
+	w.pendingSpace = isSpace(text[len(text)-1])
+}
+
+func isSpace(b byte) bool {
+	return strings.IndexByte(" \t\n\r\f", b) >= 0
+}
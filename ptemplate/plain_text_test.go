@@ -0,0 +1,84 @@
+package ptemplate
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/brandur/passages-signup/newslettermeta"
+)
+
+func TestHTMLToPlain(t *testing.T) {
+	t.Run("ConfirmationMessage", func(t *testing.T) {
+		renderer, err := NewRenderer(&RendererConfig{
+			DynamicReload:  true,
+			NewsletterMeta: newslettermeta.MustMetaFor("list.brandur.org", newslettermeta.PassagesID),
+			PublicURL:      "https://passages.example.com",
+			Templates:      os.DirFS(".."),
+		})
+		require.NoError(t, err)
+
+		buf := new(bytes.Buffer)
+		err = renderer.RenderTemplate(buf, "views/messages/confirm", map[string]interface{}{
+			"previewURL": "https://passages.example.com/admin/preview/test-token",
+			"token":      "test-token",
+		})
+		require.NoError(t, err)
+
+		plain, err := HTMLToPlain(buf.String())
+		require.NoError(t, err)
+
+		// The title and styling in the head don't leak into the body.
+		require.NotContains(t, plain, "newsletter signup")
+		require.NotContains(t, plain, "{")
+		require.NotContains(t, plain, "<")
+
+		// Links are kept so that the message can still be acted on.
+		require.Contains(t, plain,
+			"Having trouble reading this? View it in your browser "+
+				"(https://passages.example.com/admin/preview/test-token).")
+		require.Contains(t, plain,
+			"please confirm by clicking here (https://passages.example.com/confirm/test-token).")
+
+		// Paragraphs are separated by blank lines, and never broken within.
+		require.Contains(t, plain, "\n\nIf you received this email in error, it's safe to ignore it. "+
+			"By default you will stay unsubscribed.")
+		require.NotContains(t, plain, "\n\n\n")
+	})
+
+	t.Run("Paragraphs", func(t *testing.T) {
+		plain, err := HTMLToPlain(`<div>
+			<p>Hello   <strong>there</strong>, user.</p>
+			<p>Line one<br>line two</p>
+		</div>`)
+		require.NoError(t, err)
+		require.Equal(t, "Hello there, user.\n\nLine one\nline two", plain)
+	})
+
+	t.Run("Lists", func(t *testing.T) {
+		plain, err := HTMLToPlain(`<p>Topics:</p><ul><li>Cities</li><li>Transit</li></ul><p>Thanks!</p>`)
+		require.NoError(t, err)
+		require.Equal(t, "Topics:\n\n- Cities\n- Transit\n\nThanks!", plain)
+	})
+
+	t.Run("Links", func(t *testing.T) {
+		plain, err := HTMLToPlain(`<p>` +
+			`<a href="https://brandur.org">Website</a> ` +
+			`<a href="https://brandur.org">https://brandur.org</a> ` +
+			`<a href="mailto:brandur@example.com">brandur@example.com</a> ` +
+			`<a href="#top">Top</a> ` +
+			`<a href="https://example.com/empty"></a>` +
+			`</p>`)
+		require.NoError(t, err)
+		require.Equal(t, "Website (https://brandur.org) https://brandur.org brandur@example.com Top "+
+			"https://example.com/empty", plain)
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		plain, err := HTMLToPlain("")
+		require.NoError(t, err)
+		require.Empty(t, plain)
+	})
+}
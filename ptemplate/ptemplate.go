@@ -1,23 +1,48 @@
 package ptemplate
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"html/template"
 	"io"
 	"io/fs"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
-	"github.com/go-playground/validator/v10"
 	"github.com/sirupsen/logrus"
 	"github.com/yosssi/ace"
 	"golang.org/x/xerrors"
 
 	"github.com/brandur/passages-signup/newslettermeta"
+	"github.com/brandur/passages-signup/validation"
 )
 
-var validate = validator.New()
+// defaultConfirmationIntro opens confirmation emails for newsletters that
+// don't specify their own intro.
+const defaultConfirmationIntro = "Hello!"
+
+// templateExt is the file extension of Ace templates.
+const templateExt = ".ace"
+
+var validate = validation.New()
+
+// templateFuncs are the helper functions available to all templates.
+var templateFuncs = template.FuncMap{
+	"FormatDate": formatDate,
+	"Pluralize":  pluralize,
+	"StripHTML":  stripHTML,
+	"Truncate":   truncate,
+}
 
 type RendererConfig struct {
+	// ConfirmLinkBaseURL is the base URL used to build confirmation links in
+	// emails. Allows links to use a different domain than the one the site is
+	// served from. Defaults to PublicURL if empty.
+	ConfirmLinkBaseURL string `validate:"-"`
+
 	DynamicReload  bool                 `validate:"-"`
 	NewsletterMeta *newslettermeta.Meta `validate:"required"`
 	PublicURL      string               `validate:"required"`
@@ -31,11 +56,28 @@ type Renderer struct {
 
 func NewRenderer(config *RendererConfig) (*Renderer, error) {
 	if err := validate.Struct(config); err != nil {
-		return nil, xerrors.Errorf("error validating renderer config: %w", config)
+		return nil, xerrors.Errorf("error validating renderer config: %w", err)
 	}
 	return &Renderer{config, "layouts/" + config.NewsletterMeta.ID}, nil
 }
 
+// RenderFragment renders a template on its own without the renderer's layout.
+// Useful for pieces of a page that are embedded elsewhere.
+func (r *Renderer) RenderFragment(w io.Writer, templateFile string, locals map[string]interface{}) error {
+	if strings.HasPrefix(templateFile, "/") {
+		return xerrors.Errorf("template file should not start with %q: %q", "/", templateFile)
+	}
+
+	logrus.Debugf("Rendering: %s [no layout]", templateFile)
+
+	template, err := r.loadTemplate(templateFile, "")
+	if err != nil {
+		return err
+	}
+
+	return r.execute(w, template, locals)
+}
+
 // Shortcut for rendering a template and doing the right associated error
 // handling.
 func (r *Renderer) RenderTemplate(w io.Writer, templateFile string, locals map[string]interface{}) error {
@@ -43,32 +85,75 @@ func (r *Renderer) RenderTemplate(w io.Writer, templateFile string, locals map[s
 		return xerrors.Errorf("template file should not start with %q: %q", "/", templateFile)
 	}
 
-	locals = r.getLocals(locals)
+	logrus.Debugf("Rendering: %s [layout: %s]", r.layoutPath, templateFile)
 
-	logrus.Infof("Rendering: %s [layout: %s]", r.layoutPath, templateFile)
+	template, err := r.loadTemplate(r.layoutPath, templateFile)
+	if err != nil {
+		return err
+	}
 
-	template, err := ace.Load(r.layoutPath, templateFile, &ace.Options{
-		Asset: func(name string) ([]byte, error) {
-			f, err := r.Templates.Open(name)
+	return r.execute(w, template, locals)
+}
+
+// Validate compiles every view found in the renderer's templates along with
+// the layout so that a broken template can be detected at startup instead of
+// when a request tries to render it. Partials (files prefixed with `_`) are
+// skipped because they're compiled as part of the views that include them.
+func (r *Renderer) Validate() error {
+	return fs.WalkDir(r.Templates, "views", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return xerrors.Errorf("error walking templates: %w", err)
+		}
+
+		if d.IsDir() || !strings.HasSuffix(path, templateExt) || strings.HasPrefix(d.Name(), "_") {
+			return nil
+		}
+
+		if _, err := r.loadTemplate(r.layoutPath, strings.TrimSuffix(path, templateExt)); err != nil {
+			return xerrors.Errorf("error validating template %q: %w", path, err)
+		}
+
+		return nil
+	})
+}
+
+// Version returns a hash of the contents of every layout and view so that it
+// changes whenever any template does. Useful for cache validation like ETags.
+// Note that with DynamicReload on, templates may change after this is called.
+func (r *Renderer) Version() (string, error) {
+	hash := sha256.New()
+
+	for _, dir := range []string{"layouts", "views"} {
+		err := fs.WalkDir(r.Templates, dir, func(path string, d fs.DirEntry, err error) error {
 			if err != nil {
-				return nil, xerrors.Errorf("error opening template file %q: %w", name, err)
+				return xerrors.Errorf("error walking templates: %w", err)
 			}
-			b, err := io.ReadAll(f)
+
+			if d.IsDir() {
+				return nil
+			}
+
+			data, err := fs.ReadFile(r.Templates, path)
 			if err != nil {
-				return nil, xerrors.Errorf("error reading template file %q: %w", name, err)
+				return xerrors.Errorf("error reading template file %q: %w", path, err)
 			}
-			return b, nil
-		},
-		DynamicReload: r.DynamicReload,
-		FuncMap: template.FuncMap{
-			"StripHTML": stripHTML,
-		},
-	})
-	if err != nil {
-		return xerrors.Errorf("error compiling template: %w", err)
+
+			hash.Write([]byte(path + "\x00"))
+			hash.Write(data)
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
 	}
 
-	err = template.Execute(w, locals)
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// execute executes a compiled template with the default set of locals merged
+// with the given ones.
+func (r *Renderer) execute(w io.Writer, template *template.Template, locals map[string]interface{}) error {
+	err := template.Execute(w, r.getLocals(locals))
 	if err != nil {
 		err = xerrors.Errorf("error rendering template: %w", err)
 
@@ -84,9 +169,22 @@ func (r *Renderer) RenderTemplate(w io.Writer, templateFile string, locals map[s
 // rendering any template and then includes in those specified in the locals
 // parameter for this particular run.
 func (r *Renderer) getLocals(locals map[string]interface{}) map[string]interface{} {
+	confirmLinkBaseURL := r.ConfirmLinkBaseURL
+	if confirmLinkBaseURL == "" {
+		confirmLinkBaseURL = r.PublicURL
+	}
+
+	confirmationIntro := r.NewsletterMeta.ConfirmationIntro
+	if confirmationIntro == "" {
+		confirmationIntro = defaultConfirmationIntro
+	}
+
 	defaults := map[string]interface{}{
-		"NewsletterMeta": r.NewsletterMeta,
-		"PublicURL":      r.PublicURL,
+		"ConfirmLinkBaseURL": confirmLinkBaseURL,
+		"ConfirmationIntro":  confirmationIntro,
+		"NewsletterMeta":     r.NewsletterMeta,
+		"PublicURL":          r.PublicURL,
+		"Theme":              &r.NewsletterMeta.Theme,
 	}
 
 	for k, v := range locals {
@@ -96,6 +194,51 @@ func (r *Renderer) getLocals(locals map[string]interface{}) map[string]interface
 	return defaults
 }
 
+// loadTemplate compiles the given base template file along with an inner one.
+// The base is usually the renderer's layout, but may be a standalone template,
+// in which case inner should be empty.
+func (r *Renderer) loadTemplate(basePath, innerPath string) (*template.Template, error) {
+	tmpl, err := ace.Load(basePath, innerPath, &ace.Options{
+		Asset: func(name string) ([]byte, error) {
+			f, err := r.Templates.Open(name)
+			if err != nil {
+				return nil, xerrors.Errorf("error opening template file %q: %w", name, err)
+			}
+			b, err := io.ReadAll(f)
+			if err != nil {
+				return nil, xerrors.Errorf("error reading template file %q: %w", name, err)
+			}
+			return b, nil
+		},
+		DynamicReload: r.DynamicReload,
+		FuncMap:       templateFuncs,
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("error compiling template: %w", err)
+	}
+
+	return tmpl, nil
+}
+
+// formatDate formats t with a Go layout like `January 2, 2006`. A zero time
+// formats as an empty string rather than a date in year one.
+func formatDate(t time.Time, layout string) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(layout)
+}
+
+// pluralize prefixes count to singular if count is exactly one, or to plural
+// otherwise, like `1 subscriber` or `0 subscribers`.
+func pluralize(count int, singular, plural string) string {
+	word := plural
+	if count == 1 {
+		word = singular
+	}
+	return strconv.Itoa(count) + " " + word
+}
+
 var stripHTMLRE = regexp.MustCompile(`<[^>]*>`)
 
 // stripHTML does an extremely basic replacement of all HTML tags with empty
@@ -103,3 +246,19 @@ var stripHTMLRE = regexp.MustCompile(`<[^>]*>`)
 func stripHTML(content string) string {
 	return strings.TrimSpace(stripHTMLRE.ReplaceAllString(content, ""))
 }
+
+// truncate shortens content to at most length characters, replacing the last
+// of them with an ellipsis if anything was cut. Content is never cut in the
+// middle of a multibyte character.
+func truncate(content string, length int) string {
+	if length <= 0 {
+		return ""
+	}
+
+	if utf8.RuneCountInString(content) <= length {
+		return content
+	}
+
+	runes := []rune(content)
+	return string(runes[:length-1]) + "…"
+}
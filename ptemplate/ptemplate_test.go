@@ -1,12 +1,312 @@
 package ptemplate
 
 import (
+	"bytes"
+	"io/fs"
+	"os"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/stretchr/testify/require"
+
+	"github.com/brandur/passages-signup/newslettermeta"
 )
 
+func TestNewRenderer_InvalidConfig(t *testing.T) {
+	_, err := NewRenderer(&RendererConfig{
+		NewsletterMeta: newslettermeta.MustMetaFor("list.brandur.org", newslettermeta.PassagesID),
+		Templates:      os.DirFS(".."),
+	})
+	require.EqualError(t, err, "error validating renderer config: PublicURL is a required field")
+}
+
+func TestRenderFragment(t *testing.T) {
+	renderer, err := NewRenderer(&RendererConfig{
+		DynamicReload:  true,
+		NewsletterMeta: newslettermeta.MustMetaFor("list.brandur.org", newslettermeta.PassagesID),
+		PublicURL:      "https://passages.example.com",
+		Templates:      os.DirFS(".."),
+	})
+	require.NoError(t, err)
+
+	buf := new(bytes.Buffer)
+	err = renderer.RenderFragment(buf, "views/form", map[string]interface{}{})
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(buf.String(), `<form method="post" action="/submit">`), buf.String())
+	require.NotContains(t, buf.String(), "<html")
+	require.NotContains(t, buf.String(), "<head")
+	require.NotContains(t, buf.String(), "<body")
+
+	// The same template rendered in a page includes the layout.
+	buf = new(bytes.Buffer)
+	err = renderer.RenderTemplate(buf, "views/show", map[string]interface{}{})
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "<html")
+	require.Contains(t, buf.String(), `<form method="post" action="/submit">`)
+}
+
+func TestRenderTemplate_ConfirmLinkBaseURL(t *testing.T) {
+	renderer, err := NewRenderer(&RendererConfig{
+		ConfirmLinkBaseURL: "https://go.example.com",
+		DynamicReload:      true,
+		NewsletterMeta:     newslettermeta.MustMetaFor("list.brandur.org", newslettermeta.PassagesID),
+		PublicURL:          "https://passages.example.com",
+		Templates:          os.DirFS(".."),
+	})
+	require.NoError(t, err)
+
+	// Confirmation messages use the alternate base URL.
+	for _, templateFile := range []string{"views/messages/confirm", "views/messages/confirm_plain"} {
+		buf := new(bytes.Buffer)
+		err = renderer.RenderTemplate(buf, templateFile, map[string]interface{}{
+			"token": "test-token",
+		})
+		require.NoError(t, err)
+		require.Contains(t, buf.String(), "https://go.example.com/confirm/test-token")
+		require.NotContains(t, buf.String(), "https://passages.example.com/confirm/")
+	}
+
+	// Pages continue to use the public URL.
+	buf := new(bytes.Buffer)
+	err = renderer.RenderTemplate(buf, "views/show", map[string]interface{}{})
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "https://passages.example.com/public/")
+	require.NotContains(t, buf.String(), "https://go.example.com")
+}
+
+func TestRenderTemplate_ConfirmLinkBaseURLDefault(t *testing.T) {
+	renderer, err := NewRenderer(&RendererConfig{
+		DynamicReload:  true,
+		NewsletterMeta: newslettermeta.MustMetaFor("list.brandur.org", newslettermeta.PassagesID),
+		PublicURL:      "https://passages.example.com",
+		Templates:      os.DirFS(".."),
+	})
+	require.NoError(t, err)
+
+	buf := new(bytes.Buffer)
+	err = renderer.RenderTemplate(buf, "views/messages/confirm_plain", map[string]interface{}{
+		"token": "test-token",
+	})
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "https://passages.example.com/confirm/test-token")
+}
+
+func TestRenderTemplate_ConfirmationIntro(t *testing.T) {
+	render := func(t *testing.T, meta *newslettermeta.Meta, templateFile string) string {
+		t.Helper()
+
+		renderer, err := NewRenderer(&RendererConfig{
+			DynamicReload:  true,
+			NewsletterMeta: meta,
+			PublicURL:      "https://passages.example.com",
+			Templates:      os.DirFS(".."),
+		})
+		require.NoError(t, err)
+
+		buf := new(bytes.Buffer)
+		err = renderer.RenderTemplate(buf, templateFile, map[string]interface{}{
+			"token": "test-token",
+		})
+		require.NoError(t, err)
+		return buf.String()
+	}
+
+	for _, templateFile := range []string{"views/messages/confirm", "views/messages/confirm_plain"} {
+		t.Run(templateFile, func(t *testing.T) {
+			nanoglyphMeta := newslettermeta.MustMetaFor("list.brandur.org", newslettermeta.NanoglyphID)
+			require.NotEmpty(t, nanoglyphMeta.ConfirmationIntro)
+
+			body := render(t, nanoglyphMeta, templateFile)
+			require.Contains(t, body, nanoglyphMeta.ConfirmationIntro)
+
+			// Passages doesn't have its own intro, so gets the generic one.
+			passagesMeta := newslettermeta.MustMetaFor("list.brandur.org", newslettermeta.PassagesID)
+			require.Empty(t, passagesMeta.ConfirmationIntro)
+
+			body = render(t, passagesMeta, templateFile)
+			require.Contains(t, body, defaultConfirmationIntro)
+			require.NotContains(t, body, nanoglyphMeta.ConfirmationIntro)
+
+			passagesMeta.ConfirmationIntro = "Welcome! Passages is sent just a few times a year."
+
+			body = render(t, passagesMeta, templateFile)
+			require.Contains(t, body, passagesMeta.ConfirmationIntro)
+		})
+	}
+}
+
+func TestRenderTemplate_Theme(t *testing.T) {
+	render := func(t *testing.T, meta *newslettermeta.Meta, templateFile string) string {
+		t.Helper()
+
+		renderer, err := NewRenderer(&RendererConfig{
+			DynamicReload:  true,
+			NewsletterMeta: meta,
+			PublicURL:      "https://passages.example.com",
+			Templates:      os.DirFS(".."),
+		})
+		require.NoError(t, err)
+
+		buf := new(bytes.Buffer)
+		err = renderer.RenderTemplate(buf, templateFile, map[string]interface{}{
+			"token": "test-token",
+		})
+		require.NoError(t, err)
+		return buf.String()
+	}
+
+	for _, templateFile := range []string{"views/messages/confirm", "views/messages/welcome"} {
+		t.Run(templateFile, func(t *testing.T) {
+			for _, newsletterID := range []string{newslettermeta.NanoglyphID, newslettermeta.PassagesID} {
+				meta := newslettermeta.MustMetaFor("list.brandur.org", newsletterID)
+
+				body := render(t, meta, templateFile)
+				require.Contains(t, body, "color: "+meta.Theme.TextColor+";")
+				require.Contains(t, body, "border-bottom: 1px solid "+meta.Theme.LinkColor+";")
+				require.NotContains(t, body, `id="logo"`)
+			}
+
+			// Newsletters have different themes.
+			require.NotEqual(t,
+				newslettermeta.MustMetaFor("list.brandur.org", newslettermeta.NanoglyphID).Theme,
+				newslettermeta.MustMetaFor("list.brandur.org", newslettermeta.PassagesID).Theme)
+
+			meta := newslettermeta.MustMetaFor("list.brandur.org", newslettermeta.PassagesID)
+			meta.Theme.LogoURL = "https://passages.example.com/logo.png"
+
+			body := render(t, meta, templateFile)
+			require.Contains(t, body, `<img id="logo" src="https://passages.example.com/logo.png"`)
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	meta := newslettermeta.MustMetaFor("list.brandur.org", newslettermeta.PassagesID)
+
+	t.Run("Valid", func(t *testing.T) {
+		renderer, err := NewRenderer(&RendererConfig{
+			NewsletterMeta: meta,
+			PublicURL:      "https://passages.example.com",
+			Templates:      os.DirFS(".."),
+		})
+		require.NoError(t, err)
+
+		require.NoError(t, renderer.Validate())
+	})
+
+	t.Run("BrokenTemplate", func(t *testing.T) {
+		layout, err := os.ReadFile("../layouts/passages.ace")
+		require.NoError(t, err)
+
+		renderer, err := NewRenderer(&RendererConfig{
+			NewsletterMeta: meta,
+			PublicURL:      "https://passages.example.com",
+			Templates: fstest.MapFS{
+				"layouts/passages.ace": &fstest.MapFile{Data: layout},
+				"views/broken.ace": &fstest.MapFile{Data: []byte(
+					"= content main\n  p {{.unclosed\n",
+				)},
+			},
+		})
+		require.NoError(t, err)
+
+		err = renderer.Validate()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `error validating template "views/broken.ace"`)
+	})
+}
+
+func TestVersion(t *testing.T) {
+	meta := newslettermeta.MustMetaFor("list.brandur.org", newslettermeta.PassagesID)
+
+	version := func(t *testing.T, templates fs.FS) string {
+		t.Helper()
+
+		renderer, err := NewRenderer(&RendererConfig{
+			NewsletterMeta: meta,
+			PublicURL:      "https://passages.example.com",
+			Templates:      templates,
+		})
+		require.NoError(t, err)
+
+		version, err := renderer.Version()
+		require.NoError(t, err)
+		return version
+	}
+
+	templates := fstest.MapFS{
+		"layouts/passages.ace": &fstest.MapFile{Data: []byte("= yield main\n")},
+		"views/show.ace":       &fstest.MapFile{Data: []byte("= content main\n  p Hello\n")},
+	}
+	original := version(t, templates)
+	require.NotEmpty(t, original)
+
+	// Stable for the same templates.
+	require.Equal(t, original, version(t, templates))
+
+	// Changes along with a template.
+	templates["views/show.ace"] = &fstest.MapFile{Data: []byte("= content main\n  p Goodbye\n")}
+	require.NotEqual(t, original, version(t, templates))
+
+	// Works with the real templates too.
+	require.NotEmpty(t, version(t, os.DirFS("..")))
+}
+
+func TestFormatDate(t *testing.T) {
+	date := time.Date(2020, 3, 4, 5, 6, 7, 0, time.UTC)
+	require.Equal(t, "March 4, 2020", formatDate(date, "January 2, 2006"))
+	require.Equal(t, "2020-03-04", formatDate(date, "2006-01-02"))
+	require.Equal(t, "", formatDate(time.Time{}, "January 2, 2006"))
+}
+
+func TestPluralize(t *testing.T) {
+	require.Equal(t, "0 subscribers", pluralize(0, "subscriber", "subscribers"))
+	require.Equal(t, "1 subscriber", pluralize(1, "subscriber", "subscribers"))
+	require.Equal(t, "2 subscribers", pluralize(2, "subscriber", "subscribers"))
+	require.Equal(t, "-1 subscribers", pluralize(-1, "subscriber", "subscribers"))
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	renderer, err := NewRenderer(&RendererConfig{
+		NewsletterMeta: newslettermeta.MustMetaFor("list.brandur.org", newslettermeta.PassagesID),
+		PublicURL:      "https://passages.example.com",
+		Templates: fstest.MapFS{
+			"views/funcs.ace": &fstest.MapFile{Data: []byte(
+				`p {{FormatDate .date "2006-01-02"}} {{Pluralize .count "issue" "issues"}} {{Truncate .title 8}}` + "\n",
+			)},
+		},
+	})
+	require.NoError(t, err)
+
+	buf := new(bytes.Buffer)
+	err = renderer.RenderFragment(buf, "views/funcs", map[string]interface{}{
+		"count": 3,
+		"date":  time.Date(2020, 3, 4, 0, 0, 0, 0, time.UTC),
+		"title": "Passages & Glass",
+	})
+	require.NoError(t, err)
+	require.Equal(t, "<p>2020-03-04 3 issues Passage…</p>", buf.String())
+}
+
 func TestStripHTML(t *testing.T) {
 	require.Equal(t, "hello", stripHTML("hello"))
 	require.Equal(t, "hello there user", stripHTML(`<a href=""> hello <strong>there</strong> user </p>`))
 }
+
+func TestTruncate(t *testing.T) {
+	require.Equal(t, "hello", truncate("hello", 5))
+	require.Equal(t, "hello", truncate("hello", 10))
+	require.Equal(t, "hell…", truncate("hello there", 5))
+	require.Equal(t, "…", truncate("hello", 1))
+	require.Equal(t, "", truncate("hello", 0))
+	require.Equal(t, "", truncate("hello", -1))
+	require.Equal(t, "", truncate("", 5))
+
+	// Multibyte characters count as one and are never split.
+	require.Equal(t, "¡Hol…", truncate("¡Hola, mundo!", 5))
+
+	long := strings.Repeat("a", 10_000)
+	require.Equal(t, strings.Repeat("a", 99)+"…", truncate(long, 100))
+}
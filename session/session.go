@@ -0,0 +1,119 @@
+// Package session issues and validates a small signed cookie carrying a random
+// nonce. It lets a multi-step flow like showing the signup form and then
+// submitting it verify that both steps happened in the same browser without
+// carrying state in URL parameters.
+package session
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/brandur/passages-signup/signing"
+)
+
+// CookieName is the name of the session cookie.
+const CookieName = "passages_session"
+
+// MaxAge is how long an issued session is valid for.
+const MaxAge = 24 * time.Hour
+
+const (
+	nonceLength     = 16
+	payloadLength   = nonceLength + 8 // nonce and issue time
+	signatureLength = signing.Size
+)
+
+var (
+	// ErrExpired is returned when a session's signature is valid, but it was
+	// issued longer than MaxAge ago.
+	ErrExpired = errors.New("session expired")
+
+	// ErrInvalidSignature is returned when a session cookie is well-formed,
+	// but its signature doesn't match, which means that it was tampered with
+	// or signed with a different secret.
+	ErrInvalidSignature = errors.New("session signature invalid")
+
+	// ErrMalformed is returned when a session cookie's value can't be decoded.
+	ErrMalformed = errors.New("session malformed")
+
+	// ErrMissing is returned when a request doesn't carry a session cookie.
+	ErrMissing = errors.New("session missing")
+)
+
+// Manager issues and validates session cookies.
+type Manager struct {
+	secret []byte
+	secure bool
+
+	// timeNow returns the current time. Can be overridden in tests.
+	timeNow func() time.Time
+}
+
+// NewManager initializes a new Manager that signs cookies with the given
+// secret. Cookies are marked Secure if secure is true, which should be the case
+// anywhere that's served over HTTPS.
+func NewManager(secret string, secure bool) *Manager {
+	return &Manager{
+		secret:  []byte(secret),
+		secure:  secure,
+		timeNow: time.Now,
+	}
+}
+
+// Issue generates a new nonce and sets it in a signed session cookie on the
+// response. Returns the nonce.
+func (m *Manager) Issue(w http.ResponseWriter) (string, error) {
+	nonce := make([]byte, nonceLength)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", xerrors.Errorf("error generating session nonce: %w", err)
+	}
+
+	payload := make([]byte, payloadLength)
+	copy(payload, nonce)
+	binary.BigEndian.PutUint64(payload[nonceLength:], uint64(m.timeNow().Unix()))
+
+	http.SetCookie(w, &http.Cookie{
+		HttpOnly: true,
+		MaxAge:   int(MaxAge.Seconds()),
+		Name:     CookieName,
+		Path:     "/",
+		SameSite: http.SameSiteLaxMode,
+		Secure:   m.secure,
+		Value:    base64.RawURLEncoding.EncodeToString(append(payload, signing.Sign(m.secret, payload)...)),
+	})
+
+	return base64.RawURLEncoding.EncodeToString(nonce), nil
+}
+
+// Validate checks the session cookie on a request and returns its nonce.
+// Returns ErrMissing, ErrMalformed, ErrInvalidSignature, or ErrExpired if the
+// session can't be used.
+func (m *Manager) Validate(r *http.Request) (string, error) {
+	cookie, err := r.Cookie(CookieName)
+	if err != nil {
+		return "", ErrMissing
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil || len(data) != payloadLength+signatureLength {
+		return "", ErrMalformed
+	}
+
+	payload, signature := data[:payloadLength], data[payloadLength:]
+	if !signing.Verify(m.secret, payload, signature) {
+		return "", ErrInvalidSignature
+	}
+
+	issuedAt := time.Unix(int64(binary.BigEndian.Uint64(payload[nonceLength:])), 0)
+	if m.timeNow().After(issuedAt.Add(MaxAge)) {
+		return "", ErrExpired
+	}
+
+	return base64.RawURLEncoding.EncodeToString(payload[:nonceLength]), nil
+}
@@ -0,0 +1,121 @@
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestManager(t *testing.T) {
+	const secret = "a-secret-that-is-at-least-32-chars"
+
+	var (
+		manager *Manager
+		now     time.Time
+	)
+
+	setup := func(test func(*testing.T)) func(*testing.T) {
+		return func(t *testing.T) {
+			t.Helper()
+
+			now = time.Unix(1700000000, 0)
+			manager = NewManager(secret, true)
+			manager.timeNow = func() time.Time { return now }
+
+			test(t)
+		}
+	}
+
+	// Issues a session and returns the cookie that was set along with its
+	// nonce.
+	issue := func(t *testing.T, manager *Manager) (*http.Cookie, string) {
+		t.Helper()
+
+		w := httptest.NewRecorder()
+		nonce, err := manager.Issue(w)
+		require.NoError(t, err)
+
+		cookies := w.Result().Cookies()
+		require.Len(t, cookies, 1)
+		return cookies[0], nonce
+	}
+
+	requestWithCookie := func(cookie *http.Cookie) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/submit", nil)
+		req.AddCookie(cookie)
+		return req
+	}
+
+	t.Run("Issue", setup(func(t *testing.T) { //nolint:thelper
+		cookie, nonce := issue(t, manager)
+		require.NotEmpty(t, nonce)
+		require.Equal(t, CookieName, cookie.Name)
+		require.True(t, cookie.HttpOnly)
+		require.True(t, cookie.Secure)
+		require.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+		require.Equal(t, int(MaxAge.Seconds()), cookie.MaxAge)
+
+		// Every session gets a different nonce.
+		_, otherNonce := issue(t, manager)
+		require.NotEqual(t, nonce, otherNonce)
+	}))
+
+	t.Run("IssueInsecure", setup(func(t *testing.T) { //nolint:thelper
+		cookie, _ := issue(t, NewManager(secret, false))
+		require.False(t, cookie.Secure)
+	}))
+
+	t.Run("Valid", setup(func(t *testing.T) { //nolint:thelper
+		cookie, nonce := issue(t, manager)
+
+		validatedNonce, err := manager.Validate(requestWithCookie(cookie))
+		require.NoError(t, err)
+		require.Equal(t, nonce, validatedNonce)
+	}))
+
+	t.Run("Expired", setup(func(t *testing.T) { //nolint:thelper
+		cookie, _ := issue(t, manager)
+		now = now.Add(MaxAge + 1*time.Second)
+
+		_, err := manager.Validate(requestWithCookie(cookie))
+		require.ErrorIs(t, err, ErrExpired)
+	}))
+
+	t.Run("Missing", setup(func(t *testing.T) { //nolint:thelper
+		_, err := manager.Validate(httptest.NewRequest(http.MethodPost, "/submit", nil))
+		require.ErrorIs(t, err, ErrMissing)
+	}))
+
+	t.Run("Tampered", setup(func(t *testing.T) { //nolint:thelper
+		cookie, _ := issue(t, manager)
+		otherCookie, _ := issue(t, manager)
+
+		// Swap in the payload of a different session, keeping the original
+		// signature.
+		cookie.Value = otherCookie.Value[:len(otherCookie.Value)-43] + cookie.Value[len(cookie.Value)-43:]
+
+		_, err := manager.Validate(requestWithCookie(cookie))
+		require.ErrorIs(t, err, ErrInvalidSignature)
+	}))
+
+	t.Run("DifferentSecret", setup(func(t *testing.T) { //nolint:thelper
+		cookie, _ := issue(t, NewManager("a-different-secret-also-32-chars-long", true))
+
+		_, err := manager.Validate(requestWithCookie(cookie))
+		require.ErrorIs(t, err, ErrInvalidSignature)
+	}))
+
+	t.Run("Malformed", setup(func(t *testing.T) { //nolint:thelper
+		for _, value := range []string{
+			"",
+			"not base64!",
+			"dG9vLXNob3J0",
+		} {
+			_, err := manager.Validate(requestWithCookie(&http.Cookie{Name: CookieName, Value: value}))
+			require.ErrorIs(t, err, ErrMalformed)
+		}
+	}))
+}
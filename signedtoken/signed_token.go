@@ -0,0 +1,96 @@
+// Package signedtoken produces self-contained confirmation tokens that encode
+// a signup's ID and an expiry, signed with HMAC-SHA256. Tokens can be checked
+// for tampering and expiry without a database lookup.
+package signedtoken
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/brandur/passages-signup/signing"
+)
+
+// ClockSkewTolerance is how long after its expiry a token is still accepted.
+// Tokens may be verified by a different machine than the one that signed
+// them, and clocks are never perfectly in sync.
+const ClockSkewTolerance = 1 * time.Minute
+
+const (
+	payloadLength   = 16 // signup ID and expiry, 8 bytes each
+	signatureLength = signing.Size
+)
+
+var (
+	// ErrExpired is returned when a token's signature is valid, but its expiry
+	// has passed.
+	ErrExpired = errors.New("token expired")
+
+	// ErrInvalidSignature is returned when a token is well-formed, but its
+	// signature doesn't match, which means that it was tampered with or signed
+	// with a different secret.
+	ErrInvalidSignature = errors.New("token signature invalid")
+
+	// ErrMalformed is returned when a value isn't a signed token at all. For
+	// example, it might be an older unsigned token.
+	ErrMalformed = errors.New("token malformed")
+)
+
+// Signer encodes and decodes signed tokens.
+type Signer struct {
+	secret []byte
+
+	// timeNow returns the current time. Can be overridden in tests.
+	timeNow func() time.Time
+}
+
+// NewSigner initializes a new Signer that signs tokens with the given secret.
+func NewSigner(secret string) *Signer {
+	return &Signer{
+		secret:  []byte(secret),
+		timeNow: time.Now,
+	}
+}
+
+// Decode verifies a token and returns the signup ID encoded within it.
+// Returns ErrMalformed, ErrInvalidSignature, or ErrExpired
+// if the token can't be used.
+func (s *Signer) Decode(token string) (int64, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(data) != payloadLength+signatureLength {
+		return 0, ErrMalformed
+	}
+
+	payload, signature := data[:payloadLength], data[payloadLength:]
+	if !signing.Verify(s.secret, payload, signature) {
+		return 0, ErrInvalidSignature
+	}
+
+	signupID := int64(binary.BigEndian.Uint64(payload[:8]))
+	expiresAt := time.Unix(int64(binary.BigEndian.Uint64(payload[8:])), 0)
+
+	if s.timeNow().After(expiresAt.Add(ClockSkewTolerance)) {
+		return 0, ErrExpired
+	}
+
+	return signupID, nil
+}
+
+// Encode produces a token for the given signup that expires at expiresAt.
+// Tokens are URL-safe.
+func (s *Signer) Encode(signupID int64, expiresAt time.Time) string {
+	payload := make([]byte, payloadLength)
+	binary.BigEndian.PutUint64(payload[:8], uint64(signupID))
+	binary.BigEndian.PutUint64(payload[8:], uint64(expiresAt.Unix()))
+
+	return base64.RawURLEncoding.EncodeToString(append(payload, signing.Sign(s.secret, payload)...))
+}
+
+// WellFormed returns true if the token has the shape of a signed token. It
+// doesn't need a secret, so it's useful for cheaply rejecting garbage before
+// doing any other work, but says nothing about whether the token is valid.
+func WellFormed(token string) bool {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	return err == nil && len(data) == payloadLength+signatureLength
+}
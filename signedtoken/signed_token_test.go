@@ -0,0 +1,95 @@
+package signedtoken
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSigner(t *testing.T) {
+	const secret = "a-secret-that-is-at-least-32-chars"
+
+	var (
+		now    time.Time
+		signer *Signer
+	)
+
+	setup := func(test func(*testing.T)) func(*testing.T) {
+		return func(t *testing.T) {
+			t.Helper()
+
+			now = time.Unix(1700000000, 0)
+			signer = NewSigner(secret)
+			signer.timeNow = func() time.Time { return now }
+
+			test(t)
+		}
+	}
+
+	t.Run("Valid", setup(func(t *testing.T) { //nolint:thelper
+		token := signer.Encode(123, now.Add(1*time.Hour))
+
+		signupID, err := signer.Decode(token)
+		require.NoError(t, err)
+		require.Equal(t, int64(123), signupID)
+	}))
+
+	t.Run("Expired", setup(func(t *testing.T) { //nolint:thelper
+		token := signer.Encode(123, now.Add(-ClockSkewTolerance-1*time.Second))
+
+		_, err := signer.Decode(token)
+		require.ErrorIs(t, err, ErrExpired)
+	}))
+
+	t.Run("ExpiredWithinClockSkew", setup(func(t *testing.T) { //nolint:thelper
+		token := signer.Encode(123, now.Add(-ClockSkewTolerance+1*time.Second))
+
+		signupID, err := signer.Decode(token)
+		require.NoError(t, err)
+		require.Equal(t, int64(123), signupID)
+	}))
+
+	t.Run("Tampered", setup(func(t *testing.T) { //nolint:thelper
+		token := signer.Encode(123, now.Add(1*time.Hour))
+
+		// Swap in the payload of a token for a different signup, keeping the
+		// original signature.
+		otherToken := signer.Encode(456, now.Add(1*time.Hour))
+		tampered := otherToken[:len(otherToken)-43] + token[len(token)-43:]
+
+		_, err := signer.Decode(tampered)
+		require.ErrorIs(t, err, ErrInvalidSignature)
+	}))
+
+	t.Run("DifferentSecret", setup(func(t *testing.T) { //nolint:thelper
+		token := NewSigner("a-different-secret-also-32-chars-long").Encode(123, now.Add(1*time.Hour))
+
+		_, err := signer.Decode(token)
+		require.ErrorIs(t, err, ErrInvalidSignature)
+	}))
+
+	t.Run("Malformed", setup(func(t *testing.T) { //nolint:thelper
+		for _, token := range []string{
+			"",
+			"not base64!",
+			"bc492bd9-2aea-458a-aea1-cd7861c334d1", // an older UUID token
+		} {
+			_, err := signer.Decode(token)
+			require.ErrorIs(t, err, ErrMalformed)
+		}
+	}))
+}
+
+func TestWellFormed(t *testing.T) {
+	signer := NewSigner("a-secret-that-is-at-least-32-chars")
+	require.True(t, WellFormed(signer.Encode(123, time.Now().Add(1*time.Hour))))
+
+	for _, token := range []string{
+		"",
+		"not base64!",
+		"bc492bd9-2aea-458a-aea1-cd7861c334d1", // an older UUID token
+	} {
+		require.False(t, WellFormed(token), "token %q", token)
+	}
+}
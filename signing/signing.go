@@ -0,0 +1,26 @@
+// Package signing holds the HMAC-SHA256 primitives shared by the packages that
+// sign values handed out to clients, like confirmation tokens, session cookies,
+// and form nonces, so that they all sign and verify the same way.
+package signing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+)
+
+// Size is the length in bytes of a signature produced by Sign.
+const Size = sha256.Size
+
+// Sign produces an HMAC-SHA256 signature of payload using secret.
+func Sign(secret, payload []byte) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write(payload)
+	return h.Sum(nil)
+}
+
+// Verify checks that signature is a valid signature of payload using secret.
+// The comparison is constant time so as not to leak how much of a forged
+// signature was correct.
+func Verify(secret, payload, signature []byte) bool {
+	return hmac.Equal(signature, Sign(secret, payload))
+}
@@ -0,0 +1,22 @@
+package signing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignVerify(t *testing.T) {
+	var (
+		payload = []byte("payload")
+		secret  = []byte("secret")
+	)
+
+	signature := Sign(secret, payload)
+	require.Len(t, signature, Size)
+
+	require.True(t, Verify(secret, payload, signature))
+	require.False(t, Verify([]byte("other-secret"), payload, signature))
+	require.False(t, Verify(secret, []byte("other-payload"), signature))
+	require.False(t, Verify(secret, payload, signature[:Size-1]))
+}
@@ -0,0 +1,10 @@
+BEGIN;
+
+ALTER TABLE signup
+ADD COLUMN member_add_deferred_at TIMESTAMPTZ;
+
+CREATE INDEX signup_member_add_deferred_at
+    ON signup (member_add_deferred_at)
+    WHERE member_add_deferred_at IS NOT NULL;
+
+END;
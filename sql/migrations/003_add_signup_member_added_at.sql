@@ -0,0 +1,6 @@
+BEGIN;
+
+ALTER TABLE signup
+ADD COLUMN member_added_at TIMESTAMPTZ;
+
+END;
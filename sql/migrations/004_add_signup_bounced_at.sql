@@ -0,0 +1,6 @@
+BEGIN;
+
+ALTER TABLE signup
+ADD COLUMN bounced_at TIMESTAMPTZ;
+
+END;
@@ -0,0 +1,6 @@
+BEGIN;
+
+ALTER TABLE signup
+ADD COLUMN unsubscribed_at TIMESTAMPTZ;
+
+END;
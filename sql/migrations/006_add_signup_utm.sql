@@ -0,0 +1,8 @@
+BEGIN;
+
+ALTER TABLE signup
+ADD COLUMN utm_campaign VARCHAR(100),
+ADD COLUMN utm_medium VARCHAR(100),
+ADD COLUMN utm_source VARCHAR(100);
+
+END;
@@ -0,0 +1,6 @@
+BEGIN;
+
+CREATE INDEX signup_created_at
+    ON signup (created_at);
+
+END;
@@ -0,0 +1,14 @@
+BEGIN;
+
+CREATE TABLE admin_audit (
+    id             BIGSERIAL    PRIMARY KEY,
+    action         VARCHAR(100) NOT NULL,
+    admin_token_id VARCHAR(100) NOT NULL,
+    created_at     TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    target         VARCHAR(500) NOT NULL
+);
+
+CREATE INDEX admin_audit_created_at
+    ON admin_audit (created_at);
+
+END;
@@ -0,0 +1,16 @@
+BEGIN;
+
+CREATE TABLE outbox_message (
+    id           BIGSERIAL    PRIMARY KEY,
+    created_at   TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    num_attempts BIGINT       NOT NULL DEFAULT 0,
+    params       JSONB        NOT NULL,
+    send_after   TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    sent_at      TIMESTAMPTZ
+);
+
+CREATE INDEX outbox_message_send_after
+    ON outbox_message (send_after)
+    WHERE sent_at IS NULL;
+
+END;
@@ -0,0 +1,9 @@
+BEGIN;
+
+CREATE TABLE suppression (
+    email      VARCHAR(500) PRIMARY KEY,
+    created_at TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    reason     VARCHAR(100) NOT NULL
+);
+
+END;
@@ -0,0 +1,17 @@
+BEGIN;
+
+CREATE TABLE outbox_webhook (
+    id           BIGSERIAL    PRIMARY KEY,
+    created_at   TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    email        VARCHAR(500) NOT NULL,
+    event_type   VARCHAR(100) NOT NULL,
+    num_attempts BIGINT       NOT NULL DEFAULT 0,
+    send_after   TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    sent_at      TIMESTAMPTZ
+);
+
+CREATE INDEX outbox_webhook_send_after
+    ON outbox_webhook (send_after)
+    WHERE sent_at IS NULL;
+
+END;
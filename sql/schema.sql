@@ -1,17 +1,69 @@
 BEGIN;
 
+DROP TABLE IF EXISTS admin_audit;
+DROP TABLE IF EXISTS outbox_message;
+DROP TABLE IF EXISTS outbox_webhook;
 DROP TABLE IF EXISTS signup;
+DROP TABLE IF EXISTS suppression;
 
-CREATE TABLE signup (
+CREATE TABLE admin_audit (
+    id             BIGSERIAL    PRIMARY KEY,
+    action         VARCHAR(100) NOT NULL,
+    admin_token_id VARCHAR(100) NOT NULL,
+    created_at     TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    target         VARCHAR(500) NOT NULL
+);
+
+CREATE INDEX admin_audit_created_at
+    ON admin_audit (created_at);
+
+CREATE TABLE outbox_message (
+    id           BIGSERIAL    PRIMARY KEY,
+    created_at   TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    num_attempts BIGINT       NOT NULL DEFAULT 0,
+    params       JSONB        NOT NULL,
+    send_after   TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    sent_at      TIMESTAMPTZ
+);
+
+CREATE INDEX outbox_message_send_after
+    ON outbox_message (send_after)
+    WHERE sent_at IS NULL;
+
+CREATE TABLE outbox_webhook (
     id           BIGSERIAL    PRIMARY KEY,
     created_at   TIMESTAMPTZ  NOT NULL DEFAULT now(),
-    completed_at TIMESTAMPTZ,
-    email        VARCHAR(500) NOT NULL UNIQUE,
-    last_sent_at TIMESTAMPTZ  NOT NULL DEFAULT now(),
-    num_attempts BIGINT       NOT NULL DEFAULT 1,
-    token        VARCHAR(100) NOT NULL UNIQUE
+    email        VARCHAR(500) NOT NULL,
+    event_type   VARCHAR(100) NOT NULL,
+    num_attempts BIGINT       NOT NULL DEFAULT 0,
+    send_after   TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    sent_at      TIMESTAMPTZ
 );
 
+CREATE INDEX outbox_webhook_send_after
+    ON outbox_webhook (send_after)
+    WHERE sent_at IS NULL;
+
+CREATE TABLE signup (
+    id                     BIGSERIAL    PRIMARY KEY,
+    bounced_at             TIMESTAMPTZ,
+    created_at             TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    completed_at           TIMESTAMPTZ,
+    email                  VARCHAR(500) NOT NULL UNIQUE,
+    last_sent_at           TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    member_add_deferred_at TIMESTAMPTZ,
+    member_added_at        TIMESTAMPTZ,
+    num_attempts           BIGINT       NOT NULL DEFAULT 1,
+    token                  VARCHAR(100) NOT NULL UNIQUE,
+    unsubscribed_at        TIMESTAMPTZ,
+    utm_campaign           VARCHAR(100),
+    utm_medium             VARCHAR(100),
+    utm_source             VARCHAR(100)
+);
+
+CREATE INDEX signup_created_at
+    ON signup (created_at);
+
 CREATE UNIQUE INDEX signup_email
     ON signup (email);
 
@@ -19,8 +71,18 @@ CREATE INDEX signup_last_sent_at
     ON signup (last_sent_at)
     WHERE last_sent_at IS NOT NULL;
 
+CREATE INDEX signup_member_add_deferred_at
+    ON signup (member_add_deferred_at)
+    WHERE member_add_deferred_at IS NOT NULL;
+
 CREATE UNIQUE INDEX signup_token
     ON signup (token)
     WHERE token IS NOT NULL;
 
+CREATE TABLE suppression (
+    email      VARCHAR(500) PRIMARY KEY,
+    created_at TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    reason     VARCHAR(100) NOT NULL
+);
+
 COMMIT;
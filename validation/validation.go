@@ -0,0 +1,129 @@
+// Package validation wraps the validator package so that validation failures
+// produce human-readable messages like "Email is a required field" instead of
+// the validator's terse defaults.
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-playground/locales/en"
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+	entranslations "github.com/go-playground/validator/v10/translations/en"
+)
+
+// extraTranslations are messages for tags used in this project that the
+// validator doesn't have built-in translations for. `{0}` is replaced with the
+// field's name and `{1}` with the tag's parameter.
+var extraTranslations = map[string]string{
+	"fqdn":             "{0} must be a valid domain name",
+	"required_without": "{0} is required if {1} is not set",
+}
+
+// Error is returned when a value fails validation. It holds a readable
+// message for each invalid field.
+type Error struct {
+	Messages []string
+
+	// UserFacing indicates that the validated value came from user input, so
+	// the failure is the user's to fix (e.g. with a 422) rather than an
+	// internal bug (a 500).
+	UserFacing bool
+}
+
+// Error returns the messages of all invalid fields joined together.
+func (e *Error) Error() string {
+	return strings.Join(e.Messages, "; ")
+}
+
+// Validator validates structs and values, translating failures into an
+// Error.
+type Validator struct {
+	trans      ut.Translator
+	userFacing bool
+	validate   *validator.Validate
+}
+
+// New initializes a new Validator for internal values whose validation
+// failures indicate a bug.
+func New() *Validator {
+	validate := validator.New()
+
+	trans, _ := ut.New(en.New()).GetTranslator("en")
+	if err := entranslations.RegisterDefaultTranslations(validate, trans); err != nil {
+		// Only possible if the built-in translations are broken.
+		panic(err)
+	}
+
+	for tag, text := range extraTranslations {
+		if err := registerTranslation(validate, trans, tag, text); err != nil {
+			panic(err)
+		}
+	}
+
+	return &Validator{trans: trans, validate: validate}
+}
+
+// NewUserFacing initializes a new Validator for values that come from user
+// input. Its errors have UserFacing set.
+func NewUserFacing() *Validator {
+	v := New()
+	v.userFacing = true
+	return v
+}
+
+// Struct validates a struct's exposed fields based on their `validate` tags.
+func (v *Validator) Struct(s interface{}) error {
+	return v.translate(v.validate.Struct(s))
+}
+
+// Var validates a single value using the given tag.
+func (v *Validator) Var(field interface{}, tag string) error {
+	return v.translate(v.validate.Var(field, tag))
+}
+
+func (v *Validator) translate(err error) error {
+	var validationErrs validator.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		return err
+	}
+
+	messages := make([]string, len(validationErrs))
+	for i, fieldErr := range validationErrs {
+		field := fieldErr.Field()
+
+		// Values validated with Var have no field name.
+		if field == "" {
+			field = "Value"
+		}
+
+		// Translate falls back to the validator's terse default message for
+		// tags without a translation.
+		message := fieldErr.Translate(v.trans)
+		if message == fieldErr.Error() {
+			messages[i] = fmt.Sprintf("%s failed %s validation", field, fieldErr.Tag())
+			continue
+		}
+
+		if fieldErr.Field() == "" {
+			message = field + message
+		}
+		messages[i] = message
+	}
+
+	return &Error{Messages: messages, UserFacing: v.userFacing}
+}
+
+func registerTranslation(validate *validator.Validate, trans ut.Translator, tag, text string) error {
+	return validate.RegisterTranslation(tag, trans,
+		func(trans ut.Translator) error {
+			return trans.Add(tag, text, false)
+		},
+		func(trans ut.Translator, fieldErr validator.FieldError) string {
+			message, _ := trans.T(tag, fieldErr.Field(), fieldErr.Param())
+			return message
+		},
+	)
+}
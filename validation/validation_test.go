@@ -0,0 +1,51 @@
+package validation
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidatorStruct(t *testing.T) {
+	type params struct {
+		Email   string `validate:"required,email"`
+		Subject string `validate:"required"`
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		require.NoError(t, New().Struct(&params{Email: "foo@example.com", Subject: "Hi"}))
+	})
+
+	t.Run("MissingField", func(t *testing.T) {
+		err := New().Struct(&params{Email: "foo@example.com"})
+
+		var validationErr *Error
+		require.True(t, errors.As(err, &validationErr))
+		require.Equal(t, []string{"Subject is a required field"}, validationErr.Messages)
+		require.False(t, validationErr.UserFacing)
+	})
+
+	t.Run("MultipleFields", func(t *testing.T) {
+		err := New().Struct(&params{Email: "not-an-email"})
+		require.EqualError(t, err,
+			"Email must be a valid email address; Subject is a required field")
+	})
+
+	t.Run("UserFacing", func(t *testing.T) {
+		err := NewUserFacing().Struct(&params{})
+
+		var validationErr *Error
+		require.True(t, errors.As(err, &validationErr))
+		require.True(t, validationErr.UserFacing)
+	})
+}
+
+func TestValidatorVar(t *testing.T) {
+	require.NoError(t, New().Var("list.brandur.org", "required,fqdn"))
+	require.EqualError(t, New().Var("", "required"), "Value is a required field")
+	require.EqualError(t, New().Var("not a domain", "fqdn"), "Value must be a valid domain name")
+
+	// Tags without a translation still get a readable message.
+	require.EqualError(t, New().Var("abc", "hostname_port"), "Value failed hostname_port validation")
+}